@@ -2,12 +2,19 @@ package webapp
 
 import (
 	"fmt"
+	"log/slog"
+	"time"
 
 	"github.com/asggo/wasp/config"
 	"github.com/asggo/wasp/handler"
+	"github.com/asggo/wasp/mail"
 	"github.com/asggo/wasp/middleware"
+	"github.com/asggo/wasp/passphrase"
+	"github.com/asggo/wasp/ratelimit"
 	"github.com/asggo/wasp/store"
+	"github.com/asggo/wasp/upload"
 	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
 )
 
 // Application holds our web application.
@@ -28,27 +35,194 @@ func NewApplication() *Application {
 	// Get our configuration
 	cfg := config.NewConfiguration()
 
+	// Load the terms refused in usernames.
+	words, err := config.LoadWordList(cfg.UsernameDenyListPath)
+	if err != nil {
+		panic(fmt.Errorf("could not NewApplication: %v", err))
+	}
+
+	cfg.UsernameDenyList = words
+
+	// Open the list of breached passphrases refused for new passphrases.
+	if cfg.BreachListPath != "" {
+		cfg.BreachList, err = passphrase.OpenBreachList(cfg.BreachListPath)
+		if err != nil {
+			panic(fmt.Errorf("could not NewApplication: %v", err))
+		}
+	}
+
+	// Set the Argon2id parameters used for new passphrase hashes. Hashes
+	// made with weaker ones are replaced when users log in.
+	err = store.SetHashParams(store.HashParams{Memory: cfg.HashMemory, Time: cfg.HashTime, Threads: cfg.HashThreads})
+	if err != nil {
+		panic(fmt.Errorf("could not NewApplication: %v", err))
+	}
+
+	// Load the pepper mixed into passphrases before they are hashed. Hashes
+	// made with another pepper, or none, are replaced when users log in.
+	if cfg.PepperPath != "" {
+		var last string
+
+		cfg.Peppers, last, err = config.LoadPeppers(cfg.PepperPath)
+		if err != nil {
+			panic(fmt.Errorf("could not NewApplication: %v", err))
+		}
+
+		if cfg.PepperID == "" {
+			cfg.PepperID = last
+		}
+	}
+
+	err = store.SetPeppers(cfg.Peppers, cfg.PepperID)
+	if err != nil {
+		panic(fmt.Errorf("could not NewApplication: %v", err))
+	}
+
+	// Bound the number of hashes derived at once so bursts of logins cannot
+	// exhaust memory.
+	err = store.SetHashConcurrency(cfg.HashWorkers, cfg.HashQueue)
+	if err != nil {
+		panic(fmt.Errorf("could not NewApplication: %v", err))
+	}
+
 	// Setup our Store
 	store, err := store.NewStore(cfg.StorePath)
 	if err != nil {
 		panic(fmt.Errorf("could not NewApplication: %v", err))
 	}
 
+	// Load the key used to encrypt secrets in the Store.
+	key, err := config.LoadSecretKey(cfg.SecretKeyPath)
+	if err != nil {
+		panic(fmt.Errorf("could not NewApplication: %v", err))
+	}
+
+	cfg.SecretKey = key
+
+	err = store.SetSecretKey(cfg.SecretKey)
+	if err != nil {
+		panic(fmt.Errorf("could not NewApplication: %v", err))
+	}
+
+	// Use the Argon2id parameters recorded by the last calibration in place
+	// of the configured ones, calibrating first if asked to. Recorded
+	// parameters weaker than the configured ones are not used.
+	if cfg.HashCalibrate {
+		target := time.Duration(cfg.HashTarget) * time.Millisecond
+
+		_, err = store.CalibrateHashPolicy(target, cfg.HashMaxMemory, cfg.HashThreads)
+		if err != nil {
+			panic(fmt.Errorf("could not NewApplication: %v", err))
+		}
+	}
+
+	used, err := store.LoadHashPolicy()
+	if err != nil {
+		panic(fmt.Errorf("could not NewApplication: %v", err))
+	}
+
+	if _, recorded := store.GetHashPolicy(); recorded && !used {
+		slog.Warn("the recorded Argon2id parameters are weaker than the configured ones and are not used; run with -clear-calibration to remove them")
+	}
+
+	// Setup the storage for uploaded files and remove the files of deleted
+	// users from it.
+	files, err := upload.NewStorage(&cfg, &store)
+	if err != nil {
+		panic(fmt.Errorf("could not NewApplication: %v", err))
+	}
+
+	upload.RegisterUserDeleter(files)
+
+	// Setup our Mailer. Messages are logged unless an SMTP server is
+	// configured.
+	mailer := mail.NewLogMailer()
+	if cfg.SMTPAddr != "" {
+		mailer = mail.NewSMTPMailer(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	}
+
+	// Setup the rate limits, keeping them across restarts if asked to.
+	// Buckets idle for a day are full again and are not saved.
+	limiter := ratelimit.NewLimiter()
+	if cfg.RateLimitPersist {
+		if cfg.RateLimitSaveInterval <= 0 {
+			panic(fmt.Errorf("could not NewApplication: RateLimitSaveInterval %d is not positive", cfg.RateLimitSaveInterval))
+		}
+
+		err = limiter.Load(&store)
+		if err != nil {
+			panic(fmt.Errorf("could not NewApplication: %v", err))
+		}
+
+		interval := time.Duration(cfg.RateLimitSaveInterval) * time.Second
+		go limiter.SaveEvery(&store, interval, 24*time.Hour)
+	}
+
 	// Setup our router
 	r := chi.NewRouter()
 	r.NotFound(handler.NotFoundHandler)
 
-	// Setup our middleware
+	// Setup our middleware. Behind a proxy the client IP is taken from the
+	// headers it sets.
+	if cfg.TrustProxyHeaders {
+		r.Use(chimiddleware.RealIP)
+	}
+
 	r.Use(middleware.Logger())
 	r.Use(middleware.Timeout(cfg.RequestTimeout))
 	r.Use(middleware.SecurityHeaders)
 
 	// Mount our sub routers
 	r.Mount("/", indexRouter(&cfg, &store))
-	r.Mount("/account", accountRouter(&cfg, &store))
-	r.Mount("/site", siteRouter(&cfg, &store))
+	r.Mount("/account", accountRouter(&cfg, &store, mailer, limiter))
+	r.Mount("/site", siteRouter(&cfg, &store, mailer, files))
 
 	app.r = r
 
 	return &app
 }
+
+// CalibrateHashing benchmarks Argon2id on this host with the calibration
+// settings of the Config and records the parameters it picks in the Store,
+// where the application finds them on its next start and uses them if they
+// are at least as strong as the configured ones. The Store cannot be open in
+// a running application at the same time.
+func CalibrateHashing() (store.HashParams, error) {
+	cfg := config.NewConfiguration()
+
+	s, err := store.NewStore(cfg.StorePath)
+	if err != nil {
+		return store.HashParams{}, fmt.Errorf("could not CalibrateHashing: %v", err)
+	}
+	defer s.Close()
+
+	target := time.Duration(cfg.HashTarget) * time.Millisecond
+
+	p, err := s.CalibrateHashPolicy(target, cfg.HashMaxMemory, cfg.HashThreads)
+	if err != nil {
+		return p, fmt.Errorf("could not CalibrateHashing: %v", err)
+	}
+
+	return p, nil
+}
+
+// ClearHashCalibration removes the Argon2id parameters recorded by the last
+// calibration from the Store, so the application uses the configured ones
+// from its next start. The Store cannot be open in a running application at
+// the same time.
+func ClearHashCalibration() error {
+	cfg := config.NewConfiguration()
+
+	s, err := store.NewStore(cfg.StorePath)
+	if err != nil {
+		return fmt.Errorf("could not ClearHashCalibration: %v", err)
+	}
+	defer s.Close()
+
+	err = s.ClearHashPolicy()
+	if err != nil {
+		return fmt.Errorf("could not ClearHashCalibration: %v", err)
+	}
+
+	return nil
+}
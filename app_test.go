@@ -14,4 +14,20 @@ func TestApplication(t *testing.T) {
 	webtest.TestHandler(t, "tests/admin_test.txt", router)
 	webtest.TestHandler(t, "tests/account_test.txt", router)
 	webtest.TestHandler(t, "tests/user_test.txt", router)
+	webtest.TestHandler(t, "tests/alias_test.txt", router)
+	webtest.TestHandler(t, "tests/email_test.txt", router)
+	webtest.TestHandler(t, "tests/reset_test.txt", router)
+	webtest.TestHandler(t, "tests/recovery_test.txt", router)
+	webtest.TestHandler(t, "tests/users_test.txt", router)
+	webtest.TestHandler(t, "tests/roles_test.txt", router)
+	webtest.TestHandler(t, "tests/org_test.txt", router)
+	webtest.TestHandler(t, "tests/invites_test.txt", router)
+	webtest.TestHandler(t, "tests/pending_test.txt", router)
+	webtest.TestHandler(t, "tests/delete_test.txt", router)
+	webtest.TestHandler(t, "tests/preferences_test.txt", router)
+	webtest.TestHandler(t, "tests/uploads_test.txt", router)
+	webtest.TestHandler(t, "tests/lockout_test.txt", router)
+	webtest.TestHandler(t, "tests/twofactor_test.txt", router)
+	webtest.TestHandler(t, "tests/passkeys_test.txt", router)
+	webtest.TestHandler(t, "tests/ratelimit_test.txt", router)
 }
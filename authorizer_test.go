@@ -0,0 +1,86 @@
+package webapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/asggo/wasp/middleware"
+	"github.com/asggo/wasp/store"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	testAuthorizerDbPath = "authorizer_test.db"
+)
+
+// TestAuthorizerStops verifies the authorization middleware does not pass a
+// request on to the next handler once it has written an error.
+func TestAuthorizerStops(t *testing.T) {
+	s, err := store.NewStore(testAuthorizerDbPath)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+	defer os.Remove(testAuthorizerDbPath)
+	defer s.Close()
+
+	reached := false
+	next := func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}
+
+	r := chi.NewRouter()
+	r.Use(middleware.Authorizer(&s))
+	r.With(middleware.RequirePermission(store.PermAdminAccess)).Get("/admin", next)
+	r.Get("/", next)
+
+	user := store.NewUser("authorizer1234")
+	s.CreateUser(user, "authorizerpassword1")
+
+	expired, _ := store.NewSession(user.UserId, -60)
+	s.CreateSession(expired)
+
+	valid, _ := store.NewSession(user.UserId, 60)
+	s.CreateSession(valid)
+
+	orphan, _ := store.NewSession(store.NewUserToken(), 60)
+	s.CreateSession(orphan)
+
+	tests := []struct {
+		name   string
+		path   string
+		cookie string
+		code   int
+	}{
+		{"no session", "/", "", http.StatusBadRequest},
+		{"expired session", "/", expired.SessionId.String(), http.StatusUnauthorized},
+		{"unknown user", "/", orphan.SessionId.String(), http.StatusInternalServerError},
+		{"missing permission", "/admin", valid.SessionId.String(), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		reached = false
+
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "sess", Value: tt.cookie})
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.code || reached {
+			t.Fatal("Expected", tt.code, "without reaching the handler for", tt.name, ", received", w.Code, reached)
+		}
+	}
+
+	// A valid session reaches the handler.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "sess", Value: valid.SessionId.String()})
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !reached {
+		t.Fatal("Expected the handler to be reached with a valid session")
+	}
+}
@@ -1,21 +1,325 @@
 package config
 
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/asggo/wasp/passphrase"
+)
+
+// Registration modes control who can create an account at /account/register.
+// In invite-only mode a valid invite code is required to register, and in
+// closed mode no one can register.
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite-only"
+	RegistrationClosed = "closed"
+)
+
+// Username character classes control which characters a username may
+// contain. Letters include the marks that combine with them, and punctuation
+// is limited to the period, hyphen, and underscore.
+const (
+	UsernameLetters     = "letters"
+	UsernameDigits      = "digits"
+	UsernamePunctuation = "punctuation"
+)
+
+// Upload backends control where the content of uploaded files is kept. The
+// local backend uses a directory, the bolt backend uses the store database,
+// and the s3 backend uses a bucket of an S3 compatible service.
+const (
+	UploadLocal = "local"
+	UploadBolt  = "bolt"
+	UploadS3    = "s3"
+)
+
+// RateLimit allows Requests requests in each Period of seconds. A RateLimit
+// with no requests does not limit anything.
+type RateLimit struct {
+	Requests int
+	Period   int64
+}
+
+// RouteLimits holds the limits of a route for each client IP, for each
+// account named in the request, and for each client IP and account pair.
+type RouteLimits struct {
+	IP        RateLimit
+	Account   RateLimit
+	IPAccount RateLimit
+}
+
+// defaultRateLimits returns the default limits of the routes that check
+// credentials or send mail, keyed by path.
+func defaultRateLimits() map[string]RouteLimits {
+	return map[string]RouteLimits{
+		"/account/login": {
+			IP:        RateLimit{60, 60},     // 60 a minute from one IP
+			Account:   RateLimit{30, 60 * 5}, // 30 every 5 minutes for one account
+			IPAccount: RateLimit{15, 60},     // 15 a minute from one IP for one account
+		},
+		"/account/2fa":             {IP: RateLimit{30, 60}},
+		"/account/passkey":         {IP: RateLimit{60, 60}},
+		"/account/passkey/options": {IP: RateLimit{60, 60}},
+		"/account/register":        {IP: RateLimit{30, 60 * 60}},
+		"/account/admin":           {IP: RateLimit{10, 60 * 10}},
+		"/account/forgot":          {IP: RateLimit{10, 60 * 10}, Account: RateLimit{3, 60 * 10}},
+		"/account/reset":           {IP: RateLimit{20, 60 * 10}},
+	}
+}
+
 // Config holds configuration data used by the application.
 type Config struct {
-	MinUsernameLength   int
-	MinPassphraseLength int
-	StorePath           string
-	RequestTimeout      int
-	SessionLength       int64
+	MinUsernameLength       int
+	MaxUsernameLength       int
+	UsernameClasses         []string
+	ReservedUsernames       []string
+	UsernameDenyListPath    string
+	UsernameDenyList        []string
+	MinPassphraseLength     int
+	MinPassphraseEntropy    float64
+	BreachListPath          string
+	BreachList              *passphrase.BreachList
+	PassphraseChecks        []passphrase.Check
+	HashMemory              uint32
+	HashTime                uint32
+	HashThreads             uint8
+	HashWorkers             int
+	HashQueue               int
+	HashCalibrate           bool
+	HashTarget              int
+	HashMaxMemory           uint32
+	PepperPath              string
+	Peppers                 map[string][]byte
+	PepperID                string
+	LockoutThreshold        uint64
+	LockoutDuration         int64
+	LockoutBackoff          int64
+	MaxLockoutDuration      int64
+	RateLimits              map[string]RouteLimits
+	RateLimitPersist        bool
+	RateLimitSaveInterval   int64
+	TrustProxyHeaders       bool
+	StorePath               string
+	SecretKeyPath           string
+	SecretKey               []byte
+	RequestTimeout          int
+	SessionLength           int64
+	LoginChallengeLength    int64
+	TOTPIssuer              string
+	WebAuthnRPName          string
+	AliasReservationLength  int64
+	EmailVerificationLength int64
+	PasswordResetLength     int64
+	RecoveryLinkLength      int64
+	AdminPageSize           int
+	RegistrationMode        string
+	InviteLength            int64
+	RequireApproval         bool
+	BaseURL                 string
+	MailFrom                string
+	SMTPAddr                string
+	SMTPUsername            string
+	SMTPPassword            string
+	UploadBackend           string
+	UploadDir               string
+	MaxUploadSize           int64
+	UploadTypes             []string
+	S3Endpoint              string
+	S3Region                string
+	S3Bucket                string
+	S3AccessKey             string
+	S3SecretKey             string
 }
 
 // NewConfiguration creates a new Config object with the default settings.
 func NewConfiguration() Config {
 	return Config{
-		MinUsernameLength:   8,
-		MinPassphraseLength: 16,
-		StorePath:           "data/wasp.db",
-		RequestTimeout:      30,      // 30 second time out
-		SessionLength:       60 * 15, // 15 minute session
+		MinUsernameLength:       8,
+		MaxUsernameLength:       32,
+		UsernameClasses:         []string{UsernameLetters, UsernameDigits, UsernamePunctuation},
+		ReservedUsernames:       []string{"administrator", "root", "support", "help", "security", "system", "postmaster", "webmaster", "abuse", "noreply"},
+		UsernameDenyListPath:    "config/username_denylist.txt",
+		UsernameDenyList:        nil, // Loaded from UsernameDenyListPath
+		MinPassphraseLength:     16,
+		MinPassphraseEntropy:    50, // estimated bits, see passphrase.Entropy
+		BreachListPath:          "config/breached_passphrases.txt",
+		BreachList:              nil,          // Loaded from BreachListPath
+		PassphraseChecks:        nil,          // run after the built-in checks
+		HashMemory:              64 * 1024,    // Argon2id KiB, RFC 9106 second recommended option
+		HashTime:                4,            // Argon2id passes
+		HashThreads:             3,            // Argon2id lanes
+		HashWorkers:             4,            // hashes derived at once, each using HashMemory
+		HashQueue:               32,           // hashes waiting before requests are refused
+		HashCalibrate:           false,        // benchmark the Argon2id parameters on start
+		HashTarget:              500,          // milliseconds per hash when calibrating
+		HashMaxMemory:           64 * 1024,    // most KiB per hash when calibrating
+		PepperPath:              "",           // no pepper if empty
+		Peppers:                 nil,          // Loaded from PepperPath
+		PepperID:                "",           // the last key in PepperPath if empty
+		LockoutThreshold:        5,            // failed logins before a lockout, at most 10
+		LockoutDuration:         60 * 5,       // 5 minute first lockout
+		LockoutBackoff:          2,            // each lockout in a row lasts twice as long
+		MaxLockoutDuration:      60 * 60 * 24, // 24 hour longest lockout
+		RateLimits:              defaultRateLimits(),
+		RateLimitPersist:        false, // keep the limits across restarts
+		RateLimitSaveInterval:   30,    // seconds between saves when persisting
+		TrustProxyHeaders:       false, // take the client IP from X-Forwarded-For
+		StorePath:               "data/wasp.db",
+		SecretKeyPath:           "data/secret.key", // Created on first start
+		SecretKey:               nil,               // Loaded from SecretKeyPath
+		RequestTimeout:          30,                // 30 second time out
+		SessionLength:           60 * 15,           // 15 minute session
+		LoginChallengeLength:    60 * 5,            // 5 minutes to enter a second factor
+		TOTPIssuer:              "WASP",            // site name shown in authenticator apps
+		WebAuthnRPName:          "WASP",            // site name shown when using a passkey
+		AliasReservationLength:  60 * 60 * 24 * 30, // 30 day alias reservation
+		EmailVerificationLength: 60 * 60 * 24,      // 24 hour verification link
+		PasswordResetLength:     60 * 30,           // 30 minute reset link
+		RecoveryLinkLength:      60 * 60 * 24,      // 24 hour recovery link
+		AdminPageSize:           25,                // users per console page
+		RegistrationMode:        RegistrationOpen,  // anyone can register
+		InviteLength:            60 * 60 * 24 * 7,  // 7 day invite code
+		RequireApproval:         false,             // new accounts can log in at once
+		BaseURL:                 "https://localhost:8000",
+		MailFrom:                "wasp@localhost",
+		SMTPAddr:                "", // Mail is logged when no SMTP server is set
+		SMTPUsername:            "",
+		SMTPPassword:            "",
+		UploadBackend:           UploadLocal,
+		UploadDir:               "data/uploads",
+		MaxUploadSize:           10 << 20, // 10 MiB per file
+		UploadTypes:             []string{"image/png", "image/jpeg", "image/gif", "application/pdf", "text/plain"},
+		S3Endpoint:              "", // Required by the s3 backend
+		S3Region:                "us-east-1",
+		S3Bucket:                "wasp-uploads",
+		S3AccessKey:             "",
+		S3SecretKey:             "",
+	}
+}
+
+// LoadWordList reads the words in the file at the given path, one per line.
+// Blank lines and lines starting with # are skipped, and words are lower
+// cased. An empty path gives an empty list.
+func LoadWordList(path string) ([]string, error) {
+	var words []string
+
+	if path == "" {
+		return words, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return words, fmt.Errorf("could not LoadWordList: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+
+		words = append(words, word)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return words, fmt.Errorf("could not LoadWordList: %v", err)
+	}
+
+	return words, nil
+}
+
+// LoadSecretKey reads the hex encoded 32 byte key in the file at the given
+// path. If the file does not exist, a random key is created and written to
+// it so the same key is used on the next start. Keep the file private and
+// back it up with the store, since secrets encrypted with the key cannot be
+// read without it.
+func LoadSecretKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, 32)
+
+		_, err = rand.Read(key)
+		if err != nil {
+			return nil, fmt.Errorf("could not LoadSecretKey: %v", err)
+		}
+
+		err = os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600)
+		if err != nil {
+			return nil, fmt.Errorf("could not LoadSecretKey: %v", err)
+		}
+
+		return key, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not LoadSecretKey: %v", err)
 	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("could not LoadSecretKey: %s must hold a hex encoded 32 byte key", path)
+	}
+
+	return key, nil
+}
+
+// LoadPeppers reads the pepper keys in the file at the given path, one per
+// line as a key ID and a hex encoded key of at least 32 bytes separated by a
+// space. Blank lines and lines starting with # are skipped. The keys are
+// returned by ID along with the ID of the last key, which is the newest. An
+// empty path gives no keys.
+func LoadPeppers(path string) (map[string][]byte, string, error) {
+	keys := make(map[string][]byte)
+	last := ""
+
+	if path == "" {
+		return keys, last, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return keys, last, fmt.Errorf("could not LoadPeppers: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return keys, last, fmt.Errorf("could not LoadPeppers: %s lines must hold a key ID and a key", path)
+		}
+
+		key, err := hex.DecodeString(fields[1])
+		if err != nil || len(key) < 32 {
+			return keys, last, fmt.Errorf("could not LoadPeppers: key %s must be hex encoded with at least 32 bytes", fields[0])
+		}
+
+		if _, ok := keys[fields[0]]; ok {
+			return keys, last, fmt.Errorf("could not LoadPeppers: key %s is listed twice", fields[0])
+		}
+
+		keys[fields[0]] = key
+		last = fields[0]
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return keys, last, fmt.Errorf("could not LoadPeppers: %v", err)
+	}
+
+	return keys, last, nil
 }
@@ -9,6 +9,7 @@ require (
 	go.etcd.io/bbolt v1.4.0
 	golang.org/x/crypto v0.38.0
 	golang.org/x/text v0.25.0
+	rsc.io/qr v0.2.0
 )
 
 require golang.org/x/sys v0.33.0 // indirect
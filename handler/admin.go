@@ -1,27 +1,240 @@
 package handler
 
 import (
+	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 
+	"github.com/asggo/wasp/config"
 	"github.com/asggo/wasp/store"
 )
 
 var (
-	adminTmpl = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/admin.html"))
+	adminTmpl    = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/admin.html"))
+	renameTmpl   = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/rename.html"))
+	recoveryTmpl = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/recovery.html"))
+	userNotFound = "User not found."
+
+	adminTOTPRequired    = "Administrators must now use two-factor authentication."
+	adminTOTPNotRequired = "Administrators no longer need two-factor authentication."
 )
 
+// adminPage holds the data needed to render the admin index page. Settings
+// is true if the user holds the admin role, which alone may change the
+// security settings of the site.
+type adminPage struct {
+	Error       interface{}
+	Message     string
+	Settings    bool
+	RequireTOTP bool
+}
+
+// recoveryPage holds the data needed to render the account recovery page.
+type recoveryPage struct {
+	Error    interface{}
+	Alias    string
+	Link     string
+	Expires  int64
+	Activity []store.Activity
+}
+
+// renamePage holds the data needed to render the rename user page.
+type renamePage struct {
+	Error   interface{}
+	Alias   string
+	History []store.AliasChange
+}
+
 // adminHandler provides handlers for all of the endpoints in the /admin path.
 type adminHandler struct {
-	db *store.Store
+	cfg *config.Config
+	db  *store.Store
 }
 
 // Index renders the index page of the /admin path.
 func (ah *adminHandler) Index(w http.ResponseWriter, r *http.Request) {
-	adminTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), nil))
+	user := r.Context().Value("user").(store.User)
+	page := adminPage{Settings: user.HasRole(store.RoleAdmin), RequireTOTP: requireAdminTOTP(ah.db)}
+
+	adminTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// ExecRequireTOTP sets whether administrators must use two-factor
+// authentication. Once required, administrators without it are sent to enable
+// it before they can use the site. Only users with the admin role may change
+// it.
+func (ah *adminHandler) ExecRequireTOTP(w http.ResponseWriter, r *http.Request) {
+	require := r.PostFormValue("require") == "true"
+
+	msg := adminTOTPNotRequired
+	if require {
+		msg = adminTOTPRequired
+	}
+
+	err := ah.db.SetSetting(store.SettingRequireAdminTOTP, fmt.Sprint(require))
+	if err != nil {
+		e := fmt.Errorf("could not AdminHandler.ExecRequireTOTP: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	page := adminPage{Message: msg, Settings: true, RequireTOTP: require}
+
+	adminTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// ShowRename renders the rename user page. If an alias is given in the query
+// string, the alias history of that user is included.
+func (ah *adminHandler) ShowRename(w http.ResponseWriter, r *http.Request) {
+	un := r.URL.Query().Get("alias")
+	if un == "" {
+		renameTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), renamePage{}))
+		return
+	}
+
+	ah.renderRename(w, r, un, nil)
+}
+
+// ExecRename changes the alias of a user with no more access than the admin.
+// The old alias is reserved for the renamed user so it cannot be taken by
+// another user.
+func (ah *adminHandler) ExecRename(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	old := r.Form.Get("alias")
+
+	admin := r.Context().Value("user").(store.User)
+
+	user, err := ah.db.GetUserByAlias(old)
+	if err != nil {
+		renameTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), renamePage{Error: userNotFound}))
+		return
+	}
+
+	if outranks(ah.db, r, user) {
+		e := fmt.Errorf("could not AdminHandler.ExecRename: %s outranks %s", user.Alias, admin.Alias)
+		NewForbiddenError(e).Handle(w, r)
+		return
+	}
+
+	if user.Alias == "admin" {
+		ah.renderRename(w, r, user.Alias, adminAliasFixed)
+		return
+	}
+
+	un, err := validateUsername(ah.cfg, r.Form.Get("username"))
+	if err != nil {
+		ah.renderRename(w, r, user.Alias, err)
+		return
+	}
+
+	if ah.db.UserExists(un) || ah.db.AliasReserved(un, user.UserId) || ah.db.AliasConfusable(un, user.UserId) {
+		ah.renderRename(w, r, user.Alias, usernameTaken)
+		return
+	}
+
+	err = ah.db.ChangeUserAlias(user.UserId, un, admin.UserId, ah.cfg.AliasReservationLength)
+	if err != nil {
+		e := fmt.Errorf("could not AdminHandler.ExecRename: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	http.Redirect(w, r, "/site/admin/rename?alias="+url.QueryEscape(un), http.StatusFound)
+}
+
+// renderRename renders the rename user page for the user with the given alias
+// along with their alias history.
+func (ah *adminHandler) renderRename(w http.ResponseWriter, r *http.Request, alias string, e interface{}) {
+	user, err := ah.db.GetUserByAlias(alias)
+	if err != nil {
+		renameTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), renamePage{Error: userNotFound}))
+		return
+	}
+
+	history, err := ah.db.GetAliasHistory(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not AdminHandler.renderRename: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	page := renamePage{Error: e, Alias: user.Alias, History: history}
+	renameTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// ShowRecovery renders the account recovery page. If an alias is given in the
+// query string, the activity of that user is included.
+func (ah *adminHandler) ShowRecovery(w http.ResponseWriter, r *http.Request) {
+	un := r.URL.Query().Get("alias")
+	if un == "" {
+		recoveryTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), recoveryPage{}))
+		return
+	}
+
+	ah.renderRecovery(w, r, recoveryPage{Alias: un})
+}
+
+// ExecRecovery creates a one-time account recovery link for a user with no
+// more access than the admin. The link is shown to the admin to hand to the
+// user, so no mail is needed.
+func (ah *adminHandler) ExecRecovery(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	un := r.Form.Get("alias")
+
+	admin := r.Context().Value("user").(store.User)
+
+	user, err := ah.db.GetUserByAlias(un)
+	if err != nil {
+		recoveryTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), recoveryPage{Error: userNotFound}))
+		return
+	}
+
+	if outranks(ah.db, r, user) {
+		e := fmt.Errorf("could not AdminHandler.ExecRecovery: %s outranks %s", user.Alias, admin.Alias)
+		NewForbiddenError(e).Handle(w, r)
+		return
+	}
+
+	token, err := ah.db.CreateAccountRecovery(user.UserId, admin.UserId, ah.cfg.RecoveryLinkLength)
+	if err != nil {
+		e := fmt.Errorf("could not AdminHandler.ExecRecovery: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	page := recoveryPage{
+		Alias:   user.Alias,
+		Link:    fmt.Sprintf("%s/account/reset?token=%s", ah.cfg.BaseURL, token),
+		Expires: ah.cfg.RecoveryLinkLength / 60,
+	}
+
+	ah.renderRecovery(w, r, page)
+}
+
+// renderRecovery renders the account recovery page for the user with the
+// alias in the given page along with the user's activity.
+func (ah *adminHandler) renderRecovery(w http.ResponseWriter, r *http.Request, page recoveryPage) {
+	user, err := ah.db.GetUserByAlias(page.Alias)
+	if err != nil {
+		recoveryTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), recoveryPage{Error: userNotFound}))
+		return
+	}
+
+	page.Alias = user.Alias
+	page.Activity, err = ah.db.GetActivity(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not AdminHandler.renderRecovery: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	recoveryTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
 }
 
-// NewAdminHandler creates a new adminHandler with the given Store.
-func NewAdminHandler(s *store.Store) *adminHandler {
-	return &adminHandler{db: s}
+// NewAdminHandler creates a new adminHandler with the given Config and Store.
+func NewAdminHandler(c *config.Config, s *store.Store) *adminHandler {
+	return &adminHandler{cfg: c, db: s}
 }
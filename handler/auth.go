@@ -5,25 +5,39 @@ package handler
 // ----------------------------------------------------------------------------
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/asggo/wasp/config"
 	"github.com/asggo/wasp/store"
+	"github.com/asggo/wasp/webauthn"
 )
 
 var (
 	loginTmpl          = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/login.html"))
 	logoutTmpl         = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/logout.html"))
+	loginCodeTmpl      = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/logincode.html"))
 	invalidCredentials = "Invalid credentials."
+	accountPending     = "Your account is awaiting approval by an administrator."
+	accountLocked      = "Too many failed logins. Try again later or reset your passphrase."
+	invalidCode        = "Invalid code."
+	challengeFailed    = "Too many invalid codes. Log in again."
+	passkeyLoginFailed = "Passkey sign-in failed."
 )
 
+// maxUnknownAliases limits the number of unknown usernames whose failed
+// logins are kept in memory.
+const maxUnknownAliases = 10000
+
 // authHandler provides handlers for each of the endpoints within /auth
 type authHandler struct {
-	cfg *config.Config
-	db  *store.Store
+	cfg     *config.Config
+	db      *store.Store
+	unknown *failureTracker
 }
 
 // Index renders the login page.
@@ -59,41 +73,347 @@ func (ah *authHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	logoutTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), nil))
 }
 
-// Login authenticates the user and sets a session cookie upon success.
+// Login authenticates the user and sets a session cookie upon success. How
+// often logins can be tried is limited by the RateLimit middleware.
 func (ah *authHandler) Login(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 
 	un := r.Form.Get("username")
 	pw := r.Form.Get("password")
 
+	policy := lockoutPolicy(ah.cfg)
+
+	// Failed logins for unknown usernames lock them just like real accounts,
+	// so the locked response does not reveal which usernames exist.
 	user, err := ah.db.GetUserByAlias(un)
 	if err != nil {
+		if ah.unknown.locked(un) {
+			loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), accountLocked))
+			return
+		}
+
+		_, lock := ah.unknown.fail(un, policy)
+		if lock.Locked() {
+			loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), accountLocked))
+			return
+		}
+
 		loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), invalidCredentials))
 		return
 	}
 
-	if !ah.db.AuthenticateUser(user.UserId, pw) {
-		ah.db.IncrementFailedAuthCount(user.UserId)
+	// The passphrase of a locked account is not checked, so the response
+	// does not reveal whether it was right.
+	lock, err := ah.db.GetLockout(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not AuthHandler.Login: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	if lock.Locked() {
+		loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), accountLocked))
+		return
+	}
 
-		count, err := ah.db.GetFailedAuthCount(user.UserId)
+	ok, err := ah.db.AuthenticateUser(user.UserId, pw)
+	if err != nil {
+		e := fmt.Errorf("could not AuthHandler.Login: %w", err)
+		NewStoreError(e).Handle(w, r)
+		return
+	}
+
+	if !ok {
+		lock, err := ah.db.RecordFailedAuth(user.UserId, policy)
 		if err != nil {
 			e := fmt.Errorf("could not AuthHandler.Login: %v", err)
 			NewServerError(e).Handle(w, r)
 			return
 		}
 
-		// Sleep based on the failed auth count.
-		time.Sleep(time.Duration(25*(1<<count)) * time.Millisecond)
+		if lock.Locked() {
+			loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), accountLocked))
+			return
+		}
 
 		loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), invalidCredentials))
 		return
 	}
 
-	ah.db.ResetFailedAuthCount(user.UserId)
+	// Users with two-factor authentication must enter a code before a
+	// session is created. Their failed count is kept until they do, so the
+	// passphrase alone cannot start it over. A TOTP record that cannot be
+	// read refuses the login rather than skip the second step.
+	enabled, err := ah.db.TOTPEnabled(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not AuthHandler.Login: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	if enabled {
+		ah.startChallenge(w, r, user)
+		return
+	}
+
+	// A successful login ends the run of lockouts.
+	ah.db.ResetLockout(user.UserId)
+
+	// Pending users are only told their account awaits approval once they
+	// have proven who they are.
+	if user.Pending {
+		loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), accountPending))
+		return
+	}
+
+	ah.startSession(w, r, user)
+}
+
+// ShowTwoFactor renders the form for the second step of a login. Without a
+// login challenge the user is sent back to the login page.
+func (ah *authHandler) ShowTwoFactor(w http.ResponseWriter, r *http.Request) {
+	_, _, ok := ah.loadChallenge(r)
+	if !ok {
+		http.Redirect(w, r, "/account", http.StatusFound)
+		return
+	}
+
+	loginCodeTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), nil))
+}
+
+// ExecTwoFactor checks the code from the user's authenticator app, or one of
+// their recovery codes, and creates a session if it is right. Wrong codes
+// count as failed logins, and the challenge is dropped after too many of them
+// so the passphrase must be entered again.
+func (ah *authHandler) ExecTwoFactor(w http.ResponseWriter, r *http.Request) {
+	token, lc, ok := ah.loadChallenge(r)
+	if !ok {
+		http.Redirect(w, r, "/account", http.StatusFound)
+		return
+	}
+
+	user, err := ah.db.GetUser(lc.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not AuthHandler.ExecTwoFactor: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	lock, err := ah.db.GetLockout(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not AuthHandler.ExecTwoFactor: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	if !lock.Locked() {
+		// Recovery codes stand in for the authenticator app of users who
+		// lost it.
+		code := r.PostFormValue("code")
+		if store.IsRecoveryCode(code) {
+			err = ah.db.UseRecoveryCode(user.UserId, code)
+		} else {
+			err = ah.db.VerifyTOTP(user.UserId, code)
+		}
+
+		// A code that could not be checked does not count as a failure.
+		if errors.Is(err, store.ErrHashBusy) {
+			e := fmt.Errorf("could not AuthHandler.ExecTwoFactor: %w", err)
+			NewUnavailableError(e).Handle(w, r)
+			return
+		}
+
+		if err == nil {
+			ah.db.DeleteLoginChallenge(token)
+			ah.db.ResetLockout(user.UserId)
+			ah.clearChallenge(w)
+
+			if user.Pending {
+				loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), accountPending))
+				return
+			}
+
+			ah.startSession(w, r, user)
+			return
+		}
+
+		lock, err = ah.db.RecordFailedAuth(user.UserId, lockoutPolicy(ah.cfg))
+		if err != nil {
+			e := fmt.Errorf("could not AuthHandler.ExecTwoFactor: %v", err)
+			NewServerError(e).Handle(w, r)
+			return
+		}
+	}
+
+	if lock.Locked() {
+		ah.db.DeleteLoginChallenge(token)
+		ah.clearChallenge(w)
+		loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), accountLocked))
+		return
+	}
+
+	usable, err := ah.db.FailLoginChallenge(token)
+	if err != nil {
+		e := fmt.Errorf("could not AuthHandler.ExecTwoFactor: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	if !usable {
+		ah.clearChallenge(w)
+		loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), challengeFailed))
+		return
+	}
+
+	loginCodeTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), invalidCode))
+}
+
+// PasskeyOptions starts a passkey sign-in ceremony and sends the options for
+// navigator.credentials.get to the browser. The user is not known until the
+// authenticator responds with one of its discoverable credentials.
+func (ah *authHandler) PasskeyOptions(w http.ResponseWriter, r *http.Request) {
+	challenge := webauthn.NewChallenge()
+
+	token, err := ah.db.CreateCeremony(store.UserToken{}, challenge, ah.cfg.LoginChallengeLength)
+	if err != nil {
+		e := fmt.Errorf("could not AuthHandler.PasskeyOptions: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	writeCeremony(w, r, token, relyingParty(ah.cfg).RequestOptions(challenge))
+}
+
+// PasskeyLogin verifies the response of the authenticator to the sign-in
+// ceremony and creates a session for the owner of the credential. User
+// verification by the authenticator takes the place of the passphrase and
+// the second factor, but locked and pending accounts cannot sign in.
+func (ah *authHandler) PasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	c, err := ah.db.TakeCeremony(r.PostFormValue("ceremony"))
+	if err != nil || c.UserId != (store.UserToken{}) {
+		loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), passkeyLoginFailed))
+		return
+	}
+
+	assertion, err := webauthn.ParseAssertion([]byte(r.PostFormValue("credential")))
+	if err != nil {
+		loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), passkeyLoginFailed))
+		return
+	}
+
+	cred, err := ah.db.GetCredential(webauthn.Encoding.EncodeToString(assertion.ID))
+	if err != nil || string(assertion.UserHandle) != cred.UserId.String() {
+		loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), passkeyLoginFailed))
+		return
+	}
+
+	count, err := relyingParty(ah.cfg).VerifyAssertion(c.Challenge, assertion, cred.PublicKey)
+	if err != nil {
+		loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), passkeyLoginFailed))
+		return
+	}
+
+	// Locked and pending accounts are refused before the passkey is marked
+	// as used.
+	user, err := ah.db.GetUser(cred.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not AuthHandler.PasskeyLogin: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
 
+	lock, err := ah.db.GetLockout(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not AuthHandler.PasskeyLogin: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	if lock.Locked() {
+		loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), accountLocked))
+		return
+	}
+
+	if user.Pending {
+		loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), accountPending))
+		return
+	}
+
+	// The counter is checked and stored together, so a cloned authenticator
+	// cannot sign in with a counter that was already seen.
+	err = ah.db.UseCredential(cred.Id, count)
+	if err != nil {
+		loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), passkeyLoginFailed))
+		return
+	}
+
+	ah.db.ResetLockout(user.UserId)
+
+	ah.startSession(w, r, user)
+}
+
+// startChallenge creates a login challenge for the user, sets the challenge
+// cookie, and sends the user to the second step of the login.
+func (ah *authHandler) startChallenge(w http.ResponseWriter, r *http.Request, user store.User) {
+	token, err := ah.db.CreateLoginChallenge(user.UserId, ah.cfg.LoginChallengeLength)
+	if err != nil {
+		e := fmt.Errorf("could not AuthHandler.startChallenge: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	cookie := http.Cookie{
+		Name:     "chal",
+		Value:    token.String(),
+		Path:     "/account",
+		MaxAge:   int(ah.cfg.LoginChallengeLength),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	http.SetCookie(w, &cookie)
+
+	http.Redirect(w, r, "/account/2fa", http.StatusFound)
+}
+
+// loadChallenge returns the token and the login challenge named by the
+// challenge cookie, and false if there is no usable challenge.
+func (ah *authHandler) loadChallenge(r *http.Request) (string, store.LoginChallenge, bool) {
+	cookie, err := r.Cookie("chal")
+	if err != nil {
+		return "", store.LoginChallenge{}, false
+	}
+
+	lc, err := ah.db.GetLoginChallenge(cookie.Value)
+	if err != nil {
+		return "", lc, false
+	}
+
+	return cookie.Value, lc, true
+}
+
+// clearChallenge removes the challenge cookie.
+func (ah *authHandler) clearChallenge(w http.ResponseWriter) {
+	cookie := http.Cookie{
+		Name:     "chal",
+		Value:    "",
+		Path:     "/account",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	http.SetCookie(w, &cookie)
+}
+
+// startSession creates a session for the user, sets the session cookie, and
+// sends the user to the site.
+func (ah *authHandler) startSession(w http.ResponseWriter, r *http.Request, user store.User) {
 	sess, err := store.NewSession(user.UserId, ah.cfg.SessionLength)
 	if err != nil {
-		e := fmt.Errorf("could not AuthHandler.Login: %v", err)
+		e := fmt.Errorf("could not AuthHandler.startSession: %v", err)
 		NewServerError(e).Handle(w, r)
 		return
 	}
@@ -114,7 +434,143 @@ func (ah *authHandler) Login(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/site", http.StatusFound)
 }
 
+// lockoutPolicy returns the LockoutPolicy described by the given Config.
+func lockoutPolicy(c *config.Config) store.LockoutPolicy {
+	return store.LockoutPolicy{
+		Threshold:   c.LockoutThreshold,
+		Duration:    c.LockoutDuration,
+		Backoff:     c.LockoutBackoff,
+		MaxDuration: c.MaxLockoutDuration,
+	}
+}
+
+// confirmPassphrase checks the passphrase a signed in user enters to confirm
+// a sensitive change, the way Login checks it. The passphrase of a locked
+// account is not checked, and a wrong one counts as a failed login, so the
+// check cannot be used to guess the passphrase. The message to show is
+// returned if the check fails. Store errors are wrapped with %w.
+func confirmPassphrase(c *config.Config, s *store.Store, uid store.UserToken, pw string) (string, error) {
+	lock, err := s.GetLockout(uid)
+	if err != nil {
+		return "", fmt.Errorf("could not confirmPassphrase: %w", err)
+	}
+
+	if lock.Locked() {
+		return accountLocked, nil
+	}
+
+	ok, err := s.AuthenticateUser(uid, pw)
+	if err != nil {
+		return "", fmt.Errorf("could not confirmPassphrase: %w", err)
+	}
+
+	if ok {
+		return "", nil
+	}
+
+	lock, err = s.RecordFailedAuth(uid, lockoutPolicy(c))
+	if err != nil {
+		return "", fmt.Errorf("could not confirmPassphrase: %w", err)
+	}
+
+	if lock.Locked() {
+		return accountLocked, nil
+	}
+
+	return invalidCredentials, nil
+}
+
+// confirmTOTP checks the code from the authenticator app a signed in user
+// enters with their passphrase to confirm a sensitive change. Like
+// confirmPassphrase, a wrong code counts as a failed login and the message to
+// show is returned if the check fails.
+func confirmTOTP(c *config.Config, s *store.Store, uid store.UserToken, code string) (string, error) {
+	if s.VerifyTOTP(uid, code) == nil {
+		return "", nil
+	}
+
+	lock, err := s.RecordFailedAuth(uid, lockoutPolicy(c))
+	if err != nil {
+		return "", fmt.Errorf("could not confirmTOTP: %w", err)
+	}
+
+	if lock.Locked() {
+		return accountLocked, nil
+	}
+
+	return invalidCode, nil
+}
+
 // NewAuthHandler creates a new authHandler object.
 func NewAuthHandler(c *config.Config, s *store.Store) *authHandler {
-	return &authHandler{cfg: c, db: s}
+	return &authHandler{cfg: c, db: s, unknown: newFailureTracker()}
+}
+
+// ----------------------------------------------------------------------------
+// Failure Tracker
+// ----------------------------------------------------------------------------
+
+// failure holds the failed count and lockout state of an unknown username.
+type failure struct {
+	failed uint64
+	lock   store.Lockout
+}
+
+// failureTracker keeps the failed logins of unknown usernames in memory, so
+// they can be locked out by the same policy as the accounts in the Store.
+type failureTracker struct {
+	mu       sync.Mutex
+	failures map[string]failure
+}
+
+// key returns the canonical form of the username, so every spelling of an
+// unknown username shares one entry.
+func (ft *failureTracker) key(alias string) string {
+	canonical, err := store.CanonicalAlias(alias)
+	if err != nil {
+		return alias
+	}
+
+	return canonical
+}
+
+// locked returns true if the unknown username is locked.
+func (ft *failureTracker) locked(alias string) bool {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	return ft.failures[ft.key(alias)].lock.Locked()
+}
+
+// fail counts a failed login for the unknown username and returns its failed
+// count and lockout state. When the tracker is full, usernames that are not
+// locked are forgotten first.
+func (ft *failureTracker) fail(alias string, p store.LockoutPolicy) (uint64, store.Lockout) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	key := ft.key(alias)
+
+	if _, ok := ft.failures[key]; !ok && len(ft.failures) >= maxUnknownAliases {
+		for k, f := range ft.failures {
+			if !f.lock.Locked() {
+				delete(ft.failures, k)
+			}
+		}
+
+		if len(ft.failures) >= maxUnknownAliases {
+			ft.failures = make(map[string]failure)
+		}
+	}
+
+	f := ft.failures[key]
+	f.failed, f.lock = p.Fail(f.failed, f.lock, time.Now().Unix())
+	ft.failures[key] = f
+
+	return f.failed, f.lock
+}
+
+// newFailureTracker creates an empty failureTracker.
+func newFailureTracker() *failureTracker {
+	return &failureTracker{failures: make(map[string]failure)}
 }
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"net/mail"
+
+	"github.com/asggo/wasp/config"
+	wmail "github.com/asggo/wasp/mail"
+	"github.com/asggo/wasp/store"
+)
+
+var (
+	emailTmpl          = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/email.html"))
+	verifyTmpl         = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/verify.html"))
+	emailInvalid       = "The email address is not valid."
+	emailTaken         = "Email address is already in use."
+	emailVerified      = "Your email address has been verified."
+	emailNotVerified   = "The verification link is invalid or has expired."
+	emailVerifySent    = "A verification link has been sent to your email address."
+	emailUnchanged     = "Your email address has not changed."
+	emailChangeSubject = "Your email address is being changed"
+	emailChangeBody    = "A change of the email address of your account to %s was requested. Your address is not changed until the new one is verified. If you did not ask for this, change your passphrase."
+	emailVerifySubject = "Verify your email address"
+	emailVerifyBody    = "Visit the link below to verify your email address. The link expires in %d minutes.\n\n%s/account/verify?token=%s"
+)
+
+// emailPage holds the data needed to render the email page.
+type emailPage struct {
+	Error interface{}
+	User  store.User
+}
+
+// validEmail returns true if the given string is a bare email address.
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return false
+	}
+
+	return addr.Address == email
+}
+
+// sendEmailVerification creates a verification for the address returned by
+// EmailToVerify for the given user and mails the verification link to that
+// address.
+func sendEmailVerification(c *config.Config, s *store.Store, m wmail.Mailer, uid store.UserToken) error {
+	user, err := s.GetUser(uid)
+	if err != nil {
+		return fmt.Errorf("could not sendEmailVerification: %v", err)
+	}
+
+	token, err := s.CreateEmailVerification(uid, c.EmailVerificationLength)
+	if err != nil {
+		return fmt.Errorf("could not sendEmailVerification: %v", err)
+	}
+
+	body := fmt.Sprintf(emailVerifyBody, c.EmailVerificationLength/60, c.BaseURL, token)
+
+	err = m.Send(user.EmailToVerify(), emailVerifySubject, body)
+	if err != nil {
+		return fmt.Errorf("could not sendEmailVerification: %v", err)
+	}
+
+	return nil
+}
@@ -1,10 +1,14 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/asggo/wasp/store"
 	"github.com/go-chi/httplog/v2"
 )
 
@@ -13,6 +17,9 @@ const (
 	unauthorizedError   = "You are not authorized to access this content."
 	forbiddenError      = "Access to this content is forbidden."
 	notFoundError       = "The page you are looking for was not found."
+	tooLargeError       = "The request is too large."
+	tooManyError        = "Too many requests. Try again in a moment."
+	unavailableError    = "The server is busy. Please try your request again in a moment."
 	internalServerError = "Server error. Please try your request again later."
 )
 
@@ -20,10 +27,15 @@ var (
 	errTmpl = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/error.html"))
 )
 
+// unavailableRetry is the number of seconds clients are asked to wait before
+// retrying a request refused because the server is busy.
+const unavailableRetry = 1
+
 type errorHandler struct {
-	status  int
-	message string
-	err     error
+	status     int
+	message    string
+	err        error
+	retryAfter int
 }
 
 func (eh errorHandler) Handle(w http.ResponseWriter, r *http.Request) {
@@ -32,6 +44,10 @@ func (eh errorHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		oplog.Error(fmt.Sprintf("%v", eh.err))
 	}
 
+	if eh.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(eh.retryAfter))
+	}
+
 	w.WriteHeader(eh.status)
 	errTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), eh.message))
 }
@@ -68,6 +84,26 @@ func NewNotFoundError(err error) errorHandler {
 	}
 }
 
+func NewTooLargeError(err error) errorHandler {
+	return errorHandler{
+		status:  http.StatusRequestEntityTooLarge,
+		message: tooLargeError,
+		err:     err,
+	}
+}
+
+// NewTooManyRequestsError returns the error handler for a request refused
+// by a rate limit. Clients are asked to wait the given time, rounded up to
+// whole seconds.
+func NewTooManyRequestsError(err error, retry time.Duration) errorHandler {
+	return errorHandler{
+		status:     http.StatusTooManyRequests,
+		message:    tooManyError,
+		err:        err,
+		retryAfter: max(int((retry+time.Second-1)/time.Second), 1),
+	}
+}
+
 func NewServerError(err error) errorHandler {
 	return errorHandler{
 		status:  http.StatusInternalServerError,
@@ -76,6 +112,27 @@ func NewServerError(err error) errorHandler {
 	}
 }
 
+func NewUnavailableError(err error) errorHandler {
+	return errorHandler{
+		status:     http.StatusServiceUnavailable,
+		message:    unavailableError,
+		err:        err,
+		retryAfter: unavailableRetry,
+	}
+}
+
+// NewStoreError returns the error handler for a failed Store call. A full
+// passphrase hashing queue is reported as unavailable so the client tries
+// again, and any other error as a server error. The error must wrap the
+// Store error with %w.
+func NewStoreError(err error) errorHandler {
+	if errors.Is(err, store.ErrHashBusy) {
+		return NewUnavailableError(err)
+	}
+
+	return NewServerError(err)
+}
+
 func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 	NewNotFoundError(nil).Handle(w, r)
 }
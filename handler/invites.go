@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/store"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	invitesTmpl       = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/invites.html"))
+	inviteUsesInvalid = "Invites must allow between 1 and 1000 uses."
+	inviteDaysInvalid = "Invites must be valid for between 1 and 365 days."
+	inviteRoleDenied  = "You cannot invite users with a role that has permissions you do not have."
+	inviteRevoked     = "The invite has been revoked."
+)
+
+// inviteRow holds an invite and the alias of the user who issued it.
+type inviteRow struct {
+	Invite store.Invite
+	Issuer string
+}
+
+// invitesPage holds the data needed to render the invitation management page.
+type invitesPage struct {
+	Error   interface{}
+	Message string
+	Mode    string
+	Days    int64
+	Roles   []string
+	Invites []inviteRow
+	Code    string
+	Link    string
+}
+
+// invitesHandler provides handlers for managing registration invites in the
+// /admin/invites path.
+type invitesHandler struct {
+	cfg *config.Config
+	db  *store.Store
+}
+
+// Index renders the invites and a form to create a new invite.
+func (ih *invitesHandler) Index(w http.ResponseWriter, r *http.Request) {
+	ih.render(w, r, invitesPage{})
+}
+
+// CreateInvite creates a new invite with the role, use limit, and lifetime in
+// the form. The invite code is only shown once, along with a registration
+// link that includes it.
+func (ih *invitesHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(store.User)
+
+	role := r.PostFormValue("role")
+
+	uses, err := strconv.Atoi(r.PostFormValue("uses"))
+	if err != nil || uses < 1 || uses > 1000 {
+		ih.render(w, r, invitesPage{Error: inviteUsesInvalid})
+		return
+	}
+
+	days, err := strconv.ParseInt(r.PostFormValue("days"), 10, 64)
+	if err != nil || days < 1 || days > 365 {
+		ih.render(w, r, invitesPage{Error: inviteDaysInvalid})
+		return
+	}
+
+	if !ih.canGrant(r, role) {
+		ih.render(w, r, invitesPage{Error: inviteRoleDenied})
+		return
+	}
+
+	token, err := ih.db.CreateInvite(user.UserId, role, uses, days*60*60*24)
+	if err != nil {
+		e := fmt.Errorf("could not InvitesHandler.CreateInvite: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	v := url.Values{}
+	v.Set("invite", token.String())
+
+	page := invitesPage{
+		Code: token.String(),
+		Link: ih.cfg.BaseURL + "/account/register?" + v.Encode(),
+	}
+
+	ih.render(w, r, page)
+}
+
+// RevokeInvite removes the invite identified by the id in the request path.
+func (ih *invitesHandler) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	err := ih.db.RevokeInvite(chi.URLParam(r, "id"))
+	if err != nil {
+		e := fmt.Errorf("could not InvitesHandler.RevokeInvite: %v", err)
+		NewNotFoundError(e).Handle(w, r)
+		return
+	}
+
+	ih.render(w, r, invitesPage{Message: inviteRevoked})
+}
+
+// canGrant returns true if the user in the request context has every
+// permission of the given role, so invites cannot be used to give new users
+// more access than the user issuing them.
+func (ih *invitesHandler) canGrant(r *http.Request, name string) bool {
+	role, err := ih.db.GetRole(name)
+	if err != nil {
+		return false
+	}
+
+	return holdsAll(r, role.Permissions)
+}
+
+// render fills in the invites and the roles the user can grant and renders
+// the given page.
+func (ih *invitesHandler) render(w http.ResponseWriter, r *http.Request, page invitesPage) {
+	invites, err := ih.db.ListInvites()
+
+	var roles []store.Role
+	if err == nil {
+		roles, err = ih.db.ListRoles()
+	}
+
+	if err != nil {
+		e := fmt.Errorf("could not InvitesHandler.render: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	page.Mode = ih.cfg.RegistrationMode
+	page.Days = ih.cfg.InviteLength / (60 * 60 * 24)
+
+	for _, role := range roles {
+		if ih.canGrant(r, role.Name) {
+			page.Roles = append(page.Roles, role.Name)
+		}
+	}
+
+	for _, inv := range invites {
+		row := inviteRow{Invite: inv, Issuer: inv.IssuedBy.String()}
+
+		issuer, err := ih.db.GetUser(inv.IssuedBy)
+		if err == nil {
+			row.Issuer = issuer.Alias
+		}
+
+		page.Invites = append(page.Invites, row)
+	}
+
+	invitesTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// NewInvitesHandler creates a new invitesHandler with the given Config and
+// Store.
+func NewInvitesHandler(c *config.Config, s *store.Store) *invitesHandler {
+	return &invitesHandler{cfg: c, db: s}
+}
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/store"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	orgsTmpl           = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/orgs.html"))
+	orgTmpl            = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/org.html"))
+	orgNameInvalid     = "Organization names must be 1 to 64 characters."
+	memberAdded        = "If a user with that username exists, they are now a member."
+	memberSaved        = "The member's roles have been saved."
+	memberRemoved      = "The member has been removed."
+	orgManagerRequired = "The organization must keep a member who can manage it."
+)
+
+// memberRow holds a member of an organization and the roles they can be
+// given.
+type memberRow struct {
+	User  store.User
+	Roles []roleOption
+}
+
+// orgsPage holds the data needed to render the organization list.
+type orgsPage struct {
+	Error interface{}
+	Orgs  []store.Organization
+}
+
+// orgPage holds the data needed to render an organization.
+type orgPage struct {
+	Error   interface{}
+	Message string
+	Org     store.Organization
+	Roles   []string
+	Members []memberRow
+	Orgs    []store.Organization
+}
+
+// orgHandler provides handlers for the /orgs path and the organization
+// scoped /org/{org} path.
+type orgHandler struct {
+	cfg *config.Config
+	db  *store.Store
+}
+
+// Index renders the organizations the user is a member of, which lets users
+// in several organizations switch between them, and a form to create a new
+// organization.
+func (oh *orgHandler) Index(w http.ResponseWriter, r *http.Request) {
+	oh.renderOrgs(w, r, nil)
+}
+
+// CreateOrg creates a new organization owned by the user.
+func (oh *orgHandler) CreateOrg(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(store.User)
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" || utf8.RuneCountInString(name) > 64 {
+		oh.renderOrgs(w, r, orgNameInvalid)
+		return
+	}
+
+	org, err := oh.db.CreateOrg(name, user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not OrgHandler.CreateOrg: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	http.Redirect(w, r, "/site/org/"+org.OrgId.String(), http.StatusFound)
+}
+
+// Show renders the organization in the request context along with its
+// members.
+func (oh *orgHandler) Show(w http.ResponseWriter, r *http.Request) {
+	oh.renderOrg(w, r, nil, "")
+}
+
+// AddMember adds the user with the alias in the form to the organization in
+// the request context with the user role. The same message is shown whether
+// or not the user exists or is already a member, so the form cannot be used
+// to find usernames.
+func (oh *orgHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	scoped := r.Context().Value("orgstore").(store.OrgStore)
+
+	user, err := oh.db.GetUserByAlias(r.PostFormValue("alias"))
+	if err == nil {
+		scoped.AddMember(user.UserId, []string{store.RoleUser})
+	}
+
+	oh.renderOrg(w, r, nil, memberAdded)
+}
+
+// SetMemberRoles replaces the roles of a member of the organization in the
+// request context with the roles selected in the form. Only the roles in
+// store.OrgRoles can be given within an organization.
+func (oh *orgHandler) SetMemberRoles(w http.ResponseWriter, r *http.Request) {
+	scoped := r.Context().Value("orgstore").(store.OrgStore)
+
+	user, ok := oh.loadMember(w, r)
+	if !ok {
+		return
+	}
+
+	r.ParseForm()
+	roles := r.Form["role"]
+
+	for _, name := range roles {
+		if !store.IsOrgRole(name) {
+			oh.renderOrg(w, r, roleNotFound, "")
+			return
+		}
+	}
+
+	err := scoped.SetMemberRoles(user.UserId, roles)
+	if err != nil {
+		oh.renderOrg(w, r, orgManagerRequired, "")
+		return
+	}
+
+	oh.renderOrg(w, r, nil, memberSaved)
+}
+
+// RemoveMember removes a member from the organization in the request
+// context.
+func (oh *orgHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	scoped := r.Context().Value("orgstore").(store.OrgStore)
+
+	user, ok := oh.loadMember(w, r)
+	if !ok {
+		return
+	}
+
+	err := scoped.RemoveMember(user.UserId)
+	if err != nil {
+		oh.renderOrg(w, r, orgManagerRequired, "")
+		return
+	}
+
+	// A user who removes themselves can no longer see the organization.
+	current := r.Context().Value("user").(store.User)
+	if user.UserId == current.UserId {
+		http.Redirect(w, r, "/site/orgs", http.StatusFound)
+		return
+	}
+
+	oh.renderOrg(w, r, nil, memberRemoved)
+}
+
+// loadMember returns the member of the organization in the request context
+// identified by the id in the request path. If the user is not a member, a
+// not found error is rendered and false is returned.
+func (oh *orgHandler) loadMember(w http.ResponseWriter, r *http.Request) (store.User, bool) {
+	org := r.Context().Value("org").(store.Organization)
+
+	user, err := oh.db.GetUserById(chi.URLParam(r, "id"))
+	if err == nil {
+		_, err = oh.db.GetMembership(org.OrgId, user.UserId)
+	}
+
+	if err != nil {
+		e := fmt.Errorf("could not OrgHandler.loadMember: %v", err)
+		NewNotFoundError(e).Handle(w, r)
+		return user, false
+	}
+
+	return user, true
+}
+
+// renderOrgs renders the organization list with the given error.
+func (oh *orgHandler) renderOrgs(w http.ResponseWriter, r *http.Request, e interface{}) {
+	user := r.Context().Value("user").(store.User)
+
+	orgs, err := oh.db.GetUserOrgs(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not OrgHandler.renderOrgs: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	orgsTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), orgsPage{Error: e, Orgs: orgs}))
+}
+
+// renderOrg renders the organization in the request context with the given
+// error and message. Members are read through the OrgStore so only members
+// of the organization can be listed.
+func (oh *orgHandler) renderOrg(w http.ResponseWriter, r *http.Request, e interface{}, msg string) {
+	user := r.Context().Value("user").(store.User)
+	org := r.Context().Value("org").(store.Organization)
+	scoped := r.Context().Value("orgstore").(store.OrgStore)
+
+	page := orgPage{Error: e, Message: msg, Org: org}
+
+	members, err := scoped.Members()
+	if err == nil {
+		page.Orgs, err = oh.db.GetUserOrgs(user.UserId)
+	}
+
+	if err != nil {
+		e := fmt.Errorf("could not OrgHandler.renderOrg: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	for _, m := range members {
+		u, err := oh.db.GetUser(m.UserId)
+		if err != nil {
+			continue
+		}
+
+		row := memberRow{User: u}
+		for _, name := range store.OrgRoles {
+			row.Roles = append(row.Roles, roleOption{name, m.HasRole(name)})
+		}
+
+		if u.UserId == user.UserId {
+			page.Roles = m.Roles
+		}
+
+		page.Members = append(page.Members, row)
+	}
+
+	orgTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// NewOrgHandler creates a new orgHandler with the given Config and Store.
+func NewOrgHandler(c *config.Config, s *store.Store) *orgHandler {
+	return &orgHandler{cfg: c, db: s}
+}
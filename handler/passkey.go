@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/store"
+	"github.com/asggo/wasp/webauthn"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxPasskeyName limits the length of the names users give their passkeys.
+const maxPasskeyName = 64
+
+var (
+	passkeyTmpl = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/passkeys.html"))
+
+	passkeyAdded    = "Your passkey has been added."
+	passkeyRemoved  = "The passkey has been removed."
+	passkeyFailed   = "The passkey could not be added. Try again."
+	passkeyNotFound = "Passkey not found."
+	passkeyName     = "Passkey names must be at most %d characters."
+)
+
+// passkeyPage holds the data needed to render the passkeys page. TOTP is
+// true if a code from the user's authenticator app is needed to add a
+// passkey.
+type passkeyPage struct {
+	Error       interface{}
+	Message     string
+	TOTP        bool
+	Credentials []store.Credential
+}
+
+// ceremonyOptions holds the options sent to the browser to start a WebAuthn
+// ceremony. The ceremony token is sent back with the response of the
+// authenticator so the challenge can be found.
+type ceremonyOptions struct {
+	Ceremony  string      `json:"ceremony"`
+	PublicKey interface{} `json:"publicKey"`
+}
+
+// passkeyHandler provides handlers for registering and removing the WebAuthn
+// credentials of the user in the /site/user/passkeys path.
+type passkeyHandler struct {
+	cfg *config.Config
+	db  *store.Store
+}
+
+// Index renders the passkeys registered by the user and the form to add one.
+func (ph *passkeyHandler) Index(w http.ResponseWriter, r *http.Request) {
+	ph.render(w, r, nil, "")
+}
+
+// Options starts a registration ceremony and sends the options for
+// navigator.credentials.create to the browser. The user must confirm their
+// passphrase, and a code from their authenticator app if they use one, so a
+// stolen session cannot add a passkey. Wrong ones count as failed logins. The
+// user's existing credentials are excluded so an authenticator is only
+// registered once.
+func (ph *passkeyHandler) Options(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(store.User)
+
+	enabled, err := ph.db.TOTPEnabled(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not PasskeyHandler.Options: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	msg, err := confirmPassphrase(ph.cfg, ph.db, user.UserId, r.PostFormValue("password"))
+	if err == nil && msg == "" && enabled {
+		msg, err = confirmTOTP(ph.cfg, ph.db, user.UserId, r.PostFormValue("code"))
+	}
+
+	if err != nil {
+		e := fmt.Errorf("could not PasskeyHandler.Options: %w", err)
+		NewStoreError(e).Handle(w, r)
+		return
+	}
+
+	if msg != "" {
+		writeCeremonyError(w, msg)
+		return
+	}
+
+	creds, err := ph.db.GetUserCredentials(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not PasskeyHandler.Options: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	var exclude [][]byte
+	for _, c := range creds {
+		id, err := webauthn.Encoding.DecodeString(c.Id)
+		if err == nil {
+			exclude = append(exclude, id)
+		}
+	}
+
+	challenge := webauthn.NewChallenge()
+
+	token, err := ph.db.CreateCeremony(user.UserId, challenge, ph.cfg.LoginChallengeLength)
+	if err != nil {
+		e := fmt.Errorf("could not PasskeyHandler.Options: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	rp := relyingParty(ph.cfg)
+	opts := rp.CreationOptions(challenge, []byte(user.UserId.String()), user.Alias, exclude)
+
+	writeCeremony(w, r, token, opts)
+}
+
+// Register verifies the response of the authenticator to the registration
+// ceremony and stores the new credential under the name the user chose. The
+// ceremony must have been started by Options for the user, so the user has
+// confirmed who they are.
+func (ph *passkeyHandler) Register(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(store.User)
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+
+	if utf8.RuneCountInString(name) > maxPasskeyName {
+		ph.render(w, r, fmt.Sprintf(passkeyName, maxPasskeyName), "")
+		return
+	}
+
+	c, err := ph.db.TakeCeremony(r.PostFormValue("ceremony"))
+	if err != nil || c.UserId != user.UserId {
+		ph.render(w, r, passkeyFailed, "")
+		return
+	}
+
+	reg, err := relyingParty(ph.cfg).Register(c.Challenge, []byte(r.PostFormValue("credential")))
+	if err != nil {
+		ph.render(w, r, passkeyFailed, "")
+		return
+	}
+
+	cred := store.Credential{
+		Id:        webauthn.Encoding.EncodeToString(reg.ID),
+		UserId:    user.UserId,
+		Name:      name,
+		PublicKey: reg.PublicKey,
+		SignCount: reg.SignCount,
+	}
+
+	err = ph.db.AddCredential(cred)
+	if err != nil {
+		ph.render(w, r, passkeyFailed, "")
+		return
+	}
+
+	ph.render(w, r, nil, passkeyAdded)
+}
+
+// Delete removes one of the user's passkeys.
+func (ph *passkeyHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(store.User)
+
+	err := ph.db.DeleteCredential(user.UserId, chi.URLParam(r, "id"))
+	if err != nil {
+		ph.render(w, r, passkeyNotFound, "")
+		return
+	}
+
+	ph.render(w, r, nil, passkeyRemoved)
+}
+
+// render renders the passkeys page with the given error and message.
+func (ph *passkeyHandler) render(w http.ResponseWriter, r *http.Request, e interface{}, msg string) {
+	user := r.Context().Value("user").(store.User)
+
+	creds, err := ph.db.GetUserCredentials(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not PasskeyHandler.render: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	enabled, err := ph.db.TOTPEnabled(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not PasskeyHandler.render: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	page := passkeyPage{Error: e, Message: msg, TOTP: enabled, Credentials: creds}
+
+	passkeyTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// relyingParty returns the WebAuthn relying party of the site. Credentials
+// are scoped to the host of the base URL, and ceremonies must run on pages
+// from its origin.
+func relyingParty(c *config.Config) webauthn.RelyingParty {
+	rp := webauthn.RelyingParty{Name: c.WebAuthnRPName, Timeout: c.LoginChallengeLength}
+
+	u, err := url.Parse(c.BaseURL)
+	if err == nil {
+		rp.ID = u.Hostname()
+		rp.Origin = u.Scheme + "://" + u.Host
+	}
+
+	return rp
+}
+
+// writeCeremony sends the ceremony token and options to the browser as JSON.
+func writeCeremony(w http.ResponseWriter, r *http.Request, token store.CeremonyToken, opts interface{}) {
+	data, err := json.Marshal(ceremonyOptions{Ceremony: token.String(), PublicKey: opts})
+	if err != nil {
+		e := fmt.Errorf("could not writeCeremony: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// writeCeremonyError tells the browser why a ceremony could not be started.
+// The message is sent as JSON so it can be shown on the page.
+func writeCeremonyError(w http.ResponseWriter, msg string) {
+	data, _ := json.Marshal(map[string]string{"error": msg})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(data)
+}
+
+// NewPasskeyHandler returns a new passkeyHandler object.
+func NewPasskeyHandler(c *config.Config, s *store.Store) *passkeyHandler {
+	return &passkeyHandler{cfg: c, db: s}
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/mail"
+	"github.com/asggo/wasp/store"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	pendingTmpl     = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/pending.html"))
+	pendingTooLong  = "Messages must be at most 1000 characters."
+	pendingApproved = "The account has been approved."
+	pendingRejected = "The account has been rejected."
+	approvedSubject = "Your account has been approved"
+	approvedBody    = "Your account has been approved and you can now log in at the link below.\n\n%s/account"
+	rejectedSubject = "Your account request has been rejected"
+	rejectedBody    = "Your account request has been rejected and the account has been removed."
+)
+
+// pendingPage holds the data needed to render the pending users page.
+type pendingPage struct {
+	Error   interface{}
+	Message string
+	Users   []store.User
+}
+
+// pendingHandler provides handlers for approving and rejecting the accounts
+// in the /admin/pending path.
+type pendingHandler struct {
+	cfg    *config.Config
+	db     *store.Store
+	mailer mail.Mailer
+}
+
+// Index renders the users awaiting approval.
+func (ph *pendingHandler) Index(w http.ResponseWriter, r *http.Request) {
+	ph.render(w, r, nil, "")
+}
+
+// Approve allows the pending user identified by the id in the request path
+// to log in. The approval is recorded in the user's activity and the user is
+// told by email, along with the optional message in the form.
+func (ph *pendingHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	user, msg, ok := ph.loadPending(w, r)
+	if !ok {
+		return
+	}
+
+	admin := r.Context().Value("user").(store.User)
+
+	err := ph.db.ApproveUser(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not PendingHandler.Approve: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	detail := fmt.Sprintf("approved by %s", admin.Alias)
+
+	err = ph.db.AddActivity(user.UserId, store.ActivityAdminAction, detail)
+	if err != nil {
+		e := fmt.Errorf("could not PendingHandler.Approve: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	ph.notify(user, approvedSubject, fmt.Sprintf(approvedBody, ph.cfg.BaseURL), msg)
+	ph.render(w, r, nil, pendingApproved)
+}
+
+// Reject removes the pending user identified by the id in the request path.
+// The user is told by email, along with the optional message in the form.
+func (ph *pendingHandler) Reject(w http.ResponseWriter, r *http.Request) {
+	user, msg, ok := ph.loadPending(w, r)
+	if !ok {
+		return
+	}
+
+	err := ph.db.DeleteUser(user)
+	if err != nil {
+		e := fmt.Errorf("could not PendingHandler.Reject: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	ph.notify(user, rejectedSubject, rejectedBody, msg)
+	ph.render(w, r, nil, pendingRejected)
+}
+
+// loadPending returns the pending user identified by the id in the request
+// path and the message in the form. If the user cannot be found or is not
+// pending, a not found error is rendered, and if the message is too long the
+// page is rendered with an error. In both cases false is returned.
+func (ph *pendingHandler) loadPending(w http.ResponseWriter, r *http.Request) (store.User, string, bool) {
+	user, err := ph.db.GetUserById(chi.URLParam(r, "id"))
+	if err == nil && !user.Pending {
+		err = fmt.Errorf("user %s is not pending", user.UserId)
+	}
+
+	if err != nil {
+		e := fmt.Errorf("could not PendingHandler.loadPending: %v", err)
+		NewNotFoundError(e).Handle(w, r)
+		return user, "", false
+	}
+
+	msg := strings.TrimSpace(r.PostFormValue("message"))
+	if utf8.RuneCountInString(msg) > 1000 {
+		ph.render(w, r, pendingTooLong, "")
+		return user, "", false
+	}
+
+	return user, msg, true
+}
+
+// notify mails the given subject and body to the user, followed by the
+// message from the admin if there is one. Users without an email address are
+// not told. Errors are logged because the decision has already been made.
+func (ph *pendingHandler) notify(user store.User, subject, body, msg string) {
+	if user.Email == "" {
+		return
+	}
+
+	if msg != "" {
+		body = body + "\n\nMessage from the administrator:\n\n" + msg
+	}
+
+	err := ph.mailer.Send(user.Email, subject, body)
+	if err != nil {
+		slog.Error(fmt.Sprintf("could not PendingHandler.notify: %v", err))
+	}
+}
+
+// render renders the users awaiting approval with the given error and
+// message.
+func (ph *pendingHandler) render(w http.ResponseWriter, r *http.Request, e interface{}, msg string) {
+	users, err := ph.db.ListPendingUsers()
+	if err != nil {
+		e := fmt.Errorf("could not PendingHandler.render: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	page := pendingPage{Error: e, Message: msg, Users: users}
+
+	pendingTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// NewPendingHandler creates a new pendingHandler using the given Config,
+// Store, and Mailer.
+func NewPendingHandler(c *config.Config, s *store.Store, m mail.Mailer) *pendingHandler {
+	return &pendingHandler{cfg: c, db: s, mailer: m}
+}
@@ -1,11 +1,18 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
+	"log/slog"
 	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/mail"
+	"github.com/asggo/wasp/passphrase"
 	"github.com/asggo/wasp/store"
 )
 
@@ -13,60 +20,271 @@ var (
 	regTmpl          = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/register.html"))
 	regAdminTmpl     = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/register_admin.html"))
 	passwordNotMatch = "The passwords do not match."
-	passwordTooShort = "The password must be at least %d characters."
 	usernameTooShort = "The username must be at least %d characters."
+	usernameTooLong  = "The username must be at most %d characters."
+	usernameChars    = "The username may only contain %s."
+	usernameReserved = "That username is reserved."
+	usernameDenied   = "That username is not allowed."
 	usernameTaken    = "Username is already taken."
+	usernameInvalid  = "The username contains characters that are not allowed."
+	adminAliasFixed  = "The admin account cannot be renamed."
+	regClosed        = "Registration is closed."
+	inviteInvalid    = "A valid invite code is required to register."
+	accountCreated   = "Your account has been created and is awaiting approval by an administrator."
 )
 
+// usernameClassNames describes each username character class in errors.
+var usernameClassNames = map[string]string{
+	config.UsernameLetters:     "letters",
+	config.UsernameDigits:      "digits",
+	config.UsernamePunctuation: "periods, hyphens, and underscores",
+}
+
+// registerPage holds the data needed to render the registration page.
+type registerPage struct {
+	Error   interface{}
+	Message string
+	Mode    string
+	Invite  string
+}
+
+// validatePassphrase checks a new passphrase for the user with the given
+// alias against the passphrase policy, and checks its confirmation. An error
+// describing the first problem found is returned.
+func validatePassphrase(c *config.Config, alias, pw, cn string) error {
+	err := passphrasePolicy(c).Validate(pw, passphrase.Subject{Alias: alias})
+	if err != nil {
+		return err
+	}
+
+	if pw != cn {
+		return errors.New(passwordNotMatch)
+	}
+
+	return nil
+}
+
+// passphrasePolicy returns the passphrase policy of the site. The breach
+// list is only checked when one is loaded, and the checks in
+// PassphraseChecks run after the built-in ones.
+func passphrasePolicy(c *config.Config) passphrase.Policy {
+	p := passphrase.Policy{
+		passphrase.MinLength(c.MinPassphraseLength),
+		passphrase.NoAlias(),
+		passphrase.MinEntropy(c.MinPassphraseEntropy),
+	}
+
+	if c.BreachList != nil {
+		p = append(p, passphrase.NotBreached(c.BreachList))
+	}
+
+	return append(p, c.PassphraseChecks...)
+}
+
+// validateUsername checks a new username against the username policy and
+// returns its canonical form. An error describing the first problem found is
+// returned. The admin username is always reserved. Reserved names and deny
+// list terms are also matched against the skeleton of the username, so
+// lookalikes are refused too.
+func validateUsername(c *config.Config, un string) (string, error) {
+	un, err := store.CanonicalAlias(un)
+	if err != nil {
+		return un, errors.New(usernameInvalid)
+	}
+
+	length := utf8.RuneCountInString(un)
+
+	if length < c.MinUsernameLength {
+		return un, fmt.Errorf(usernameTooShort, c.MinUsernameLength)
+	}
+
+	if length > c.MaxUsernameLength {
+		return un, fmt.Errorf(usernameTooLong, c.MaxUsernameLength)
+	}
+
+	for _, r := range un {
+		if !usernameRuneAllowed(c.UsernameClasses, r) {
+			var names []string
+			for _, class := range c.UsernameClasses {
+				names = append(names, usernameClassNames[class])
+			}
+
+			return un, fmt.Errorf(usernameChars, strings.Join(names, ", "))
+		}
+	}
+
+	// Reserved names are refused along with their lookalikes and variants
+	// padded with digits or split with punctuation, such as admin1 or
+	// ad.min.
+	skeleton := store.AliasSkeleton(un)
+	variant := store.AliasSkeleton(strings.Trim(strings.Map(dropPunctuation, un), "0123456789"))
+
+	reserved := append([]string{"admin"}, c.ReservedUsernames...)
+	for _, name := range reserved {
+		name = store.AliasSkeleton(name)
+		if skeleton == name || variant == name {
+			return un, errors.New(usernameReserved)
+		}
+	}
+
+	for _, term := range c.UsernameDenyList {
+		if strings.Contains(un, term) || strings.Contains(skeleton, store.AliasSkeleton(term)) {
+			return un, errors.New(usernameDenied)
+		}
+	}
+
+	return un, nil
+}
+
+// dropPunctuation removes the punctuation allowed in usernames when used with
+// strings.Map.
+func dropPunctuation(r rune) rune {
+	if r == '.' || r == '-' || r == '_' {
+		return -1
+	}
+
+	return r
+}
+
+// usernameRuneAllowed returns true if the rune belongs to one of the given
+// username character classes.
+func usernameRuneAllowed(classes []string, r rune) bool {
+	for _, class := range classes {
+		switch class {
+		case config.UsernameLetters:
+			if unicode.IsLetter(r) || unicode.IsMark(r) {
+				return true
+			}
+		case config.UsernameDigits:
+			if unicode.IsDigit(r) {
+				return true
+			}
+		case config.UsernamePunctuation:
+			if r == '.' || r == '-' || r == '_' {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 // registerHandler provides handlers for all of the endpoints in the /register
 // path.
 type registerHandler struct {
-	cfg *config.Config
-	db  *store.Store
+	cfg    *config.Config
+	db     *store.Store
+	mailer mail.Mailer
 }
 
-// Index renders the index page of the /register path.
+// Index renders the index page of the /register path. An invite code in the
+// query string is filled in on the registration form.
 func (rh *registerHandler) Index(w http.ResponseWriter, r *http.Request) {
-	regTmpl.ExecuteTemplate(w, "layout", nil)
+	var e interface{}
+
+	if rh.cfg.RegistrationMode == config.RegistrationClosed {
+		e = regClosed
+	}
+
+	rh.render(w, r, r.URL.Query().Get("invite"), e)
 }
 
 // Register creates a new User in the Store using the information provided in
-// the registration form.
+// the registration form. Depending on the registration mode, a valid invite
+// code is required or registration is refused. When approval is required,
+// users who register without an invite cannot log in until an admin approves
+// them.
 func (rh *registerHandler) Register(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 
-	un := r.Form.Get("username")
 	pw := r.Form.Get("password")
 	cn := r.Form.Get("confirm")
+	em := store.NormalizeEmail(r.Form.Get("email"))
+	ic := r.Form.Get("invite")
 
-	if len(un) < rh.cfg.MinUsernameLength {
-		e := fmt.Errorf(usernameTooShort, rh.cfg.MinUsernameLength)
-		regTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), e))
+	invited := rh.cfg.RegistrationMode == config.RegistrationInvite
+
+	if rh.cfg.RegistrationMode == config.RegistrationClosed {
+		rh.render(w, r, ic, regClosed)
 		return
 	}
 
-	if len(pw) < rh.cfg.MinPassphraseLength {
-		e := fmt.Errorf(passwordTooShort, rh.cfg.MinPassphraseLength)
-		regTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), e))
+	if invited && !rh.db.InviteValid(ic) {
+		rh.render(w, r, ic, inviteInvalid)
 		return
 	}
 
-	if pw != cn {
-		regTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), passwordNotMatch))
+	// Usernames are checked and stored in their canonical form.
+	un, err := validateUsername(rh.cfg, r.Form.Get("username"))
+	if err != nil {
+		rh.render(w, r, ic, err)
+		return
+	}
+
+	err = validatePassphrase(rh.cfg, un, pw, cn)
+	if err != nil {
+		rh.render(w, r, ic, err)
+		return
+	}
+
+	if rh.db.UserExists(un) || rh.db.AliasReserved(un, store.UserToken{}) || rh.db.AliasConfusable(un, store.UserToken{}) {
+		rh.render(w, r, ic, usernameTaken)
+		return
+	}
+
+	// The email address is optional.
+	if em != "" && !validEmail(em) {
+		rh.render(w, r, ic, emailInvalid)
 		return
 	}
 
-	if rh.db.UserExists(un) {
-		regTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), usernameTaken))
+	if em != "" && rh.db.EmailExists(em) {
+		rh.render(w, r, ic, emailTaken)
 		return
 	}
 
+	// The email address is stored with the user, so a taken address does
+	// not leave a user behind.
 	user := store.NewUser(un)
+	user.Pending = rh.cfg.RequireApproval && !invited
+	user.Email = em
+
+	if invited {
+		// The invite may have been used up since it was checked.
+		err = rh.db.CreateInvitedUser(user, pw, ic)
+		if errors.Is(err, store.ErrHashBusy) {
+			e := fmt.Errorf("could not RegisterHandler.Register: %w", err)
+			NewUnavailableError(e).Handle(w, r)
+			return
+		}
+
+		if err != nil {
+			rh.render(w, r, ic, inviteInvalid)
+			return
+		}
+	} else {
+		err = rh.db.CreateUser(user, pw)
+	}
 
-	err := rh.db.CreateUser(user, pw)
 	if err != nil {
-		e := fmt.Errorf("could not RegisterHandler.Register: %v", err)
-		NewServerError(e).Handle(w, r)
+		e := fmt.Errorf("could not RegisterHandler.Register: %w", err)
+		NewStoreError(e).Handle(w, r)
+		return
+	}
+
+	// The account exists now, so a failure to send the verification link is
+	// only logged. The user can ask for another one from the email page.
+	if em != "" {
+		err = sendEmailVerification(rh.cfg, rh.db, rh.mailer, user.UserId)
+		if err != nil {
+			slog.Error(fmt.Sprintf("could not RegisterHandler.Register: %v", err))
+		}
+	}
+
+	if user.Pending {
+		page := registerPage{Message: accountCreated, Mode: rh.cfg.RegistrationMode}
+		regTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+		return
 	}
 
 	http.Redirect(w, r, "/account", http.StatusFound)
@@ -79,36 +297,58 @@ func (rh *registerHandler) RegisterAdmin(w http.ResponseWriter, r *http.Request)
 	pw := r.Form.Get("password")
 	cn := r.Form.Get("confirm")
 
-	if len(pw) < rh.cfg.MinPassphraseLength {
-		e := fmt.Errorf(passwordTooShort, rh.cfg.MinPassphraseLength)
-		regAdminTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), e))
-		return
-	}
-
-	if pw != cn {
-		regAdminTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), passwordNotMatch))
+	err := validatePassphrase(rh.cfg, "admin", pw, cn)
+	if err != nil {
+		regAdminTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), err))
 		return
 	}
 
 	if rh.db.UserExists("admin") {
 		e := fmt.Errorf("could not RegisterHandler.RegisterAdmin: admin user exists")
 		NewBadRequestError(e).Handle(w, r)
+		return
 	}
 
 	user := store.NewUser("admin")
-	user.Admin = true
+	user.Roles = append(user.Roles, store.RoleAdmin)
 
-	err := rh.db.CreateUser(user, pw)
+	err = rh.db.CreateUser(user, pw)
 	if err != nil {
-		e := fmt.Errorf("could not RegisterHandler.RegisterAdmin: %v", err)
-		NewServerError(e).Handle(w, r)
+		e := fmt.Errorf("could not RegisterHandler.RegisterAdmin: %w", err)
+		NewStoreError(e).Handle(w, r)
+		return
 	}
 
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-// NewRegisterHandler creates a new registerHandler using the given Config and
-// Store.
-func NewRegisterHandler(c *config.Config, s *store.Store) *registerHandler {
-	return &registerHandler{cfg: c, db: s}
+// render renders the registration page with the given invite code and error.
+func (rh *registerHandler) render(w http.ResponseWriter, r *http.Request, invite string, e interface{}) {
+	page := registerPage{
+		Error:  e,
+		Mode:   rh.cfg.RegistrationMode,
+		Invite: invite,
+	}
+
+	regTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// VerifyEmail marks the email address associated with the verification token
+// in the query string as verified.
+func (rh *registerHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	_, err := rh.db.VerifyEmail(token)
+	if err != nil {
+		verifyTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), emailNotVerified))
+		return
+	}
+
+	verifyTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), emailVerified))
+}
+
+// NewRegisterHandler creates a new registerHandler using the given Config,
+// Store, and Mailer.
+func NewRegisterHandler(c *config.Config, s *store.Store, m mail.Mailer) *registerHandler {
+	return &registerHandler{cfg: c, db: s, mailer: m}
 }
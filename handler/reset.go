@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/mail"
+	"github.com/asggo/wasp/store"
+)
+
+var (
+	forgotTmpl   = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/forgot.html"))
+	resetTmpl    = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/reset.html"))
+	resetSent    = "If the account exists and has a verified email address, a password reset link has been sent to it."
+	resetInvalid = "The password reset link is invalid or has expired."
+	resetDone    = "Your password has been reset."
+	resetSubject = "Reset your password"
+	resetBody    = "Visit the link below to reset your password. The link expires in %d minutes. If you did not request a password reset, you can ignore this message.\n\n%s/account/reset?token=%s"
+)
+
+// resetPage holds the data needed to render the reset password page.
+type resetPage struct {
+	Error interface{}
+	Token string
+}
+
+// resetHandler provides handlers for the forgotten password endpoints in the
+// /account path.
+type resetHandler struct {
+	cfg    *config.Config
+	db     *store.Store
+	mailer mail.Mailer
+}
+
+// Index renders the forgotten password page.
+func (rh *resetHandler) Index(w http.ResponseWriter, r *http.Request) {
+	forgotTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), nil))
+}
+
+// Forgot sends a password reset link to the verified email address of the
+// account identified by the username or email address in the form. The same
+// response is given whether or not the account exists.
+func (rh *resetHandler) Forgot(w http.ResponseWriter, r *http.Request) {
+	var user store.User
+	var err error
+
+	r.ParseForm()
+
+	id := r.Form.Get("username")
+
+	if strings.Contains(id, "@") {
+		user, err = rh.db.GetUserByEmail(id)
+	} else {
+		user, err = rh.db.GetUserByAlias(id)
+	}
+
+	if err == nil && user.Email != "" && user.EmailVerified {
+		// Mail is sent in the background so the response time does not
+		// reveal whether the account exists.
+		go rh.sendReset(user)
+	}
+
+	forgotTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), resetSent))
+}
+
+// ShowReset renders the reset password page for the reset token in the query
+// string.
+func (rh *resetHandler) ShowReset(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	if !rh.db.PasswordResetValid(token) {
+		resetTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), resetPage{Error: resetInvalid}))
+		return
+	}
+
+	resetTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), resetPage{Token: token}))
+}
+
+// ExecReset sets the user's new password, revokes all of their sessions, and
+// resets their failed authentication count.
+func (rh *resetHandler) ExecReset(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	token := r.Form.Get("token")
+	pw := r.Form.Get("password")
+	cn := r.Form.Get("confirm")
+
+	uid, err := rh.db.GetPasswordResetUser(token)
+	if err != nil {
+		resetTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), resetPage{Error: resetInvalid}))
+		return
+	}
+
+	user, err := rh.db.GetUser(uid)
+	if err != nil {
+		resetTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), resetPage{Error: resetInvalid}))
+		return
+	}
+
+	err = validatePassphrase(rh.cfg, user.Alias, pw, cn)
+	if err != nil {
+		resetTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), resetPage{Error: err, Token: token}))
+		return
+	}
+
+	uid, err = rh.db.ResetUserPassword(token, pw)
+	if errors.Is(err, store.ErrHashBusy) {
+		e := fmt.Errorf("could not ResetHandler.ExecReset: %w", err)
+		NewUnavailableError(e).Handle(w, r)
+		return
+	}
+
+	if err != nil {
+		resetTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), resetPage{Error: resetInvalid}))
+		return
+	}
+
+	err = rh.db.DeleteUserSessions(uid)
+	if err != nil {
+		e := fmt.Errorf("could not ResetHandler.ExecReset: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	loginTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), resetDone))
+}
+
+// sendReset creates a password reset for the given user and mails the reset
+// link to their email address. Errors are logged because the user is never
+// told whether the reset was sent.
+func (rh *resetHandler) sendReset(user store.User) {
+	token, err := rh.db.CreatePasswordReset(user.UserId, rh.cfg.PasswordResetLength)
+	if err != nil {
+		slog.Error(fmt.Sprintf("could not ResetHandler.sendReset: %v", err))
+		return
+	}
+
+	body := fmt.Sprintf(resetBody, rh.cfg.PasswordResetLength/60, rh.cfg.BaseURL, token)
+
+	err = rh.mailer.Send(user.Email, resetSubject, body)
+	if err != nil {
+		slog.Error(fmt.Sprintf("could not ResetHandler.sendReset: %v", err))
+	}
+}
+
+// NewResetHandler creates a new resetHandler using the given Config, Store,
+// and Mailer.
+func NewResetHandler(c *config.Config, s *store.Store, m mail.Mailer) *resetHandler {
+	return &resetHandler{cfg: c, db: s, mailer: m}
+}
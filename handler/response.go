@@ -7,9 +7,24 @@ import (
 )
 
 type Response struct {
-	Auth  bool
-	Admin bool
-	Data  interface{}
+	Auth     bool
+	CSRF     string
+	Data     interface{}
+	Prefs    store.Preferences
+	perms    map[string]bool
+	orgPerms map[string]bool
+}
+
+// Can returns true if the authenticated user has been granted the given
+// permission. Templates use it to show only what the user is allowed to do.
+func (r Response) Can(perm string) bool {
+	return r.perms[perm]
+}
+
+// OrgCan returns true if the authenticated user has been granted the given
+// permission within the organization of the request.
+func (r Response) OrgCan(perm string) bool {
+	return r.orgPerms[perm]
 }
 
 // NewResponse returns an appropriate Response object based on the context
@@ -17,13 +32,36 @@ type Response struct {
 func NewResponse(ctx context.Context, data interface{}) Response {
 	val := ctx.Value("user")
 	if val == nil {
-		return Response{Auth: false, Admin: false, Data: data}
+		return Response{Auth: false, Data: data}
+	}
+
+	resp := Response{Auth: true, Data: data}
+
+	// The permissions of the user are loaded by the Authorizer.
+	perms, ok := ctx.Value("permissions").(map[string]bool)
+	if ok {
+		resp.perms = perms
+	}
+
+	// The permissions within an organization are loaded by the OrgScope.
+	orgPerms, ok := ctx.Value("orgpermissions").(map[string]bool)
+	if ok {
+		resp.orgPerms = orgPerms
 	}
 
-	user := val.(store.User)
-	if user.Admin {
-		return Response{Auth: true, Admin: true, Data: data}
-	} else {
-		return Response{Auth: true, Admin: false, Data: data}
+	// The preferences of the user are loaded by the Authorizer. Templates
+	// read them with the typed getters, such as
+	// {{ .Prefs.String "ui" "theme" "light" }}.
+	prefs, ok := ctx.Value("preferences").(store.Preferences)
+	if ok {
+		resp.Prefs = prefs
 	}
+
+	// Forms that change state include the session's CSRF token.
+	sess, ok := ctx.Value("session").(store.Session)
+	if ok {
+		resp.CSRF = sess.CSRFToken
+	}
+
+	return resp
 }
@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/store"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	rolesTmpl         = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/roles.html"))
+	roleTmpl          = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/role.html"))
+	roleInvalid       = "Role names must be 2 to 32 lowercase letters, numbers, dashes, or underscores, starting with a letter."
+	roleExists        = "A role with that name already exists."
+	roleAdminFixed    = "The admin role cannot be changed."
+	roleCreated       = "The role has been created."
+	roleSaved         = "The role has been saved."
+	roleHeld          = "You cannot change a role you hold or a role with permissions you do not have."
+	permissionUnknown = "Unknown permission."
+	permissionDenied  = "You cannot grant permissions you do not have."
+)
+
+// permissionOption holds a permission that can be granted to a role and
+// whether the role has it.
+type permissionOption struct {
+	Name    string
+	Granted bool
+}
+
+// rolesPage holds the data needed to render the role list.
+type rolesPage struct {
+	Error       interface{}
+	Message     string
+	Roles       []store.Role
+	Permissions []string
+}
+
+// rolePage holds the data needed to render a single role.
+type rolePage struct {
+	Error       interface{}
+	Message     string
+	Name        string
+	Permissions []permissionOption
+}
+
+// rolesHandler provides handlers for creating and changing roles in the
+// /admin/roles path.
+type rolesHandler struct {
+	cfg *config.Config
+	db  *store.Store
+}
+
+// Index renders the list of roles and a form to create a new role.
+func (rh *rolesHandler) Index(w http.ResponseWriter, r *http.Request) {
+	rh.renderRoles(w, r, nil, "")
+}
+
+// CreateRole creates a new role with the permissions selected in the form.
+// Only permissions the user holds can be granted to the role.
+func (rh *rolesHandler) CreateRole(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	role, err := store.NewRole(r.Form.Get("name"), r.Form["permission"])
+	if err != nil {
+		rh.renderRoles(w, r, roleInvalid, "")
+		return
+	}
+
+	if !holdsAll(r, role.Permissions) {
+		rh.renderRoles(w, r, permissionDenied, "")
+		return
+	}
+
+	_, err = rh.db.GetRole(role.Name)
+	if err == nil {
+		rh.renderRoles(w, r, roleExists, "")
+		return
+	}
+
+	err = rh.db.CreateRole(role)
+	if err != nil {
+		e := fmt.Errorf("could not RolesHandler.CreateRole: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	rh.renderRoles(w, r, nil, roleCreated)
+}
+
+// Detail renders a form to change the permissions of a single role.
+func (rh *rolesHandler) Detail(w http.ResponseWriter, r *http.Request) {
+	role, ok := rh.loadRole(w, r)
+	if !ok {
+		return
+	}
+
+	rh.renderRole(w, r, role, nil, "")
+}
+
+// SaveRole replaces the permissions of a role with the permissions selected
+// in the form. The admin role always has every permission and cannot be
+// changed. Users cannot change a role they hold, or a role with permissions
+// they lack, unless they hold the admin role and with it every permission, and
+// only permissions the user holds can be granted.
+func (rh *rolesHandler) SaveRole(w http.ResponseWriter, r *http.Request) {
+	role, ok := rh.loadRole(w, r)
+	if !ok {
+		return
+	}
+
+	if role.Name == store.RoleAdmin {
+		rh.renderRole(w, r, role, roleAdminFixed, "")
+		return
+	}
+
+	user := r.Context().Value("user").(store.User)
+
+	if !user.HasRole(store.RoleAdmin) && (user.HasRole(role.Name) || !holdsAll(r, role.Permissions)) {
+		rh.renderRole(w, r, role, roleHeld, "")
+		return
+	}
+
+	r.ParseForm()
+
+	updated, err := store.NewRole(role.Name, r.Form["permission"])
+	if err != nil {
+		rh.renderRole(w, r, role, permissionUnknown, "")
+		return
+	}
+
+	if !holdsAll(r, updated.Permissions) {
+		rh.renderRole(w, r, role, permissionDenied, "")
+		return
+	}
+
+	err = rh.db.SaveRole(updated)
+	if err != nil {
+		e := fmt.Errorf("could not RolesHandler.SaveRole: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	rh.renderRole(w, r, updated, nil, roleSaved)
+}
+
+// loadRole returns the role named in the request path. If the role cannot be
+// found, a not found error is rendered and false is returned.
+func (rh *rolesHandler) loadRole(w http.ResponseWriter, r *http.Request) (store.Role, bool) {
+	role, err := rh.db.GetRole(chi.URLParam(r, "name"))
+	if err != nil {
+		e := fmt.Errorf("could not RolesHandler.loadRole: %v", err)
+		NewNotFoundError(e).Handle(w, r)
+		return role, false
+	}
+
+	return role, true
+}
+
+// renderRoles renders the role list with the given error and message.
+func (rh *rolesHandler) renderRoles(w http.ResponseWriter, r *http.Request, e interface{}, msg string) {
+	roles, err := rh.db.ListRoles()
+	if err != nil {
+		e := fmt.Errorf("could not RolesHandler.renderRoles: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	page := rolesPage{
+		Error:       e,
+		Message:     msg,
+		Roles:       roles,
+		Permissions: store.Permissions,
+	}
+
+	rolesTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// renderRole renders the given role with the given error and message.
+func (rh *rolesHandler) renderRole(w http.ResponseWriter, r *http.Request, role store.Role, e interface{}, msg string) {
+	page := rolePage{Error: e, Message: msg, Name: role.Name}
+
+	for _, p := range store.Permissions {
+		page.Permissions = append(page.Permissions, permissionOption{p, role.Has(p)})
+	}
+
+	roleTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// holdsAll returns true if the user in the request context has every one of
+// the given permissions.
+func holdsAll(r *http.Request, perms []string) bool {
+	held, _ := r.Context().Value("permissions").(map[string]bool)
+
+	for _, p := range perms {
+		if !held[p] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// outranks returns true if the target user has a permission the user in the
+// request context lacks. Users cannot manage accounts with more access than
+// their own, so they cannot take them over.
+func outranks(db *store.Store, r *http.Request, target store.User) bool {
+	var perms []string
+
+	for p, ok := range db.GetPermissions(target) {
+		if ok {
+			perms = append(perms, p)
+		}
+	}
+
+	return !holdsAll(r, perms)
+}
+
+// NewRolesHandler creates a new rolesHandler with the given Config and Store.
+func NewRolesHandler(c *config.Config, s *store.Store) *rolesHandler {
+	return &rolesHandler{cfg: c, db: s}
+}
@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/store"
+	"github.com/asggo/wasp/totp"
+	"rsc.io/qr"
+)
+
+var (
+	twoFactorTmpl = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/twofactor.html"))
+
+	totpEnabled       = "Two-factor authentication has been enabled."
+	totpDisabled      = "Two-factor authentication has been disabled."
+	totpRequired      = "Administrators must enable two-factor authentication before continuing."
+	totpDisableDenied = "Administrators cannot disable two-factor authentication while it is required."
+	recoveryCreated   = "New recovery codes have been generated. Your old codes no longer work."
+)
+
+// twoFactorPage holds the data needed to render the two-factor
+// authentication page. QRCode and Secret are only set while the user is
+// enrolling, and RecoveryCodes only right after they are generated.
+type twoFactorPage struct {
+	Error         interface{}
+	Message       string
+	Enabled       bool
+	QRCode        template.HTML
+	Secret        string
+	RecoveryLeft  int
+	RecoveryCodes []string
+}
+
+// twoFactorHandler provides handlers for enrolling in and disabling TOTP
+// two-factor authentication in the /site/user/2fa path.
+type twoFactorHandler struct {
+	cfg *config.Config
+	db  *store.Store
+}
+
+// Index renders the two-factor authentication status of the user. Users who
+// have not enabled it are shown a QR code and secret to add to their
+// authenticator app.
+func (th *twoFactorHandler) Index(w http.ResponseWriter, r *http.Request) {
+	var msg string
+
+	user := r.Context().Value("user").(store.User)
+	perms, _ := r.Context().Value("permissions").(map[string]bool)
+
+	if perms[store.PermAdminAccess] && requireAdminTOTP(th.db) {
+		msg = totpRequired
+	}
+
+	enabled, err := th.db.TOTPEnabled(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not TwoFactorHandler.Index: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	th.render(w, r, twoFactorPage{Message: msg, Enabled: enabled})
+}
+
+// Enable confirms the pending secret with a code from the user's
+// authenticator app, and shows the user their first set of recovery codes.
+func (th *twoFactorHandler) Enable(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(store.User)
+
+	err := th.db.EnableTOTP(user.UserId, r.PostFormValue("code"))
+	if err != nil {
+		th.render(w, r, twoFactorPage{Error: invalidCode})
+		return
+	}
+
+	codes, err := th.db.GenerateRecoveryCodes(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not TwoFactorHandler.Enable: %w", err)
+		NewStoreError(e).Handle(w, r)
+		return
+	}
+
+	th.render(w, r, twoFactorPage{Message: totpEnabled, Enabled: true, RecoveryCodes: codes})
+}
+
+// Recovery replaces the user's recovery codes with a new set once they
+// confirm their passphrase. A wrong passphrase counts as a failed login.
+func (th *twoFactorHandler) Recovery(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(store.User)
+
+	enabled, err := th.db.TOTPEnabled(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not TwoFactorHandler.Recovery: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	if !enabled {
+		th.render(w, r, twoFactorPage{})
+		return
+	}
+
+	msg, err := confirmPassphrase(th.cfg, th.db, user.UserId, r.PostFormValue("password"))
+	if err != nil {
+		e := fmt.Errorf("could not TwoFactorHandler.Recovery: %w", err)
+		NewStoreError(e).Handle(w, r)
+		return
+	}
+
+	if msg != "" {
+		th.render(w, r, twoFactorPage{Error: msg, Enabled: true})
+		return
+	}
+
+	codes, err := th.db.GenerateRecoveryCodes(user.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not TwoFactorHandler.Recovery: %w", err)
+		NewStoreError(e).Handle(w, r)
+		return
+	}
+
+	th.render(w, r, twoFactorPage{Message: recoveryCreated, Enabled: true, RecoveryCodes: codes})
+}
+
+// Disable removes the user's TOTP secret once they confirm their passphrase
+// and a current code from their authenticator app. A wrong passphrase or code
+// counts as a failed login. Administrators cannot disable it while it is
+// required for them.
+func (th *twoFactorHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(store.User)
+	perms, _ := r.Context().Value("permissions").(map[string]bool)
+
+	if perms[store.PermAdminAccess] && requireAdminTOTP(th.db) {
+		th.render(w, r, twoFactorPage{Error: totpDisableDenied, Enabled: true})
+		return
+	}
+
+	msg, err := confirmPassphrase(th.cfg, th.db, user.UserId, r.PostFormValue("password"))
+	if err != nil {
+		e := fmt.Errorf("could not TwoFactorHandler.Disable: %w", err)
+		NewStoreError(e).Handle(w, r)
+		return
+	}
+
+	if msg == "" {
+		msg, err = confirmTOTP(th.cfg, th.db, user.UserId, r.PostFormValue("code"))
+		if err != nil {
+			e := fmt.Errorf("could not TwoFactorHandler.Disable: %w", err)
+			NewStoreError(e).Handle(w, r)
+			return
+		}
+	}
+
+	if msg != "" {
+		th.render(w, r, twoFactorPage{Error: msg, Enabled: true})
+		return
+	}
+
+	err = th.db.DisableTOTP(user.UserId, "")
+	if err != nil {
+		e := fmt.Errorf("could not TwoFactorHandler.Disable: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	th.render(w, r, twoFactorPage{Message: totpDisabled})
+}
+
+// render renders the two-factor authentication page. While TOTP is not
+// enabled, the pending secret is included as a QR code and in the Base32
+// form typed into authenticator apps. Once enabled, the number of unused
+// recovery codes is included.
+func (th *twoFactorHandler) render(w http.ResponseWriter, r *http.Request, page twoFactorPage) {
+	user := r.Context().Value("user").(store.User)
+
+	if page.Enabled {
+		page.RecoveryLeft = th.db.RecoveryCodesLeft(user.UserId)
+	}
+
+	if !page.Enabled {
+		secret, err := th.db.PendingTOTPSecret(user.UserId)
+		if err != nil {
+			e := fmt.Errorf("could not TwoFactorHandler.render: %v", err)
+			NewServerError(e).Handle(w, r)
+			return
+		}
+
+		page.QRCode, err = qrSVG(totp.URI(th.cfg.TOTPIssuer, user.Alias, secret))
+		if err != nil {
+			e := fmt.Errorf("could not TwoFactorHandler.render: %v", err)
+			NewServerError(e).Handle(w, r)
+			return
+		}
+
+		page.Secret = totp.Encode(secret)
+	}
+
+	twoFactorTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// qrSVG renders the text as a QR code in an inline SVG image. The image is
+// inlined because the content security policy does not allow data URIs.
+func qrSVG(text string) (template.HTML, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return "", err
+	}
+
+	// Leave the four module quiet zone scanners expect around the code.
+	size := code.Size + 8
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`, size, size, size*4, size*4)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+4, y+4)
+			}
+		}
+	}
+
+	b.WriteString(`"/></svg>`)
+
+	return template.HTML(b.String()), nil
+}
+
+// requireAdminTOTP returns true if administrators must use two-factor
+// authentication.
+func requireAdminTOTP(s *store.Store) bool {
+	return s.GetSetting(store.SettingRequireAdminTOTP, "false") == "true"
+}
+
+// NewTwoFactorHandler returns a new twoFactorHandler object.
+func NewTwoFactorHandler(c *config.Config, s *store.Store) *twoFactorHandler {
+	return &twoFactorHandler{cfg: c, db: s}
+}
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/store"
+	"github.com/asggo/wasp/upload"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	uploadTmpl = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/uploads.html"))
+
+	uploadMissing  = "Choose a file to upload."
+	uploadTooLarge = "Files must be at most %d bytes."
+	uploadType     = "Files of type %s cannot be uploaded."
+	uploadSaved    = "Your file has been uploaded."
+	uploadDeleted  = "The file has been deleted."
+)
+
+// uploadPage holds the data needed to render the uploads page.
+type uploadPage struct {
+	Error   interface{}
+	Message string
+	Uploads []store.Upload
+}
+
+// uploadHandler provides handlers for storing and serving the files users
+// upload in the /site/uploads path.
+type uploadHandler struct {
+	cfg   *config.Config
+	db    *store.Store
+	files upload.Storage
+}
+
+// Index renders the files uploaded by the user and the upload form.
+func (uh *uploadHandler) Index(w http.ResponseWriter, r *http.Request) {
+	uh.render(w, r, nil, "")
+}
+
+// Upload stores the file in the form. The content type is determined by
+// sniffing the content rather than trusting the browser, and must be one of
+// the allowed upload types.
+func (uh *uploadHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	u := r.Context().Value("user").(store.User)
+
+	file, header, err := r.FormFile("file")
+	if errors.Is(err, http.ErrMissingFile) {
+		uh.render(w, r, uploadMissing, "")
+		return
+	}
+
+	if err != nil {
+		e := fmt.Errorf("could not UploadHandler.Upload: %v", err)
+		NewBadRequestError(e).Handle(w, r)
+		return
+	}
+	defer file.Close()
+	defer r.MultipartForm.RemoveAll()
+
+	data, err := io.ReadAll(io.LimitReader(file, uh.cfg.MaxUploadSize+1))
+	if err != nil {
+		e := fmt.Errorf("could not UploadHandler.Upload: %v", err)
+		NewBadRequestError(e).Handle(w, r)
+		return
+	}
+
+	if int64(len(data)) > uh.cfg.MaxUploadSize {
+		uh.render(w, r, fmt.Sprintf(uploadTooLarge, uh.cfg.MaxUploadSize), "")
+		return
+	}
+
+	if len(data) == 0 {
+		uh.render(w, r, uploadMissing, "")
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !slices.Contains(uh.cfg.UploadTypes, mediaType) {
+		uh.render(w, r, fmt.Sprintf(uploadType, mediaType), "")
+		return
+	}
+
+	up := store.NewUpload(u.UserId, cleanFilename(header.Filename), contentType, int64(len(data)))
+
+	err = uh.files.Put(up.UploadId.String(), data)
+	if err != nil {
+		e := fmt.Errorf("could not UploadHandler.Upload: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	err = uh.db.CreateUpload(up)
+	if err != nil {
+		uh.files.Delete(up.UploadId.String())
+
+		e := fmt.Errorf("could not UploadHandler.Upload: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	uh.render(w, r, nil, uploadSaved)
+}
+
+// Download sends the file identified by the id in the request path. The file
+// is always sent as an attachment with its sniffed content type, and
+// browsers are told not to sniff it again, so an uploaded file is never
+// rendered as part of the site.
+func (uh *uploadHandler) Download(w http.ResponseWriter, r *http.Request) {
+	up, ok := uh.loadUpload(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := uh.files.Get(up.UploadId.String())
+	if err != nil {
+		e := fmt.Errorf("could not UploadHandler.Download: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": up.Name})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+
+	w.Header().Set("Content-Type", up.ContentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Write(data)
+}
+
+// Delete removes the file identified by the id in the request path.
+func (uh *uploadHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	up, ok := uh.loadUpload(w, r)
+	if !ok {
+		return
+	}
+
+	err := upload.Delete(uh.db, uh.files, up)
+	if err != nil {
+		e := fmt.Errorf("could not UploadHandler.Delete: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	uh.render(w, r, nil, uploadDeleted)
+}
+
+// loadUpload returns the upload identified by the id in the request path. If
+// the upload cannot be found or belongs to another user, a not found error is
+// rendered and false is returned, so other users' files cannot be detected.
+func (uh *uploadHandler) loadUpload(w http.ResponseWriter, r *http.Request) (store.Upload, bool) {
+	u := r.Context().Value("user").(store.User)
+
+	up, err := uh.db.GetUpload(chi.URLParam(r, "id"))
+	if err == nil && up.UserId != u.UserId {
+		err = fmt.Errorf("upload %s does not belong to %s", up.UploadId, u.Alias)
+	}
+
+	if err != nil {
+		e := fmt.Errorf("could not UploadHandler.loadUpload: %v", err)
+		NewNotFoundError(e).Handle(w, r)
+		return up, false
+	}
+
+	return up, true
+}
+
+// render renders the user's files with the given error and message.
+func (uh *uploadHandler) render(w http.ResponseWriter, r *http.Request, e interface{}, msg string) {
+	u := r.Context().Value("user").(store.User)
+
+	uploads, err := uh.db.ListUploads(u.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not UploadHandler.render: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	page := uploadPage{Error: e, Message: msg, Uploads: uploads}
+
+	uploadTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// cleanFilename returns the base name of the file name sent by the browser
+// without control characters, limited to 255 characters. Files without a
+// usable name are called upload.
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+
+		return r
+	}, name)
+
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		return "upload"
+	}
+
+	if utf8.RuneCountInString(name) > 255 {
+		name = string([]rune(name)[:255])
+	}
+
+	return name
+}
+
+// NewUploadHandler creates a new uploadHandler using the given Config, Store,
+// and upload Storage.
+func NewUploadHandler(c *config.Config, s *store.Store, fs upload.Storage) *uploadHandler {
+	return &uploadHandler{cfg: c, db: s, files: fs}
+}
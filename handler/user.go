@@ -1,23 +1,53 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"strconv"
 
 	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/mail"
 	"github.com/asggo/wasp/store"
 )
 
 var (
-	userTmpl = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/user.html"))
-	pwdTmpl  = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/changepw.html"))
+	userTmpl  = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/user.html"))
+	pwdTmpl   = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/changepw.html"))
+	aliasTmpl = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/changealias.html"))
+	actTmpl   = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/activity.html"))
+	delTmpl   = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/deleteaccount.html"))
+	prefTmpl  = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/preferences.html"))
+
+	passwordResetRequired = "You must change your password before continuing."
+	adminDeleteFixed      = "The admin account cannot be deleted."
+	accountDeleted        = "Your account has been deleted."
+	lastOrgManager        = "You are the only member who can manage one of your organizations. Give another member a role that can manage it first."
+	themeInvalid          = "Choose the light or dark theme."
+	pageSizeInvalid       = "The page size must be between 10 and 100."
+	prefsSaved            = "Your preferences have been saved."
 )
 
+// prefsPage holds the data needed to render the preferences page.
+type prefsPage struct {
+	Error    interface{}
+	Message  string
+	PageSize int
+}
+
+// aliasPage holds the data needed to render the change alias page.
+type aliasPage struct {
+	Error   interface{}
+	History []store.AliasChange
+}
+
 // userHandler provides handlers for each endpoint in the /site path.
 type userHandler struct {
-	db  *store.Store
-	cfg *config.Config
+	db     *store.Store
+	cfg    *config.Config
+	mailer mail.Mailer
 }
 
 // Index renders the index page of the /user path.
@@ -27,9 +57,31 @@ func (uh *userHandler) Index(w http.ResponseWriter, r *http.Request) {
 	userTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), user))
 }
 
-// ShowChangePassword renders the change password page.
+// Activity renders the security activity recorded for the user.
+func (uh *userHandler) Activity(w http.ResponseWriter, r *http.Request) {
+	u := r.Context().Value("user").(store.User)
+
+	activities, err := uh.db.GetActivity(u.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.Activity: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	actTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), activities))
+}
+
+// ShowChangePassword renders the change password page. Users who are
+// required to reset their password are told so.
 func (uh *userHandler) ShowChangePassword(w http.ResponseWriter, r *http.Request) {
-	pwdTmpl.ExecuteTemplate(w, "layout", nil)
+	u := r.Context().Value("user").(store.User)
+
+	if u.ResetRequired {
+		pwdTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), passwordResetRequired))
+		return
+	}
+
+	pwdTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), nil))
 }
 
 // ExecChangePassword resets the users password.
@@ -42,32 +94,340 @@ func (uh *userHandler) ExecChangePassword(w http.ResponseWriter, r *http.Request
 
 	u := r.Context().Value("user").(store.User)
 
-	if !uh.db.AuthenticateUser(u.UserId, opw) {
-		pwdTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), invalidCredentials))
+	msg, err := confirmPassphrase(uh.cfg, uh.db, u.UserId, opw)
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.ExecChangePassword: %w", err)
+		NewStoreError(e).Handle(w, r)
 		return
 	}
 
-	if len(npw) < uh.cfg.MinPassphraseLength {
-		e := fmt.Errorf(passwordTooShort, uh.cfg.MinPassphraseLength)
-		pwdTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), e))
+	if msg != "" {
+		pwdTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), msg))
 		return
 	}
 
-	if npw != cpw {
-		pwdTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), passwordNotMatch))
+	err = validatePassphrase(uh.cfg, u.Alias, npw, cpw)
+	if err != nil {
+		pwdTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), err))
 		return
 	}
 
-	err := uh.db.ChangeUserPassword(u.UserId, npw)
+	err = uh.db.ChangeUserPassword(u.UserId, npw)
 	if err != nil {
-		e := fmt.Errorf("could not UserHandler.ExecChangePassword: %v", err)
-		NewServerError(e).Handle(w, r)
+		e := fmt.Errorf("could not UserHandler.ExecChangePassword: %w", err)
+		NewStoreError(e).Handle(w, r)
+		return
 	}
 
 	http.Redirect(w, r, "/account/logout", http.StatusFound)
 }
 
-// NewUserHandler creates a new userHandler with the given Store.
-func NewUserHandler(c *config.Config, s *store.Store) *userHandler {
-	return &userHandler{cfg: c, db: s}
+// ShowChangeAlias renders the change alias page along with the user's alias
+// history.
+func (uh *userHandler) ShowChangeAlias(w http.ResponseWriter, r *http.Request) {
+	uh.renderChangeAlias(w, r, nil)
+}
+
+// ExecChangeAlias changes the user's alias. The old alias is reserved for the
+// user so it cannot be taken by another user.
+func (uh *userHandler) ExecChangeAlias(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	u := r.Context().Value("user").(store.User)
+
+	if u.Alias == "admin" {
+		uh.renderChangeAlias(w, r, adminAliasFixed)
+		return
+	}
+
+	un, err := validateUsername(uh.cfg, r.Form.Get("username"))
+	if err != nil {
+		uh.renderChangeAlias(w, r, err)
+		return
+	}
+
+	if uh.db.UserExists(un) || uh.db.AliasReserved(un, u.UserId) || uh.db.AliasConfusable(un, u.UserId) {
+		uh.renderChangeAlias(w, r, usernameTaken)
+		return
+	}
+
+	err = uh.db.ChangeUserAlias(u.UserId, un, u.UserId, uh.cfg.AliasReservationLength)
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.ExecChangeAlias: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	http.Redirect(w, r, "/site/user", http.StatusFound)
+}
+
+// renderChangeAlias renders the change alias page with the given error.
+func (uh *userHandler) renderChangeAlias(w http.ResponseWriter, r *http.Request, e interface{}) {
+	u := r.Context().Value("user").(store.User)
+
+	history, err := uh.db.GetAliasHistory(u.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.renderChangeAlias: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	page := aliasPage{Error: e, History: history}
+	aliasTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// ShowEmail renders the email page with the user's email address and its
+// verification status.
+func (uh *userHandler) ShowEmail(w http.ResponseWriter, r *http.Request) {
+	uh.renderEmail(w, r, nil)
+}
+
+// ExecChangeEmail changes the user's email address after checking their
+// passphrase, and sends a verification link to the new address. A verified
+// address is kept until the new one is verified, and is told of the change, so
+// a stolen session cannot quietly take over password resets.
+func (uh *userHandler) ExecChangeEmail(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	em := store.NormalizeEmail(r.Form.Get("email"))
+
+	u := r.Context().Value("user").(store.User)
+
+	msg, err := confirmPassphrase(uh.cfg, uh.db, u.UserId, r.Form.Get("password"))
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.ExecChangeEmail: %w", err)
+		NewStoreError(e).Handle(w, r)
+		return
+	}
+
+	if msg != "" {
+		uh.renderEmail(w, r, msg)
+		return
+	}
+
+	if !validEmail(em) {
+		uh.renderEmail(w, r, emailInvalid)
+		return
+	}
+
+	owner, err := uh.db.GetUserByEmail(em)
+	if err == nil && owner.UserId != u.UserId {
+		uh.renderEmail(w, r, emailTaken)
+		return
+	}
+
+	if u.Email == "" || !u.EmailVerified {
+		err = uh.db.SetUserEmail(u.UserId, em)
+	} else {
+		err = uh.db.RequestEmailChange(u.UserId, em)
+	}
+
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.ExecChangeEmail: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	// Asking for the verified address again drops a pending change.
+	if u.EmailVerified && em == u.Email {
+		uh.renderEmail(w, r, emailUnchanged)
+		return
+	}
+
+	if u.EmailVerified {
+		err = uh.mailer.Send(u.Email, emailChangeSubject, fmt.Sprintf(emailChangeBody, em))
+		if err != nil {
+			e := fmt.Errorf("could not UserHandler.ExecChangeEmail: %v", err)
+			NewServerError(e).Handle(w, r)
+			return
+		}
+	}
+
+	err = sendEmailVerification(uh.cfg, uh.db, uh.mailer, u.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.ExecChangeEmail: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	uh.renderEmail(w, r, emailVerifySent)
+}
+
+// ExecVerifyEmail sends a new verification link to the user's email address.
+func (uh *userHandler) ExecVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	u := r.Context().Value("user").(store.User)
+
+	if u.EmailToVerify() == "" {
+		uh.renderEmail(w, r, emailInvalid)
+		return
+	}
+
+	err := sendEmailVerification(uh.cfg, uh.db, uh.mailer, u.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.ExecVerifyEmail: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	uh.renderEmail(w, r, emailVerifySent)
+}
+
+// renderEmail renders the email page with the given message.
+func (uh *userHandler) renderEmail(w http.ResponseWriter, r *http.Request, e interface{}) {
+	u := r.Context().Value("user").(store.User)
+
+	// Reload the user so changes made by this request are shown.
+	user, err := uh.db.GetUser(u.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.renderEmail: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	emailTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), emailPage{Error: e, User: user}))
+}
+
+// ShowPreferences renders the preferences page.
+func (uh *userHandler) ShowPreferences(w http.ResponseWriter, r *http.Request) {
+	uh.renderPreferences(w, r, prefsPage{})
+}
+
+// ExecPreferences saves the preferences in the form. The page size of the
+// user management console is only saved for users who can view it.
+func (uh *userHandler) ExecPreferences(w http.ResponseWriter, r *http.Request) {
+	u := r.Context().Value("user").(store.User)
+	perms, _ := r.Context().Value("permissions").(map[string]bool)
+
+	theme := r.PostFormValue("theme")
+	if theme != "light" && theme != "dark" {
+		uh.renderPreferences(w, r, prefsPage{Error: themeInvalid})
+		return
+	}
+
+	size, err := strconv.Atoi(r.PostFormValue("page_size"))
+	if perms[store.PermUsersView] && (err != nil || size < 10 || size > 100) {
+		uh.renderPreferences(w, r, prefsPage{Error: pageSizeInvalid})
+		return
+	}
+
+	err = uh.db.SetPreference(u.UserId, "ui", "theme", theme)
+	if err == nil && perms[store.PermUsersView] {
+		err = uh.db.SetPreference(u.UserId, "admin", "page_size", strconv.Itoa(size))
+	}
+
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.ExecPreferences: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	// Redirect so the new preferences are loaded into the request context.
+	http.Redirect(w, r, "/site/user/preferences?saved=1", http.StatusFound)
+}
+
+// renderPreferences renders the preferences page with the given page.
+func (uh *userHandler) renderPreferences(w http.ResponseWriter, r *http.Request, page prefsPage) {
+	prefs, _ := r.Context().Value("preferences").(store.Preferences)
+
+	page.PageSize = prefs.Int("admin", "page_size", uh.cfg.AdminPageSize)
+
+	if r.URL.Query().Get("saved") != "" {
+		page.Message = prefsSaved
+	}
+
+	prefTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// ShowDeleteAccount renders the delete account page.
+func (uh *userHandler) ShowDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	delTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), nil))
+}
+
+// ExecDeleteAccount removes the user and all of their data from the Store
+// once they have entered their passphrase again. The session cookie is
+// cleared because the session no longer exists.
+func (uh *userHandler) ExecDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	u := r.Context().Value("user").(store.User)
+
+	// The admin account is needed to manage the site.
+	if u.Alias == "admin" {
+		delTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), adminDeleteFixed))
+		return
+	}
+
+	msg, err := confirmPassphrase(uh.cfg, uh.db, u.UserId, r.PostFormValue("password"))
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.ExecDeleteAccount: %w", err)
+		NewStoreError(e).Handle(w, r)
+		return
+	}
+
+	if msg != "" {
+		delTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), msg))
+		return
+	}
+
+	err = uh.db.DeleteUser(u)
+	if errors.Is(err, store.ErrLastManager) {
+		delTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), lastOrgManager))
+		return
+	}
+
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.ExecDeleteAccount: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	cookie := http.Cookie{
+		Name:     "sess",
+		Value:    "",
+		MaxAge:   -1,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	http.SetCookie(w, &cookie)
+
+	// The user no longer exists, so the page is rendered as for a visitor.
+	loginTmpl.ExecuteTemplate(w, "layout", Response{Data: accountDeleted})
+}
+
+// Export sends the user a JSON archive of the personal data kept about them,
+// including the data of every module that registered a store.Exporter. The
+// export is recorded in the user's activity.
+func (uh *userHandler) Export(w http.ResponseWriter, r *http.Request) {
+	u := r.Context().Value("user").(store.User)
+
+	err := uh.db.AddActivity(u.UserId, store.ActivityDataExported, "")
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.Export: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	export, err := uh.db.ExportUser(u.UserId)
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.Export: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		e := fmt.Errorf("could not UserHandler.Export: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="wasp-export.json"`)
+	w.Write(data)
+}
+
+// NewUserHandler creates a new userHandler with the given Config, Store, and
+// Mailer.
+func NewUserHandler(c *config.Config, s *store.Store, m mail.Mailer) *userHandler {
+	return &userHandler{cfg: c, db: s, mailer: m}
 }
@@ -0,0 +1,415 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/store"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	usersTmpl        = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/users.html"))
+	userDetailTmpl   = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/userdetail.html"))
+	confirmTmpl      = template.Must(template.ParseFiles("templates/layout.html", "templates/nav.html", "templates/confirm.html"))
+	actionNotAllowed = "This action cannot be taken on this account."
+	adminRoleKept    = "The admin account and your own account must keep the admin role."
+	roleNotFound     = "Role not found."
+)
+
+// userAction describes an action an admin can take on a user from the user
+// management console.
+type userAction struct {
+	Name    string
+	Title   string
+	Confirm string
+}
+
+// userActions holds the actions available in the user management console.
+var userActions = map[string]userAction{
+	"reset-failed": {"reset-failed", "Reset Failed Logins", "Reset the failed login count of %s?"},
+	"unlock":       {"unlock", "Unlock Account", "Unlock %s and reset their failed login count and lockout history?"},
+	"reset-2fa":    {"reset-2fa", "Reset Two-Factor Authentication", "Remove the authenticator app of %s so they can log in with their passphrase alone?"},
+	"passkeys":     {"passkeys", "Revoke Passkeys", "Remove every passkey of %s so they must log in with their passphrase?"},
+	"logout":       {"logout", "Force Logout", "End every session of %s?"},
+	"force-reset":  {"force-reset", "Force Password Reset", "End every session of %s, remove their passkeys, and require a new password at next login?"},
+	"delete":       {"delete", "Delete User", "Permanently delete %s and all of their data?"},
+}
+
+// usersPage holds the data needed to render the user list.
+type usersPage struct {
+	Users []store.User
+	Query string
+	Total int
+	Page  int
+	Pages int
+	Prev  string
+	Next  string
+}
+
+// roleOption holds a role that can be assigned to a user and whether the user
+// has it.
+type roleOption struct {
+	Name     string
+	Assigned bool
+}
+
+// userDetailPage holds the data needed to render the user detail view.
+type userDetailPage struct {
+	Error    interface{}
+	User     store.User
+	Failed   uint64
+	Lockout  store.Lockout
+	TOTP     bool
+	Recovery int
+	Passkeys []store.Credential
+	Sessions []store.Session
+	Activity []store.Activity
+	Actions  []userAction
+	Roles    []roleOption
+}
+
+// confirmPage holds the data needed to render an action confirmation.
+type confirmPage struct {
+	User    store.User
+	Action  userAction
+	Message string
+}
+
+// usersHandler provides handlers for the user management console in the
+// /admin/users path.
+type usersHandler struct {
+	cfg *config.Config
+	db  *store.Store
+}
+
+// Index renders a page of the users matching the search query.
+func (uh *usersHandler) Index(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	// The page size can be changed on the user's preferences page.
+	prefs, _ := r.Context().Value("preferences").(store.Preferences)
+	size := prefs.Int("admin", "page_size", uh.cfg.AdminPageSize)
+	if size < 1 {
+		size = uh.cfg.AdminPageSize
+	}
+
+	users, total, err := uh.db.ListUsers(q, (page-1)*size, size)
+	if err != nil {
+		e := fmt.Errorf("could not UsersHandler.Index: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	data := usersPage{
+		Users: users,
+		Query: q,
+		Total: total,
+		Page:  page,
+		Pages: (total + size - 1) / size,
+	}
+
+	if page > 1 {
+		data.Prev = pageLink(q, page-1)
+	}
+
+	if page < data.Pages {
+		data.Next = pageLink(q, page+1)
+	}
+
+	usersTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), data))
+}
+
+// Detail renders the details of a single user along with the actions that can
+// be taken on them.
+func (uh *usersHandler) Detail(w http.ResponseWriter, r *http.Request) {
+	user, ok := uh.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	uh.renderDetail(w, r, user, nil)
+}
+
+// ConfirmAction renders a confirmation form for an action on a user.
+func (uh *usersHandler) ConfirmAction(w http.ResponseWriter, r *http.Request) {
+	user, ok := uh.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	action, ok := userActions[chi.URLParam(r, "action")]
+	if !ok {
+		NewNotFoundError(nil).Handle(w, r)
+		return
+	}
+
+	if outranks(uh.db, r, user) {
+		e := fmt.Errorf("could not UsersHandler.ConfirmAction: %s outranks the admin", user.Alias)
+		NewForbiddenError(e).Handle(w, r)
+		return
+	}
+
+	page := confirmPage{
+		User:    user,
+		Action:  action,
+		Message: fmt.Sprintf(action.Confirm, user.Alias),
+	}
+
+	confirmTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// ExecAction takes a confirmed action on a user with no more access than the
+// admin. The action is recorded in the user's activity.
+func (uh *usersHandler) ExecAction(w http.ResponseWriter, r *http.Request) {
+	user, ok := uh.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	action, ok := userActions[chi.URLParam(r, "action")]
+	if !ok {
+		NewNotFoundError(nil).Handle(w, r)
+		return
+	}
+
+	admin := r.Context().Value("user").(store.User)
+
+	// No action can be taken on a user with more access than the admin.
+	if outranks(uh.db, r, user) {
+		e := fmt.Errorf("could not UsersHandler.ExecAction: %s outranks %s", user.Alias, admin.Alias)
+		NewForbiddenError(e).Handle(w, r)
+		return
+	}
+
+	// The admin account and the acting admin cannot be deleted from the
+	// console.
+	if action.Name == "delete" && (user.Alias == "admin" || user.UserId == admin.UserId) {
+		uh.renderDetail(w, r, user, actionNotAllowed)
+		return
+	}
+
+	var err error
+
+	switch action.Name {
+	case "reset-failed":
+		err = uh.db.ResetFailedAuthCount(user.UserId)
+	case "unlock":
+		err = uh.db.ResetLockout(user.UserId)
+	case "reset-2fa":
+		err = uh.db.DisableTOTP(user.UserId, "by "+admin.Alias)
+	case "passkeys":
+		err = uh.db.DeleteUserCredentials(user.UserId, "by "+admin.Alias)
+	case "logout":
+		err = uh.db.DeleteUserSessions(user.UserId)
+	case "force-reset":
+		err = uh.db.SetPasswordResetRequired(user.UserId, true)
+		if err == nil {
+			err = uh.db.DeleteUserSessions(user.UserId)
+		}
+
+		if err == nil {
+			err = uh.db.DeleteUserCredentials(user.UserId, "by "+admin.Alias)
+		}
+	case "delete":
+		err = uh.db.DeleteUser(user)
+	}
+
+	if errors.Is(err, store.ErrLastManager) {
+		uh.renderDetail(w, r, user, lastOrgManager)
+		return
+	}
+
+	if err != nil {
+		e := fmt.Errorf("could not UsersHandler.ExecAction: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	if action.Name == "delete" {
+		http.Redirect(w, r, "/site/admin/users", http.StatusFound)
+		return
+	}
+
+	detail := fmt.Sprintf("%s by %s", action.Name, admin.Alias)
+
+	err = uh.db.AddActivity(user.UserId, store.ActivityAdminAction, detail)
+	if err != nil {
+		e := fmt.Errorf("could not UsersHandler.ExecAction: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	http.Redirect(w, r, "/site/admin/users/"+user.UserId.String(), http.StatusFound)
+}
+
+// ExecRoles replaces the roles assigned to a user with the roles selected in
+// the form. Only roles whose every permission the admin holds can be granted
+// or taken away, so roles cannot be used to gain access. The change is
+// recorded in the user's activity.
+func (uh *usersHandler) ExecRoles(w http.ResponseWriter, r *http.Request) {
+	user, ok := uh.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	admin := r.Context().Value("user").(store.User)
+
+	if outranks(uh.db, r, user) {
+		e := fmt.Errorf("could not UsersHandler.ExecRoles: %s outranks %s", user.Alias, admin.Alias)
+		NewForbiddenError(e).Handle(w, r)
+		return
+	}
+
+	r.ParseForm()
+	roles := r.Form["role"]
+
+	for _, name := range roles {
+		_, err := uh.db.GetRole(name)
+		if err != nil {
+			uh.renderDetail(w, r, user, roleNotFound)
+			return
+		}
+	}
+
+	updated := store.User{Roles: roles}
+
+	for _, name := range append(roles, user.Roles...) {
+		if user.HasRole(name) == updated.HasRole(name) {
+			continue
+		}
+
+		// A role removed from the Store grants nothing, so it can be taken
+		// away by anyone.
+		role, err := uh.db.GetRole(name)
+		if err == nil && !holdsAll(r, role.Permissions) {
+			e := fmt.Errorf("could not UsersHandler.ExecRoles: %s cannot grant %s", admin.Alias, name)
+			NewForbiddenError(e).Handle(w, r)
+			return
+		}
+	}
+
+	// The admin account and the acting admin cannot lose the admin role, so
+	// there is always someone who can manage roles.
+	kept := updated.HasRole(store.RoleAdmin)
+	if !kept && (user.Alias == "admin" || user.UserId == admin.UserId) {
+		uh.renderDetail(w, r, user, adminRoleKept)
+		return
+	}
+
+	err := uh.db.SetUserRoles(user.UserId, roles)
+	if err != nil {
+		e := fmt.Errorf("could not UsersHandler.ExecRoles: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	detail := fmt.Sprintf("roles set to %s by %s", strings.Join(roles, ", "), admin.Alias)
+
+	err = uh.db.AddActivity(user.UserId, store.ActivityAdminAction, detail)
+	if err != nil {
+		e := fmt.Errorf("could not UsersHandler.ExecRoles: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	http.Redirect(w, r, "/site/admin/users/"+user.UserId.String(), http.StatusFound)
+}
+
+// loadUser returns the user identified by the id in the request path. If the
+// user cannot be found, a not found error is rendered and false is returned.
+func (uh *usersHandler) loadUser(w http.ResponseWriter, r *http.Request) (store.User, bool) {
+	id := chi.URLParam(r, "id")
+
+	user, err := uh.db.GetUserById(id)
+	if err != nil {
+		e := fmt.Errorf("could not UsersHandler.loadUser: %v", err)
+		NewNotFoundError(e).Handle(w, r)
+		return user, false
+	}
+
+	return user, true
+}
+
+// renderDetail renders the detail view of the given user with the given error.
+func (uh *usersHandler) renderDetail(w http.ResponseWriter, r *http.Request, user store.User, e interface{}) {
+	var err error
+
+	page := userDetailPage{Error: e, User: user}
+
+	page.Failed, err = uh.db.GetFailedAuthCount(user.UserId)
+	if err == nil {
+		page.Lockout, err = uh.db.GetLockout(user.UserId)
+	}
+
+	if err == nil {
+		page.TOTP, err = uh.db.TOTPEnabled(user.UserId)
+	}
+
+	page.Recovery = uh.db.RecoveryCodesLeft(user.UserId)
+
+	if err == nil {
+		page.Passkeys, err = uh.db.GetUserCredentials(user.UserId)
+	}
+
+	if err == nil {
+		page.Sessions, err = uh.db.GetUserSessions(user.UserId)
+	}
+
+	if err == nil {
+		page.Activity, err = uh.db.GetActivity(user.UserId)
+	}
+
+	var roles []store.Role
+	if err == nil {
+		roles, err = uh.db.ListRoles()
+	}
+
+	if err != nil {
+		e := fmt.Errorf("could not UsersHandler.renderDetail: %v", err)
+		NewServerError(e).Handle(w, r)
+		return
+	}
+
+	// Users with more access than the admin are shown without actions.
+	if !outranks(uh.db, r, user) {
+		for _, name := range []string{"reset-failed", "unlock", "reset-2fa", "passkeys", "logout", "force-reset", "delete"} {
+			page.Actions = append(page.Actions, userActions[name])
+		}
+	}
+
+	for _, role := range roles {
+		page.Roles = append(page.Roles, roleOption{role.Name, user.HasRole(role.Name)})
+	}
+
+	userDetailTmpl.ExecuteTemplate(w, "layout", NewResponse(r.Context(), page))
+}
+
+// pageLink returns the link to the given page of the user list for the given
+// search query.
+func pageLink(q string, page int) string {
+	v := url.Values{}
+	v.Set("page", strconv.Itoa(page))
+
+	if q != "" {
+		v.Set("q", q)
+	}
+
+	return "/site/admin/users?" + v.Encode()
+}
+
+// NewUsersHandler creates a new usersHandler with the given Config and Store.
+func NewUsersHandler(c *config.Config, s *store.Store) *usersHandler {
+	return &usersHandler{cfg: c, db: s}
+}
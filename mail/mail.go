@@ -0,0 +1,79 @@
+package mail
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// Mailer sends email messages on behalf of the application. Implement this
+// interface to deliver mail through another service.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+//----------------------------------------------------------------------------
+// LogMailer
+//----------------------------------------------------------------------------
+
+// logMailer writes messages to the log instead of sending them. It is useful
+// for development and for deployments without mail infrastructure.
+type logMailer struct{}
+
+// Send writes the message to the default logger.
+func (l logMailer) Send(to, subject, body string) error {
+	slog.Info("mail", "to", to, "subject", subject, "body", body)
+
+	return nil
+}
+
+// NewLogMailer creates a Mailer that writes messages to the log.
+func NewLogMailer() Mailer {
+	return logMailer{}
+}
+
+//----------------------------------------------------------------------------
+// SMTPMailer
+//----------------------------------------------------------------------------
+
+// smtpMailer sends messages through an SMTP server.
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// Send delivers the message to the SMTP server.
+func (m smtpMailer) Send(to, subject, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("could not SMTPMailer.Send: invalid header value")
+	}
+
+	msg := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		m.from, to, subject, body,
+	)
+
+	err := smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg))
+	if err != nil {
+		return fmt.Errorf("could not SMTPMailer.Send: %v", err)
+	}
+
+	return nil
+}
+
+// NewSMTPMailer creates a Mailer that sends messages from the given address
+// through the SMTP server at addr. If a username is given, PLAIN
+// authentication is used.
+func NewSMTPMailer(addr, username, password, from string) Mailer {
+	m := smtpMailer{addr: addr, from: from}
+
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m
+}
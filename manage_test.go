@@ -0,0 +1,422 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/mail"
+	"github.com/asggo/wasp/ratelimit"
+	"github.com/asggo/wasp/store"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	testManageDbPath = "manage_test.db"
+	testManagePass   = "managepassword12"
+	testForbidden    = "Access to this content is forbidden."
+)
+
+// newManageServer returns a test server for the given Store with the account
+// and site routes mounted.
+func newManageServer(cfg *config.Config, s *store.Store) *httptest.Server {
+	r := chi.NewRouter()
+	r.Mount("/account", accountRouter(cfg, s, mail.NewLogMailer(), ratelimit.NewLimiter()))
+	r.Mount("/site", siteRouter(cfg, s, mail.NewLogMailer(), nil))
+
+	return httptest.NewTLSServer(r)
+}
+
+// newManageUser creates a user with the given alias and roles.
+func newManageUser(t *testing.T, s *store.Store, alias string, roles ...string) store.User {
+	u := store.NewUser(alias)
+
+	err := s.CreateUser(u, testManagePass)
+	if err == nil {
+		err = s.SetUserRoles(u.UserId, roles)
+	}
+
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	return u
+}
+
+// TestManageOutranked verifies a user who can manage users but lacks the
+// admin role cannot act on users with more access than their own.
+func TestManageOutranked(t *testing.T) {
+	cfg := config.NewConfiguration()
+
+	s, err := store.NewStore(testManageDbPath)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+	defer os.Remove(testManageDbPath)
+	defer s.Close()
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	s.SetSecretKey(key)
+
+	role := store.Role{Name: "helpdesk", Permissions: []string{store.PermAdminAccess, store.PermUsersView, store.PermUsersManage}}
+
+	err = s.CreateRole(role)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	newManageUser(t, &s, "helpdesk1234", "helpdesk")
+	owner := newManageUser(t, &s, "owner12345", store.RoleAdmin)
+	plain := newManageUser(t, &s, "plain12345", store.RoleUser)
+
+	srv := newManageServer(&cfg, &s)
+	defer srv.Close()
+
+	pc := newPasskeyClient(t, srv)
+	pc.do("/account/login", url.Values{"username": {"helpdesk1234"}, "password": {testManagePass}})
+
+	_, body := pc.do("/site/admin/recovery", nil)
+	csrf := testCSRFPattern.FindStringSubmatch(body)[1]
+
+	// Recovery links and renames of an admin are refused.
+	_, body = pc.do("/site/admin/recovery", url.Values{"csrf": {csrf}, "alias": {owner.Alias}})
+	if !strings.Contains(body, testForbidden) || strings.Contains(body, "/account/reset?token=") {
+		t.Fatal("Expected recovery of an admin to be forbidden, received", body)
+	}
+
+	_, body = pc.do("/site/admin/rename", url.Values{"csrf": {csrf}, "alias": {owner.Alias}, "username": {"renamed1234"}})
+	if !strings.Contains(body, testForbidden) || s.UserExists("renamed1234") {
+		t.Fatal("Expected rename of an admin to be forbidden, received", body)
+	}
+
+	// Every console action on an admin is refused, and none are shown.
+	_, body = pc.do("/site/admin/users/"+owner.UserId.String(), nil)
+	if strings.Contains(body, "/force-reset") {
+		t.Fatal("Expected no actions on an admin, received", body)
+	}
+
+	_, body = pc.do("/site/admin/users/"+owner.UserId.String()+"/delete", nil)
+	if !strings.Contains(body, testForbidden) {
+		t.Fatal("Expected the delete confirmation to be forbidden, received", body)
+	}
+
+	for _, action := range []string{"reset-failed", "unlock", "reset-2fa", "passkeys", "logout", "force-reset", "delete"} {
+		_, body = pc.do("/site/admin/users/"+owner.UserId.String()+"/"+action, url.Values{"csrf": {csrf}})
+		if !strings.Contains(body, testForbidden) {
+			t.Fatal("Expected", action, "of an admin to be forbidden, received", body)
+		}
+	}
+
+	if _, err := s.GetUser(owner.UserId); err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// The security settings of the site are only for the admin role.
+	_, body = pc.do("/site/admin", nil)
+	if strings.Contains(body, "/site/admin/2fa") {
+		t.Fatal("Expected no site settings, received", body)
+	}
+
+	_, body = pc.do("/site/admin/2fa", url.Values{"csrf": {csrf}, "require": {"false"}})
+	if !strings.Contains(body, testForbidden) {
+		t.Fatal("Expected the site settings to be forbidden, received", body)
+	}
+
+	// Users with no more access can still be managed.
+	_, body = pc.do("/site/admin/recovery", url.Values{"csrf": {csrf}, "alias": {plain.Alias}})
+	if !strings.Contains(body, "/account/reset?token=") {
+		t.Fatal("Expected a recovery link, received", body)
+	}
+
+	_, body = pc.do("/site/admin/users/"+plain.UserId.String()+"/logout", url.Values{"csrf": {csrf}})
+	if strings.Contains(body, testForbidden) {
+		t.Fatal("Expected logout of a user to be allowed, received", body)
+	}
+
+	// Passkeys are listed, and removed when a passphrase reset is forced.
+	s.AddCredential(store.Credential{Id: "bWFuYWdl", UserId: plain.UserId, Name: "Plain Key"})
+
+	_, body = pc.do("/site/admin/users/"+plain.UserId.String(), nil)
+	if !strings.Contains(body, "Plain Key") {
+		t.Fatal("Expected the passkey to be listed, received", body)
+	}
+
+	pc.do("/site/admin/users/"+plain.UserId.String()+"/force-reset", url.Values{"csrf": {csrf}})
+
+	creds, _ := s.GetUserCredentials(plain.UserId)
+	if len(creds) != 0 {
+		t.Fatal("Expected", 0, "passkeys, received", len(creds))
+	}
+}
+
+// TestManageRename verifies an admin can rename a user with the CSRF token of
+// their session, and that the admin account cannot be renamed.
+func TestManageRename(t *testing.T) {
+	cfg := config.NewConfiguration()
+
+	s, err := store.NewStore(testManageDbPath)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+	defer os.Remove(testManageDbPath)
+	defer s.Close()
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	s.SetSecretKey(key)
+
+	newManageUser(t, &s, "admin", store.RoleAdmin)
+	newManageUser(t, &s, "user1234", store.RoleUser)
+
+	srv := newManageServer(&cfg, &s)
+	defer srv.Close()
+
+	pc := newPasskeyClient(t, srv)
+	pc.do("/account/login", url.Values{"username": {"admin"}, "password": {testManagePass}})
+
+	_, body := pc.do("/site/admin/rename", nil)
+	csrf := testCSRFPattern.FindStringSubmatch(body)[1]
+
+	// Renames without the CSRF token are forbidden.
+	_, body = pc.do("/site/admin/rename", url.Values{"alias": {"user1234"}, "username": {"user5678"}})
+	if !strings.Contains(body, testForbidden) || s.UserExists("user5678") {
+		t.Fatal("Expected the rename to be forbidden, received", body)
+	}
+
+	tests := []struct {
+		alias, username, want string
+	}{
+		{"nobody1234", "user9012", "User not found."},
+		{"user1234", "user1234", "Username is already taken."},
+		{"admin", "user9012", "The admin account cannot be renamed."},
+	}
+
+	for _, tt := range tests {
+		_, body = pc.do("/site/admin/rename", url.Values{"csrf": {csrf}, "alias": {tt.alias}, "username": {tt.username}})
+		if !strings.Contains(body, tt.want) {
+			t.Fatal("Expected", tt.want, ", received", body)
+		}
+	}
+
+	_, body = pc.do("/site/admin/rename", url.Values{"csrf": {csrf}, "alias": {"user1234"}, "username": {"user5678"}})
+	if !strings.Contains(body, "Username History for user5678") || !strings.Contains(body, "user1234 changed to user5678") {
+		t.Fatal("Expected the username history, received", body)
+	}
+}
+
+// TestManageRecovery verifies an admin can create a recovery link with the
+// CSRF token of their session, and that the user can see it was issued.
+func TestManageRecovery(t *testing.T) {
+	cfg := config.NewConfiguration()
+
+	s, err := store.NewStore(testManageDbPath)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+	defer os.Remove(testManageDbPath)
+	defer s.Close()
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	s.SetSecretKey(key)
+
+	newManageUser(t, &s, "admin", store.RoleAdmin)
+	newManageUser(t, &s, "user1234", store.RoleUser)
+
+	srv := newManageServer(&cfg, &s)
+	defer srv.Close()
+
+	pc := newPasskeyClient(t, srv)
+	pc.do("/account/login", url.Values{"username": {"admin"}, "password": {testManagePass}})
+
+	_, body := pc.do("/site/admin/recovery", nil)
+	csrf := testCSRFPattern.FindStringSubmatch(body)[1]
+
+	// Recovery links without the CSRF token are forbidden.
+	_, body = pc.do("/site/admin/recovery", url.Values{"alias": {"user1234"}})
+	if !strings.Contains(body, testForbidden) || strings.Contains(body, "/account/reset?token=") {
+		t.Fatal("Expected the recovery link to be forbidden, received", body)
+	}
+
+	_, body = pc.do("/site/admin/recovery", url.Values{"csrf": {csrf}, "alias": {"nobody1234"}})
+	if !strings.Contains(body, "User not found.") {
+		t.Fatal("Expected", "User not found.", ", received", body)
+	}
+
+	// The link is shown once, and the issue is recorded.
+	_, body = pc.do("/site/admin/recovery", url.Values{"csrf": {csrf}, "alias": {"user1234"}})
+	if !strings.Contains(body, "Recovery Link for user1234") || !strings.Contains(body, "/account/reset?token=rset_") {
+		t.Fatal("Expected a recovery link, received", body)
+	}
+
+	if !strings.Contains(body, "recovery_issued issued by admin") {
+		t.Fatal("Expected the issue to be recorded, received", body)
+	}
+
+	_, body = pc.do("/site/admin/recovery?alias=user1234", nil)
+	if strings.Contains(body, "/account/reset?token=rset_") {
+		t.Fatal("Expected the link to be shown once, received", body)
+	}
+
+	// The user can see the recovery link in their activity.
+	pc.do("/account/logout", nil)
+	pc.do("/account/login", url.Values{"username": {"user1234"}, "password": {testManagePass}})
+
+	_, body = pc.do("/site/user/activity", nil)
+	if !strings.Contains(body, "recovery_issued issued by admin") {
+		t.Fatal("Expected the issue in the activity, received", body)
+	}
+}
+
+// TestManageRoles verifies a user who can manage roles but lacks the admin
+// role cannot grant roles or permissions they do not hold.
+func TestManageRoles(t *testing.T) {
+	cfg := config.NewConfiguration()
+
+	s, err := store.NewStore(testManageDbPath)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+	defer os.Remove(testManageDbPath)
+	defer s.Close()
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	s.SetSecretKey(key)
+
+	role := store.Role{Name: "rolekeeper", Permissions: []string{store.PermAdminAccess, store.PermUsersView, store.PermRolesManage}}
+
+	err = s.CreateRole(role)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	keeper := newManageUser(t, &s, "keeper1234", store.RoleUser, "rolekeeper")
+	owner := newManageUser(t, &s, "owner12345", store.RoleAdmin)
+	plain := newManageUser(t, &s, "plain12345", store.RoleUser)
+
+	srv := newManageServer(&cfg, &s)
+	defer srv.Close()
+
+	pc := newPasskeyClient(t, srv)
+	pc.do("/account/login", url.Values{"username": {"keeper1234"}, "password": {testManagePass}})
+
+	_, body := pc.do("/site/admin/roles", nil)
+	csrf := testCSRFPattern.FindStringSubmatch(body)[1]
+
+	// The admin role cannot be granted to another user or to themselves.
+	for _, u := range []store.User{plain, keeper} {
+		_, body = pc.do("/site/admin/users/"+u.UserId.String()+"/roles", url.Values{"csrf": {csrf}, "role": {store.RoleUser, store.RoleAdmin}})
+		if !strings.Contains(body, testForbidden) {
+			t.Fatal("Expected granting admin to be forbidden, received", body)
+		}
+
+		u, _ = s.GetUser(u.UserId)
+		if u.HasRole(store.RoleAdmin) {
+			t.Fatal("Expected", u.Alias, "to lack the admin role")
+		}
+	}
+
+	// The roles of an admin cannot be changed.
+	_, body = pc.do("/site/admin/users/"+owner.UserId.String()+"/roles", url.Values{"csrf": {csrf}, "role": {store.RoleUser}})
+	if !strings.Contains(body, testForbidden) {
+		t.Fatal("Expected changing the roles of an admin to be forbidden, received", body)
+	}
+
+	owner, _ = s.GetUser(owner.UserId)
+	if !owner.HasRole(store.RoleAdmin) {
+		t.Fatal("Expected", owner.Alias, "to keep the admin role")
+	}
+
+	// Roles cannot be given permissions the user lacks, and roles the user
+	// holds cannot be changed.
+	_, body = pc.do("/site/admin/roles", url.Values{"csrf": {csrf}, "name": {"escalate"}, "permission": {store.PermUsersManage}})
+	if !strings.Contains(body, "You cannot grant permissions you do not have.") {
+		t.Fatal("Expected the permission to be denied, received", body)
+	}
+
+	if _, err := s.GetRole("escalate"); err == nil {
+		t.Fatal("Expected the role not to be created")
+	}
+
+	_, body = pc.do("/site/admin/roles/rolekeeper", url.Values{"csrf": {csrf}, "permission": {store.PermAdminAccess, store.PermUsersView, store.PermRolesManage, store.PermUsersManage}})
+	if !strings.Contains(body, "You cannot change a role you hold") {
+		t.Fatal("Expected the held role to be fixed, received", body)
+	}
+
+	role, _ = s.GetRole("rolekeeper")
+	if role.Has(store.PermUsersManage) {
+		t.Fatal("Expected the held role to be unchanged")
+	}
+
+	// Roles with permissions the user holds can be created and granted.
+	_, body = pc.do("/site/admin/roles", url.Values{"csrf": {csrf}, "name": {"viewer"}, "permission": {store.PermUsersView}})
+	if !strings.Contains(body, "The role has been created.") {
+		t.Fatal("Expected the role to be created, received", body)
+	}
+
+	pc.do("/site/admin/users/"+plain.UserId.String()+"/roles", url.Values{"csrf": {csrf}, "role": {store.RoleUser, "viewer"}})
+
+	plain, _ = s.GetUser(plain.UserId)
+	if !plain.HasRole("viewer") {
+		t.Fatal("Expected", plain.Alias, "to hold the viewer role")
+	}
+}
+
+// TestManageInvites verifies a user who can manage invites but cannot access
+// the rest of the admin site can still create invites.
+func TestManageInvites(t *testing.T) {
+	cfg := config.NewConfiguration()
+
+	s, err := store.NewStore(testManageDbPath)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+	defer os.Remove(testManageDbPath)
+	defer s.Close()
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	s.SetSecretKey(key)
+
+	role := store.Role{Name: "inviter", Permissions: []string{store.PermInvitesManage}}
+
+	err = s.CreateRole(role)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	newManageUser(t, &s, "inviter1234", store.RoleUser, "inviter")
+
+	srv := newManageServer(&cfg, &s)
+	defer srv.Close()
+
+	pc := newPasskeyClient(t, srv)
+	pc.do("/account/login", url.Values{"username": {"inviter1234"}, "password": {testManagePass}})
+
+	_, body := pc.do("/site/admin", nil)
+	if !strings.Contains(body, testForbidden) {
+		t.Fatal("Expected the admin site to be forbidden, received", body)
+	}
+
+	if !strings.Contains(body, `href="/site/admin/invites"`) {
+		t.Fatal("Expected a link to the invites, received", body)
+	}
+
+	_, body = pc.do("/site/admin/invites", nil)
+	if strings.Contains(body, testForbidden) {
+		t.Fatal("Expected the invites to be allowed, received", body)
+	}
+
+	csrf := testCSRFPattern.FindStringSubmatch(body)[1]
+
+	_, body = pc.do("/site/admin/invites", url.Values{"csrf": {csrf}, "role": {store.RoleUser}, "uses": {"1"}, "days": {"1"}})
+	if !strings.Contains(body, "/account/register?invite=invt_") {
+		t.Fatal("Expected an invite link, received", body)
+	}
+}
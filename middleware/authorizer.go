@@ -2,16 +2,20 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/asggo/wasp/handler"
 	"github.com/asggo/wasp/store"
+	"github.com/go-chi/chi/v5"
 )
 
 // Authorizer determines if the request has proper session cookie. If so, it
-// loads the user tied to the session cookie in the request. Otherwise it
-// returns an invalid session error.
+// loads the session, the user tied to the session cookie, the permissions
+// granted to the user by their roles, and the user's preferences in the
+// request. Otherwise it returns an invalid session error.
 func Authorizer(s *store.Store) func(next http.Handler) http.Handler {
 	handlerFn := func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
@@ -27,19 +31,32 @@ func Authorizer(s *store.Store) func(next http.Handler) http.Handler {
 				if err != nil {
 					e := fmt.Errorf("could not Authorizer: %v", err)
 					handler.NewServerError(e).Handle(w, r)
+					return
 				}
 
 				e := fmt.Errorf("could not Authorizer: session expired")
 				handler.NewUnauthorizedError(e).Handle(w, r)
+				return
 			}
 
 			user, err := s.GetUser(sess.UserId)
 			if err != nil {
 				e := fmt.Errorf("could not Authorizer: %v", err)
 				handler.NewServerError(e).Handle(w, r)
+				return
+			}
+
+			prefs, err := s.GetPreferences(user.UserId)
+			if err != nil {
+				e := fmt.Errorf("could not Authorizer: %v", err)
+				handler.NewServerError(e).Handle(w, r)
+				return
 			}
 
 			ctx := context.WithValue(r.Context(), "user", user)
+			ctx = context.WithValue(ctx, "session", sess)
+			ctx = context.WithValue(ctx, "permissions", s.GetPermissions(user))
+			ctx = context.WithValue(ctx, "preferences", prefs)
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		}
@@ -50,16 +67,72 @@ func Authorizer(s *store.Store) func(next http.Handler) http.Handler {
 	return handlerFn
 }
 
-// AdminAuthorizer determines if the User object in the request context is an
-// admin user. If so, they are allowed to pass through to the /admin path
-// otherwise they are redirected to the /site path.
-func AdminAuthorizer(next http.Handler) http.Handler {
+// RequirePermission determines if the User object in the request context has
+// been granted the given permission by one of their roles. If so, they are
+// allowed to pass through, otherwise a forbidden error is returned.
+func RequirePermission(perm string) func(next http.Handler) http.Handler {
+	handlerFn := func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			user := r.Context().Value("user").(store.User)
+			perms, _ := r.Context().Value("permissions").(map[string]bool)
+
+			if !perms[perm] {
+				e := fmt.Errorf("could not RequirePermission: %s does not have %s", user.Alias, perm)
+				handler.NewForbiddenError(e).Handle(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+
+	return handlerFn
+}
+
+// RequireRole determines if the User object in the request context has been
+// given the named role. If so, they are allowed to pass through, otherwise a
+// forbidden error is returned. It guards the few actions no permission can be
+// granted for, such as the security settings of the whole site.
+func RequireRole(role string) func(next http.Handler) http.Handler {
+	handlerFn := func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			user := r.Context().Value("user").(store.User)
+
+			if !user.HasRole(role) {
+				e := fmt.Errorf("could not RequireRole: %s does not have %s", user.Alias, role)
+				handler.NewForbiddenError(e).Handle(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+
+	return handlerFn
+}
+
+// CSRF verifies state changing requests include the CSRF token of the session
+// in the request context as the csrf form value. Requests without a matching
+// token are forbidden.
+func CSRF(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		user := r.Context().Value("user").(store.User)
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sess := r.Context().Value("session").(store.Session)
+		token := r.PostFormValue("csrf")
 
-		if !user.Admin {
-			e := fmt.Errorf("could not AdminAuthorizer: %s is not admin user", user.Alias)
+		if sess.CSRFToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRFToken)) != 1 {
+			e := fmt.Errorf("could not CSRF: invalid token for %s", r.URL.Path)
 			handler.NewForbiddenError(e).Handle(w, r)
+			return
 		}
 
 		next.ServeHTTP(w, r)
@@ -67,3 +140,116 @@ func AdminAuthorizer(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(fn)
 }
+
+// PasswordResetEnforcer redirects a user who is required to reset their
+// passphrase to the change password page until they have done so.
+func PasswordResetEnforcer(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(store.User)
+
+		if user.ResetRequired && r.URL.Path != "/site/user/changepw" {
+			http.Redirect(w, r, "/site/user/changepw", http.StatusFound)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// AdminTOTPEnforcer redirects a user with access to the admin site to the
+// two-factor authentication page until they enable it, when two-factor
+// authentication is required for administrators.
+func AdminTOTPEnforcer(s *store.Store) func(next http.Handler) http.Handler {
+	handlerFn := func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			user := r.Context().Value("user").(store.User)
+			perms, _ := r.Context().Value("permissions").(map[string]bool)
+
+			if perms[store.PermAdminAccess] && !strings.HasPrefix(r.URL.Path, "/site/user/2fa") &&
+				s.GetSetting(store.SettingRequireAdminTOTP, "false") == "true" {
+				enabled, err := s.TOTPEnabled(user.UserId)
+				if err != nil {
+					e := fmt.Errorf("could not AdminTOTPEnforcer: %v", err)
+					handler.NewServerError(e).Handle(w, r)
+					return
+				}
+
+				if !enabled {
+					http.Redirect(w, r, "/site/user/2fa", http.StatusFound)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+
+	return handlerFn
+}
+
+// OrgScope loads the organization identified by the org parameter in the
+// request path. If the user in the request context is a member, the
+// organization, the membership, the permissions granted by the membership,
+// and an OrgStore scoped to the organization are loaded in the request.
+// Otherwise a not found error is returned so the organization is not
+// revealed.
+func OrgScope(s *store.Store) func(next http.Handler) http.Handler {
+	handlerFn := func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			user := r.Context().Value("user").(store.User)
+
+			org, err := s.GetOrgById(chi.URLParam(r, "org"))
+			if err != nil {
+				e := fmt.Errorf("could not OrgScope: %v", err)
+				handler.NewNotFoundError(e).Handle(w, r)
+				return
+			}
+
+			m, err := s.GetMembership(org.OrgId, user.UserId)
+			if err != nil {
+				e := fmt.Errorf("could not OrgScope: %v", err)
+				handler.NewNotFoundError(e).Handle(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), "org", org)
+			ctx = context.WithValue(ctx, "membership", m)
+			ctx = context.WithValue(ctx, "orgpermissions", s.GetMemberPermissions(m))
+			ctx = context.WithValue(ctx, "orgstore", s.Org(org.OrgId))
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+
+	return handlerFn
+}
+
+// RequireOrgPermission determines if the membership in the request context
+// has been granted the given permission within the organization. If so, the
+// user is allowed to pass through, otherwise a forbidden error is returned.
+func RequireOrgPermission(perm string) func(next http.Handler) http.Handler {
+	handlerFn := func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			user := r.Context().Value("user").(store.User)
+			perms, _ := r.Context().Value("orgpermissions").(map[string]bool)
+
+			if !perms[perm] {
+				e := fmt.Errorf("could not RequireOrgPermission: %s does not have %s", user.Alias, perm)
+				handler.NewForbiddenError(e).Handle(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+
+	return handlerFn
+}
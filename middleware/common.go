@@ -1,10 +1,12 @@
 package middleware
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
 	"time"
 
+	"github.com/asggo/wasp/handler"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/httplog/v2"
 )
@@ -41,3 +43,26 @@ func SecurityHeaders(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(fn)
 }
+
+// MaxBodySize limits the request body to the given number of bytes. Requests
+// that declare a larger body are refused at once, and reads past the limit
+// fail, so form parsing never consumes more than the limit.
+func MaxBodySize(n int64) func(next http.Handler) http.Handler {
+	handlerFn := func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				e := fmt.Errorf("could not MaxBodySize: body of %d bytes exceeds %d", r.ContentLength, n)
+				handler.NewTooLargeError(e).Handle(w, r)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+
+	return handlerFn
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/handler"
+	"github.com/asggo/wasp/ratelimit"
+	"github.com/asggo/wasp/store"
+)
+
+// RateLimit refuses requests to the route beyond its limits for the client
+// IP, for the account named in the given form field, and for the two
+// together, with a too many requests error. The account limits are skipped
+// when accountField is empty or the form does not name an account.
+func RateLimit(lim *ratelimit.Limiter, route string, rl config.RouteLimits, accountField string) func(next http.Handler) http.Handler {
+	handlerFn := func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r)
+			keys := []ratelimit.Key{
+				{Name: route + "|ip|" + ip, Limit: toLimit(rl.IP)},
+			}
+
+			if accountField != "" {
+				acct := accountName(r.PostFormValue(accountField))
+				if acct != "" {
+					keys = append(keys,
+						ratelimit.Key{Name: route + "|account|" + acct, Limit: toLimit(rl.Account)},
+						ratelimit.Key{Name: route + "|pair|" + ip + "|" + acct, Limit: toLimit(rl.IPAccount)},
+					)
+				}
+			}
+
+			ok, wait := lim.Allow(keys...)
+			if !ok {
+				e := fmt.Errorf("could not RateLimit: %s limit reached for %s", route, ip)
+				handler.NewTooManyRequestsError(e, wait).Handle(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+
+	return handlerFn
+}
+
+// toLimit converts a configured RateLimit to a ratelimit.Limit.
+func toLimit(rl config.RateLimit) ratelimit.Limit {
+	return ratelimit.Limit{Requests: rl.Requests, Period: time.Duration(rl.Period) * time.Second}
+}
+
+// clientIP returns the IP address the request came from. IPv6 addresses are
+// cut to their /64 network, since a single client is usually given a whole
+// one.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+
+	if ip.To4() != nil {
+		return ip.String()
+	}
+
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// accountName returns the canonical form of the alias, so the spellings of
+// an alias share a limit. Aliases that cannot be made canonical are trimmed
+// and lower cased instead.
+func accountName(alias string) string {
+	canon, err := store.CanonicalAlias(alias)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(alias))
+	}
+
+	return canon
+}
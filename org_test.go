@@ -0,0 +1,77 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/store"
+)
+
+var testOrgDbPath = "org_test.db"
+
+// TestOrgMembers verifies adding a member does not tell whether a username
+// exists, and that only organization roles can be given to members.
+func TestOrgMembers(t *testing.T) {
+	cfg := config.NewConfiguration()
+
+	s, err := store.NewStore(testOrgDbPath)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+	defer os.Remove(testOrgDbPath)
+	defer s.Close()
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	s.SetSecretKey(key)
+
+	owner := newManageUser(t, &s, "owner12345", store.RoleUser)
+	member := newManageUser(t, &s, "member1234", store.RoleUser)
+
+	org, err := s.CreateOrg("Example", owner.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	srv := newManageServer(&cfg, &s)
+	defer srv.Close()
+
+	pc := newPasskeyClient(t, srv)
+	pc.do("/account/login", url.Values{"username": {"owner12345"}, "password": {testManagePass}})
+
+	path := "/site/org/" + org.OrgId.String()
+
+	_, body := pc.do(path, nil)
+	csrf := testCSRFPattern.FindStringSubmatch(body)[1]
+
+	// Unknown users, new members, and existing members get the same message.
+	for _, alias := range []string{"nobody1234", "member1234", "member1234"} {
+		_, body = pc.do(path+"/members", url.Values{"csrf": {csrf}, "alias": {alias}})
+		if !strings.Contains(body, "If a user with that username exists, they are now a member.") {
+			t.Fatal("Expected the same message for", alias, ", received", body)
+		}
+	}
+
+	if _, err := s.GetMembership(org.OrgId, member.UserId); err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// Site wide roles are not offered and cannot be given.
+	if strings.Contains(body, `value="admin"`) {
+		t.Fatal("Expected the admin role not to be offered, received", body)
+	}
+
+	_, body = pc.do(path+"/members/"+member.UserId.String()+"/roles", url.Values{"csrf": {csrf}, "role": {store.RoleUser, store.RoleAdmin}})
+	if !strings.Contains(body, "Role not found.") {
+		t.Fatal("Expected", "Role not found.", ", received", body)
+	}
+
+	m, _ := s.GetMembership(org.OrgId, member.UserId)
+	if m.HasRole(store.RoleAdmin) {
+		t.Fatal("Expected", []string{store.RoleUser}, ", received", m.Roles)
+	}
+}
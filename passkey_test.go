@@ -0,0 +1,243 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/mail"
+	"github.com/asggo/wasp/ratelimit"
+	"github.com/asggo/wasp/store"
+	"github.com/asggo/wasp/webauthn"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	testPasskeyDbPath = "passkey_test.db"
+	testPasskeyAlias  = "passkey1234"
+	testPasskeyPass   = "passkeypassword1"
+	testCSRFPattern   = regexp.MustCompile(`name="csrf" type="hidden" value="([^"]+)"`)
+)
+
+// passkeyClient sends requests to the test server with its own cookies.
+type passkeyClient struct {
+	t      *testing.T
+	url    string
+	client *http.Client
+}
+
+// do sends a GET request, or a POST request if form is not nil, and returns
+// the final path and the body of the response.
+func (pc *passkeyClient) do(path string, form url.Values) (string, string) {
+	var resp *http.Response
+	var err error
+
+	if form == nil {
+		resp, err = pc.client.Get(pc.url + path)
+	} else {
+		resp, err = pc.client.PostForm(pc.url+path, form)
+	}
+
+	if err != nil {
+		pc.t.Fatal("Expected", nil, ", received", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	return resp.Request.URL.Path, string(body)
+}
+
+// options fetches the options of a ceremony into opts and returns the
+// ceremony token.
+func (pc *passkeyClient) options(path string, form url.Values, opts interface{}) string {
+	var co struct {
+		Ceremony  string          `json:"ceremony"`
+		PublicKey json.RawMessage `json:"publicKey"`
+	}
+
+	_, body := pc.do(path, form)
+
+	err := json.Unmarshal([]byte(body), &co)
+	if err == nil {
+		err = json.Unmarshal(co.PublicKey, opts)
+	}
+
+	if err != nil {
+		pc.t.Fatal("Expected", nil, ", received", err, body)
+	}
+
+	return co.Ceremony
+}
+
+func newPasskeyClient(t *testing.T, srv *httptest.Server) *passkeyClient {
+	client := *srv.Client()
+	client.Jar, _ = cookiejar.New(nil)
+
+	return &passkeyClient{t: t, url: srv.URL, client: &client}
+}
+
+// TestPasskey registers a passkey with a software authenticator and signs in
+// with it, running both WebAuthn ceremonies through the application routes.
+func TestPasskey(t *testing.T) {
+	cfg := config.NewConfiguration()
+
+	s, err := store.NewStore(testPasskeyDbPath)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+	defer os.Remove(testPasskeyDbPath)
+	defer s.Close()
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	s.SetSecretKey(key)
+
+	user := store.NewUser(testPasskeyAlias)
+	s.CreateUser(user, testPasskeyPass)
+
+	r := chi.NewRouter()
+	r.Mount("/account", accountRouter(&cfg, &s, mail.NewLogMailer(), ratelimit.NewLimiter()))
+	r.Mount("/site", siteRouter(&cfg, &s, mail.NewLogMailer(), nil))
+
+	srv := httptest.NewTLSServer(r)
+	defer srv.Close()
+
+	// The browser runs on the pages of the configured base URL.
+	auth := webauthn.NewAuthenticator(cfg.BaseURL)
+
+	// Log in with the passphrase and register a passkey.
+	pc := newPasskeyClient(t, srv)
+	pc.do("/account/login", url.Values{"username": {testPasskeyAlias}, "password": {testPasskeyPass}})
+
+	_, body := pc.do("/site/user/passkeys", nil)
+	if !strings.Contains(body, "You have no passkeys.") {
+		t.Fatal("Expected no passkeys, received", body)
+	}
+
+	csrf := testCSRFPattern.FindStringSubmatch(body)[1]
+
+	// A ceremony is only started once the user confirms their passphrase.
+	_, body = pc.do("/site/user/passkeys/options", url.Values{"csrf": {csrf}, "password": {"wrongpassword12"}})
+	if !strings.Contains(body, `"error":"Invalid credentials."`) {
+		t.Fatal("Expected invalid credentials, received", body)
+	}
+
+	var create webauthn.CreationOptions
+	ceremony := pc.options("/site/user/passkeys/options", url.Values{"csrf": {csrf}, "password": {testPasskeyPass}}, &create)
+
+	cred, err := auth.Create(create)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	form := url.Values{"csrf": {csrf}, "ceremony": {ceremony}, "credential": {string(cred)}, "name": {"Test Key"}}
+
+	_, body = pc.do("/site/user/passkeys", form)
+	if !strings.Contains(body, "Your passkey has been added.") || !strings.Contains(body, "Test Key") {
+		t.Fatal("Expected passkey to be added, received", body)
+	}
+
+	// The ceremony cannot be answered twice.
+	_, body = pc.do("/site/user/passkeys", form)
+	if !strings.Contains(body, "The passkey could not be added.") {
+		t.Fatal("Expected reused ceremony to fail, received", body)
+	}
+
+	// The registered passkey is excluded from the next registration.
+	pc.options("/site/user/passkeys/options", url.Values{"csrf": {csrf}, "password": {testPasskeyPass}}, &create)
+	if len(create.ExcludeCredentials) != 1 {
+		t.Fatal("Expected", 1, "excluded credential, received", len(create.ExcludeCredentials))
+	}
+
+	_, err = auth.Create(create)
+	if err == nil {
+		t.Fatal("Expected error for registered authenticator, received", nil)
+	}
+
+	// Sign in with the passkey without a passphrase.
+	pc = newPasskeyClient(t, srv)
+
+	var get webauthn.RequestOptions
+	ceremony = pc.options("/account/passkey/options", url.Values{}, &get)
+
+	assertion, err := auth.Get(get)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	form = url.Values{"ceremony": {ceremony}, "credential": {string(assertion)}}
+
+	path, _ := pc.do("/account/passkey", form)
+	if path != "/site" {
+		t.Fatal("Expected", "/site", ", received", path)
+	}
+
+	_, body = pc.do("/site/user", nil)
+	if !strings.Contains(body, testPasskeyAlias) {
+		t.Fatal("Expected to be signed in as", testPasskeyAlias, ", received", body)
+	}
+
+	// The assertion cannot be replayed, even with a new ceremony, since the
+	// challenge and the signature counter no longer match.
+	replay := newPasskeyClient(t, srv)
+
+	_, body = replay.do("/account/passkey", form)
+	if !strings.Contains(body, "Passkey sign-in failed.") {
+		t.Fatal("Expected replay to fail, received", body)
+	}
+
+	form["ceremony"] = []string{replay.options("/account/passkey/options", url.Values{}, &get)}
+
+	_, body = replay.do("/account/passkey", form)
+	if !strings.Contains(body, "Passkey sign-in failed.") {
+		t.Fatal("Expected replay to fail, received", body)
+	}
+
+	// A locked account cannot sign in, and the passkey is not marked as used.
+	policy := store.LockoutPolicy{Threshold: cfg.LockoutThreshold, Duration: cfg.LockoutDuration, Backoff: cfg.LockoutBackoff, MaxDuration: cfg.MaxLockoutDuration}
+	for i := uint64(0); i < cfg.LockoutThreshold; i++ {
+		s.RecordFailedAuth(user.UserId, policy)
+	}
+
+	ceremony = replay.options("/account/passkey/options", url.Values{}, &get)
+	assertion, _ = auth.Get(get)
+
+	_, body = replay.do("/account/passkey", url.Values{"ceremony": {ceremony}, "credential": {string(assertion)}})
+	if !strings.Contains(body, "Too many failed logins.") {
+		t.Fatal("Expected locked account to fail, received", body)
+	}
+
+	s.ResetLockout(user.UserId)
+
+	// A removed passkey cannot sign in.
+	creds, _ := s.GetUserCredentials(user.UserId)
+	if len(creds) != 1 || creds[0].SignCount != 1 {
+		t.Fatal("Expected used credential, received", creds)
+	}
+
+	_, body = pc.do("/site/user/passkeys", nil)
+	csrf = testCSRFPattern.FindStringSubmatch(body)[1]
+
+	_, body = pc.do("/site/user/passkeys/"+creds[0].Id+"/delete", url.Values{"csrf": {csrf}})
+	if !strings.Contains(body, "The passkey has been removed.") {
+		t.Fatal("Expected passkey to be removed, received", body)
+	}
+
+	ceremony = replay.options("/account/passkey/options", url.Values{}, &get)
+	assertion, _ = auth.Get(get)
+
+	_, body = replay.do("/account/passkey", url.Values{"ceremony": {ceremony}, "credential": {string(assertion)}})
+	if !strings.Contains(body, "Passkey sign-in failed.") {
+		t.Fatal("Expected removed passkey to fail, received", body)
+	}
+}
@@ -0,0 +1,150 @@
+package passphrase
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	hashLength = 2 * sha1.Size // hex characters
+	maxLine    = 256           // bytes read to find a line
+)
+
+// BreachList is a file of the upper case hex SHA-1 hashes of breached
+// passphrases, one per line in sorted order, such as the ordered-by-hash
+// download of Have I Been Pwned. Anything after the hash on a line, like the
+// :count suffix of that download, is ignored. The file is searched in place
+// so lists of any size can be used without loading them into memory.
+type BreachList struct {
+	r    io.ReaderAt
+	size int64
+}
+
+// OpenBreachList opens the breach list in the file at the given path.
+func OpenBreachList(path string) (*BreachList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not OpenBreachList: %v", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not OpenBreachList: %v", err)
+	}
+
+	return NewBreachList(f, info.Size()), nil
+}
+
+// NewBreachList returns a BreachList that reads a list of the given size
+// from r.
+func NewBreachList(r io.ReaderAt, size int64) *BreachList {
+	return &BreachList{r: r, size: size}
+}
+
+// Close closes the file of the list if it has one.
+func (l *BreachList) Close() error {
+	c, ok := l.r.(io.Closer)
+	if !ok {
+		return nil
+	}
+
+	return c.Close()
+}
+
+// Contains returns true if the SHA-1 hash of the passphrase is in the list.
+// The list is searched with a binary search over byte offsets: each step
+// reads the first line starting at or after the middle of the range, and the
+// range only ever holds the start of lines not yet ruled out.
+func (l *BreachList) Contains(pw string) (bool, error) {
+	sum := sha1.Sum([]byte(pw))
+	target := []byte(hex.EncodeToString(sum[:]))
+	target = bytes.ToUpper(target)
+
+	lo, hi := int64(0), l.size
+
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		start, line, next, err := l.lineFrom(mid)
+		if err != nil {
+			return false, fmt.Errorf("could not BreachList.Contains: %v", err)
+		}
+
+		// No line starts in the upper half of the range.
+		if start >= hi {
+			hi = mid
+			continue
+		}
+
+		hash := line
+		if len(hash) > hashLength {
+			hash = hash[:hashLength]
+		}
+
+		switch bytes.Compare(bytes.ToUpper(hash), target) {
+		case 0:
+			return true, nil
+		case -1:
+			lo = next
+		default:
+			hi = start
+		}
+	}
+
+	return false, nil
+}
+
+// lineFrom returns the offset and content of the first line starting at or
+// after off, and the offset after it. A line starts at the beginning of the
+// file or after a newline. If no line starts at or after off, the size of
+// the list is returned as the offset.
+func (l *BreachList) lineFrom(off int64) (int64, []byte, int64, error) {
+	start := off
+
+	// Read from the byte before off, so a line starting at off is found
+	// after its newline.
+	if off > 0 {
+		start = off - 1
+	}
+
+	buf := make([]byte, maxLine)
+
+	n, err := l.r.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, nil, 0, err
+	}
+
+	buf = buf[:n]
+
+	if off > 0 {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			if start+int64(n) >= l.size {
+				return l.size, nil, l.size, nil
+			}
+
+			return 0, nil, 0, fmt.Errorf("line longer than %d bytes", maxLine)
+		}
+
+		buf = buf[i+1:]
+		start += int64(i + 1)
+	}
+
+	end := bytes.IndexByte(buf, '\n')
+	if end < 0 {
+		if start+int64(len(buf)) < l.size {
+			return 0, nil, 0, fmt.Errorf("line longer than %d bytes", maxLine)
+		}
+
+		end = len(buf)
+	}
+
+	line := bytes.TrimRight(buf[:end], "\r")
+
+	return start, line, start + int64(end) + 1, nil
+}
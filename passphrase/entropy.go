@@ -0,0 +1,106 @@
+package passphrase
+
+import (
+	"math"
+	"unicode"
+)
+
+// Character pool sizes used to estimate the entropy of a passphrase. Letters
+// outside of ASCII are counted as one large pool.
+const (
+	lowerPool  = 26
+	upperPool  = 26
+	digitPool  = 10
+	symbolPool = 33
+	otherPool  = 100
+)
+
+// Entropy returns a rough estimate of the entropy of the passphrase in bits.
+// It is the lower of two estimates. The first counts each character as a
+// choice from the pools of characters used in the passphrase, but counts a
+// character that repeats the one before it, or continues a run such as 1234
+// or dcba, as one bit. The second is the Shannon entropy of the characters,
+// which is low when a few characters make up the passphrase, as in abababab.
+// Neither finds words or keyboard patterns, which the breach list covers.
+func Entropy(pw string) float64 {
+	runes := []rune(pw)
+	if len(runes) == 0 {
+		return 0
+	}
+
+	return math.Min(patternEntropy(runes), shannonEntropy(runes))
+}
+
+// patternEntropy estimates the entropy from the character pools used,
+// discounting repeated characters and runs.
+func patternEntropy(runes []rune) float64 {
+	var lower, upper, digit, symbol, other bool
+
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r <= unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	for _, p := range []struct {
+		used bool
+		size int
+	}{{lower, lowerPool}, {upper, upperPool}, {digit, digitPool}, {symbol, symbolPool}, {other, otherPool}} {
+		if p.used {
+			pool += p.size
+		}
+	}
+
+	bits := math.Log2(float64(pool))
+	total := 0.0
+
+	for i, r := range runes {
+		switch {
+		case i > 0 && r == runes[i-1]:
+			total++
+		case i > 1 && isRun(runes[i-2], runes[i-1], r):
+			total++
+		default:
+			total += bits
+		}
+	}
+
+	return total
+}
+
+// isRun returns true if the three characters step up or down by one, as in
+// abc or 321.
+func isRun(a, b, c rune) bool {
+	step := b - a
+
+	return (step == 1 || step == -1) && c-b == step
+}
+
+// shannonEntropy returns the Shannon entropy of the characters of the
+// passphrase times its length.
+func shannonEntropy(runes []rune) float64 {
+	counts := make(map[rune]int)
+	for _, r := range runes {
+		counts[r]++
+	}
+
+	n := float64(len(runes))
+	per := 0.0
+
+	for _, c := range counts {
+		p := float64(c) / n
+		per -= p * math.Log2(p)
+	}
+
+	return per * n
+}
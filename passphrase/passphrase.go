@@ -0,0 +1,101 @@
+// Package passphrase implements the policy new passphrases are checked
+// against. A Policy is a list of checks run in order, so sites can add their
+// own checks to the built-in ones for length, estimated entropy, the
+// user's alias, and passphrases known from data breaches.
+package passphrase
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	tooShort    = "The password must be at least %d characters."
+	tooWeak     = "The password is too easy to guess. Use more words, and avoid repeated characters and sequences such as 1234 or abcd."
+	hasAlias    = "The password must not contain your username."
+	breached    = "The password has appeared in a data breach. Choose a different one."
+	breachCheck = "The password could not be checked. Try again later."
+)
+
+// Subject describes the account a passphrase is chosen for, so checks can
+// refuse passphrases built from it.
+type Subject struct {
+	Alias string
+}
+
+// Check checks a passphrase for the given subject and returns an error with
+// a message for the user if the passphrase is refused.
+type Check func(pw string, s Subject) error
+
+// Policy is a list of checks a passphrase must pass.
+type Policy []Check
+
+// Validate runs the checks of the policy in order and returns the error of
+// the first one that refuses the passphrase.
+func (p Policy) Validate(pw string, s Subject) error {
+	for _, check := range p {
+		err := check(pw, s)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MinLength refuses passphrases with fewer than n characters.
+func MinLength(n int) Check {
+	return func(pw string, s Subject) error {
+		if utf8.RuneCountInString(pw) < n {
+			return fmt.Errorf(tooShort, n)
+		}
+
+		return nil
+	}
+}
+
+// MinEntropy refuses passphrases with an estimated entropy of fewer than the
+// given number of bits. See Entropy for how it is estimated.
+func MinEntropy(bits float64) Check {
+	return func(pw string, s Subject) error {
+		if Entropy(pw) < bits {
+			return errors.New(tooWeak)
+		}
+
+		return nil
+	}
+}
+
+// NoAlias refuses passphrases containing the alias of the subject, ignoring
+// case.
+func NoAlias() Check {
+	return func(pw string, s Subject) error {
+		alias := strings.ToLower(s.Alias)
+
+		if alias != "" && strings.Contains(strings.ToLower(pw), alias) {
+			return errors.New(hasAlias)
+		}
+
+		return nil
+	}
+}
+
+// NotBreached refuses passphrases found in the breach list. Passphrases are
+// refused if the list cannot be read, so a missing file does not quietly
+// turn the check off.
+func NotBreached(l *BreachList) Check {
+	return func(pw string, s Subject) error {
+		found, err := l.Contains(pw)
+		if err != nil {
+			return errors.New(breachCheck)
+		}
+
+		if found {
+			return errors.New(breached)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,123 @@
+package passphrase
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testBreachList returns a breach list in the format of the Have I Been
+// Pwned download holding the hashes of the given passphrases.
+func testBreachList(passphrases []string) *BreachList {
+	var lines []string
+
+	for i, pw := range passphrases {
+		sum := sha1.Sum([]byte(pw))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+		lines = append(lines, fmt.Sprintf("%s:%d\r\n", hash, i*i+1))
+	}
+
+	sort.Strings(lines)
+
+	data := []byte(strings.Join(lines, ""))
+
+	return NewBreachList(bytes.NewReader(data), int64(len(data)))
+}
+
+func TestPolicy(t *testing.T) {
+	p := Policy{MinLength(16), MinEntropy(50), NoAlias(), NotBreached(testBreachList([]string{"correcthorsebatterystaple"}))}
+	s := Subject{Alias: "user9012"}
+
+	refused := map[string]string{
+		"short":                        fmt.Sprintf(tooShort, 16),
+		"aaaaaaaaaaaaaaaa":             tooWeak,
+		"abcdefghijklmnop":             tooWeak,
+		"abababababababab":             tooWeak,
+		"1234567890123456":             tooWeak,
+		"my name is USER9012 ok":       hasAlias,
+		"correcthorsebatterystaple":    breached,
+		"seven ducks under one bridge": "",
+		"Tr0ub4dor&3 and more words":   "",
+	}
+
+	for pw, expected := range refused {
+		received := ""
+
+		err := p.Validate(pw, s)
+		if err != nil {
+			received = err.Error()
+		}
+
+		if received != expected {
+			t.Fatal("Expected", expected, ", received", received, "for", pw)
+		}
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	if Entropy("") != 0 {
+		t.Fatal("Expected", 0, ", received", Entropy(""))
+	}
+
+	if Entropy("aaaa") > 4 {
+		t.Fatal("Expected at most", 4, ", received", Entropy("aaaa"))
+	}
+
+	// Runs count less than the same characters out of order.
+	if Entropy("abcdefgh") >= Entropy("hbfdgcae") {
+		t.Fatal("Expected run to count less, received", Entropy("abcdefgh"), Entropy("hbfdgcae"))
+	}
+
+	// Few distinct characters count less, even without runs.
+	if Entropy("abababababababab") != 16 {
+		t.Fatal("Expected", 16, ", received", Entropy("abababababababab"))
+	}
+}
+
+func TestBreachList(t *testing.T) {
+	var breached []string
+	for i := 0; i < 500; i++ {
+		breached = append(breached, fmt.Sprintf("passphrase%d", i))
+	}
+
+	l := testBreachList(breached)
+
+	for _, pw := range breached {
+		found, err := l.Contains(pw)
+		if err != nil || !found {
+			t.Fatal("Expected", pw, "to be found, received", found, err)
+		}
+	}
+
+	for i := 500; i < 1000; i++ {
+		pw := fmt.Sprintf("passphrase%d", i)
+
+		found, err := l.Contains(pw)
+		if err != nil || found {
+			t.Fatal("Expected", pw, "not to be found, received", found, err)
+		}
+	}
+
+	// Plain hashes with no count or final newline are read too.
+	sum := sha1.Sum([]byte("password"))
+	data := []byte("0000000000000000000000000000000000000000\n" + hex.EncodeToString(sum[:]))
+
+	l = NewBreachList(bytes.NewReader(data), int64(len(data)))
+
+	found, err := l.Contains("password")
+	if err != nil || !found {
+		t.Fatal("Expected", true, ", received", found, err)
+	}
+
+	// An empty list holds nothing.
+	l = NewBreachList(bytes.NewReader(nil), 0)
+
+	found, err = l.Contains("password")
+	if err != nil || found {
+		t.Fatal("Expected", false, ", received", found, err)
+	}
+}
@@ -0,0 +1,234 @@
+// Package ratelimit limits how often clients can make requests with token
+// buckets. Each bucket holds up to the number of requests a Limit allows and
+// refills steadily over its period, so short bursts are allowed while the
+// average rate is held to the limit.
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/asggo/wasp/store"
+)
+
+// maxBuckets limits the number of buckets kept in memory. Tests lower it.
+var maxBuckets = 100000
+
+// Limit allows Requests requests in each Period. A Limit with no requests
+// or no period does not limit anything.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// enabled returns true if the limit limits anything.
+func (l Limit) enabled() bool {
+	return l.Requests > 0 && l.Period > 0
+}
+
+// rate returns the number of tokens added to a bucket each second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Key names a bucket and the limit it is held to. Buckets are shared by
+// every Key with the same name, so names must include what is limited, such
+// as the route and the client IP.
+type Key struct {
+	Name  string
+	Limit Limit
+}
+
+// bucket holds the tokens left in a bucket, when they were counted, and when
+// the bucket is full again.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+// Limiter holds the token buckets of every client being limited.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]bucket
+	now     func() time.Time
+}
+
+// refill returns the tokens in the bucket with the given name at the given
+// time. New buckets are full.
+func (l *Limiter) refill(name string, limit Limit, now time.Time) float64 {
+	b, ok := l.buckets[name]
+	if !ok {
+		return float64(limit.Requests)
+	}
+
+	tokens := b.tokens + now.Sub(b.updated).Seconds()*limit.rate()
+
+	return math.Min(tokens, float64(limit.Requests))
+}
+
+// Allow takes a token from the bucket of each key and returns true if every
+// bucket has one. Otherwise no tokens are taken, and the time until every
+// bucket has a token again is returned, so clients that wait are let in.
+func (l *Limiter) Allow(keys ...Key) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	tokens := make([]float64, len(keys))
+
+	var wait time.Duration
+
+	for i, k := range keys {
+		if !k.Limit.enabled() {
+			continue
+		}
+
+		tokens[i] = l.refill(k.Name, k.Limit, now)
+		if tokens[i] < 1 {
+			d := time.Duration((1 - tokens[i]) / k.Limit.rate() * float64(time.Second))
+			wait = max(wait, d)
+		}
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+
+	l.makeRoom(keys, now)
+
+	for i, k := range keys {
+		if k.Limit.enabled() {
+			left := tokens[i] - 1
+			full := now.Add(time.Duration((float64(k.Limit.Requests) - left) / k.Limit.rate() * float64(time.Second)))
+
+			l.buckets[k.Name] = bucket{tokens: left, updated: now, full: full}
+		}
+	}
+
+	return true, 0
+}
+
+// makeRoom removes buckets when the buckets of the keys would not fit under
+// maxBuckets. Buckets that are full again go first, since they are the same
+// as no bucket. Only if that is not enough are the least recently used of
+// the other buckets removed, down to nine tenths of maxBuckets so the next
+// new buckets fit without another pass. The buckets of the keys are kept.
+func (l *Limiter) makeRoom(keys []Key, now time.Time) {
+	added := 0
+	for _, k := range keys {
+		if _, ok := l.buckets[k.Name]; !ok && k.Limit.enabled() {
+			added++
+		}
+	}
+
+	if len(l.buckets)+added <= maxBuckets {
+		return
+	}
+
+	for name, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, name)
+		}
+	}
+
+	if len(l.buckets)+added <= maxBuckets {
+		return
+	}
+
+	requested := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		requested[k.Name] = true
+	}
+
+	names := make([]string, 0, len(l.buckets))
+	for name := range l.buckets {
+		if !requested[name] {
+			names = append(names, name)
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return l.buckets[names[i]].updated.Before(l.buckets[names[j]].updated)
+	})
+
+	excess := min(len(l.buckets)+added-maxBuckets*9/10, len(names))
+	for _, name := range names[:excess] {
+		delete(l.buckets, name)
+	}
+}
+
+// Prune removes the buckets that have been idle for longer than the given
+// time. A bucket idle for the period of its limit is full again, and the
+// same as no bucket.
+func (l *Limiter) Prune(idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	for name, b := range l.buckets {
+		if now.Sub(b.updated) > idle {
+			delete(l.buckets, name)
+		}
+	}
+}
+
+// Save records the buckets in the Store.
+func (l *Limiter) Save(s *store.Store) error {
+	l.mu.Lock()
+
+	buckets := make(map[string]store.RateBucket, len(l.buckets))
+	for name, b := range l.buckets {
+		buckets[name] = store.RateBucket{Tokens: b.tokens, Updated: b.updated.UnixNano(), Full: b.full.UnixNano()}
+	}
+
+	l.mu.Unlock()
+
+	return s.SaveRateBuckets(buckets)
+}
+
+// Load replaces the buckets with the ones recorded in the Store.
+func (l *Limiter) Load(s *store.Store) error {
+	buckets, err := s.GetRateBuckets()
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buckets = make(map[string]bucket, len(buckets))
+	for name, rb := range buckets {
+		l.buckets[name] = bucket{tokens: rb.Tokens, updated: time.Unix(0, rb.Updated), full: time.Unix(0, rb.Full)}
+	}
+
+	return nil
+}
+
+// SaveEvery prunes the buckets idle for longer than idle and records the
+// rest in the Store at each interval. It does not return. The interval must
+// be positive.
+func (l *Limiter) SaveEvery(s *store.Store, interval, idle time.Duration) {
+	if interval <= 0 {
+		panic(fmt.Errorf("could not Limiter.SaveEvery: interval %v is not positive", interval))
+	}
+
+	for range time.Tick(interval) {
+		l.Prune(idle)
+
+		err := l.Save(s)
+		if err != nil {
+			slog.Error(fmt.Sprintf("could not Limiter.SaveEvery: %v", err))
+		}
+	}
+}
+
+// NewLimiter creates a Limiter with no buckets.
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: make(map[string]bucket), now: time.Now}
+}
@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/asggo/wasp/store"
+)
+
+// newTestLimiter returns a Limiter whose clock only moves when the returned
+// function is called.
+func newTestLimiter() (*Limiter, func(time.Duration)) {
+	now := time.Unix(1700000000, 0)
+	l := NewLimiter()
+	l.now = func() time.Time { return now }
+
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestAllow(t *testing.T) {
+	l, advance := newTestLimiter()
+	ip := Key{Name: "ip", Limit: Limit{Requests: 3, Period: 3 * time.Second}}
+
+	// A new bucket allows a burst of the full limit.
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow(ip)
+		if !ok {
+			t.Fatal("Expected request", i, "to be allowed")
+		}
+	}
+
+	ok, wait := l.Allow(ip)
+	if ok || wait != time.Second {
+		t.Fatal("Expected a denial with a 1s wait, received", ok, wait)
+	}
+
+	// The bucket refills over the period.
+	advance(time.Second)
+
+	ok, _ = l.Allow(ip)
+	if !ok {
+		t.Fatal("Expected a request to be allowed after waiting")
+	}
+
+	// A disabled limit never denies.
+	for i := 0; i < 10; i++ {
+		ok, _ := l.Allow(Key{Name: "none"})
+		if !ok {
+			t.Fatal("Expected a disabled limit to allow request", i)
+		}
+	}
+}
+
+func TestAllowAll(t *testing.T) {
+	l, _ := newTestLimiter()
+	acct := Key{Name: "account", Limit: Limit{Requests: 1, Period: time.Minute}}
+	ip1 := Key{Name: "ip1", Limit: Limit{Requests: 5, Period: time.Minute}}
+	ip2 := Key{Name: "ip2", Limit: Limit{Requests: 5, Period: time.Minute}}
+
+	ok, _ := l.Allow(ip1, acct)
+	if !ok {
+		t.Fatal("Expected the first request to be allowed")
+	}
+
+	// The account bucket is empty, so the request is denied and the IP
+	// bucket keeps its tokens.
+	ok, wait := l.Allow(ip2, acct)
+	if ok || wait != time.Minute {
+		t.Fatal("Expected a denial with a 1m wait, received", ok, wait)
+	}
+
+	for i := 0; i < 5; i++ {
+		ok, _ := l.Allow(ip2)
+		if !ok {
+			t.Fatal("Expected request", i, "from ip2 to be allowed")
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	l, advance := newTestLimiter()
+	k := Key{Name: "ip", Limit: Limit{Requests: 1, Period: time.Minute}}
+
+	l.Allow(k)
+	advance(2 * time.Minute)
+	l.Prune(time.Minute)
+
+	if len(l.buckets) != 0 {
+		t.Fatal("Expected no buckets, received", l.buckets)
+	}
+}
+
+func TestMakeRoom(t *testing.T) {
+	defer func(n int) { maxBuckets = n }(maxBuckets)
+	maxBuckets = 4
+
+	l, advance := newTestLimiter()
+	slow := Limit{Requests: 1, Period: time.Minute}
+
+	l.Allow(Key{Name: "a", Limit: slow})
+	l.Allow(Key{Name: "b", Limit: slow})
+	advance(time.Second)
+	l.Allow(Key{Name: "c", Limit: slow})
+	l.Allow(Key{Name: "d", Limit: Limit{Requests: 100, Period: time.Second}})
+	advance(time.Second)
+
+	// A bucket that is full again makes room before any other.
+	l.Allow(Key{Name: "e", Limit: slow})
+
+	if _, ok := l.buckets["d"]; ok || len(l.buckets) != 4 {
+		t.Fatal("Expected the full bucket to be removed, received", l.buckets)
+	}
+
+	if ok, _ := l.Allow(Key{Name: "a", Limit: slow}); ok {
+		t.Fatal("Expected the empty bucket to be kept")
+	}
+
+	// Otherwise the least recently used buckets make room, leaving the
+	// rest as they were.
+	l.Allow(Key{Name: "f", Limit: slow})
+
+	for _, name := range []string{"a", "b"} {
+		if _, ok := l.buckets[name]; ok {
+			t.Fatal("Expected bucket", name, "to be removed, received", l.buckets)
+		}
+	}
+
+	for _, name := range []string{"c", "e", "f"} {
+		if ok, _ := l.Allow(Key{Name: name, Limit: slow}); ok {
+			t.Fatal("Expected bucket", name, "to be kept")
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := "ratelimit_test.db"
+
+	s, err := store.NewStore(path)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+	defer os.Remove(path)
+	defer s.Close()
+
+	l, _ := newTestLimiter()
+	k := Key{Name: "ip", Limit: Limit{Requests: 1, Period: time.Minute}}
+	l.Allow(k)
+
+	err = l.Save(&s)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// A new Limiter with the saved buckets keeps the limit.
+	r, _ := newTestLimiter()
+
+	err = r.Load(&s)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if !r.buckets["ip"].full.Equal(l.buckets["ip"].full) {
+		t.Fatal("Expected", l.buckets["ip"], ", received", r.buckets["ip"])
+	}
+
+	ok, _ := r.Allow(k)
+	if ok {
+		t.Fatal("Expected the loaded bucket to deny the request")
+	}
+}
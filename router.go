@@ -1,12 +1,16 @@
 package webapp
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/asggo/wasp/config"
 	"github.com/asggo/wasp/handler"
+	"github.com/asggo/wasp/mail"
 	"github.com/asggo/wasp/middleware"
+	"github.com/asggo/wasp/ratelimit"
 	"github.com/asggo/wasp/store"
+	"github.com/asggo/wasp/upload"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -22,33 +26,53 @@ func indexRouter(c *config.Config, s *store.Store) http.Handler {
 }
 
 // accountRouter defines all of the routes needed for account creation and
-// authentication.
-func accountRouter(c *config.Config, s *store.Store) http.Handler {
+// authentication. The routes that check credentials or send mail are rate
+// limited by the Limiter.
+func accountRouter(c *config.Config, s *store.Store, m mail.Mailer, lim *ratelimit.Limiter) http.Handler {
 	r := chi.NewRouter()
 	ah := handler.NewAuthHandler(c, s)
-	rh := handler.NewRegisterHandler(c, s)
+	rh := handler.NewRegisterHandler(c, s, m)
+	ph := handler.NewResetHandler(c, s, m)
+
+	limit := func(route, accountField string) chi.Router {
+		return r.With(middleware.RateLimit(lim, route, c.RateLimits[route], accountField))
+	}
 
 	r.Get("/", ah.Index)
-	r.Post("/login", ah.Login)
+	limit("/account/login", "username").Post("/login", ah.Login)
 	r.Get("/logout", ah.Logout)
 	r.Get("/register", rh.Index)
-	r.Post("/register", rh.Register)
-	r.Post("/admin", rh.RegisterAdmin)
+	limit("/account/register", "").Post("/register", rh.Register)
+	limit("/account/admin", "").Post("/admin", rh.RegisterAdmin)
+	r.Get("/verify", rh.VerifyEmail)
+	r.Get("/forgot", ph.Index)
+	limit("/account/forgot", "username").Post("/forgot", ph.Forgot)
+	r.Get("/reset", ph.ShowReset)
+	limit("/account/reset", "").Post("/reset", ph.ExecReset)
+	r.Get("/2fa", ah.ShowTwoFactor)
+	limit("/account/2fa", "").Post("/2fa", ah.ExecTwoFactor)
+	limit("/account/passkey/options", "").Post("/passkey/options", ah.PasskeyOptions)
+	limit("/account/passkey", "").Post("/passkey", ah.PasskeyLogin)
 
 	return r
 }
 
 // siteRouter defines all of the routes needed for the authenticated portion
 // of the site.
-func siteRouter(c *config.Config, s *store.Store) http.Handler {
+func siteRouter(c *config.Config, s *store.Store, m mail.Mailer, fs upload.Storage) http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.Authorizer(s))
+	r.Use(middleware.PasswordResetEnforcer)
+	r.Use(middleware.AdminTOTPEnforcer(s))
 
 	h := handler.NewSiteHandler(s)
 
 	r.Get("/", h.Index)
-	r.Mount("/admin", adminRouter(s))
-	r.Mount("/user", userRouter(c, s))
+	r.Mount("/admin", adminRouter(c, s, m))
+	r.Mount("/user", userRouter(c, s, m))
+	r.Mount("/uploads", uploadRouter(c, s, fs))
+	r.Mount("/orgs", orgsRouter(c, s))
+	r.Mount("/org/{org}", orgRouter(c, s))
 
 	return r
 }
@@ -57,27 +81,193 @@ func siteRouter(c *config.Config, s *store.Store) http.Handler {
 // use the Authorizer middleware.
 
 // adminRouter defines all of the routes needed for the administrative portion
-// of the site. Includes middleware to confirm a user is an admin.
-func adminRouter(s *store.Store) http.Handler {
+// of the site, and the expvar metrics at /metrics. Includes middleware to
+// confirm a user has permission to access the admin site and to take each
+// action, and to protect renames, recovery links, and the site settings
+// against CSRF. Invites only need the invites.manage permission, so they are
+// mounted outside the check for access to the admin site.
+func adminRouter(c *config.Config, s *store.Store, m mail.Mailer) http.Handler {
+	r := chi.NewRouter()
+	r.Mount("/invites", invitesRouter(c, s))
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequirePermission(store.PermAdminAccess))
+
+		h := handler.NewAdminHandler(c, s)
+		manage := r.With(middleware.RequirePermission(store.PermUsersManage), middleware.CSRF)
+
+		r.Get("/", h.Index)
+		r.Get("/metrics", expvar.Handler().ServeHTTP)
+		manage.Get("/rename", h.ShowRename)
+		manage.Post("/rename", h.ExecRename)
+		manage.Get("/recovery", h.ShowRecovery)
+		manage.Post("/recovery", h.ExecRecovery)
+		r.With(middleware.RequireRole(store.RoleAdmin), middleware.CSRF).Post("/2fa", h.ExecRequireTOTP)
+		r.Mount("/users", usersRouter(c, s))
+		r.Mount("/roles", rolesRouter(c, s))
+		r.Mount("/pending", pendingRouter(c, s, m))
+	})
+
+	return r
+}
+
+// usersRouter defines all of the routes needed for the user management
+// console. Includes middleware to protect the console actions against CSRF.
+func usersRouter(c *config.Config, s *store.Store) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.RequirePermission(store.PermUsersView))
+	r.Use(middleware.CSRF)
+
+	h := handler.NewUsersHandler(c, s)
+	manage := r.With(middleware.RequirePermission(store.PermUsersManage))
+	roles := r.With(middleware.RequirePermission(store.PermRolesManage))
+
+	r.Get("/", h.Index)
+	r.Get("/{id}", h.Detail)
+	roles.Post("/{id}/roles", h.ExecRoles)
+	manage.Get("/{id}/{action}", h.ConfirmAction)
+	manage.Post("/{id}/{action}", h.ExecAction)
+
+	return r
+}
+
+// rolesRouter defines all of the routes needed to create and change roles.
+// Includes middleware to protect the role changes against CSRF.
+func rolesRouter(c *config.Config, s *store.Store) http.Handler {
 	r := chi.NewRouter()
-	r.Use(middleware.AdminAuthorizer)
+	r.Use(middleware.RequirePermission(store.PermRolesManage))
+	r.Use(middleware.CSRF)
 
-	h := handler.NewAdminHandler(s)
+	h := handler.NewRolesHandler(c, s)
 
 	r.Get("/", h.Index)
+	r.Post("/", h.CreateRole)
+	r.Get("/{name}", h.Detail)
+	r.Post("/{name}", h.SaveRole)
 
 	return r
 }
 
 // userRouter defines all of the routes needed to manage the user account.
-func userRouter(c *config.Config, s *store.Store) http.Handler {
+// Includes middleware to protect password, username, and email changes,
+// preferences, two-factor authentication, passkeys, and account deletion
+// against CSRF.
+func userRouter(c *config.Config, s *store.Store, m mail.Mailer) http.Handler {
 	r := chi.NewRouter()
 
-	h := handler.NewUserHandler(c, s)
+	h := handler.NewUserHandler(c, s, m)
+	th := handler.NewTwoFactorHandler(c, s)
+	ph := handler.NewPasskeyHandler(c, s)
+	protected := r.With(middleware.CSRF)
 
 	r.Get("/", h.Index)
+	r.Get("/activity", h.Activity)
 	r.Get("/changepw", h.ShowChangePassword)
-	r.Post("/changepw", h.ExecChangePassword)
+	protected.Post("/changepw", h.ExecChangePassword)
+	r.Get("/alias", h.ShowChangeAlias)
+	protected.Post("/alias", h.ExecChangeAlias)
+	r.Get("/email", h.ShowEmail)
+	protected.Post("/email", h.ExecChangeEmail)
+	protected.Post("/email/verify", h.ExecVerifyEmail)
+	r.Get("/2fa", th.Index)
+	protected.Post("/2fa", th.Enable)
+	protected.Post("/2fa/disable", th.Disable)
+	protected.Post("/2fa/recovery", th.Recovery)
+	r.Get("/passkeys", ph.Index)
+	protected.Post("/passkeys/options", ph.Options)
+	protected.Post("/passkeys", ph.Register)
+	protected.Post("/passkeys/{id}/delete", ph.Delete)
+	r.Get("/preferences", h.ShowPreferences)
+	protected.Post("/preferences", h.ExecPreferences)
+	r.Get("/export", h.Export)
+	r.Get("/delete", h.ShowDeleteAccount)
+	protected.Post("/delete", h.ExecDeleteAccount)
+
+	return r
+}
+
+// uploadRouter defines all of the routes needed to upload, download, and
+// delete files. Includes middleware to limit the size of request bodies,
+// which runs before the CSRF check parses the form, and to protect changes
+// against CSRF.
+func uploadRouter(c *config.Config, s *store.Store, fs upload.Storage) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.MaxBodySize(c.MaxUploadSize + 1<<20)) // room for the other form fields
+	r.Use(middleware.CSRF)
+
+	h := handler.NewUploadHandler(c, s, fs)
+
+	r.Get("/", h.Index)
+	r.Post("/", h.Upload)
+	r.Get("/{id}", h.Download)
+	r.Post("/{id}/delete", h.Delete)
+
+	return r
+}
+
+// invitesRouter defines all of the routes needed to manage registration
+// invites. Includes middleware to protect invite changes against CSRF.
+func invitesRouter(c *config.Config, s *store.Store) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.RequirePermission(store.PermInvitesManage))
+	r.Use(middleware.CSRF)
+
+	h := handler.NewInvitesHandler(c, s)
+
+	r.Get("/", h.Index)
+	r.Post("/", h.CreateInvite)
+	r.Post("/{id}/revoke", h.RevokeInvite)
+
+	return r
+}
+
+// pendingRouter defines all of the routes needed to approve or reject users
+// awaiting approval. Includes middleware to protect the decisions against
+// CSRF.
+func pendingRouter(c *config.Config, s *store.Store, m mail.Mailer) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.RequirePermission(store.PermUsersManage))
+	r.Use(middleware.CSRF)
+
+	h := handler.NewPendingHandler(c, s, m)
+
+	r.Get("/", h.Index)
+	r.Post("/{id}/approve", h.Approve)
+	r.Post("/{id}/reject", h.Reject)
+
+	return r
+}
+
+// orgsRouter defines all of the routes needed to list, switch between, and
+// create organizations. Includes middleware to protect organization creation
+// against CSRF.
+func orgsRouter(c *config.Config, s *store.Store) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.CSRF)
+
+	h := handler.NewOrgHandler(c, s)
+
+	r.Get("/", h.Index)
+	r.Post("/", h.CreateOrg)
+
+	return r
+}
+
+// orgRouter defines all of the routes scoped to a single organization.
+// Includes middleware to confirm the user is a member of the organization, to
+// load the organization's scoped store, and to protect changes against CSRF.
+func orgRouter(c *config.Config, s *store.Store) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.OrgScope(s))
+	r.Use(middleware.CSRF)
+
+	h := handler.NewOrgHandler(c, s)
+	manage := r.With(middleware.RequireOrgPermission(store.PermOrgManage))
+
+	r.Get("/", h.Show)
+	manage.Post("/members", h.AddMember)
+	manage.Post("/members/{id}/roles", h.SetMemberRoles)
+	manage.Post("/members/{id}/remove", h.RemoveMember)
 
 	return r
 }
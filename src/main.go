@@ -1,12 +1,41 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"net/http"
+	"os"
 
 	"github.com/asggo/wasp"
 )
 
 func main() {
+	calibrate := flag.Bool("calibrate", false, "benchmark Argon2id, record the parameters for new passphrase hashes, and exit")
+	clearPolicy := flag.Bool("clear-calibration", false, "remove the recorded Argon2id parameters so the configured ones are used, and exit")
+	flag.Parse()
+
+	if *clearPolicy {
+		err := webapp.ClearHashCalibration()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Argon2id parameters cleared; the configured ones will be used.")
+		return
+	}
+
+	if *calibrate {
+		p, err := webapp.CalibrateHashing()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Argon2id parameters recorded: m=%d,t=%d,p=%d\n", p.Memory, p.Time, p.Threads)
+		return
+	}
+
 	app := webapp.NewApplication()
 
 	http.ListenAndServe(":8000", app.Router())
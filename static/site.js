@@ -1,3 +1,93 @@
 window.addEventListener("load", runOnPageLoad);
 
-function runOnPageLoad() {}
\ No newline at end of file
+function runOnPageLoad() {
+    setupPasskeyForm("passkey-register", "create");
+    setupPasskeyForm("passkey-login", "get");
+}
+
+// setupPasskeyForm runs a WebAuthn ceremony when the form with the given id
+// is submitted. The form is hidden in browsers without WebAuthn.
+function setupPasskeyForm(id, ceremony) {
+    var form = document.getElementById(id);
+    if (!form) {
+        return;
+    }
+
+    if (!window.PublicKeyCredential) {
+        form.hidden = true;
+        return;
+    }
+
+    form.addEventListener("submit", function (event) {
+        event.preventDefault();
+
+        runPasskeyCeremony(form, ceremony).catch(function (err) {
+            form.querySelector(".passkey-error").textContent = err.reason || "The passkey request was cancelled or failed.";
+        });
+    });
+}
+
+// runPasskeyCeremony fetches the ceremony options named by the form, asks the
+// authenticator for a credential, and submits the credential with the form.
+async function runPasskeyCeremony(form, ceremony) {
+    var resp = await fetch(form.dataset.options, {
+        method: "POST",
+        body: new URLSearchParams(new FormData(form)),
+        credentials: "same-origin",
+    });
+
+    // Requests the server refuses, such as a wrong passphrase, carry the
+    // reason to show on the page.
+    if (!resp.ok) {
+        var err = new Error(resp.statusText);
+        err.reason = await resp.json().then(function (body) { return body.error; }, function () { return ""; });
+        throw err;
+    }
+
+    var options = await resp.json();
+    var publicKey = options.publicKey;
+
+    publicKey.challenge = fromBase64url(publicKey.challenge);
+    if (publicKey.user) {
+        publicKey.user.id = fromBase64url(publicKey.user.id);
+    }
+
+    (publicKey.excludeCredentials || []).concat(publicKey.allowCredentials || []).forEach(function (c) {
+        c.id = fromBase64url(c.id);
+    });
+
+    var cred;
+    if (ceremony == "create") {
+        cred = await navigator.credentials.create({ publicKey: publicKey });
+    } else {
+        cred = await navigator.credentials.get({ publicKey: publicKey });
+    }
+
+    form.elements.ceremony.value = options.ceremony;
+    form.elements.credential.value = JSON.stringify(credentialToJSON(cred));
+    form.submit();
+}
+
+// credentialToJSON returns the JSON form of a PublicKeyCredential with binary
+// values base64url encoded.
+function credentialToJSON(cred) {
+    var response = { clientDataJSON: toBase64url(cred.response.clientDataJSON) };
+
+    ["attestationObject", "authenticatorData", "signature", "userHandle"].forEach(function (name) {
+        if (cred.response[name]) {
+            response[name] = toBase64url(cred.response[name]);
+        }
+    });
+
+    return { id: cred.id, rawId: toBase64url(cred.rawId), type: cred.type, response: response };
+}
+
+function fromBase64url(s) {
+    s = s.replace(/-/g, "+").replace(/_/g, "/");
+    return Uint8Array.from(atob(s), function (c) { return c.charCodeAt(0); });
+}
+
+function toBase64url(buf) {
+    var s = String.fromCharCode.apply(null, new Uint8Array(buf));
+    return btoa(s).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
\ No newline at end of file
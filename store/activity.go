@@ -0,0 +1,139 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Activity actions recorded by the Store.
+const (
+	ActivityPasswordReset  = "password_reset"
+	ActivityRecoveryIssued = "recovery_issued"
+	ActivityRecoveryUsed   = "recovery_used"
+	ActivityAdminAction    = "admin_action"
+	ActivityAliasFlagged   = "alias_flagged"
+	ActivityDataExported   = "data_exported"
+	ActivityAccountLocked  = "account_locked"
+	ActivityTOTPEnabled    = "totp_enabled"
+	ActivityTOTPDisabled   = "totp_disabled"
+	ActivityPasskeyAdded   = "passkey_added"
+	ActivityPasskeyRemoved = "passkey_removed"
+
+	ActivityRecoveryCodesCreated = "recovery_codes_generated"
+	ActivityRecoveryCodeUsed     = "recovery_code_used"
+)
+
+var (
+	activityKey    = "%s:%020d"
+	activityPrefix = "%s:"
+)
+
+//----------------------------------------------------------------------------
+// Activity Struct
+//----------------------------------------------------------------------------
+
+// Activity records a single security relevant event on a user account.
+type Activity struct {
+	UserId UserToken `json:"user_id"`
+	Action string    `json:"action"`
+	Detail string    `json:"detail"`
+	Time   int64     `json:"time"`
+}
+
+// Date returns the time of the activity formatted for display.
+func (a Activity) Date() string {
+	return time.Unix(0, a.Time).UTC().Format(time.RFC1123)
+}
+
+// bytes converts an Activity object to a JSON byte array.
+func (a *Activity) bytes() ([]byte, error) {
+	var b []byte
+
+	b, err := json.Marshal(a)
+	if err != nil {
+		return b, fmt.Errorf("could not Activity.bytes: %v", err)
+	}
+
+	return b, nil
+}
+
+// addActivity records an activity for the user identified by uid within the
+// given transaction. Activities are keyed by user id and time so they can be
+// listed in order.
+func addActivity(tx *bolt.Tx, uid UserToken, action, detail string) error {
+	a := Activity{
+		UserId: uid,
+		Action: action,
+		Detail: detail,
+		Time:   time.Now().UnixNano(),
+	}
+
+	data, err := a.bytes()
+	if err != nil {
+		return err
+	}
+
+	b := tx.Bucket([]byte(activityBucket))
+	key := fmt.Sprintf(activityKey, uid.String(), a.Time)
+
+	// Activities recorded in the same nanosecond must not overwrite each
+	// other.
+	for b.Get([]byte(key)) != nil {
+		a.Time = a.Time + 1
+		key = fmt.Sprintf(activityKey, uid.String(), a.Time)
+	}
+
+	return b.Put([]byte(key), data)
+}
+
+//----------------------------------------------------------------------------
+// Activity Storage Methods
+//----------------------------------------------------------------------------
+
+// AddActivity records an activity for the user identified by uid.
+func (s *Store) AddActivity(uid UserToken, action, detail string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return addActivity(tx, uid, action, detail)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.AddActivity: %v", err)
+	}
+
+	return nil
+}
+
+// GetActivity returns the activities recorded for the user identified by uid,
+// oldest first.
+func (s *Store) GetActivity(uid UserToken) ([]Activity, error) {
+	var activities []Activity
+
+	prefix := []byte(fmt.Sprintf(activityPrefix, uid.String()))
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(activityBucket)).Cursor()
+
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var a Activity
+
+			err := json.Unmarshal(v, &a)
+			if err != nil {
+				return err
+			}
+
+			activities = append(activities, a)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return activities, fmt.Errorf("could not Store.GetActivity: %v", err)
+	}
+
+	return activities, nil
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+var (
+	testActivityAction = "test_action"
+	testActivityDbPath = "activity_test.db"
+)
+
+func testStoreActivity(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	u2 := NewUser(testAliasOther)
+	db := newTestStore(t, testActivityDbPath)
+	defer deleteTestStore(t, testActivityDbPath)
+
+	activities, err := db.GetActivity(u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if len(activities) != 0 {
+		t.Fatal("Expected", 0, ", received", len(activities))
+	}
+
+	// Add activities to both users.
+	for i := 0; i < 3; i++ {
+		err = db.AddActivity(u1.UserId, testActivityAction, fmt.Sprint(i))
+		if err != nil {
+			t.Fatal("Expected", nil, ", received", err)
+		}
+	}
+
+	db.AddActivity(u2.UserId, testActivityAction, "other")
+
+	// Only the user's activities are returned, oldest first.
+	activities, err = db.GetActivity(u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if len(activities) != 3 {
+		t.Fatal("Expected", 3, ", received", len(activities))
+	}
+
+	for i, a := range activities {
+		if a.UserId != u1.UserId || a.Action != testActivityAction || a.Detail != fmt.Sprint(i) {
+			t.Fatal("Expected", i, ", received", a)
+		}
+	}
+
+	db.Close()
+}
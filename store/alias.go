@@ -0,0 +1,404 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	aliasesKey = "%s:aliases"
+)
+
+//----------------------------------------------------------------------------
+// Alias Structs
+//----------------------------------------------------------------------------
+
+// AliasChange records a single change to a user's alias.
+type AliasChange struct {
+	OldAlias  string    `json:"old_alias"`
+	NewAlias  string    `json:"new_alias"`
+	ChangedBy UserToken `json:"changed_by"`
+	Time      int64     `json:"time"`
+}
+
+// Date returns the time of the change formatted for display.
+func (a AliasChange) Date() string {
+	return time.Unix(a.Time, 0).UTC().Format(time.RFC1123)
+}
+
+// reservation holds an alias that was released by a user. Until it expires,
+// the alias can only be taken back by the user that released it.
+type reservation struct {
+	UserId     UserToken `json:"user_id"`
+	Expiration int64     `json:"expire"`
+}
+
+// isExpired returns true if the reservation is expired.
+func (r *reservation) isExpired() bool {
+	return time.Now().Unix() > r.Expiration
+}
+
+// addAliasChange adds the given change to the alias history of the user
+// identified by uid using the given user bucket.
+func addAliasChange(b *bolt.Bucket, uid UserToken, change AliasChange) error {
+	var history []AliasChange
+
+	key := fmt.Sprintf(aliasesKey, uid.String())
+	data := b.Get([]byte(key))
+	if data != nil {
+		err := json.Unmarshal(data, &history)
+		if err != nil {
+			return err
+		}
+	}
+
+	history = append(history, change)
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(key), data)
+}
+
+// placeholderAlias returns the canonical alias given to the user identified
+// by uid when their alias cannot be kept.
+func placeholderAlias(uid UserToken) string {
+	return "renamed-" + strings.ToLower(uid.String()[len(userTokenPrefix):][:12])
+}
+
+//----------------------------------------------------------------------------
+// Alias Storage Methods
+//----------------------------------------------------------------------------
+
+// ChangeUserAlias gives the user identified by uid the given alias. The
+// user's old alias is reserved for the given number of seconds so it cannot
+// be taken by another user, and the change is added to the user's alias
+// history. The by token identifies the user making the change. A transaction
+// is used so the alias keys, the User bytes, the reservation, and the history
+// are all updated together. The canonical form of the alias is used and a
+// flag set on the alias by the alias migration is cleared.
+func (s *Store) ChangeUserAlias(uid UserToken, alias string, by UserToken, reserve int64) error {
+	now := time.Now().Unix()
+
+	alias, err := CanonicalAlias(alias)
+	if err != nil {
+		return fmt.Errorf("could not Store.ChangeUserAlias: %v", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
+		rb := tx.Bucket([]byte(reservedBucket))
+
+		data := b.Get([]byte(uid.String()))
+		if data == nil {
+			return fmt.Errorf("user %s not found", uid)
+		}
+
+		user, err := NewUserFromBytes(data)
+		if err != nil {
+			return err
+		}
+
+		if user.Alias == alias {
+			return fmt.Errorf("alias %s is unchanged", alias)
+		}
+
+		if b.Get([]byte(alias)) != nil {
+			return fmt.Errorf("alias %s exists", alias)
+		}
+
+		data = rb.Get([]byte(alias))
+		if data != nil {
+			var r reservation
+
+			err = json.Unmarshal(data, &r)
+			if err != nil {
+				return err
+			}
+
+			if !r.isExpired() && r.UserId != uid {
+				return fmt.Errorf("alias %s is reserved", alias)
+			}
+
+			err = rb.Delete([]byte(alias))
+			if err != nil {
+				return err
+			}
+		}
+
+		// Move the alias key to the new alias.
+		err = b.Delete([]byte(user.Alias))
+		if err != nil {
+			return err
+		}
+
+		err = b.Put([]byte(alias), []byte(uid.String()))
+		if err != nil {
+			return err
+		}
+
+		// Reserve the old alias.
+		res, err := json.Marshal(reservation{UserId: uid, Expiration: now + reserve})
+		if err != nil {
+			return err
+		}
+
+		err = rb.Put([]byte(user.Alias), res)
+		if err != nil {
+			return err
+		}
+
+		// Add the change to the alias history.
+		err = addAliasChange(b, uid, AliasChange{
+			OldAlias:  user.Alias,
+			NewAlias:  alias,
+			ChangedBy: by,
+			Time:      now,
+		})
+		if err != nil {
+			return err
+		}
+
+		// Save the User with the new alias.
+		user.Alias = alias
+		user.AliasFlagged = false
+		userBytes, err := user.bytes()
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(uid.String()), userBytes)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.ChangeUserAlias: %v", err)
+	}
+
+	return nil
+}
+
+// GetAliasHistory returns the alias changes made to the user identified by
+// uid, oldest first.
+func (s *Store) GetAliasHistory(uid UserToken) ([]AliasChange, error) {
+	var history []AliasChange
+
+	key := fmt.Sprintf(aliasesKey, uid.String())
+	data := s.read(userBucket, key)
+	if data == nil {
+		return history, nil
+	}
+
+	err := json.Unmarshal(data, &history)
+	if err != nil {
+		return history, fmt.Errorf("could not Store.GetAliasHistory: %v", err)
+	}
+
+	return history, nil
+}
+
+// AliasReserved returns true if the canonical form of the given alias is
+// reserved for a user other than the user identified by uid.
+func (s *Store) AliasReserved(alias string, uid UserToken) bool {
+	var r reservation
+
+	alias, err := CanonicalAlias(alias)
+	if err != nil {
+		return false
+	}
+
+	data := s.read(reservedBucket, alias)
+	if data == nil {
+		return false
+	}
+
+	err = json.Unmarshal(data, &r)
+	if err != nil {
+		return true
+	}
+
+	return !r.isExpired() && r.UserId != uid
+}
+
+// AliasConfusable returns true if the given alias looks like the alias of,
+// or an alias reserved for, a user other than the user identified by uid.
+// Aliases look alike when they have the same skeleton.
+func (s *Store) AliasConfusable(alias string, uid UserToken) bool {
+	skeleton := AliasSkeleton(alias)
+	found := false
+
+	s.db.View(func(tx *bolt.Tx) error {
+		err := eachUser(tx, func(u User) {
+			if u.UserId != uid && AliasSkeleton(u.Alias) == skeleton {
+				found = true
+			}
+		})
+		if err != nil || found {
+			return err
+		}
+
+		return tx.Bucket([]byte(reservedBucket)).ForEach(func(k, v []byte) error {
+			var r reservation
+
+			err := json.Unmarshal(v, &r)
+			if err == nil && r.UserId != uid && !r.isExpired() && AliasSkeleton(string(k)) == skeleton {
+				found = true
+			}
+
+			return nil
+		})
+	})
+
+	return found
+}
+
+// migrateAliases stores every alias in its canonical form. A user whose alias
+// has no canonical form, or whose canonical alias is taken by another user,
+// is given a placeholder alias and flagged so an admin can choose a new one.
+// When the aliases of several users have the same canonical form, the oldest
+// user keeps it, even if a newer user's alias was already in that form.
+// Reserved aliases are also canonicalized, and reservations that collide with
+// an alias or another reservation are dropped.
+func (s *Store) migrateAliases() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
+		rb := tx.Bucket([]byte(reservedBucket))
+		now := time.Now().Unix()
+
+		// Keys cannot be updated while iterating over the bucket.
+		var users []User
+
+		err := eachUser(tx, func(u User) {
+			users = append(users, u)
+		})
+		if err != nil {
+			return err
+		}
+
+		// Users created before creation times were recorded are the oldest,
+		// and ties are broken by user id so the result does not depend on
+		// the order of the bucket.
+		sort.Slice(users, func(i, j int) bool {
+			if users[i].Created != users[j].Created {
+				return users[i].Created < users[j].Created
+			}
+
+			return users[i].UserId.String() < users[j].UserId.String()
+		})
+
+		// The oldest user with each canonical alias keeps it.
+		owners := make(map[string]UserToken)
+
+		for _, user := range users {
+			alias, err := CanonicalAlias(user.Alias)
+			if _, ok := owners[alias]; err == nil && !ok {
+				owners[alias] = user.UserId
+			}
+		}
+
+		for _, user := range users {
+			old := user.Alias
+
+			alias, err := CanonicalAlias(old)
+
+			detail := ""
+
+			switch {
+			case err != nil:
+				detail = fmt.Sprintf("alias %q has no canonical form", old)
+			case owners[alias] != user.UserId:
+				detail = fmt.Sprintf("alias %q collided with %s", old, alias)
+			case alias == old:
+				continue
+			}
+
+			if detail != "" {
+				alias = placeholderAlias(user.UserId)
+				user.AliasFlagged = true
+			}
+
+			// An alias already in its canonical form may have been given
+			// to an older user, so it is only removed if it is still ours.
+			if string(b.Get([]byte(old))) == user.UserId.String() {
+				err = b.Delete([]byte(old))
+				if err != nil {
+					return err
+				}
+			}
+
+			err = b.Put([]byte(alias), []byte(user.UserId.String()))
+			if err != nil {
+				return err
+			}
+
+			err = addAliasChange(b, user.UserId, AliasChange{
+				OldAlias:  old,
+				NewAlias:  alias,
+				ChangedBy: user.UserId,
+				Time:      now,
+			})
+			if err != nil {
+				return err
+			}
+
+			user.Alias = alias
+			userBytes, err := user.bytes()
+			if err != nil {
+				return err
+			}
+
+			err = b.Put([]byte(user.UserId.String()), userBytes)
+			if err != nil {
+				return err
+			}
+
+			if detail != "" {
+				detail = fmt.Sprintf("%s and was replaced with %s", detail, alias)
+
+				err = addActivity(tx, user.UserId, ActivityAliasFlagged, detail)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		reserved := make(map[string][]byte)
+
+		err = rb.ForEach(func(k, v []byte) error {
+			alias, err := CanonicalAlias(string(k))
+			if err != nil || alias != string(k) {
+				reserved[string(k)] = v
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, v := range reserved {
+			err = rb.Delete([]byte(k))
+			if err != nil {
+				return err
+			}
+
+			alias, err := CanonicalAlias(k)
+			if err != nil || b.Get([]byte(alias)) != nil || rb.Get([]byte(alias)) != nil {
+				continue
+			}
+
+			err = rb.Put([]byte(alias), v)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,247 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var (
+	testAliasNew    = "newalias"
+	testAliasOther  = "otheralias"
+	testAliasDbPath = "alias_test.db"
+)
+
+func testStoreAlias(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	u2 := NewUser(testAliasOther)
+	db := newTestStore(t, testAliasDbPath)
+	defer deleteTestStore(t, testAliasDbPath)
+
+	err := db.CreateUser(u1, testUserPassphrase)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	err = db.CreateUser(u2, testUserPassphrase)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// Cannot take an alias that is in use.
+	err = db.ChangeUserAlias(u1.UserId, testAliasOther, u1.UserId, 60)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	// Change the alias.
+	err = db.ChangeUserAlias(u1.UserId, testAliasNew, u1.UserId, 60)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	u3, err := db.GetUserByAlias(testAliasNew)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if u3.UserId != u1.UserId || u3.Alias != testAliasNew {
+		t.Fatal("Expected", testAliasNew, ", received", u3.Alias)
+	}
+
+	if db.UserExists(testUserAlias) {
+		t.Fatal("Expected old alias to not exist, but it does.")
+	}
+
+	// The old alias is reserved for everyone but its previous owner.
+	if !db.AliasReserved(testUserAlias, u2.UserId) {
+		t.Fatal("Expected old alias to be reserved, but it is not.")
+	}
+
+	if db.AliasReserved(testUserAlias, u1.UserId) {
+		t.Fatal("Expected old alias to be available to its owner, but it is not.")
+	}
+
+	err = db.CreateUser(NewUser(testUserAlias), testUserPassphrase)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	err = db.ChangeUserAlias(u2.UserId, testUserAlias, u2.UserId, 60)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	// An expired reservation can be taken by anyone.
+	err = db.ChangeUserAlias(u2.UserId, testAliasOther+"2", u2.UserId, -1)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if db.AliasReserved(testAliasOther, u1.UserId) {
+		t.Fatal("Expected expired alias to not be reserved, but it is.")
+	}
+
+	// The previous owner can take back a reserved alias.
+	err = db.ChangeUserAlias(u1.UserId, testUserAlias, u2.UserId, 60)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// Verify the history.
+	history, err := db.GetAliasHistory(u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if len(history) != 2 {
+		t.Fatal("Expected", 2, ", received", len(history))
+	}
+
+	if history[0].OldAlias != testUserAlias || history[0].NewAlias != testAliasNew {
+		t.Fatal("Expected", testUserAlias, testAliasNew, ", received", history[0])
+	}
+
+	if history[1].ChangedBy != u2.UserId {
+		t.Fatal("Expected", u2.UserId, ", received", history[1].ChangedBy)
+	}
+
+	db.Close()
+}
+
+func testStoreAliasConfusable(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser("paypal1234")
+	u2 := NewUser(testAliasOther)
+	db := newTestStore(t, testAliasDbPath)
+	defer deleteTestStore(t, testAliasDbPath)
+
+	db.CreateUser(u1, testUserPassphrase)
+	db.CreateUser(u2, testUserPassphrase)
+
+	// An all Cyrillic lookalike passes CanonicalAlias but is confusable.
+	lookalike := "\u0440\u0430\u0443\u0440\u0430\u04cf1234"
+	if !db.AliasConfusable(lookalike, UserToken{}) {
+		t.Fatal("Expected", lookalike, "to be confusable")
+	}
+
+	// Users are not confused with themselves.
+	if db.AliasConfusable(lookalike, u1.UserId) || db.AliasConfusable("different1234", UserToken{}) {
+		t.Fatal("Expected alias not to be confusable")
+	}
+
+	// Reserved aliases are confusable until the reservation expires.
+	err := db.ChangeUserAlias(u1.UserId, "paypal5678", u1.UserId, 60)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if !db.AliasConfusable(lookalike, u2.UserId) {
+		t.Fatal("Expected", lookalike, "to be confusable with a reservation")
+	}
+
+	db.Close()
+}
+
+func testStoreAliasMigration(t *testing.T) {
+	fmt.Println(t.Name())
+
+	db := newTestStore(t, testAliasDbPath)
+	defer deleteTestStore(t, testAliasDbPath)
+
+	// Store aliases the way they were normalized before canonical aliases.
+	// The second alias has the same canonical form as the first and the
+	// third alias has no canonical form. The next two have the same
+	// canonical form, which the older user keeps. The older user keeps it
+	// even when the newer user's alias is already canonical, as in the
+	// last two.
+	aliases := []string{"josé", "josé", "bad alias", "Mixed1234", "Twin1234", "TWIN1234", "Elder1234", "elder1234"}
+	created := []int64{0, 50, 0, 0, 200, 100, 100, 200}
+
+	var users []User
+	for i, a := range aliases {
+		u := User{UserId: NewUserToken(), Alias: a, Roles: []string{RoleUser}, Created: created[i]}
+		data, _ := u.bytes()
+
+		db.write(userBucket, u.UserId.String(), data)
+		db.write(userBucket, a, []byte(u.UserId.String()))
+
+		users = append(users, u)
+	}
+
+	db.write(reservedBucket, "Reserved1", []byte(`{"expire": 0}`))
+	db.write(reservedBucket, "josé", []byte(`{"expire": 0}`))
+
+	db.Close()
+
+	db = newTestStore(t, testAliasDbPath)
+	defer db.Close()
+
+	// Canonical aliases are kept and other aliases are canonicalized.
+	u, err := db.GetUserByAlias("josé")
+	if err != nil || u.UserId != users[0].UserId || u.AliasFlagged {
+		t.Fatal("Expected", users[0].UserId, ", received", u.UserId, err)
+	}
+
+	u, err = db.GetUserByAlias("mixed1234")
+	if err != nil || u.UserId != users[3].UserId || u.AliasFlagged {
+		t.Fatal("Expected", users[3].UserId, ", received", u.UserId, err)
+	}
+
+	u, err = db.GetUserByAlias("twin1234")
+	if err != nil || u.UserId != users[5].UserId || u.AliasFlagged {
+		t.Fatal("Expected", users[5].UserId, ", received", u.UserId, err)
+	}
+
+	u, err = db.GetUserByAlias("elder1234")
+	if err != nil || u.UserId != users[6].UserId || u.AliasFlagged {
+		t.Fatal("Expected", users[6].UserId, ", received", u.UserId, err)
+	}
+
+	// Colliding and invalid aliases are replaced and flagged with the cause.
+	causes := map[int]string{1: "collided with josé", 2: "has no canonical form", 4: "collided with twin1234", 7: "collided with elder1234"}
+
+	for i, cause := range causes {
+		u, _ = db.GetUser(users[i].UserId)
+		if !u.AliasFlagged || u.Alias != placeholderAlias(u.UserId) {
+			t.Fatal("Expected", placeholderAlias(u.UserId), ", received", u.Alias)
+		}
+
+		history, _ := db.GetAliasHistory(u.UserId)
+		if len(history) != 1 || history[0].OldAlias != aliases[i] {
+			t.Fatal("Expected", aliases[i], ", received", history)
+		}
+
+		activity, _ := db.GetActivity(u.UserId)
+		if len(activity) != 1 || activity[0].Action != ActivityAliasFlagged || !strings.Contains(activity[0].Detail, cause) {
+			t.Fatal("Expected", ActivityAliasFlagged, cause, ", received", activity)
+		}
+	}
+
+	if db.read(userBucket, "bad alias") != nil || db.read(userBucket, "josé") != nil {
+		t.Fatal("Expected old alias keys to be removed")
+	}
+
+	// Reservations are canonicalized, and dropped if they collide.
+	if db.read(reservedBucket, "reserved1") == nil || db.read(reservedBucket, "Reserved1") != nil {
+		t.Fatal("Expected reservation to be canonicalized")
+	}
+
+	if db.read(reservedBucket, "josé") != nil || db.read(reservedBucket, "josé") != nil {
+		t.Fatal("Expected colliding reservation to be dropped")
+	}
+
+	// Changing the alias clears the flag.
+	err = db.ChangeUserAlias(users[2].UserId, "goodalias", users[2].UserId, 60)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	u, _ = db.GetUser(users[2].UserId)
+	if u.AliasFlagged || u.Alias != "goodalias" {
+		t.Fatal("Expected goodalias, received", u.Alias)
+	}
+}
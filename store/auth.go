@@ -1,7 +1,11 @@
 package store
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
 )
 
 const (
@@ -9,31 +13,167 @@ const (
 )
 
 var (
-	failedKey = "%s:failed"
-	hashKey   = "%s:hash"
+	failedKey  = "%s:failed"
+	hashKey    = "%s:hash"
+	lockoutKey = "%s:lockout"
 )
 
+// ----------------------------------------------------------------------------
+// Lockout Structs
+// ----------------------------------------------------------------------------
+
+// Lockout holds the lockout state of an account. Until is the time the
+// account unlocks on its own, and Lockouts counts the lockouts since the last
+// successful login so each one can last longer than the one before.
+type Lockout struct {
+	Until    int64 `json:"until"`
+	Lockouts int   `json:"lockouts"`
+}
+
+// Locked returns true if the account is still locked.
+func (l Lockout) Locked() bool {
+	return time.Now().Unix() < l.Until
+}
+
+// Date returns the time the account unlocks formatted for display.
+func (l Lockout) Date() string {
+	return time.Unix(l.Until, 0).UTC().Format(time.RFC1123)
+}
+
+// LockoutPolicy controls when failed logins lock an account and for how
+// long. An account is locked once its failed count reaches the Threshold,
+// which is capped at the largest count kept. The first lockout lasts Duration
+// seconds, and each lockout in a row lasts Backoff times longer than the one
+// before, up to MaxDuration seconds. A Threshold of 0 disables lockouts.
+type LockoutPolicy struct {
+	Threshold   uint64
+	Duration    int64
+	Backoff     int64
+	MaxDuration int64
+}
+
+// Fail returns the failed count and Lockout that follow another failed login
+// at the given Unix time. The failed count starts over when the account is
+// locked.
+func (p LockoutPolicy) Fail(failed uint64, l Lockout, now int64) (uint64, Lockout) {
+	if failed < maxFailCount {
+		failed = failed + 1
+	}
+
+	threshold := min(p.Threshold, maxFailCount)
+	if threshold == 0 || failed < threshold {
+		return failed, l
+	}
+
+	d := p.Duration
+	for i := 0; i < l.Lockouts && d < p.MaxDuration; i++ {
+		d = d * max(p.Backoff, 1)
+	}
+
+	if p.MaxDuration > 0 {
+		d = min(d, p.MaxDuration)
+	}
+
+	l.Until = now + d
+	l.Lockouts = l.Lockouts + 1
+
+	return 0, l
+}
+
 // ----------------------------------------------------------------------------
 // Authentication Storage Methods
 // ----------------------------------------------------------------------------
 // Authenticate takes a passphrase and verifies it matches the user's original
-// passphrase.
-func (s *Store) AuthenticateUser(ut UserToken, passphrase string) bool {
+// passphrase. If the stored hash was made with weaker parameters than the
+// ones now used, it is replaced with a new hash of the passphrase so costs can
+// be raised without resetting passphrases. ErrHashBusy is returned if the
+// hashing queue is full.
+func (s *Store) AuthenticateUser(ut UserToken, passphrase string) (bool, error) {
 	key := fmt.Sprintf(hashKey, ut.String())
 	hash := s.read(userBucket, key)
 
-	return VerifyHash(string(hash), passphrase)
+	ok, err := CheckHash(string(hash), passphrase)
+	if err != nil {
+		return false, fmt.Errorf("could not Store.AuthenticateUser: %w", err)
+	}
+
+	if ok && NeedsRehash(string(hash)) {
+		s.rehash(key, string(hash), passphrase)
+	}
+
+	return ok, nil
+}
+
+// rehash replaces the hash stored under key with a new hash of the
+// passphrase, unless the hash was changed since old was read. Errors are
+// ignored since the old hash still works.
+func (s *Store) rehash(key, old, passphrase string) {
+	hash, err := GenerateHash(passphrase)
+	if err != nil {
+		return
+	}
+
+	s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
+
+		if string(b.Get([]byte(key))) != old {
+			return nil
+		}
+
+		return b.Put([]byte(key), []byte(hash))
+	})
 }
 
+// ChangeUserPassword stores a hash of the given passphrase for the user. A
+// transaction is used to store the hash and clear any required passphrase
+// reset together.
 func (s *Store) ChangeUserPassword(ut UserToken, passphrase string) error {
 	key := fmt.Sprintf(hashKey, ut.String())
 
 	hash, err := GenerateHash(passphrase)
+	if err != nil {
+		return fmt.Errorf("could not Store.ChangeUserPassword: %w", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
+
+		err := b.Put([]byte(key), []byte(hash))
+		if err != nil {
+			return err
+		}
+
+		return clearResetRequired(b, ut)
+	})
+
 	if err != nil {
 		return fmt.Errorf("could not Store.ChangeUserPassword: %v", err)
 	}
 
-	return s.write(userBucket, key, []byte(hash))
+	return nil
+}
+
+// clearResetRequired clears the required passphrase reset of the user
+// identified by ut using the given user bucket.
+func clearResetRequired(b *bolt.Bucket, ut UserToken) error {
+	data := b.Get([]byte(ut.String()))
+	if data == nil {
+		return nil
+	}
+
+	user, err := NewUserFromBytes(data)
+	if err != nil || !user.ResetRequired {
+		return err
+	}
+
+	user.ResetRequired = false
+
+	userBytes, err := user.bytes()
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(ut.String()), userBytes)
 }
 
 func (s *Store) GetFailedAuthCount(ut UserToken) (uint64, error) {
@@ -67,3 +207,110 @@ func (s *Store) ResetFailedAuthCount(ut UserToken) error {
 
 	return s.writeUint64(userBucket, key, 0)
 }
+
+// GetLockout returns the lockout state of the user identified by ut.
+func (s *Store) GetLockout(ut UserToken) (Lockout, error) {
+	var l Lockout
+
+	data := s.read(userBucket, fmt.Sprintf(lockoutKey, ut.String()))
+	if data == nil {
+		return l, nil
+	}
+
+	err := json.Unmarshal(data, &l)
+	if err != nil {
+		return l, fmt.Errorf("could not Store.GetLockout: %v", err)
+	}
+
+	return l, nil
+}
+
+// RecordFailedAuth counts a failed login for the user identified by ut and
+// locks the account when the given LockoutPolicy says so. A transaction is
+// used to update the failed count and the lockout state together, and a new
+// lockout is recorded in the user's activity. The resulting Lockout is
+// returned.
+func (s *Store) RecordFailedAuth(ut UserToken, p LockoutPolicy) (Lockout, error) {
+	var l Lockout
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var failed uint64
+
+		b := tx.Bucket([]byte(userBucket))
+		fkey := []byte(fmt.Sprintf(failedKey, ut.String()))
+		lkey := []byte(fmt.Sprintf(lockoutKey, ut.String()))
+
+		data := b.Get(fkey)
+		if data != nil {
+			i, err := bytesToUint64(data)
+			if err != nil {
+				return err
+			}
+
+			failed = i
+		}
+
+		data = b.Get(lkey)
+		if data != nil {
+			err := json.Unmarshal(data, &l)
+			if err != nil {
+				return err
+			}
+		}
+
+		prev := l.Lockouts
+		failed, l = p.Fail(failed, l, time.Now().Unix())
+
+		err := b.Put(fkey, uint64ToBytes(failed))
+		if err != nil {
+			return err
+		}
+
+		if l.Lockouts == prev {
+			return nil
+		}
+
+		data, err = json.Marshal(l)
+		if err != nil {
+			return err
+		}
+
+		err = b.Put(lkey, data)
+		if err != nil {
+			return err
+		}
+
+		return addActivity(tx, ut, ActivityAccountLocked, "until "+l.Date())
+	})
+
+	if err != nil {
+		return l, fmt.Errorf("could not Store.RecordFailedAuth: %v", err)
+	}
+
+	return l, nil
+}
+
+// ResetLockout unlocks the account of the user identified by ut and resets
+// its failed count, so the next lockout is as short as the first.
+func (s *Store) ResetLockout(ut UserToken) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return resetLockout(tx.Bucket([]byte(userBucket)), ut)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.ResetLockout: %v", err)
+	}
+
+	return nil
+}
+
+// resetLockout resets the failed count and removes the lockout state of the
+// user identified by ut using the given user bucket.
+func resetLockout(b *bolt.Bucket, ut UserToken) error {
+	err := b.Put([]byte(fmt.Sprintf(failedKey, ut.String())), uint64ToBytes(0))
+	if err != nil {
+		return err
+	}
+
+	return b.Delete([]byte(fmt.Sprintf(lockoutKey, ut.String())))
+}
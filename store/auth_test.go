@@ -24,12 +24,12 @@ func testStoreAuth(t *testing.T) {
 	}
 
 	// Verify we can authenticate with the right password.
-	if !db.AuthenticateUser(u1.UserId, testAuthGoodPassword) {
+	if ok, _ := db.AuthenticateUser(u1.UserId, testAuthGoodPassword); !ok {
 		t.Fatal("Expected password to match:", testAuthGoodPassword)
 	}
 
 	// Verify we cannot authenticate with the wrong password.
-	if db.AuthenticateUser(u1.UserId, testAuthBadPassword) {
+	if ok, _ := db.AuthenticateUser(u1.UserId, testAuthBadPassword); ok {
 		t.Fatal("Expected password to not match:", testAuthBadPassword)
 	}
 
@@ -40,10 +40,36 @@ func testStoreAuth(t *testing.T) {
 	}
 
 	// Verify we can authenticate with the new password.
-	if !db.AuthenticateUser(u1.UserId, testAuthBadPassword) {
+	if ok, _ := db.AuthenticateUser(u1.UserId, testAuthBadPassword); !ok {
 		t.Fatal("Expected password to match:", testAuthBadPassword)
 	}
 
+	// A hash made with weaker parameters is replaced when the user
+	// authenticates.
+	key := fmt.Sprintf(hashKey, u1.UserId.String())
+
+	SetHashParams(HashParams{Memory: 8 * 1024, Time: 1, Threads: 1})
+	db.ChangeUserPassword(u1.UserId, testAuthGoodPassword)
+	SetHashParams(DefaultHashParams)
+
+	weak := string(db.read(userBucket, key))
+	if !NeedsRehash(weak) {
+		t.Fatal("Expected", weak, "to need a rehash")
+	}
+
+	if ok, _ := db.AuthenticateUser(u1.UserId, testAuthGoodPassword); !ok {
+		t.Fatal("Expected password to match:", testAuthGoodPassword)
+	}
+
+	hash := string(db.read(userBucket, key))
+	if hash == weak || NeedsRehash(hash) {
+		t.Fatal("Expected rehashed passphrase, received", hash)
+	}
+
+	if ok, _ := db.AuthenticateUser(u1.UserId, testAuthGoodPassword); !ok {
+		t.Fatal("Expected password to match:", testAuthGoodPassword)
+	}
+
 	// Get failed auth count
 	count, err := db.GetFailedAuthCount(u1.UserId)
 	if err != nil {
@@ -82,3 +108,90 @@ func testStoreAuth(t *testing.T) {
 
 	db.Close()
 }
+
+func testStoreLockout(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	db := newTestStore(t, testAuthDbPath)
+	defer deleteTestStore(t, testAuthDbPath)
+
+	db.CreateUser(u1, testAuthGoodPassword)
+
+	policy := LockoutPolicy{Threshold: 3, Duration: 60, Backoff: 2, MaxDuration: 150}
+
+	// Failed logins below the threshold do not lock the account.
+	for i := 0; i < 2; i++ {
+		lock, err := db.RecordFailedAuth(u1.UserId, policy)
+		if err != nil {
+			t.Fatal("Expected", nil, ", received", err)
+		}
+
+		if lock.Locked() {
+			t.Fatal("Expected unlocked account, received", lock)
+		}
+	}
+
+	count, _ := db.GetFailedAuthCount(u1.UserId)
+	if count != 2 {
+		t.Fatal("Expected", 2, ", received", count)
+	}
+
+	// The failed login that reaches the threshold locks the account and starts
+	// the failed count over.
+	lock, err := db.RecordFailedAuth(u1.UserId, policy)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if !lock.Locked() || lock.Lockouts != 1 {
+		t.Fatal("Expected first lockout, received", lock)
+	}
+
+	stored, _ := db.GetLockout(u1.UserId)
+	if stored != lock {
+		t.Fatal("Expected", lock, ", received", stored)
+	}
+
+	count, _ = db.GetFailedAuthCount(u1.UserId)
+	if count != 0 {
+		t.Fatal("Expected", 0, ", received", count)
+	}
+
+	activity, _ := db.GetActivity(u1.UserId)
+	if len(activity) != 1 || activity[0].Action != ActivityAccountLocked {
+		t.Fatal("Expected", ActivityAccountLocked, ", received", activity)
+	}
+
+	// Resetting the lockout unlocks the account.
+	err = db.ResetLockout(u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	stored, _ = db.GetLockout(u1.UserId)
+	if stored.Locked() || stored.Lockouts != 0 {
+		t.Fatal("Expected unlocked account, received", stored)
+	}
+
+	// Each lockout in a row lasts longer, up to the longest lockout.
+	now := int64(1000)
+	failed, l := uint64(0), Lockout{}
+	for _, expected := range []int64{60, 120, 150, 150} {
+		for i := uint64(0); i < policy.Threshold; i++ {
+			failed, l = policy.Fail(failed, l, now)
+		}
+
+		if l.Until-now != expected {
+			t.Fatal("Expected", expected, ", received", l.Until-now)
+		}
+	}
+
+	// A threshold of 0 never locks the account.
+	failed, l = LockoutPolicy{Duration: 60}.Fail(maxFailCount, Lockout{}, now)
+	if failed != maxFailCount || l.Until != 0 {
+		t.Fatal("Expected no lockout, received", failed, l)
+	}
+
+	db.Close()
+}
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// minCalibrationMemory is the least memory calibration picks, in KiB,
+	// unless the ceiling is lower. It is the OWASP minimum for Argon2id.
+	minCalibrationMemory = 19 * 1024
+
+	// maxCalibrationTime limits the passes calibration picks on fast hosts.
+	maxCalibrationTime = 64
+
+	// calibrationRuns is the number of times each setting is measured. The
+	// fastest run is used, since slower ones are noise from other work.
+	calibrationRuns = 3
+)
+
+// measureHash returns the time it takes to derive a hash with the given
+// parameters. Tests replace it with a model of a host.
+var measureHash = func(p HashParams) time.Duration {
+	var salt [saltSize]byte
+
+	argon := newArgonHash(p.Memory, p.Time, p.Threads, salt)
+
+	start := time.Now()
+	argon.derive("calibration passphrase")
+
+	return time.Since(start)
+}
+
+// fastestHash returns the fastest of several measurements of a derivation
+// with the given parameters.
+func fastestHash(p HashParams) time.Duration {
+	fastest := measureHash(p)
+
+	for i := 1; i < calibrationRuns; i++ {
+		fastest = min(fastest, measureHash(p))
+	}
+
+	return fastest
+}
+
+//----------------------------------------------------------------------------
+// Hash Calibration
+//----------------------------------------------------------------------------
+
+// CalibrateHash benchmarks Argon2id derivations on this host and returns the
+// strongest parameters that take about the target time. Memory matters most
+// against attacks with special hardware, so the most memory under the
+// ceiling, in KiB, is used, and halved only while a single pass is slower than
+// the target. The number of passes is then raised to fill the target. The
+// given number of threads is kept.
+func CalibrateHash(target time.Duration, maxMemory uint32, threads uint8) (HashParams, error) {
+	p := HashParams{Memory: maxMemory, Time: 1, Threads: threads}
+
+	if target <= 0 || threads < 1 || maxMemory < 8*uint32(threads) {
+		return p, fmt.Errorf("could not CalibrateHash: invalid target %v, memory %d KiB, or threads %d", target, maxMemory, threads)
+	}
+
+	floor := max(min(uint32(minCalibrationMemory), maxMemory), 8*uint32(threads))
+
+	d := fastestHash(p)
+	for d > target && p.Memory/2 >= floor {
+		p.Memory = p.Memory / 2
+		d = fastestHash(p)
+	}
+
+	// Each pass takes about as long as the first, so start from the number
+	// that fits and step down while the measurement runs over.
+	p.Time = uint32(min(max(int64(target/max(d, 1)), 1), maxCalibrationTime))
+
+	for p.Time > 1 && fastestHash(p) > target {
+		p.Time--
+	}
+
+	return p, nil
+}
+
+//----------------------------------------------------------------------------
+// Hash Policy Storage Methods
+//----------------------------------------------------------------------------
+
+// SetHashPolicy records the parameters used for new hashes in the Store, so
+// they are used on the next start too, and uses them now if they are at
+// least as strong as the current ones.
+func (s *Store) SetHashPolicy(p HashParams) error {
+	_, err := useStrongerHashParams(p)
+	if err != nil {
+		return fmt.Errorf("could not Store.SetHashPolicy: %v", err)
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("could not Store.SetHashPolicy: %v", err)
+	}
+
+	err = s.SetSetting(SettingHashPolicy, string(data))
+	if err != nil {
+		return fmt.Errorf("could not Store.SetHashPolicy: %v", err)
+	}
+
+	return nil
+}
+
+// GetHashPolicy returns the parameters recorded with SetHashPolicy. False is
+// returned if none have been recorded.
+func (s *Store) GetHashPolicy() (HashParams, bool) {
+	var p HashParams
+
+	data := s.GetSetting(SettingHashPolicy, "")
+	if data == "" {
+		return p, false
+	}
+
+	err := json.Unmarshal([]byte(data), &p)
+
+	return p, err == nil
+}
+
+// LoadHashPolicy uses the parameters recorded with SetHashPolicy for new
+// hashes if they are at least as strong as the current ones, such as those of
+// the Config. Otherwise the current parameters are kept, so a calibration
+// recorded on a slower host, or before the Config was raised, cannot weaken
+// new hashes. True is returned if the recorded parameters are used.
+func (s *Store) LoadHashPolicy() (bool, error) {
+	p, ok := s.GetHashPolicy()
+	if !ok {
+		return false, nil
+	}
+
+	used, err := useStrongerHashParams(p)
+	if err != nil {
+		return false, fmt.Errorf("could not Store.LoadHashPolicy: %v", err)
+	}
+
+	return used, nil
+}
+
+// ClearHashPolicy removes the parameters recorded with SetHashPolicy, so the
+// configured ones are used from the next start. The current parameters are
+// not changed.
+func (s *Store) ClearHashPolicy() error {
+	err := s.delete(settingBucket, SettingHashPolicy)
+	if err != nil {
+		return fmt.Errorf("could not Store.ClearHashPolicy: %v", err)
+	}
+
+	return nil
+}
+
+// useStrongerHashParams uses the given parameters for new hashes if they have
+// at least the memory and passes of the current ones, the same comparison
+// NeedsRehash makes. True is returned if they are used.
+func useStrongerHashParams(p HashParams) (bool, error) {
+	err := checkHashParams(p)
+	if err != nil {
+		return false, err
+	}
+
+	current := GetHashParams()
+
+	if p.Memory < current.Memory || p.Time < current.Time {
+		return false, nil
+	}
+
+	return true, SetHashParams(p)
+}
+
+// CalibrateHashPolicy runs CalibrateHash and records the parameters it picks
+// with SetHashPolicy.
+func (s *Store) CalibrateHashPolicy(target time.Duration, maxMemory uint32, threads uint8) (HashParams, error) {
+	p, err := CalibrateHash(target, maxMemory, threads)
+	if err != nil {
+		return p, fmt.Errorf("could not Store.CalibrateHashPolicy: %v", err)
+	}
+
+	err = s.SetHashPolicy(p)
+	if err != nil {
+		return p, fmt.Errorf("could not Store.CalibrateHashPolicy: %v", err)
+	}
+
+	return p, nil
+}
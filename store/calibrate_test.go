@@ -0,0 +1,153 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var testHashPolicyDbPath = "hashpolicy_test.db"
+
+// modelHost replaces measureHash with a host that takes the given time per
+// KiB of memory per pass.
+func modelHost(perKiB time.Duration) func() {
+	measure := measureHash
+
+	measureHash = func(p HashParams) time.Duration {
+		return time.Duration(p.Memory) * time.Duration(p.Time) * perKiB
+	}
+
+	return func() { measureHash = measure }
+}
+
+func TestCalibrateHash(t *testing.T) {
+	target := 500 * time.Millisecond
+
+	// A fast host keeps the most memory and adds passes.
+	restore := modelHost(time.Microsecond)
+
+	p, err := CalibrateHash(target, 64*1024, 3)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	expected := HashParams{Memory: 64 * 1024, Time: 7, Threads: 3}
+	if p != expected {
+		t.Fatal("Expected", expected, ", received", p)
+	}
+
+	restore()
+
+	// A slow host halves the memory, but not below the floor.
+	restore = modelHost(20 * time.Microsecond)
+
+	p, _ = CalibrateHash(target, 64*1024, 3)
+
+	expected = HashParams{Memory: 32 * 1024, Time: 1, Threads: 3}
+	if p != expected {
+		t.Fatal("Expected", expected, ", received", p)
+	}
+
+	restore()
+
+	// A very fast host is held to the most passes.
+	restore = modelHost(time.Nanosecond)
+
+	p, _ = CalibrateHash(target, 64*1024, 3)
+	if p.Time != maxCalibrationTime {
+		t.Fatal("Expected", maxCalibrationTime, ", received", p.Time)
+	}
+
+	restore()
+
+	_, err = CalibrateHash(target, 16, 4)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	// The real measurement stays near a small target.
+	p, err = CalibrateHash(50*time.Millisecond, 8*1024, 1)
+	if err != nil || p.Memory != 8*1024 || p.Time < 1 {
+		t.Fatal("Expected calibrated parameters, received", p, err)
+	}
+}
+
+func testStoreHashPolicy(t *testing.T) {
+	fmt.Println(t.Name())
+
+	db := newTestStore(t, testHashPolicyDbPath)
+	defer deleteTestStore(t, testHashPolicyDbPath)
+	defer SetHashParams(DefaultHashParams)
+
+	_, ok := db.GetHashPolicy()
+	if ok {
+		t.Fatal("Expected no hash policy")
+	}
+
+	used, err := db.LoadHashPolicy()
+	if err != nil || used || GetHashParams() != DefaultHashParams {
+		t.Fatal("Expected", DefaultHashParams, ", received", GetHashParams(), err)
+	}
+
+	err = db.SetHashPolicy(HashParams{Memory: 8, Time: 1, Threads: 2})
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	// A recorded policy weaker than the current parameters in memory or
+	// passes is not used.
+	for _, weak := range []HashParams{{Memory: 32 * 1024, Time: 8, Threads: 1}, {Memory: 128 * 1024, Time: 2, Threads: 1}} {
+		err = db.SetHashPolicy(weak)
+		if err != nil || GetHashParams() != DefaultHashParams {
+			t.Fatal("Expected", DefaultHashParams, ", received", GetHashParams(), err)
+		}
+
+		used, err = db.LoadHashPolicy()
+		if err != nil || used || GetHashParams() != DefaultHashParams {
+			t.Fatal("Expected", DefaultHashParams, ", received", GetHashParams(), err)
+		}
+	}
+
+	// A stronger recorded policy is used after a restart.
+	p := HashParams{Memory: 128 * 1024, Time: 4, Threads: 1}
+
+	err = db.SetHashPolicy(p)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	SetHashParams(DefaultHashParams)
+
+	used, err = db.LoadHashPolicy()
+	if err != nil || !used || GetHashParams() != p {
+		t.Fatal("Expected", p, ", received", GetHashParams(), err)
+	}
+
+	// A cleared policy leaves the configured parameters in use.
+	err = db.ClearHashPolicy()
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	SetHashParams(DefaultHashParams)
+
+	used, err = db.LoadHashPolicy()
+	if _, ok := db.GetHashPolicy(); ok || used || err != nil || GetHashParams() != DefaultHashParams {
+		t.Fatal("Expected", DefaultHashParams, ", received", GetHashParams(), err)
+	}
+
+	restore := modelHost(time.Microsecond)
+	defer restore()
+
+	calibrated, err := db.CalibrateHashPolicy(500*time.Millisecond, 64*1024, 3)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	saved, _ := db.GetHashPolicy()
+	if saved != calibrated || GetHashParams() != calibrated {
+		t.Fatal("Expected", calibrated, ", received", saved, GetHashParams())
+	}
+
+	db.Close()
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//----------------------------------------------------------------------------
+// Ceremony Struct
+//----------------------------------------------------------------------------
+
+// Ceremony holds the challenge of a WebAuthn registration or sign-in while
+// the browser waits for the authenticator. UserId is set for registrations
+// and empty for sign-ins, where the user is not known until the
+// authenticator responds.
+type Ceremony struct {
+	UserId     UserToken `json:"user_id"`
+	Challenge  []byte    `json:"challenge"`
+	Expiration int64     `json:"expire"`
+}
+
+//----------------------------------------------------------------------------
+// Ceremony Storage Methods
+//----------------------------------------------------------------------------
+
+// CreateCeremony stores the challenge of a new ceremony for the user
+// identified by uid, which is empty for sign-ins. The returned CeremonyToken
+// is valid for the given number of seconds. Expired ceremonies are removed
+// at the same time, since browsers often abandon them.
+func (s *Store) CreateCeremony(uid UserToken, challenge []byte, length int64) (CeremonyToken, error) {
+	token := NewCeremonyToken()
+	now := time.Now().Unix()
+
+	c := Ceremony{
+		UserId:     uid,
+		Challenge:  challenge,
+		Expiration: now + length,
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return token, fmt.Errorf("could not Store.CreateCeremony: %v", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(ceremonyBucket))
+
+		err := deleteMatching(b, func(k, v []byte) bool {
+			var old Ceremony
+
+			return json.Unmarshal(v, &old) != nil || now > old.Expiration
+		})
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(hashToken(token.String())), data)
+	})
+
+	if err != nil {
+		return token, fmt.Errorf("could not Store.CreateCeremony: %v", err)
+	}
+
+	return token, nil
+}
+
+// TakeCeremony takes a ceremony token string and returns the ceremony
+// associated with it. The ceremony is removed so its challenge can only be
+// answered once. Expired ceremonies are not returned.
+func (s *Store) TakeCeremony(token string) (Ceremony, error) {
+	var c Ceremony
+
+	ct, err := parseCeremonyToken(token)
+	if err != nil {
+		return c, fmt.Errorf("could not Store.TakeCeremony: %v", err)
+	}
+
+	key := []byte(hashToken(ct.String()))
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(ceremonyBucket))
+
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("ceremony not found")
+		}
+
+		err := json.Unmarshal(data, &c)
+		if err != nil {
+			return err
+		}
+
+		return b.Delete(key)
+	})
+
+	if err != nil {
+		return c, fmt.Errorf("could not Store.TakeCeremony: %v", err)
+	}
+
+	if time.Now().Unix() > c.Expiration {
+		return c, fmt.Errorf("could not Store.TakeCeremony: ceremony expired")
+	}
+
+	return c, nil
+}
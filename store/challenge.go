@@ -0,0 +1,147 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	maxChallengeAttempts = 5
+)
+
+//----------------------------------------------------------------------------
+// Login Challenge Struct
+//----------------------------------------------------------------------------
+
+// LoginChallenge holds a login whose passphrase has been verified but which
+// still needs a second factor before a session is created.
+type LoginChallenge struct {
+	UserId     UserToken `json:"user_id"`
+	Expiration int64     `json:"expire"`
+	Attempts   int       `json:"attempts"`
+}
+
+//----------------------------------------------------------------------------
+// Login Challenge Storage Methods
+//----------------------------------------------------------------------------
+
+// CreateLoginChallenge creates a login challenge for the user identified by
+// uid. The returned ChallengeToken is valid for the given number of seconds.
+// Only a hash of the token is kept in the Store.
+func (s *Store) CreateLoginChallenge(uid UserToken, length int64) (ChallengeToken, error) {
+	token := NewChallengeToken()
+
+	lc := LoginChallenge{
+		UserId:     uid,
+		Expiration: time.Now().Unix() + length,
+	}
+
+	data, err := json.Marshal(lc)
+	if err != nil {
+		return token, fmt.Errorf("could not Store.CreateLoginChallenge: %v", err)
+	}
+
+	err = s.write(chalBucket, hashToken(token.String()), data)
+	if err != nil {
+		return token, fmt.Errorf("could not Store.CreateLoginChallenge: %v", err)
+	}
+
+	return token, nil
+}
+
+// GetLoginChallenge takes a challenge token string and returns the login
+// challenge associated with it. Expired challenges are not returned.
+func (s *Store) GetLoginChallenge(token string) (LoginChallenge, error) {
+	var lc LoginChallenge
+
+	ct, err := parseChallengeToken(token)
+	if err != nil {
+		return lc, fmt.Errorf("could not Store.GetLoginChallenge: %v", err)
+	}
+
+	data := s.read(chalBucket, hashToken(ct.String()))
+	if data == nil {
+		return lc, fmt.Errorf("could not Store.GetLoginChallenge: challenge not found")
+	}
+
+	err = json.Unmarshal(data, &lc)
+	if err != nil {
+		return lc, fmt.Errorf("could not Store.GetLoginChallenge: %v", err)
+	}
+
+	if time.Now().Unix() > lc.Expiration {
+		return lc, fmt.Errorf("could not Store.GetLoginChallenge: challenge expired")
+	}
+
+	return lc, nil
+}
+
+// FailLoginChallenge counts a wrong second factor for the login challenge
+// identified by the token string. The challenge is removed once it has
+// failed too many times, so the passphrase must be entered again. It returns
+// true if the challenge can still be used.
+func (s *Store) FailLoginChallenge(token string) (bool, error) {
+	var usable bool
+
+	ct, err := parseChallengeToken(token)
+	if err != nil {
+		return false, fmt.Errorf("could not Store.FailLoginChallenge: %v", err)
+	}
+
+	key := []byte(hashToken(ct.String()))
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		var lc LoginChallenge
+
+		b := tx.Bucket([]byte(chalBucket))
+
+		data := b.Get(key)
+		if data == nil {
+			return nil
+		}
+
+		err := json.Unmarshal(data, &lc)
+		if err != nil {
+			return err
+		}
+
+		lc.Attempts = lc.Attempts + 1
+		if lc.Attempts >= maxChallengeAttempts {
+			return b.Delete(key)
+		}
+
+		data, err = json.Marshal(lc)
+		if err != nil {
+			return err
+		}
+
+		usable = true
+
+		return b.Put(key, data)
+	})
+
+	if err != nil {
+		return false, fmt.Errorf("could not Store.FailLoginChallenge: %v", err)
+	}
+
+	return usable, nil
+}
+
+// DeleteLoginChallenge removes the login challenge identified by the token
+// string.
+func (s *Store) DeleteLoginChallenge(token string) error {
+	ct, err := parseChallengeToken(token)
+	if err != nil {
+		return fmt.Errorf("could not Store.DeleteLoginChallenge: %v", err)
+	}
+
+	err = s.delete(chalBucket, hashToken(ct.String()))
+	if err != nil {
+		return fmt.Errorf("could not Store.DeleteLoginChallenge: %v", err)
+	}
+
+	return nil
+}
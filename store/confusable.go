@@ -0,0 +1,54 @@
+package store
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusables maps letters and digits that look like a lowercase Latin letter
+// to that letter. It covers the lowercase Cyrillic and Greek letters that are
+// hard to tell apart from Latin ones, after the case mapping done by
+// CanonicalAlias.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j',
+	'к': 'k', 'ӏ': 'l', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'у': 'y',
+	'ү': 'y', 'х': 'x', 'ԝ': 'w',
+	// Greek
+	'α': 'a', 'γ': 'y', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'υ': 'u', 'χ': 'x',
+	// Latin and digits
+	'ı': 'i', 'ɩ': 'i', 'ɡ': 'g', '0': 'o', '1': 'l',
+}
+
+// confusableRuns maps runs of letters that look like a single letter to it.
+var confusableRuns = strings.NewReplacer("rn", "m", "vv", "w")
+
+// AliasSkeleton returns the skeleton of the given alias: its canonical form
+// with marks removed and confusable letters replaced with the Latin letters
+// they look like. Aliases with the same skeleton look alike, such as "admin"
+// spelled with a Cyrillic "а".
+func AliasSkeleton(alias string) string {
+	canonical, err := CanonicalAlias(alias)
+	if err != nil {
+		canonical = strings.ToLower(alias)
+	}
+
+	var sb strings.Builder
+
+	for _, r := range norm.NFD.String(canonical) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+
+		sb.WriteRune(r)
+	}
+
+	return confusableRuns.Replace(sb.String())
+}
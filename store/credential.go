@@ -0,0 +1,249 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//----------------------------------------------------------------------------
+// Credential Struct
+//----------------------------------------------------------------------------
+
+// Credential holds a WebAuthn credential, such as a passkey or security key,
+// registered by a user. Id is the base64url credential ID, PublicKey is the
+// COSE form of the public key, and SignCount is the last signature counter
+// reported by the authenticator.
+type Credential struct {
+	Id        string    `json:"id"`
+	UserId    UserToken `json:"user_id"`
+	Name      string    `json:"name"`
+	PublicKey []byte    `json:"public_key"`
+	SignCount uint32    `json:"sign_count"`
+	Created   int64     `json:"created"`
+	LastUsed  int64     `json:"last_used"`
+}
+
+// Date returns the time the credential was registered in a human readable
+// form.
+func (c Credential) Date() string {
+	return time.Unix(c.Created, 0).Format(time.RFC1123)
+}
+
+// LastUsedDate returns the time the credential was last used to sign in in a
+// human readable form, or never if it has not been used.
+func (c Credential) LastUsedDate() string {
+	if c.LastUsed == 0 {
+		return "never"
+	}
+
+	return time.Unix(c.LastUsed, 0).Format(time.RFC1123)
+}
+
+//----------------------------------------------------------------------------
+// Credential Storage Methods
+//----------------------------------------------------------------------------
+
+// AddCredential stores a new credential. An error is returned if a
+// credential with the same ID is already registered. The change is recorded
+// in the user's activity.
+func (s *Store) AddCredential(c Credential) error {
+	c.Created = time.Now().Unix()
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("could not Store.AddCredential: %v", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(credBucket))
+
+		if b.Get([]byte(c.Id)) != nil {
+			return fmt.Errorf("credential already registered")
+		}
+
+		err := b.Put([]byte(c.Id), data)
+		if err != nil {
+			return err
+		}
+
+		return addActivity(tx, c.UserId, ActivityPasskeyAdded, c.Name)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.AddCredential: %v", err)
+	}
+
+	return nil
+}
+
+// GetCredential returns the credential with the given base64url ID.
+func (s *Store) GetCredential(id string) (Credential, error) {
+	var c Credential
+
+	data := s.read(credBucket, id)
+	if data == nil {
+		return c, fmt.Errorf("could not Store.GetCredential: credential not found")
+	}
+
+	err := json.Unmarshal(data, &c)
+	if err != nil {
+		return c, fmt.Errorf("could not Store.GetCredential: %v", err)
+	}
+
+	return c, nil
+}
+
+// GetUserCredentials returns the credentials registered by the user
+// identified by uid.
+func (s *Store) GetUserCredentials(uid UserToken) ([]Credential, error) {
+	var creds []Credential
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(credBucket))
+
+		return b.ForEach(func(k, v []byte) error {
+			if !ownedBy(v, uid) {
+				return nil
+			}
+
+			var c Credential
+
+			err := json.Unmarshal(v, &c)
+			if err != nil {
+				return err
+			}
+
+			creds = append(creds, c)
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return creds, fmt.Errorf("could not Store.GetUserCredentials: %v", err)
+	}
+
+	return creds, nil
+}
+
+// UseCredential records a sign-in with the credential with the given
+// base64url ID and the signature counter the authenticator reported. Once
+// either counter is non-zero the new counter must be higher than the stored
+// one, since a lower counter means the authenticator may have been cloned.
+func (s *Store) UseCredential(id string, count uint32) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var c Credential
+
+		b := tx.Bucket([]byte(credBucket))
+
+		data := b.Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("credential not found")
+		}
+
+		err := json.Unmarshal(data, &c)
+		if err != nil {
+			return err
+		}
+
+		if (count != 0 || c.SignCount != 0) && count <= c.SignCount {
+			return fmt.Errorf("signature counter did not increase")
+		}
+
+		c.SignCount = count
+		c.LastUsed = time.Now().Unix()
+
+		data, err = json.Marshal(c)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(id), data)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.UseCredential: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteCredential removes the credential with the given base64url ID if it
+// belongs to the user identified by uid. The change is recorded in the
+// user's activity.
+func (s *Store) DeleteCredential(uid UserToken, id string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var c Credential
+
+		b := tx.Bucket([]byte(credBucket))
+
+		data := b.Get([]byte(id))
+		if data == nil || !ownedBy(data, uid) {
+			return fmt.Errorf("credential not found")
+		}
+
+		err := json.Unmarshal(data, &c)
+		if err != nil {
+			return err
+		}
+
+		err = b.Delete([]byte(id))
+		if err != nil {
+			return err
+		}
+
+		return addActivity(tx, uid, ActivityPasskeyRemoved, c.Name)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.DeleteCredential: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteUserCredentials removes every credential of the user identified by
+// uid, so a passkey added by someone who took over the account stops working
+// once it is recovered. The change and the given detail, such as who made
+// it, are recorded in the user's activity.
+func (s *Store) DeleteUserCredentials(uid UserToken, detail string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return deleteUserCredentials(tx, uid, detail)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.DeleteUserCredentials: %v", err)
+	}
+
+	return nil
+}
+
+// deleteUserCredentials removes every credential of the user identified by
+// uid using the given transaction. The removal is recorded in the user's
+// activity if they had any credentials.
+func deleteUserCredentials(tx *bolt.Tx, uid UserToken, detail string) error {
+	var n int
+
+	err := deleteMatching(tx.Bucket([]byte(credBucket)), func(k, v []byte) bool {
+		if !ownedBy(v, uid) {
+			return false
+		}
+
+		n++
+
+		return true
+	})
+
+	if err != nil || n == 0 {
+		return err
+	}
+
+	if detail != "" {
+		detail = " " + detail
+	}
+
+	return addActivity(tx, uid, ActivityPasskeyRemoved, fmt.Sprintf("%d passkeys%s", n, detail))
+}
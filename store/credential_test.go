@@ -0,0 +1,199 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+var (
+	testCredentialDbPath = "credential_test.db"
+)
+
+func testStoreCredential(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	u2 := NewUser(testUserAlias + "2")
+	db := newTestStore(t, testCredentialDbPath)
+	defer deleteTestStore(t, testCredentialDbPath)
+
+	db.CreateUser(u1, testAuthGoodPassword)
+	db.CreateUser(u2, testAuthGoodPassword)
+
+	c := Credential{Id: "Y3JlZGVudGlhbA", UserId: u1.UserId, Name: "Laptop", PublicKey: []byte{1, 2, 3}}
+
+	err := db.AddCredential(c)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// A credential ID can only be registered once.
+	err = db.AddCredential(Credential{Id: c.Id, UserId: u2.UserId})
+	if err == nil {
+		t.Fatal("Expected error for duplicate credential, received", nil)
+	}
+
+	stored, err := db.GetCredential(c.Id)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if stored.UserId != u1.UserId || stored.Name != c.Name || stored.Created == 0 {
+		t.Fatal("Expected", c, ", received", stored)
+	}
+
+	creds, _ := db.GetUserCredentials(u1.UserId)
+	if len(creds) != 1 {
+		t.Fatal("Expected", 1, ", received", len(creds))
+	}
+
+	creds, _ = db.GetUserCredentials(u2.UserId)
+	if len(creds) != 0 {
+		t.Fatal("Expected", 0, ", received", len(creds))
+	}
+
+	// Authenticators without a counter always report 0.
+	err = db.UseCredential(c.Id, 0)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// Once the counter is used it must increase with each sign-in.
+	err = db.UseCredential(c.Id, 5)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	for _, count := range []uint32{5, 4, 0} {
+		err = db.UseCredential(c.Id, count)
+		if err == nil {
+			t.Fatal("Expected error for counter", count, ", received", nil)
+		}
+	}
+
+	stored, _ = db.GetCredential(c.Id)
+	if stored.SignCount != 5 || stored.LastUsed == 0 {
+		t.Fatal("Expected counter", 5, ", received", stored)
+	}
+
+	// Only the owner can delete a credential.
+	err = db.DeleteCredential(u2.UserId, c.Id)
+	if err == nil {
+		t.Fatal("Expected error for another user's credential, received", nil)
+	}
+
+	err = db.DeleteCredential(u1.UserId, c.Id)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	_, err = db.GetCredential(c.Id)
+	if err == nil {
+		t.Fatal("Expected error for deleted credential, received", nil)
+	}
+
+	activity, _ := db.GetActivity(u1.UserId)
+	if len(activity) != 2 || activity[0].Action != ActivityPasskeyAdded || activity[1].Action != ActivityPasskeyRemoved {
+		t.Fatal("Expected passkey activity, received", activity)
+	}
+
+	// Every credential of a user can be removed at once, leaving those of
+	// other users.
+	c2 := Credential{Id: "b3RoZXI", UserId: u2.UserId, Name: "Phone"}
+
+	db.AddCredential(c)
+	db.AddCredential(Credential{Id: "c2Vjb25k", UserId: u1.UserId, Name: "Key"})
+	db.AddCredential(c2)
+
+	err = db.DeleteUserCredentials(u1.UserId, "by admin")
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	creds, _ = db.GetUserCredentials(u1.UserId)
+	if len(creds) != 0 {
+		t.Fatal("Expected", 0, ", received", len(creds))
+	}
+
+	activity, _ = db.GetActivity(u1.UserId)
+	last := activity[len(activity)-1]
+	if last.Action != ActivityPasskeyRemoved || last.Detail != "2 passkeys by admin" {
+		t.Fatal("Expected passkey activity, received", last)
+	}
+
+	// Credentials are removed when the passphrase is reset.
+	db.AddCredential(c)
+
+	token, _ := db.CreatePasswordReset(u1.UserId, 60)
+
+	_, err = db.ResetUserPassword(token.String(), testAuthGoodPassword)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	creds, _ = db.GetUserCredentials(u1.UserId)
+	if len(creds) != 0 {
+		t.Fatal("Expected", 0, ", received", len(creds))
+	}
+
+	if _, err = db.GetCredential(c2.Id); err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// Credentials are deleted with the user.
+	db.AddCredential(c)
+	db.DeleteUser(u1)
+
+	_, err = db.GetCredential(c.Id)
+	if err == nil {
+		t.Fatal("Expected error for deleted user's credential, received", nil)
+	}
+
+	db.Close()
+}
+
+func testStoreCeremony(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	db := newTestStore(t, testCredentialDbPath)
+	defer deleteTestStore(t, testCredentialDbPath)
+
+	token, err := db.CreateCeremony(u1.UserId, []byte("challenge"), 300)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	c, err := db.TakeCeremony(token.String())
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if c.UserId != u1.UserId || string(c.Challenge) != "challenge" {
+		t.Fatal("Expected ceremony of", u1.UserId, ", received", c)
+	}
+
+	// A ceremony can only be taken once.
+	_, err = db.TakeCeremony(token.String())
+	if err == nil {
+		t.Fatal("Expected error for used ceremony, received", nil)
+	}
+
+	// Expired ceremonies are not returned, and are removed when the next
+	// ceremony is created.
+	expired, _ := db.CreateCeremony(UserToken{}, []byte("challenge"), -1)
+
+	_, err = db.TakeCeremony(expired.String())
+	if err == nil {
+		t.Fatal("Expected error for expired ceremony, received", nil)
+	}
+
+	expired, _ = db.CreateCeremony(UserToken{}, []byte("challenge"), -1)
+	db.CreateCeremony(UserToken{}, []byte("challenge"), 300)
+
+	if db.read(ceremonyBucket, hashToken(expired.String())) != nil {
+		t.Fatal("Expected expired ceremony to be removed")
+	}
+
+	db.Close()
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"strings"
+	"sync"
 
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/text/unicode/norm"
@@ -16,12 +17,65 @@ const (
 	keySize  = 32
 )
 
-// argonDerive derives an Argon2id hash based on the stored parameters.
+// HashParams holds the Argon2id cost parameters used for new hashes. Memory
+// is in KiB.
+type HashParams struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+// DefaultHashParams is the SECOND RECOMMENDED option from RFC 9106.
+var DefaultHashParams = HashParams{Memory: 64 * 1024, Time: 4, Threads: 3}
+
+var (
+	hashParams   = DefaultHashParams
+	hashParamsMu sync.RWMutex
+)
+
+// checkHashParams returns an error if Argon2id cannot use the parameters.
+// It needs at least one pass, one thread, and 8 KiB of memory per thread.
+func checkHashParams(p HashParams) error {
+	if p.Time < 1 || p.Threads < 1 || p.Memory < 8*uint32(p.Threads) {
+		return fmt.Errorf("invalid parameters m=%d,t=%d,p=%d", p.Memory, p.Time, p.Threads)
+	}
+
+	return nil
+}
+
+// SetHashParams sets the parameters used for new hashes. Argon2id needs at
+// least one pass, one thread, and 8 KiB of memory per thread.
+func SetHashParams(p HashParams) error {
+	err := checkHashParams(p)
+	if err != nil {
+		return fmt.Errorf("could not SetHashParams: %v", err)
+	}
+
+	hashParamsMu.Lock()
+	hashParams = p
+	hashParamsMu.Unlock()
+
+	return nil
+}
+
+// GetHashParams returns the parameters used for new hashes.
+func GetHashParams() HashParams {
+	hashParamsMu.RLock()
+	defer hashParamsMu.RUnlock()
+
+	return hashParams
+}
+
+// argonDerive derives an Argon2id hash based on the stored parameters. When
+// keyID is set, the passphrase is peppered with the key before it is hashed
+// and the ID is recorded in the keyid parameter of the hash.
 type argonHash struct {
 	memory  uint32
 	time    uint32
 	threads uint8
 	salt    [saltSize]byte
+	keyID   string
+	pepper  []byte
 }
 
 // derive takes a passphrase and returns a salt and derived key.
@@ -29,18 +83,22 @@ func (a argonHash) derive(passphrase string) string {
 	// Normalize our passphrase
 	passphrase = norm.NFKD.String(passphrase)
 
+	input := []byte(passphrase)
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", a.memory, a.time, a.threads)
+
+	if a.keyID != "" {
+		input = applyPepper(a.pepper, passphrase)
+		params = params + ",keyid=" + a.keyID
+	}
+
 	// Derive our hash.
-	key := argon2.IDKey([]byte(passphrase), a.salt[:], a.time, a.memory, a.threads, keySize)
+	key := argon2.IDKey(input, a.salt[:], a.time, a.memory, a.threads, keySize)
 
 	// Encode our data
 	salt := base64.RawStdEncoding.EncodeToString(a.salt[:])
 	hash := base64.RawStdEncoding.EncodeToString(key)
 
-	return fmt.Sprintf(
-		"$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s",
-		a.memory, a.time, a.threads,
-		salt, hash,
-	)
+	return fmt.Sprintf("$argon2id$v=19$%s$%s$%s", params, salt, hash)
 }
 
 // newArgonHash creates a new argonHash with the given parameters and salt.
@@ -69,12 +127,35 @@ func newArgonHashFromString(hash string) (argonHash, error) {
 		return ah, fmt.Errorf("could not newArgonHashFromString: invalid hash split")
 	}
 
-	// Extract parameters
-	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &ah.memory, &ah.time, &ah.threads)
-	if err != nil {
+	// Extract parameters, and the ID of the pepper if one was used.
+	params, keyID, peppered := strings.Cut(parts[3], ",keyid=")
+
+	_, err := fmt.Sscanf(params, "m=%d,t=%d,p=%d", &ah.memory, &ah.time, &ah.threads)
+	if err != nil || fmt.Sprintf("m=%d,t=%d,p=%d", ah.memory, ah.time, ah.threads) != params {
 		return ah, fmt.Errorf("could not newArgonHashFromString: invalid parameters")
 	}
 
+	// Argon2id panics on parameters it cannot use, and a hash with larger
+	// parameters than this host accepts would exhaust memory on each login.
+	err = checkHashParams(HashParams{Memory: ah.memory, Time: ah.time, Threads: ah.threads})
+	if err != nil {
+		return ah, fmt.Errorf("could not newArgonHashFromString: %v", err)
+	}
+
+	limit := argonLimits()
+	if ah.memory > limit.Memory || ah.time > limit.Time || ah.threads > limit.Threads {
+		return ah, fmt.Errorf("could not newArgonHashFromString: parameters %s are above the limits", params)
+	}
+
+	if peppered {
+		pepper, ok := getPepper(keyID)
+		if !ok {
+			return ah, fmt.Errorf("could not newArgonHashFromString: unknown pepper %q", keyID)
+		}
+
+		ah.keyID, ah.pepper = keyID, pepper
+	}
+
 	// Extract salt
 	saltBytes, _ = base64.RawStdEncoding.DecodeString(parts[4])
 	if len(saltBytes) != saltSize {
@@ -86,7 +167,9 @@ func newArgonHashFromString(hash string) (argonHash, error) {
 	return ah, nil
 }
 
-// GenerateHash creates a new Argon2id hash with the given passphrase.
+// GenerateHash creates a new Argon2id hash with the given passphrase using
+// the parameters set with SetHashParams and the current pepper set with
+// SetPeppers.
 func GenerateHash(passphrase string) (string, error) {
 	var saltBytes [saltSize]byte
 	var hash string
@@ -97,16 +180,63 @@ func GenerateHash(passphrase string) (string, error) {
 		return hash, fmt.Errorf("could not argonHash.derive: %v", err)
 	}
 
-	// Create an argonHash with the SECOND RECOMMENDED option from RFC9106 and
-	// derive our hash.
-	argon := newArgonHash(64*1024, 4, 3, saltBytes)
-	hash = argon.derive(passphrase)
+	// Create an argonHash with the current parameters and pepper and derive
+	// our hash. The hash is derived in the hashing pool, which fails at once
+	// if too many are waiting.
+	p := GetHashParams()
+	argon := newArgonHash(p.Memory, p.Time, p.Threads, saltBytes)
+	argon.keyID, argon.pepper = currentPepper()
+
+	err = runHash(func() {
+		hash = argon.derive(passphrase)
+	})
+
+	if err != nil {
+		return hash, fmt.Errorf("could not GenerateHash: %w", err)
+	}
 
 	return hash, nil
 }
 
-// VerifyHash verifies a given passphrase generates the given hash.
-func VerifyHash(hash, passphrase string) bool {
+// The largest Argon2id parameters accepted for an imported hash. Verifying a
+// hash costs what its parameters say, so larger ones would let an imported
+// hash tie up the hashing queue or exhaust memory on each login.
+const (
+	maxArgonMemory  = 256 * 1024 // KiB
+	maxArgonTime    = 64
+	maxArgonThreads = 16
+)
+
+// argonLimits returns the largest Argon2id parameters verified, which are the
+// limits for imported hashes, or the parameters for new hashes if larger.
+func argonLimits() HashParams {
+	p := GetHashParams()
+
+	return HashParams{
+		Memory:  max(p.Memory, maxArgonMemory),
+		Time:    max(p.Time, maxArgonTime),
+		Threads: max(p.Threads, maxArgonThreads),
+	}
+}
+
+// checkArgon2id returns an error if an Argon2id hash is malformed or its
+// parameters are above the limits for imported hashes.
+func checkArgon2id(hash string) error {
+	argon, err := newArgonHashFromString(hash)
+	if err != nil {
+		return err
+	}
+
+	if argon.memory > maxArgonMemory || argon.time > maxArgonTime || argon.threads > maxArgonThreads {
+		return fmt.Errorf("argon2id parameters m=%d,t=%d,p=%d are above the limits", argon.memory, argon.time, argon.threads)
+	}
+
+	return nil
+}
+
+// verifyArgon2id verifies a given passphrase generates the given Argon2id
+// hash.
+func verifyArgon2id(hash, passphrase string) bool {
 	argon, err := newArgonHashFromString(hash)
 	if err != nil {
 		return false
@@ -117,3 +247,20 @@ func VerifyHash(hash, passphrase string) bool {
 
 	return cmp == 1
 }
+
+// NeedsRehash returns true if the hash was made with less memory or fewer
+// passes than the parameters now used for new hashes, with a pepper other
+// than the current one, or is not an Argon2id hash, so it should be replaced
+// the next time the passphrase is known. The number of threads does not
+// change the cost of an attack, so it is not compared.
+func NeedsRehash(hash string) bool {
+	argon, err := newArgonHashFromString(hash)
+	if err != nil {
+		return true
+	}
+
+	p := GetHashParams()
+	id, _ := currentPepper()
+
+	return argon.memory < p.Memory || argon.time < p.Time || argon.keyID != id
+}
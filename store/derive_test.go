@@ -24,6 +24,7 @@ func TestDeriver(t *testing.T) {
 	t.Run("Test newArgonHashFromString", testNewArgonHashFromString)
 	t.Run("Test GenerateHash", testGenerateHash)
 	t.Run("Test VerifyHash", testVerifyHash)
+	t.Run("Test SetHashParams", testSetHashParams)
 }
 
 func testNewArgonHash(t *testing.T) {
@@ -117,3 +118,56 @@ func testVerifyHash(t *testing.T) {
 		t.Fatal("Expected hash to not match, but they did")
 	}
 }
+
+func testSetHashParams(t *testing.T) {
+	fmt.Println(t.Name())
+
+	defer SetHashParams(DefaultHashParams)
+
+	invalid := []HashParams{
+		{Memory: 64 * 1024, Time: 0, Threads: 1},
+		{Memory: 64 * 1024, Time: 1, Threads: 0},
+		{Memory: 16, Time: 1, Threads: 4},
+	}
+
+	for _, p := range invalid {
+		if SetHashParams(p) == nil {
+			t.Fatal("Expected error, received", nil, "for", p)
+		}
+	}
+
+	if GetHashParams() != DefaultHashParams {
+		t.Fatal("Expected", DefaultHashParams, ", received", GetHashParams())
+	}
+
+	if NeedsRehash(goodHash) {
+		t.Fatal("Expected", goodHash, "not to need a rehash")
+	}
+
+	if !NeedsRehash(badAlgHash) {
+		t.Fatal("Expected", badAlgHash, "to need a rehash")
+	}
+
+	// New hashes use the new parameters, and older hashes still verify.
+	p := HashParams{Memory: 8 * 1024, Time: 5, Threads: 1}
+
+	err := SetHashParams(p)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if !NeedsRehash(goodHash) {
+		t.Fatal("Expected", goodHash, "to need a rehash")
+	}
+
+	hash, _ := GenerateHash(goodPassword)
+
+	a, _ := newArgonHashFromString(hash)
+	if a.memory != p.Memory || a.time != p.Time || a.threads != p.Threads {
+		t.Fatal("Expected", p, ", received", a.memory, a.time, a.threads)
+	}
+
+	if !VerifyHash(goodHash, goodPassword) || !VerifyHash(hash, goodPassword) {
+		t.Fatal("Expected hashes to match, but they did not")
+	}
+}
@@ -0,0 +1,283 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//----------------------------------------------------------------------------
+// Verification Struct
+//----------------------------------------------------------------------------
+
+// verification holds a pending email verification. The email address is kept
+// so the verification is void if the user changes their address before it is
+// used.
+type verification struct {
+	UserId     UserToken `json:"user_id"`
+	Email      string    `json:"email"`
+	Expiration int64     `json:"expire"`
+}
+
+// NormalizeEmail returns the form of the given email address that is used as
+// a key in the Store.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// EmailToVerify returns the address a verification link is sent to: the
+// address the user is changing to, if any, or else their current address.
+func (u User) EmailToVerify() string {
+	if u.PendingEmail != "" {
+		return u.PendingEmail
+	}
+
+	return u.Email
+}
+
+//----------------------------------------------------------------------------
+// Email Storage Methods
+//----------------------------------------------------------------------------
+
+// SetUserEmail gives the user identified by uid the given email address and
+// marks it unverified. A transaction is used to update the email index and
+// the User bytes together. An error is returned if the address belongs to
+// another user.
+func (s *Store) SetUserEmail(uid UserToken, email string) error {
+	email = NormalizeEmail(email)
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
+		eb := tx.Bucket([]byte(emailBucket))
+
+		data := b.Get([]byte(uid.String()))
+		if data == nil {
+			return fmt.Errorf("user %s not found", uid)
+		}
+
+		user, err := NewUserFromBytes(data)
+		if err != nil {
+			return err
+		}
+
+		owner := eb.Get([]byte(email))
+		if owner != nil && string(owner) != uid.String() {
+			return fmt.Errorf("email %s exists", email)
+		}
+
+		if user.Email != "" {
+			err = eb.Delete([]byte(user.Email))
+			if err != nil {
+				return err
+			}
+		}
+
+		err = eb.Put([]byte(email), []byte(uid.String()))
+		if err != nil {
+			return err
+		}
+
+		user.Email = email
+		user.EmailVerified = false
+		user.PendingEmail = ""
+
+		userBytes, err := user.bytes()
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(uid.String()), userBytes)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.SetUserEmail: %v", err)
+	}
+
+	return nil
+}
+
+// RequestEmailChange records the given email address as the one the user
+// identified by uid is changing to. Their current address is kept until the
+// new one is verified with VerifyEmail. Requesting the current address drops
+// a pending change. An error is returned if the address belongs to another
+// user.
+func (s *Store) RequestEmailChange(uid UserToken, email string) error {
+	email = NormalizeEmail(email)
+
+	owner := s.read(emailBucket, email)
+	if owner != nil && string(owner) != uid.String() {
+		return fmt.Errorf("could not Store.RequestEmailChange: email %s exists", email)
+	}
+
+	err := s.updateUser(uid, func(u *User) {
+		u.PendingEmail = email
+		if email == u.Email {
+			u.PendingEmail = ""
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.RequestEmailChange: %v", err)
+	}
+
+	return nil
+}
+
+// EmailExists returns true if the given email address belongs to a user.
+func (s *Store) EmailExists(email string) bool {
+	data := s.read(emailBucket, NormalizeEmail(email))
+
+	return data != nil
+}
+
+// GetUserByEmail takes an email address and returns the User associated with
+// it.
+func (s *Store) GetUserByEmail(email string) (User, error) {
+	var user User
+
+	email = NormalizeEmail(email)
+
+	data := s.read(emailBucket, email)
+	if data == nil {
+		return user, fmt.Errorf("could not Store.GetUserByEmail: email %s not found", email)
+	}
+
+	token, err := parseUserToken(string(data))
+	if err != nil {
+		return user, fmt.Errorf("could not Store.GetUserByEmail: %s %v", email, err)
+	}
+
+	return s.GetUser(token)
+}
+
+// CreateEmailVerification creates a verification for the address returned by
+// EmailToVerify for the user identified by uid. The returned VerifyToken is valid
+// for the given number of seconds and can only be used once.
+func (s *Store) CreateEmailVerification(uid UserToken, length int64) (VerifyToken, error) {
+	token := NewVerifyToken()
+
+	user, err := s.GetUser(uid)
+	if err != nil {
+		return token, fmt.Errorf("could not Store.CreateEmailVerification: %v", err)
+	}
+
+	if user.EmailToVerify() == "" {
+		return token, fmt.Errorf("could not Store.CreateEmailVerification: user %s has no email", uid)
+	}
+
+	v := verification{
+		UserId:     uid,
+		Email:      user.EmailToVerify(),
+		Expiration: time.Now().Unix() + length,
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return token, fmt.Errorf("could not Store.CreateEmailVerification: %v", err)
+	}
+
+	err = s.write(verifyBucket, hashToken(token.String()), data)
+	if err != nil {
+		return token, fmt.Errorf("could not Store.CreateEmailVerification: %v", err)
+	}
+
+	return token, nil
+}
+
+// VerifyEmail takes a verification token string and marks the associated
+// email address verified. A verified pending address replaces the user's
+// current address. The verification is removed when it is used, so it
+// cannot be used again. The verified User is returned.
+func (s *Store) VerifyEmail(token string) (User, error) {
+	var user User
+
+	vt, err := parseVerifyToken(token)
+	if err != nil {
+		return user, fmt.Errorf("could not Store.VerifyEmail: %v", err)
+	}
+
+	key := []byte(hashToken(vt.String()))
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		var v verification
+
+		vb := tx.Bucket([]byte(verifyBucket))
+		b := tx.Bucket([]byte(userBucket))
+
+		data := vb.Get(key)
+		if data == nil {
+			return fmt.Errorf("verification not found")
+		}
+
+		err := json.Unmarshal(data, &v)
+		if err != nil {
+			return err
+		}
+
+		if time.Now().Unix() > v.Expiration {
+			return fmt.Errorf("verification expired")
+		}
+
+		// The verification is single use.
+		err = vb.Delete(key)
+		if err != nil {
+			return err
+		}
+
+		data = b.Get([]byte(v.UserId.String()))
+		if data == nil {
+			return fmt.Errorf("user %s not found", v.UserId)
+		}
+
+		user, err = NewUserFromBytes(data)
+		if err != nil {
+			return err
+		}
+
+		if v.Email == user.PendingEmail && v.Email != user.Email {
+			eb := tx.Bucket([]byte(emailBucket))
+
+			owner := eb.Get([]byte(v.Email))
+			if owner != nil && string(owner) != v.UserId.String() {
+				return fmt.Errorf("email %s exists", v.Email)
+			}
+
+			if user.Email != "" {
+				err = eb.Delete([]byte(user.Email))
+				if err != nil {
+					return err
+				}
+			}
+
+			err = eb.Put([]byte(v.Email), []byte(v.UserId.String()))
+			if err != nil {
+				return err
+			}
+
+			user.Email = v.Email
+		}
+
+		if user.Email != v.Email {
+			return fmt.Errorf("email address has changed")
+		}
+
+		user.EmailVerified = true
+		user.PendingEmail = ""
+
+		userBytes, err := user.bytes()
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(v.UserId.String()), userBytes)
+	})
+
+	if err != nil {
+		return user, fmt.Errorf("could not Store.VerifyEmail: %v", err)
+	}
+
+	return user, nil
+}
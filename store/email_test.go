@@ -0,0 +1,188 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+var (
+	testEmailAddress = "Alias@Example.com"
+	testEmailOther   = "other@example.com"
+	testEmailDbPath  = "email_test.db"
+)
+
+func testStoreEmail(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	u2 := NewUser(testAliasOther)
+	db := newTestStore(t, testEmailDbPath)
+	defer deleteTestStore(t, testEmailDbPath)
+
+	db.CreateUser(u1, testUserPassphrase)
+	db.CreateUser(u2, testUserPassphrase)
+
+	// A user without an email cannot be verified.
+	_, err := db.CreateEmailVerification(u1.UserId, 60)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	// Set Email
+	err = db.SetUserEmail(u1.UserId, testEmailAddress)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if !db.EmailExists(testEmailAddress) {
+		t.Fatal("Expected email to exist, but it does not.")
+	}
+
+	// The email index is unique.
+	err = db.SetUserEmail(u2.UserId, testEmailAddress)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	// Get User by Email
+	u3, err := db.GetUserByEmail(testEmailAddress)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if u3.UserId != u1.UserId || u3.Email != NormalizeEmail(testEmailAddress) || u3.EmailVerified {
+		t.Fatal("Expected unverified", testEmailAddress, ", received", u3)
+	}
+
+	// Verify Email
+	token, err := db.CreateEmailVerification(u1.UserId, 60)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	u3, err = db.VerifyEmail(token.String())
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if !u3.EmailVerified {
+		t.Fatal("Expected verified email, received", u3)
+	}
+
+	// A verification token can only be used once.
+	_, err = db.VerifyEmail(token.String())
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	// An expired verification token cannot be used.
+	token, _ = db.CreateEmailVerification(u1.UserId, -1)
+	_, err = db.VerifyEmail(token.String())
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	// Changing the email resets verification and voids pending tokens.
+	token, _ = db.CreateEmailVerification(u1.UserId, 60)
+
+	err = db.SetUserEmail(u1.UserId, testEmailOther)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	u3, _ = db.GetUser(u1.UserId)
+	if u3.EmailVerified {
+		t.Fatal("Expected unverified email, received", u3)
+	}
+
+	if db.EmailExists(testEmailAddress) {
+		t.Fatal("Expected old email to not exist, but it does.")
+	}
+
+	_, err = db.VerifyEmail(token.String())
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	// A change of a verified address keeps it until the new one is verified.
+	token, _ = db.CreateEmailVerification(u1.UserId, 60)
+	db.VerifyEmail(token.String())
+
+	err = db.RequestEmailChange(u1.UserId, testEmailAddress)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	u3, _ = db.GetUser(u1.UserId)
+	if u3.Email != testEmailOther || !u3.EmailVerified || u3.EmailToVerify() != NormalizeEmail(testEmailAddress) {
+		t.Fatal("Expected pending change to", testEmailAddress, ", received", u3)
+	}
+
+	if db.EmailExists(testEmailAddress) {
+		t.Fatal("Expected pending email to not exist, but it does.")
+	}
+
+	token, _ = db.CreateEmailVerification(u1.UserId, 60)
+
+	u3, err = db.VerifyEmail(token.String())
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if u3.Email != NormalizeEmail(testEmailAddress) || !u3.EmailVerified || u3.PendingEmail != "" {
+		t.Fatal("Expected verified", testEmailAddress, ", received", u3)
+	}
+
+	if db.EmailExists(testEmailOther) || !db.EmailExists(testEmailAddress) {
+		t.Fatal("Expected only the new email to exist.")
+	}
+
+	// A pending change cannot take an address that was taken meanwhile.
+	db.RequestEmailChange(u1.UserId, testEmailOther)
+	token, _ = db.CreateEmailVerification(u1.UserId, 60)
+	db.SetUserEmail(u2.UserId, testEmailOther)
+
+	_, err = db.VerifyEmail(token.String())
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	err = db.RequestEmailChange(u1.UserId, testEmailOther)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	// Users created with an email address are indexed by it, and cannot take
+	// an address in use.
+	u4 := NewUser("emailuser1234")
+	u4.Email = testEmailOther
+
+	err = db.CreateUser(u4, testUserPassphrase)
+	if err == nil || db.UserExists(u4.Alias) {
+		t.Fatal("Expected error, received", err)
+	}
+
+	u4.Email = "new@example.com"
+
+	err = db.CreateUser(u4, testUserPassphrase)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	u5, err := db.GetUserByEmail(u4.Email)
+	if err != nil || u5.UserId != u4.UserId {
+		t.Fatal("Expected", u4, ", received", u5, err)
+	}
+
+	// Deleting the user releases the email.
+	err = db.DeleteUser(u3)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if db.EmailExists(testEmailAddress) {
+		t.Fatal("Expected email to not exist, but it does.")
+	}
+
+	db.Close()
+}
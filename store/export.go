@@ -0,0 +1,156 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+//----------------------------------------------------------------------------
+// Export Structs
+//----------------------------------------------------------------------------
+
+// Exporter returns the data a module of the application holds about the
+// given user. The data is included in the user's personal data export and
+// must be safe to marshal as JSON.
+type Exporter func(s *Store, u User) (interface{}, error)
+
+// exporters holds the registered Exporter of each module by name.
+var (
+	exporters   = make(map[string]Exporter)
+	exportersMu sync.RWMutex
+)
+
+// RegisterExporter adds the data returned by the given Exporter to every
+// personal data export under the given module name. Registering a name twice
+// replaces the earlier Exporter.
+func RegisterExporter(name string, fn Exporter) {
+	exportersMu.Lock()
+	defer exportersMu.Unlock()
+
+	exporters[name] = fn
+}
+
+// ExportSession holds the parts of a session included in an export. Session
+// and CSRF tokens are left out so the export cannot be used to take over the
+// account.
+type ExportSession struct {
+	Expiration int64 `json:"expire"`
+}
+
+// Export holds a copy of the personal data kept about a user. Passphrase
+// hashes and tokens are never included.
+type Export struct {
+	Created  int64                  `json:"created"`
+	User     User                   `json:"user"`
+	Sessions []ExportSession        `json:"sessions"`
+	Activity []Activity             `json:"activity"`
+	Modules  map[string]interface{} `json:"modules"`
+}
+
+// The built in modules with personal data outside the User record.
+func init() {
+	RegisterExporter("alias_history", func(s *Store, u User) (interface{}, error) {
+		return s.GetAliasHistory(u.UserId)
+	})
+
+	RegisterExporter("preferences", func(s *Store, u User) (interface{}, error) {
+		prefs, err := s.GetPreferences(u.UserId)
+		return prefs.values, err
+	})
+
+	RegisterExporter("two_factor", func(s *Store, u User) (interface{}, error) {
+		enabled, err := s.TOTPEnabled(u.UserId)
+
+		return map[string]interface{}{
+			"totp":                enabled,
+			"recovery_codes_left": s.RecoveryCodesLeft(u.UserId),
+		}, err
+	})
+
+	RegisterExporter("passkeys", func(s *Store, u User) (interface{}, error) {
+		return s.GetUserCredentials(u.UserId)
+	})
+
+	RegisterExporter("uploads", func(s *Store, u User) (interface{}, error) {
+		return s.ListUploads(u.UserId)
+	})
+
+	RegisterExporter("organizations", func(s *Store, u User) (interface{}, error) {
+		var orgs []Membership
+
+		list, err := s.GetUserOrgs(u.UserId)
+		if err != nil {
+			return orgs, err
+		}
+
+		for _, org := range list {
+			m, err := s.GetMembership(org.OrgId, u.UserId)
+			if err != nil {
+				return orgs, err
+			}
+
+			orgs = append(orgs, m)
+		}
+
+		return orgs, nil
+	})
+}
+
+//----------------------------------------------------------------------------
+// Export Storage Methods
+//----------------------------------------------------------------------------
+
+// ExportUser gathers the personal data kept about the user identified by uid:
+// the User record, sessions, activity, and the data of every module with a
+// registered Exporter.
+func (s *Store) ExportUser(uid UserToken) (Export, error) {
+	export := Export{
+		Created:  time.Now().Unix(),
+		Sessions: []ExportSession{},
+		Modules:  make(map[string]interface{}),
+	}
+
+	user, err := s.GetUser(uid)
+	if err != nil {
+		return export, fmt.Errorf("could not Store.ExportUser: %v", err)
+	}
+
+	export.User = user
+
+	sessions, err := s.GetUserSessions(uid)
+	if err != nil {
+		return export, fmt.Errorf("could not Store.ExportUser: %v", err)
+	}
+
+	for _, sess := range sessions {
+		export.Sessions = append(export.Sessions, ExportSession{Expiration: sess.Expiration})
+	}
+
+	export.Activity, err = s.GetActivity(uid)
+	if err != nil {
+		return export, fmt.Errorf("could not Store.ExportUser: %v", err)
+	}
+
+	exportersMu.RLock()
+	defer exportersMu.RUnlock()
+
+	names := make([]string, 0, len(exporters))
+	for name := range exporters {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, err := exporters[name](s, user)
+		if err != nil {
+			return export, fmt.Errorf("could not Store.ExportUser: %s %v", name, err)
+		}
+
+		export.Modules[name] = data
+	}
+
+	return export, nil
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var (
+	testExportDbPath = "export_test.db"
+)
+
+func testStoreExport(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	db := newTestStore(t, testExportDbPath)
+	defer deleteTestStore(t, testExportDbPath)
+
+	_, err := db.ExportUser(u1.UserId)
+	if err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+
+	db.CreateUser(u1, testUserPassphrase)
+	db.ChangeUserAlias(u1.UserId, testAliasNew, u1.UserId, 60)
+	db.AddActivity(u1.UserId, testActivityAction, "")
+	db.CreateOrg("Export Org", u1.UserId)
+
+	sess, _ := NewSession(u1.UserId, 60)
+	db.CreateSession(sess)
+
+	RegisterExporter("test", func(s *Store, u User) (interface{}, error) {
+		return map[string]string{"alias": u.Alias}, nil
+	})
+
+	export, err := db.ExportUser(u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if export.User.Alias != testAliasNew {
+		t.Fatal("Expected", testAliasNew, ", received", export.User.Alias)
+	}
+
+	if len(export.Sessions) != 1 || export.Sessions[0].Expiration != sess.Expiration {
+		t.Fatal("Expected", sess.Expiration, ", received", export.Sessions)
+	}
+
+	if len(export.Activity) != 1 || export.Activity[0].Action != testActivityAction {
+		t.Fatal("Expected", testActivityAction, ", received", export.Activity)
+	}
+
+	history := export.Modules["alias_history"].([]AliasChange)
+	if len(history) != 1 || history[0].NewAlias != testAliasNew {
+		t.Fatal("Expected", testAliasNew, ", received", history)
+	}
+
+	orgs := export.Modules["organizations"].([]Membership)
+	if len(orgs) != 1 || !orgs[0].HasRole(RoleOwner) {
+		t.Fatal("Expected", RoleOwner, ", received", orgs)
+	}
+
+	if export.Modules["test"].(map[string]string)["alias"] != testAliasNew {
+		t.Fatal("Expected", testAliasNew, ", received", export.Modules["test"])
+	}
+
+	// Tokens are never exported.
+	data, _ := json.Marshal(export)
+	if strings.Contains(string(data), sess.SessionId.String()) || strings.Contains(string(data), sess.CSRFToken) {
+		t.Fatal("Expected no session tokens, received", string(data))
+	}
+
+	// Module errors fail the export.
+	RegisterExporter("test", func(s *Store, u User) (interface{}, error) {
+		return nil, fmt.Errorf("test error")
+	})
+	defer func() {
+		exportersMu.Lock()
+		delete(exporters, "test")
+		exportersMu.Unlock()
+	}()
+
+	_, err = db.ExportUser(u1.UserId)
+	if err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHashBusy is returned when a passphrase hash cannot be made or checked
+// because the hashing queue is full. Callers should ask the client to try
+// again later.
+var ErrHashBusy = errors.New("passphrase hashing queue is full")
+
+//----------------------------------------------------------------------------
+// Hash Pool
+//----------------------------------------------------------------------------
+
+// hashPool bounds the number of passphrase hashes derived at once, since each
+// Argon2id derivation holds its memory cost until it is done. Derivations
+// wait for one of the workers in a queue of bounded size, and fail at once
+// when the queue is full.
+type hashPool struct {
+	workers   chan struct{} // held by each running derivation
+	tickets   chan struct{} // held by each running or waiting derivation
+	queueSize int
+
+	running   atomic.Int64
+	queued    atomic.Int64
+	completed atomic.Uint64
+	rejected  atomic.Uint64
+	waitNanos atomic.Int64
+	hashNanos atomic.Int64
+}
+
+// newHashPool returns a hashPool running the given number of derivations at
+// once, with room for queue more to wait.
+func newHashPool(workers, queue int) *hashPool {
+	return &hashPool{
+		workers:   make(chan struct{}, workers),
+		tickets:   make(chan struct{}, workers+queue),
+		queueSize: queue,
+	}
+}
+
+// run calls fn once a worker is free, or returns ErrHashBusy without calling
+// it if the queue is full. The worker is freed even if fn panics.
+func (p *hashPool) run(fn func()) error {
+	select {
+	case p.tickets <- struct{}{}:
+	default:
+		p.rejected.Add(1)
+		return ErrHashBusy
+	}
+	defer func() { <-p.tickets }()
+
+	queued := time.Now()
+	p.queued.Add(1)
+	p.workers <- struct{}{}
+	p.queued.Add(-1)
+	p.running.Add(1)
+	defer func() {
+		p.running.Add(-1)
+		<-p.workers
+	}()
+
+	started := time.Now()
+	fn()
+	done := time.Now()
+
+	p.waitNanos.Add(int64(started.Sub(queued)))
+	p.hashNanos.Add(int64(done.Sub(started)))
+	p.completed.Add(1)
+
+	return nil
+}
+
+// HashStats holds metrics about the passphrase hashing pool. Queued counts
+// the derivations waiting for a worker. WaitSeconds and HashSeconds are the
+// total time completed derivations spent waiting and hashing, and the
+// averages divide them by Completed.
+type HashStats struct {
+	Workers        int     `json:"workers"`
+	QueueSize      int     `json:"queue_size"`
+	Running        int64   `json:"running"`
+	Queued         int64   `json:"queued"`
+	Completed      uint64  `json:"completed"`
+	Rejected       uint64  `json:"rejected"`
+	WaitSeconds    float64 `json:"wait_seconds"`
+	HashSeconds    float64 `json:"hash_seconds"`
+	AvgWaitSeconds float64 `json:"avg_wait_seconds"`
+	AvgHashSeconds float64 `json:"avg_hash_seconds"`
+}
+
+// stats returns the current metrics of the pool.
+func (p *hashPool) stats() HashStats {
+	hs := HashStats{
+		Workers:     cap(p.workers),
+		QueueSize:   p.queueSize,
+		Running:     p.running.Load(),
+		Queued:      p.queued.Load(),
+		Completed:   p.completed.Load(),
+		Rejected:    p.rejected.Load(),
+		WaitSeconds: time.Duration(p.waitNanos.Load()).Seconds(),
+		HashSeconds: time.Duration(p.hashNanos.Load()).Seconds(),
+	}
+
+	if hs.Completed > 0 {
+		hs.AvgWaitSeconds = hs.WaitSeconds / float64(hs.Completed)
+		hs.AvgHashSeconds = hs.HashSeconds / float64(hs.Completed)
+	}
+
+	return hs
+}
+
+// DefaultHashWorkers and DefaultHashQueue size the pool until
+// SetHashConcurrency is called.
+const (
+	DefaultHashWorkers = 4
+	DefaultHashQueue   = 32
+)
+
+var (
+	hashes   = newHashPool(DefaultHashWorkers, DefaultHashQueue)
+	hashesMu sync.RWMutex
+)
+
+// The pool metrics are published with expvar as passphrase_hashing.
+func init() {
+	expvar.Publish("passphrase_hashing", expvar.Func(func() interface{} {
+		return GetHashStats()
+	}))
+}
+
+// SetHashConcurrency replaces the hashing pool with one running the given
+// number of derivations at once, with room for queue more to wait.
+// Derivations already started finish in the old pool, and the metrics start
+// over.
+func SetHashConcurrency(workers, queue int) error {
+	if workers < 1 || queue < 0 {
+		return fmt.Errorf("could not SetHashConcurrency: invalid size %d workers, %d queued", workers, queue)
+	}
+
+	hashesMu.Lock()
+	hashes = newHashPool(workers, queue)
+	hashesMu.Unlock()
+
+	return nil
+}
+
+// GetHashStats returns the current metrics of the hashing pool.
+func GetHashStats() HashStats {
+	hashesMu.RLock()
+	defer hashesMu.RUnlock()
+
+	return hashes.stats()
+}
+
+// runHash runs a passphrase derivation in the hashing pool.
+func runHash(fn func()) error {
+	hashesMu.RLock()
+	p := hashes
+	hashesMu.RUnlock()
+
+	return p.run(fn)
+}
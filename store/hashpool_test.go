@@ -0,0 +1,107 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHashPool(t *testing.T) {
+	p := newHashPool(1, 1)
+
+	// Hold the only worker.
+	hold := make(chan struct{})
+	started := make(chan struct{})
+
+	go p.run(func() {
+		close(started)
+		<-hold
+	})
+
+	<-started
+
+	// The next derivation waits in the queue.
+	waited := make(chan error)
+
+	go func() {
+		waited <- p.run(func() {})
+	}()
+
+	for p.stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full, so the third fails at once.
+	err := p.run(func() { t.Fatal("Expected rejected derivation not to run") })
+	if !errors.Is(err, ErrHashBusy) {
+		t.Fatal("Expected", ErrHashBusy, ", received", err)
+	}
+
+	stats := p.stats()
+	if stats.Running != 1 || stats.Queued != 1 || stats.Rejected != 1 {
+		t.Fatal("Expected 1 running, 1 queued, and 1 rejected, received", stats)
+	}
+
+	close(hold)
+
+	err = <-waited
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	stats = p.stats()
+	if stats.Running != 0 || stats.Queued != 0 || stats.Completed != 2 {
+		t.Fatal("Expected 2 completed, received", stats)
+	}
+
+	if stats.WaitSeconds <= 0 || stats.AvgHashSeconds <= 0 {
+		t.Fatal("Expected wait and hash times, received", stats)
+	}
+
+	// A derivation that panics frees its worker for the next one.
+	func() {
+		defer func() { recover() }()
+		p.run(func() { panic("derivation failed") })
+	}()
+
+	stats = p.stats()
+	if stats.Running != 0 || stats.Completed != 2 {
+		t.Fatal("Expected 0 running and 2 completed, received", stats)
+	}
+
+	err = p.run(func() {})
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// A full pool is reported to the callers of the hash functions.
+	defer SetHashConcurrency(DefaultHashWorkers, DefaultHashQueue)
+
+	err = SetHashConcurrency(0, 1)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	SetHashConcurrency(1, 0)
+
+	hashesMu.RLock()
+	busy := hashes
+	hashesMu.RUnlock()
+
+	busy.tickets <- struct{}{}
+	defer func() { <-busy.tickets }()
+
+	_, err = GenerateHash(goodPassword)
+	if !errors.Is(err, ErrHashBusy) {
+		t.Fatal("Expected", ErrHashBusy, ", received", err)
+	}
+
+	_, err = CheckHash(goodHash, goodPassword)
+	if !errors.Is(err, ErrHashBusy) {
+		t.Fatal("Expected", ErrHashBusy, ", received", err)
+	}
+
+	if VerifyHash(goodHash, goodPassword) {
+		t.Fatal("Expected busy hash not to match")
+	}
+}
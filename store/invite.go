@@ -0,0 +1,213 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//----------------------------------------------------------------------------
+// Invite Struct
+//----------------------------------------------------------------------------
+
+// Invite holds a registration invite. The invite code itself is never
+// stored, the invite is identified by a hash of the code instead.
+type Invite struct {
+	InviteId   string    `json:"invite_id"`
+	IssuedBy   UserToken `json:"issued_by"`
+	Role       string    `json:"role"`
+	MaxUses    int       `json:"max_uses"`
+	Uses       int       `json:"uses"`
+	Expiration int64     `json:"expire"`
+}
+
+// Date returns the expiration of the invite formatted for display.
+func (i Invite) Date() string {
+	return time.Unix(i.Expiration, 0).UTC().Format(time.RFC1123)
+}
+
+// Usable returns true if the invite has not expired or been used up.
+func (i Invite) Usable() bool {
+	return time.Now().Unix() <= i.Expiration && i.Uses < i.MaxUses
+}
+
+//----------------------------------------------------------------------------
+// Invite Storage Methods
+//----------------------------------------------------------------------------
+
+// CreateInvite creates an invite issued by the user identified by by. The
+// returned InviteToken is valid for the given number of seconds and can be
+// used to register up to maxUses accounts, each of which is given the given
+// role. Only a hash of the token is kept in the Store.
+func (s *Store) CreateInvite(by UserToken, role string, maxUses int, length int64) (InviteToken, error) {
+	token := NewInviteToken()
+
+	if maxUses < 1 {
+		return token, fmt.Errorf("could not Store.CreateInvite: invalid use limit %d", maxUses)
+	}
+
+	inv := Invite{
+		InviteId:   hashToken(token.String()),
+		IssuedBy:   by,
+		Role:       role,
+		MaxUses:    maxUses,
+		Expiration: time.Now().Unix() + length,
+	}
+
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return token, fmt.Errorf("could not Store.CreateInvite: %v", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		err := rolesExist(tx, []string{role})
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte(inviteBucket)).Put([]byte(inv.InviteId), data)
+	})
+
+	if err != nil {
+		return token, fmt.Errorf("could not Store.CreateInvite: %v", err)
+	}
+
+	return token, nil
+}
+
+// ListInvites returns every invite in the Store, the invites expiring soonest
+// first.
+func (s *Store) ListInvites() ([]Invite, error) {
+	var invites []Invite
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(inviteBucket)).ForEach(func(k, v []byte) error {
+			var inv Invite
+
+			err := json.Unmarshal(v, &inv)
+			if err != nil {
+				return err
+			}
+
+			invites = append(invites, inv)
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return invites, fmt.Errorf("could not Store.ListInvites: %v", err)
+	}
+
+	sort.Slice(invites, func(i, j int) bool {
+		return invites[i].Expiration < invites[j].Expiration
+	})
+
+	return invites, nil
+}
+
+// RevokeInvite removes the invite identified by id so it can no longer be
+// used.
+func (s *Store) RevokeInvite(id string) error {
+	if s.read(inviteBucket, id) == nil {
+		return fmt.Errorf("could not Store.RevokeInvite: invite %s not found", id)
+	}
+
+	err := s.delete(inviteBucket, id)
+	if err != nil {
+		return fmt.Errorf("could not Store.RevokeInvite: %v", err)
+	}
+
+	return nil
+}
+
+// InviteValid returns true if the given invite code string can be used to
+// register.
+func (s *Store) InviteValid(code string) bool {
+	var inv Invite
+
+	it, err := parseInviteToken(code)
+	if err != nil {
+		return false
+	}
+
+	data := s.read(inviteBucket, hashToken(it.String()))
+	if data == nil {
+		return false
+	}
+
+	err = json.Unmarshal(data, &inv)
+	if err != nil {
+		return false
+	}
+
+	return inv.Usable()
+}
+
+// CreateInvitedUser takes a User and an invite code string and creates the
+// user in the Store with the role of the invite. A transaction is used to
+// count the use of the invite and create the user together, so an invite
+// cannot be used more often than it allows.
+func (s *Store) CreateInvitedUser(u User, passphrase, code string) error {
+	it, err := parseInviteToken(code)
+	if err != nil {
+		return fmt.Errorf("could not Store.CreateInvitedUser: %v", err)
+	}
+
+	hash, err := GenerateHash(passphrase)
+	if err != nil {
+		return fmt.Errorf("could not Store.CreateInvitedUser: %w", err)
+	}
+
+	// Verify the alias is not reserved
+	if s.AliasReserved(u.Alias, u.UserId) {
+		return fmt.Errorf("could not Store.CreateInvitedUser: alias %s is reserved", u.Alias)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		var inv Invite
+
+		b := tx.Bucket([]byte(inviteBucket))
+
+		data := b.Get([]byte(hashToken(it.String())))
+		if data == nil {
+			return fmt.Errorf("invite not found")
+		}
+
+		err := json.Unmarshal(data, &inv)
+		if err != nil {
+			return err
+		}
+
+		if !inv.Usable() {
+			return fmt.Errorf("invite expired or used up")
+		}
+
+		inv.Uses = inv.Uses + 1
+
+		data, err = json.Marshal(inv)
+		if err != nil {
+			return err
+		}
+
+		err = b.Put([]byte(inv.InviteId), data)
+		if err != nil {
+			return err
+		}
+
+		if !u.HasRole(inv.Role) {
+			u.Roles = append(u.Roles, inv.Role)
+		}
+
+		return putUser(tx, u, hash)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.CreateInvitedUser: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+var (
+	testInviteDbPath = "invite_test.db"
+)
+
+func testStoreInvite(t *testing.T) {
+	fmt.Println(t.Name())
+
+	db := newTestStore(t, testInviteDbPath)
+	defer deleteTestStore(t, testInviteDbPath)
+
+	admin := NewUser("admin")
+	db.CreateUser(admin, testUserPassphrase)
+
+	// Invites must grant an existing role and allow at least one use.
+	_, err := db.CreateInvite(admin.UserId, "missing", 1, 60)
+	if err == nil {
+		t.Fatal("Expected", "role not found", ", received", err)
+	}
+
+	_, err = db.CreateInvite(admin.UserId, RoleUser, 0, 60)
+	if err == nil {
+		t.Fatal("Expected", "invalid use limit", ", received", err)
+	}
+
+	token, err := db.CreateInvite(admin.UserId, RoleOwner, 2, 60)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// Only a hash of the code is stored.
+	if db.read(inviteBucket, token.String()) != nil {
+		t.Fatal("Expected invite to be stored by hash")
+	}
+
+	if !db.InviteValid(token.String()) || db.InviteValid(NewInviteToken().String()) || db.InviteValid("bad") {
+		t.Fatal("Expected only the issued invite to be valid")
+	}
+
+	// Users registered with the invite are given its role.
+	u1 := NewUser(testUserAlias)
+
+	err = db.CreateInvitedUser(u1, testUserPassphrase, NewInviteToken().String())
+	if err == nil {
+		t.Fatal("Expected", "invite not found", ", received", err)
+	}
+
+	err = db.CreateInvitedUser(u1, testUserPassphrase, token.String())
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	user, _ := db.GetUser(u1.UserId)
+	if !user.HasRole(RoleUser) || !user.HasRole(RoleOwner) {
+		t.Fatal("Expected", []string{RoleUser, RoleOwner}, ", received", user.Roles)
+	}
+
+	// A failed registration does not use the invite.
+	err = db.CreateInvitedUser(NewUser(testUserAlias), testUserPassphrase, token.String())
+	if err == nil {
+		t.Fatal("Expected", "alias exists", ", received", err)
+	}
+
+	invites, _ := db.ListInvites()
+	if len(invites) != 1 || invites[0].Uses != 1 || invites[0].IssuedBy != admin.UserId {
+		t.Fatal("Expected one use, received", invites)
+	}
+
+	// The invite cannot be used more often than it allows.
+	db.CreateInvitedUser(NewUser(testAliasOther), testUserPassphrase, token.String())
+
+	if db.InviteValid(token.String()) {
+		t.Fatal("Expected", false, ", received", true)
+	}
+
+	err = db.CreateInvitedUser(NewUser("thirduser"), testUserPassphrase, token.String())
+	if err == nil {
+		t.Fatal("Expected", "invite used up", ", received", err)
+	}
+
+	// Expired invites cannot be used.
+	expired, _ := db.CreateInvite(admin.UserId, RoleUser, 1, -1)
+	if db.InviteValid(expired.String()) {
+		t.Fatal("Expected", false, ", received", true)
+	}
+
+	err = db.CreateInvitedUser(NewUser("thirduser"), testUserPassphrase, expired.String())
+	if err == nil {
+		t.Fatal("Expected", "invite expired", ", received", err)
+	}
+
+	// Revoked invites are removed.
+	revoked, _ := db.CreateInvite(admin.UserId, RoleUser, 1, 60)
+
+	err = db.RevokeInvite(hashToken(revoked.String()))
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if db.InviteValid(revoked.String()) {
+		t.Fatal("Expected", false, ", received", true)
+	}
+
+	err = db.RevokeInvite(hashToken(revoked.String()))
+	if err == nil {
+		t.Fatal("Expected", "invite not found", ", received", err)
+	}
+}
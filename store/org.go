@@ -0,0 +1,551 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrLastManager is returned when a user cannot be deleted because an
+// organization would be left without a member who can manage it.
+var ErrLastManager = errors.New("user is the last manager of an organization")
+
+var (
+	memberKey    = "%s:%s"
+	memberPrefix = "%s:"
+	orgDataKey   = "%s:%s"
+)
+
+//----------------------------------------------------------------------------
+// Organization Structs
+//----------------------------------------------------------------------------
+
+// Organization holds a single organization, or tenant, whose members and
+// data are kept apart from every other organization.
+type Organization struct {
+	OrgId OrgToken `json:"org_id"`
+	Name  string   `json:"name"`
+}
+
+// Membership holds the roles a user has been given in an organization. The
+// roles only grant their permissions within that organization.
+type Membership struct {
+	OrgId  OrgToken  `json:"org_id"`
+	UserId UserToken `json:"user_id"`
+	Roles  []string  `json:"roles"`
+}
+
+// HasRole returns true if the membership has been given the given role.
+func (m Membership) HasRole(role string) bool {
+	return User{Roles: m.Roles}.HasRole(role)
+}
+
+// putMembership stores the given membership in the given member bucket. Each
+// membership is stored under an org:user key to list the members of an
+// organization and a user:org key to list the organizations of a user.
+func putMembership(b *bolt.Bucket, m Membership) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	err = b.Put([]byte(fmt.Sprintf(memberKey, m.OrgId, m.UserId)), data)
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(fmt.Sprintf(memberKey, m.UserId, m.OrgId)), data)
+}
+
+// listMemberships returns the memberships stored in the given member bucket
+// under keys starting with the given id.
+func listMemberships(b *bolt.Bucket, id string) ([]Membership, error) {
+	var members []Membership
+
+	prefix := []byte(fmt.Sprintf(memberPrefix, id))
+	c := b.Cursor()
+
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		var m Membership
+
+		err := json.Unmarshal(v, &m)
+		if err != nil {
+			return members, err
+		}
+
+		members = append(members, m)
+	}
+
+	return members, nil
+}
+
+//----------------------------------------------------------------------------
+// Organization Storage Methods
+//----------------------------------------------------------------------------
+
+// CreateOrg creates a new organization with the given name. A transaction is
+// used to store the organization, create its data bucket, and make the user
+// identified by owner its first member with the owner role together.
+func (s *Store) CreateOrg(name string, owner UserToken) (Organization, error) {
+	org := Organization{OrgId: NewOrgToken(), Name: name}
+
+	data, err := json.Marshal(org)
+	if err != nil {
+		return org, fmt.Errorf("could not Store.CreateOrg: %v", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(userBucket)).Get([]byte(owner.String())) == nil {
+			return fmt.Errorf("user %s not found", owner)
+		}
+
+		err := tx.Bucket([]byte(orgBucket)).Put([]byte(org.OrgId.String()), data)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Bucket([]byte(orgDataBucket)).CreateBucket([]byte(org.OrgId.String()))
+		if err != nil {
+			return err
+		}
+
+		m := Membership{OrgId: org.OrgId, UserId: owner, Roles: []string{RoleOwner}}
+
+		return putMembership(tx.Bucket([]byte(memberBucket)), m)
+	})
+
+	if err != nil {
+		return org, fmt.Errorf("could not Store.CreateOrg: %v", err)
+	}
+
+	return org, nil
+}
+
+// GetOrg takes an OrgToken and returns the Organization associated with it.
+func (s *Store) GetOrg(oid OrgToken) (Organization, error) {
+	var org Organization
+
+	data := s.read(orgBucket, oid.String())
+	if data == nil {
+		return org, fmt.Errorf("could not Store.GetOrg: org %s not found", oid)
+	}
+
+	err := json.Unmarshal(data, &org)
+	if err != nil {
+		return org, fmt.Errorf("could not Store.GetOrg: %v", err)
+	}
+
+	return org, nil
+}
+
+// GetOrgById takes an organization id string and returns the Organization
+// associated with it.
+func (s *Store) GetOrgById(id string) (Organization, error) {
+	var org Organization
+
+	token, err := parseOrgToken(id)
+	if err != nil {
+		return org, fmt.Errorf("could not Store.GetOrgById: %v", err)
+	}
+
+	return s.GetOrg(token)
+}
+
+// GetUserOrgs returns the organizations the user identified by uid is a
+// member of, sorted by name.
+func (s *Store) GetUserOrgs(uid UserToken) ([]Organization, error) {
+	var orgs []Organization
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		members, err := listMemberships(tx.Bucket([]byte(memberBucket)), uid.String())
+		if err != nil {
+			return err
+		}
+
+		ob := tx.Bucket([]byte(orgBucket))
+
+		for _, m := range members {
+			var org Organization
+
+			err = json.Unmarshal(ob.Get([]byte(m.OrgId.String())), &org)
+			if err != nil {
+				return err
+			}
+
+			orgs = append(orgs, org)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return orgs, fmt.Errorf("could not Store.GetUserOrgs: %v", err)
+	}
+
+	sort.Slice(orgs, func(i, j int) bool {
+		return orgs[i].Name < orgs[j].Name
+	})
+
+	return orgs, nil
+}
+
+// GetMembership returns the membership of the user identified by uid in the
+// organization identified by oid. An error is returned if the user is not a
+// member.
+func (s *Store) GetMembership(oid OrgToken, uid UserToken) (Membership, error) {
+	var m Membership
+
+	data := s.read(memberBucket, fmt.Sprintf(memberKey, oid, uid))
+	if data == nil {
+		return m, fmt.Errorf("could not Store.GetMembership: %s is not a member of %s", uid, oid)
+	}
+
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		return m, fmt.Errorf("could not Store.GetMembership: %v", err)
+	}
+
+	return m, nil
+}
+
+// GetMemberPermissions returns the set of permissions granted within an
+// organization by the roles of the given membership. Only the roles in
+// OrgRoles grant permissions within an organization.
+func (s *Store) GetMemberPermissions(m Membership) map[string]bool {
+	var roles []string
+
+	for _, name := range m.Roles {
+		if IsOrgRole(name) {
+			roles = append(roles, name)
+		}
+	}
+
+	return s.GetPermissions(User{Roles: roles})
+}
+
+// Org returns an OrgStore that can only access the members and data of the
+// organization identified by oid.
+func (s *Store) Org(oid OrgToken) OrgStore {
+	return OrgStore{db: s.db, org: oid}
+}
+
+//----------------------------------------------------------------------------
+// Organization Scoped Storage
+//----------------------------------------------------------------------------
+
+// OrgStore provides access to the members and data of a single organization.
+// Handlers working within an organization should use the OrgStore in the
+// request context so they cannot read or change another organization's
+// records by accident.
+type OrgStore struct {
+	db  *bolt.DB
+	org OrgToken
+}
+
+// OrgId returns the id of the organization the OrgStore is scoped to.
+func (o OrgStore) OrgId() OrgToken {
+	return o.org
+}
+
+// Members returns the memberships of the organization.
+func (o OrgStore) Members() ([]Membership, error) {
+	var members []Membership
+
+	err := o.db.View(func(tx *bolt.Tx) error {
+		var err error
+
+		members, err = listMemberships(tx.Bucket([]byte(memberBucket)), o.org.String())
+
+		return err
+	})
+
+	if err != nil {
+		return members, fmt.Errorf("could not OrgStore.Members: %v", err)
+	}
+
+	return members, nil
+}
+
+// AddMember makes the user identified by uid a member of the organization
+// with the given roles. An error is returned if the user is already a member
+// or a role does not exist or is not in OrgRoles.
+func (o OrgStore) AddMember(uid UserToken, roles []string) error {
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(memberBucket))
+
+		if tx.Bucket([]byte(userBucket)).Get([]byte(uid.String())) == nil {
+			return fmt.Errorf("user %s not found", uid)
+		}
+
+		if b.Get([]byte(fmt.Sprintf(memberKey, o.org, uid))) != nil {
+			return fmt.Errorf("%s is already a member", uid)
+		}
+
+		err := rolesExist(tx, roles)
+		if err == nil {
+			err = orgRolesOnly(roles)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return putMembership(b, Membership{OrgId: o.org, UserId: uid, Roles: roles})
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not OrgStore.AddMember: %v", err)
+	}
+
+	return nil
+}
+
+// SetMemberRoles replaces the roles of the member identified by uid. An error
+// is returned if a role does not exist or is not in OrgRoles, or the
+// organization would be left without a member who can manage it.
+func (o OrgStore) SetMemberRoles(uid UserToken, roles []string) error {
+	if roles == nil {
+		roles = []string{}
+	}
+
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(memberBucket))
+
+		if b.Get([]byte(fmt.Sprintf(memberKey, o.org, uid))) == nil {
+			return fmt.Errorf("%s is not a member", uid)
+		}
+
+		err := rolesExist(tx, roles)
+		if err == nil {
+			err = orgRolesOnly(roles)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = putMembership(b, Membership{OrgId: o.org, UserId: uid, Roles: roles})
+		if err != nil {
+			return err
+		}
+
+		return o.checkManaged(tx)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not OrgStore.SetMemberRoles: %v", err)
+	}
+
+	return nil
+}
+
+// RemoveMember removes the member identified by uid from the organization. An
+// error is returned if the organization would be left without a member who
+// can manage it.
+func (o OrgStore) RemoveMember(uid UserToken) error {
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(memberBucket))
+
+		key := []byte(fmt.Sprintf(memberKey, o.org, uid))
+		if b.Get(key) == nil {
+			return fmt.Errorf("%s is not a member", uid)
+		}
+
+		err := b.Delete(key)
+		if err != nil {
+			return err
+		}
+
+		err = b.Delete([]byte(fmt.Sprintf(memberKey, uid, o.org)))
+		if err != nil {
+			return err
+		}
+
+		return o.checkManaged(tx)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not OrgStore.RemoveMember: %v", err)
+	}
+
+	return nil
+}
+
+// Put stores the given value under the given kind and key in the
+// organization's data bucket.
+func (o OrgStore) Put(kind, key string, value []byte) error {
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		b, err := o.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(fmt.Sprintf(orgDataKey, kind, key)), value)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not OrgStore.Put: %v", err)
+	}
+
+	return nil
+}
+
+// Get returns the value stored under the given kind and key in the
+// organization's data bucket. If the key does not exist, Get returns nil.
+func (o OrgStore) Get(kind, key string) []byte {
+	var val []byte
+
+	o.db.View(func(tx *bolt.Tx) error {
+		b, err := o.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		// Values are only valid during the transaction.
+		data := b.Get([]byte(fmt.Sprintf(orgDataKey, kind, key)))
+		if data != nil {
+			val = append([]byte{}, data...)
+		}
+
+		return nil
+	})
+
+	return val
+}
+
+// Delete removes the value stored under the given kind and key in the
+// organization's data bucket.
+func (o OrgStore) Delete(kind, key string) error {
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		b, err := o.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		return b.Delete([]byte(fmt.Sprintf(orgDataKey, kind, key)))
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not OrgStore.Delete: %v", err)
+	}
+
+	return nil
+}
+
+// List returns every value stored under the given kind in the organization's
+// data bucket, in key order.
+func (o OrgStore) List(kind string) ([][]byte, error) {
+	var values [][]byte
+
+	err := o.db.View(func(tx *bolt.Tx) error {
+		b, err := o.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		prefix := []byte(fmt.Sprintf(orgDataKey, kind, ""))
+		c := b.Cursor()
+
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			values = append(values, append([]byte{}, v...))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return values, fmt.Errorf("could not OrgStore.List: %v", err)
+	}
+
+	return values, nil
+}
+
+// bucket returns the data bucket of the organization within the given
+// transaction.
+func (o OrgStore) bucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	b := tx.Bucket([]byte(orgDataBucket)).Bucket([]byte(o.org.String()))
+	if b == nil {
+		return nil, fmt.Errorf("org %s not found", o.org)
+	}
+
+	return b, nil
+}
+
+// checkManaged returns an error if no member of the organization has been
+// given a role that grants management of the organization.
+func (o OrgStore) checkManaged(tx *bolt.Tx) error {
+	members, err := listMemberships(tx.Bucket([]byte(memberBucket)), o.org.String())
+	if err != nil {
+		return err
+	}
+
+	rb := tx.Bucket([]byte(roleBucket))
+
+	for _, m := range members {
+		if permissionsOf(rb, m.Roles)[PermOrgManage] {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("org %s must have a member with %s", o.org, PermOrgManage)
+}
+
+// checkOrgsManaged returns ErrLastManager if one of the organizations of the
+// user identified by uid has no other member who can manage it.
+func checkOrgsManaged(tx *bolt.Tx, uid UserToken) error {
+	b := tx.Bucket([]byte(memberBucket))
+	rb := tx.Bucket([]byte(roleBucket))
+
+	orgs, err := listMemberships(b, uid.String())
+	if err != nil {
+		return err
+	}
+
+	for _, org := range orgs {
+		members, err := listMemberships(b, org.OrgId.String())
+		if err != nil {
+			return err
+		}
+
+		managed := false
+		for _, m := range members {
+			if m.UserId != uid && permissionsOf(rb, m.Roles)[PermOrgManage] {
+				managed = true
+			}
+		}
+
+		if !managed {
+			return fmt.Errorf("%w: org %s", ErrLastManager, org.OrgId)
+		}
+	}
+
+	return nil
+}
+
+// rolesExist returns an error if one of the given roles does not exist.
+func rolesExist(tx *bolt.Tx, roles []string) error {
+	b := tx.Bucket([]byte(roleBucket))
+
+	for _, name := range roles {
+		if b.Get([]byte(name)) == nil {
+			return fmt.Errorf("role %s not found", name)
+		}
+	}
+
+	return nil
+}
+
+// orgRolesOnly returns an error if one of the given roles is not in OrgRoles.
+func orgRolesOnly(roles []string) error {
+	for _, name := range roles {
+		if !IsOrgRole(name) {
+			return fmt.Errorf("role %s cannot be given in an organization", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+var (
+	testOrgName    = "Example Org"
+	testOrgKind    = "note"
+	testOrgDbPath  = "org_test.db"
+	testOrgValue   = "org value"
+	testOrgValue2  = "other org value"
+	testOrgKeyName = "key"
+)
+
+func testStoreOrg(t *testing.T) {
+	fmt.Println(t.Name())
+
+	db := newTestStore(t, testOrgDbPath)
+	defer deleteTestStore(t, testOrgDbPath)
+
+	u1 := NewUser(testUserAlias)
+	u2 := NewUser(testAliasOther)
+	db.CreateUser(u1, testUserPassphrase)
+	db.CreateUser(u2, testUserPassphrase)
+
+	// An organization can only be created by an existing user.
+	_, err := db.CreateOrg(testOrgName, NewUserToken())
+	if err == nil {
+		t.Fatal("Expected", "user not found", ", received", err)
+	}
+
+	org1, err := db.CreateOrg(testOrgName, u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	org2, _ := db.CreateOrg("Another Org", u2.UserId)
+
+	org, err := db.GetOrgById(org1.OrgId.String())
+	if err != nil || org.Name != testOrgName {
+		t.Fatal("Expected", testOrgName, ", received", org.Name, err)
+	}
+
+	// The creator is the owner and can manage the organization.
+	m, err := db.GetMembership(org1.OrgId, u1.UserId)
+	if err != nil || !m.HasRole(RoleOwner) {
+		t.Fatal("Expected", RoleOwner, ", received", m.Roles, err)
+	}
+
+	if !db.GetMemberPermissions(m)[PermOrgManage] {
+		t.Fatal("Expected", true, ", received", false)
+	}
+
+	_, err = db.GetMembership(org1.OrgId, u2.UserId)
+	if err == nil {
+		t.Fatal("Expected", "not a member", ", received", err)
+	}
+
+	// Add a member with per organization roles.
+	o1 := db.Org(org1.OrgId)
+
+	err = o1.AddMember(u2.UserId, []string{"missing"})
+	if err == nil {
+		t.Fatal("Expected", "role not found", ", received", err)
+	}
+
+	// Site wide roles cannot be given within an organization.
+	err = o1.AddMember(u2.UserId, []string{RoleAdmin})
+	if err == nil {
+		t.Fatal("Expected", "cannot be given", ", received", err)
+	}
+
+	err = o1.AddMember(u2.UserId, []string{RoleUser})
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	err = o1.SetMemberRoles(u2.UserId, []string{RoleUser, RoleAdmin})
+	if err == nil {
+		t.Fatal("Expected", "cannot be given", ", received", err)
+	}
+
+	err = o1.AddMember(u2.UserId, []string{RoleUser})
+	if err == nil {
+		t.Fatal("Expected", "already a member", ", received", err)
+	}
+
+	members, _ := o1.Members()
+	if len(members) != 2 {
+		t.Fatal("Expected", 2, ", received", len(members))
+	}
+
+	orgs, _ := db.GetUserOrgs(u2.UserId)
+	if len(orgs) != 2 || orgs[0].OrgId != org2.OrgId || orgs[1].OrgId != org1.OrgId {
+		t.Fatal("Expected", []Organization{org2, org1}, ", received", orgs)
+	}
+
+	// The organization must keep a member who can manage it.
+	err = o1.SetMemberRoles(u1.UserId, []string{RoleUser})
+	if err == nil {
+		t.Fatal("Expected", "must have a member", ", received", err)
+	}
+
+	err = o1.RemoveMember(u1.UserId)
+	if err == nil {
+		t.Fatal("Expected", "must have a member", ", received", err)
+	}
+
+	err = o1.SetMemberRoles(u2.UserId, []string{RoleOwner})
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	err = o1.RemoveMember(u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	orgs, _ = db.GetUserOrgs(u1.UserId)
+	if len(orgs) != 0 {
+		t.Fatal("Expected", 0, ", received", len(orgs))
+	}
+
+	// Organization data is kept apart from other organizations.
+	o2 := db.Org(org2.OrgId)
+
+	o1.Put(testOrgKind, testOrgKeyName, []byte(testOrgValue))
+	o2.Put(testOrgKind, testOrgKeyName, []byte(testOrgValue2))
+
+	if string(o1.Get(testOrgKind, testOrgKeyName)) != testOrgValue {
+		t.Fatal("Expected", testOrgValue, ", received", string(o1.Get(testOrgKind, testOrgKeyName)))
+	}
+
+	if string(o2.Get(testOrgKind, testOrgKeyName)) != testOrgValue2 {
+		t.Fatal("Expected", testOrgValue2, ", received", string(o2.Get(testOrgKind, testOrgKeyName)))
+	}
+
+	values, _ := o1.List(testOrgKind)
+	if len(values) != 1 || string(values[0]) != testOrgValue {
+		t.Fatal("Expected", testOrgValue, ", received", values)
+	}
+
+	o1.Delete(testOrgKind, testOrgKeyName)
+
+	if o1.Get(testOrgKind, testOrgKeyName) != nil || o2.Get(testOrgKind, testOrgKeyName) == nil {
+		t.Fatal("Expected only the first organization's value to be deleted")
+	}
+
+	// An OrgStore for an unknown organization cannot store data.
+	err = db.Org(NewOrgToken()).Put(testOrgKind, testOrgKeyName, []byte(testOrgValue))
+	if err == nil {
+		t.Fatal("Expected", "org not found", ", received", err)
+	}
+
+	// The last member who can manage an organization cannot be deleted.
+	err = db.DeleteUser(u2)
+	if !errors.Is(err, ErrLastManager) || !db.UserExists(u2.Alias) {
+		t.Fatal("Expected", ErrLastManager, ", received", err)
+	}
+
+	// Deleting a user removes their memberships.
+	o1.AddMember(u1.UserId, []string{RoleOwner})
+	o2.AddMember(u1.UserId, []string{RoleOwner})
+
+	err = db.DeleteUser(u2)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	members, _ = o2.Members()
+	if len(members) != 1 || members[0].UserId != u1.UserId {
+		t.Fatal("Expected", u1.UserId, ", received", members)
+	}
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+// minPepperSize is the least number of bytes in a pepper key.
+const minPepperSize = 32
+
+// peppers holds the secret keys mixed into passphrases before they are
+// hashed, by key ID, and the ID of the key used for new hashes. No pepper is
+// used while pepperID is empty.
+var (
+	peppers   = make(map[string][]byte)
+	pepperID  string
+	peppersMu sync.RWMutex
+)
+
+// validPepperID returns true if the ID is 1 to 16 letters and digits, so it
+// can be kept in the parameters of a PHC string.
+func validPepperID(id string) bool {
+	if len(id) < 1 || len(id) > 16 {
+		return false
+	}
+
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SetPeppers sets the pepper keys by key ID and the ID of the key used for
+// new hashes, which must be one of them. Hashes record the ID of the key they
+// were made with, so keys can be rotated by adding a new key and making it
+// current. Older keys must be kept until every hash made with them has been
+// replaced, which happens when their users log in. An empty current ID turns
+// the pepper off for new hashes.
+func SetPeppers(keys map[string][]byte, current string) error {
+	for id, key := range keys {
+		if !validPepperID(id) {
+			return fmt.Errorf("could not SetPeppers: invalid key ID %q", id)
+		}
+
+		if len(key) < minPepperSize {
+			return fmt.Errorf("could not SetPeppers: key %s must have at least %d bytes", id, minPepperSize)
+		}
+	}
+
+	if _, ok := keys[current]; current != "" && !ok {
+		return fmt.Errorf("could not SetPeppers: unknown key ID %q", current)
+	}
+
+	copied := make(map[string][]byte)
+	for id, key := range keys {
+		copied[id] = append([]byte(nil), key...)
+	}
+
+	peppersMu.Lock()
+	peppers = copied
+	pepperID = current
+	peppersMu.Unlock()
+
+	return nil
+}
+
+// currentPepper returns the ID and key of the pepper used for new hashes.
+// The ID is empty if no pepper is used.
+func currentPepper() (string, []byte) {
+	peppersMu.RLock()
+	defer peppersMu.RUnlock()
+
+	return pepperID, peppers[pepperID]
+}
+
+// getPepper returns the pepper key with the given ID.
+func getPepper(id string) ([]byte, bool) {
+	peppersMu.RLock()
+	defer peppersMu.RUnlock()
+
+	key, ok := peppers[id]
+
+	return key, ok
+}
+
+// applyPepper returns the HMAC-SHA256 of the passphrase keyed with the
+// pepper, which is hashed in place of the passphrase.
+func applyPepper(pepper []byte, passphrase string) []byte {
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(passphrase))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var testPepperDbPath = "pepper_test.db"
+
+func TestPepper(t *testing.T) {
+	k1 := bytes.Repeat([]byte{1}, minPepperSize)
+	k2 := bytes.Repeat([]byte{2}, minPepperSize)
+
+	defer SetPeppers(nil, "")
+	defer SetHashParams(DefaultHashParams)
+
+	SetHashParams(HashParams{Memory: 8 * 1024, Time: 1, Threads: 1})
+
+	invalid := []struct {
+		keys    map[string][]byte
+		current string
+	}{
+		{map[string][]byte{"k1": k1[:16]}, "k1"},
+		{map[string][]byte{"k-1": k1}, "k-1"},
+		{map[string][]byte{"k1": k1}, "k2"},
+	}
+
+	for _, i := range invalid {
+		if SetPeppers(i.keys, i.current) == nil {
+			t.Fatal("Expected error, received", nil, "for", i.current)
+		}
+	}
+
+	plain, _ := GenerateHash(goodPassword)
+
+	// New hashes record the key ID of the pepper.
+	err := SetPeppers(map[string][]byte{"k1": k1}, "k1")
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	peppered, _ := GenerateHash(goodPassword)
+	if !strings.Contains(peppered, ",keyid=k1$") {
+		t.Fatal("Expected key ID k1, received", peppered)
+	}
+
+	if !VerifyHash(peppered, goodPassword) || VerifyHash(peppered, goodPassword+"x") {
+		t.Fatal("Expected peppered hash to match only", goodPassword)
+	}
+
+	// Hashes without a pepper still verify and are replaced.
+	if !VerifyHash(plain, goodPassword) || !NeedsRehash(plain) || NeedsRehash(peppered) {
+		t.Fatal("Expected unpeppered hash to verify and need a rehash")
+	}
+
+	// The pepper is needed to verify the hash.
+	SetPeppers(nil, "")
+
+	if VerifyHash(peppered, goodPassword) {
+		t.Fatal("Expected peppered hash not to match without its key")
+	}
+
+	// After a rotation hashes with the old key verify until replaced.
+	SetPeppers(map[string][]byte{"k1": k1, "k2": k2}, "k2")
+
+	if !VerifyHash(peppered, goodPassword) || !NeedsRehash(peppered) {
+		t.Fatal("Expected old key to verify and need a rehash")
+	}
+
+	// A login replaces the hash with one using the current key.
+	db := newTestStore(t, testPepperDbPath)
+	defer deleteTestStore(t, testPepperDbPath)
+
+	u1 := NewUser(testUserAlias)
+
+	err = db.ImportUser(u1, peppered)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if ok, _ := db.AuthenticateUser(u1.UserId, goodPassword); !ok {
+		t.Fatal("Expected password to match:", goodPassword)
+	}
+
+	hash := string(db.read(userBucket, fmt.Sprintf(hashKey, u1.UserId.String())))
+	if !strings.Contains(hash, ",keyid=k2$") {
+		t.Fatal("Expected key ID k2, received", hash)
+	}
+
+	db.Close()
+}
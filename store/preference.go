@@ -0,0 +1,145 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	prefKey    = "%s:%s:%s"
+	prefPrefix = "%s:"
+	prefName   = regexp.MustCompile(`^[a-z][a-z0-9_.-]{0,31}$`)
+)
+
+//----------------------------------------------------------------------------
+// Preferences Struct
+//----------------------------------------------------------------------------
+
+// Preferences holds the preferences of a single user. Each preference is
+// named by a namespace, usually the feature it belongs to, and a key within
+// the namespace. Values are stored as strings and read with the typed
+// getters, which return the given default when the preference is not set or
+// cannot be read as the requested type.
+type Preferences struct {
+	values map[string]string
+}
+
+// lookup returns the value of the preference and whether it is set.
+func (p Preferences) lookup(ns, key string) (string, bool) {
+	v, ok := p.values[ns+":"+key]
+	return v, ok
+}
+
+// String returns the preference as a string or the default.
+func (p Preferences) String(ns, key, def string) string {
+	v, ok := p.lookup(ns, key)
+	if !ok {
+		return def
+	}
+
+	return v
+}
+
+// Int returns the preference as an int or the default.
+func (p Preferences) Int(ns, key string, def int) int {
+	v, ok := p.lookup(ns, key)
+	if !ok {
+		return def
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+
+	return i
+}
+
+// Bool returns the preference as a bool or the default.
+func (p Preferences) Bool(ns, key string, def bool) bool {
+	v, ok := p.lookup(ns, key)
+	if !ok {
+		return def
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def
+	}
+
+	return b
+}
+
+// validPreference returns an error if the namespace or key is not a valid
+// preference name.
+func validPreference(ns, key string) error {
+	if !prefName.MatchString(ns) || !prefName.MatchString(key) {
+		return fmt.Errorf("invalid preference %s:%s", ns, key)
+	}
+
+	return nil
+}
+
+//----------------------------------------------------------------------------
+// Preference Storage Methods
+//----------------------------------------------------------------------------
+
+// SetPreference stores the value of the preference named by the namespace and
+// key for the user identified by uid. Use strconv to format other types.
+func (s *Store) SetPreference(uid UserToken, ns, key, value string) error {
+	err := validPreference(ns, key)
+	if err != nil {
+		return fmt.Errorf("could not Store.SetPreference: %v", err)
+	}
+
+	err = s.write(prefBucket, fmt.Sprintf(prefKey, uid, ns, key), []byte(value))
+	if err != nil {
+		return fmt.Errorf("could not Store.SetPreference: %v", err)
+	}
+
+	return nil
+}
+
+// DeletePreference removes the preference named by the namespace and key for
+// the user identified by uid, so its default is used again.
+func (s *Store) DeletePreference(uid UserToken, ns, key string) error {
+	err := validPreference(ns, key)
+	if err != nil {
+		return fmt.Errorf("could not Store.DeletePreference: %v", err)
+	}
+
+	err = s.delete(prefBucket, fmt.Sprintf(prefKey, uid, ns, key))
+	if err != nil {
+		return fmt.Errorf("could not Store.DeletePreference: %v", err)
+	}
+
+	return nil
+}
+
+// GetPreferences returns every preference of the user identified by uid.
+func (s *Store) GetPreferences(uid UserToken) (Preferences, error) {
+	prefs := Preferences{values: make(map[string]string)}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(prefBucket)).Cursor()
+
+		prefix := []byte(fmt.Sprintf(prefPrefix, uid))
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			name := strings.TrimPrefix(string(k), string(prefix))
+			prefs.values[name] = string(v)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return prefs, fmt.Errorf("could not Store.GetPreferences: %v", err)
+	}
+
+	return prefs, nil
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+var (
+	testPrefDbPath = "pref_test.db"
+)
+
+func testStorePreference(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	u2 := NewUser(testAliasOther)
+	db := newTestStore(t, testPrefDbPath)
+	defer deleteTestStore(t, testPrefDbPath)
+
+	db.CreateUser(u1, testUserPassphrase)
+	db.CreateUser(u2, testUserPassphrase)
+
+	// Defaults are returned for preferences that are not set.
+	prefs, err := db.GetPreferences(u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if prefs.String("ui", "theme", "light") != "light" || prefs.Int("ui", "size", 25) != 25 || !prefs.Bool("ui", "notify", true) {
+		t.Fatal("Expected defaults, received", prefs)
+	}
+
+	// Set Preferences
+	values := map[string]string{"theme": "dark", "size": "50", "notify": "false", "broken": "ten"}
+	for k, v := range values {
+		err = db.SetPreference(u1.UserId, "ui", k, v)
+		if err != nil {
+			t.Fatal("Expected", nil, ", received", err)
+		}
+	}
+
+	db.SetPreference(u1.UserId, "other", "theme", "blue")
+	db.SetPreference(u2.UserId, "ui", "theme", "light")
+
+	prefs, _ = db.GetPreferences(u1.UserId)
+	if prefs.String("ui", "theme", "light") != "dark" || prefs.String("other", "theme", "") != "blue" {
+		t.Fatal("Expected dark and blue, received", prefs)
+	}
+
+	if prefs.Int("ui", "size", 25) != 50 || prefs.Bool("ui", "notify", true) {
+		t.Fatal("Expected 50 and false, received", prefs)
+	}
+
+	// Values that cannot be read as the type give the default.
+	if prefs.Int("ui", "broken", 10) != 10 || !prefs.Bool("ui", "broken", true) {
+		t.Fatal("Expected defaults, received", prefs)
+	}
+
+	// Invalid names are rejected.
+	for _, name := range [][2]string{{"", "theme"}, {"ui", ""}, {"UI", "theme"}, {"ui", "a:b"}} {
+		err = db.SetPreference(u1.UserId, name[0], name[1], "x")
+		if err == nil {
+			t.Fatal("Expected error for", name, ", received nil")
+		}
+	}
+
+	// Delete Preference
+	err = db.DeletePreference(u1.UserId, "ui", "theme")
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	prefs, _ = db.GetPreferences(u1.UserId)
+	if prefs.String("ui", "theme", "light") != "light" {
+		t.Fatal("Expected", "light", ", received", prefs.String("ui", "theme", "light"))
+	}
+
+	// Preferences are deleted with the user.
+	db.DeleteUser(u1)
+
+	prefs, _ = db.GetPreferences(u1.UserId)
+	if len(prefs.values) != 0 {
+		t.Fatal("Expected", 0, ", received", len(prefs.values))
+	}
+
+	prefs, _ = db.GetPreferences(u2.UserId)
+	if prefs.String("ui", "theme", "dark") != "light" {
+		t.Fatal("Expected", "light", ", received", prefs.String("ui", "theme", "dark"))
+	}
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//----------------------------------------------------------------------------
+// Rate Bucket Struct
+//----------------------------------------------------------------------------
+
+// RateBucket holds the state of a rate limit token bucket: the tokens left,
+// the Unix time in nanoseconds they were counted at, and the Unix time in
+// nanoseconds the bucket is full again.
+type RateBucket struct {
+	Tokens  float64 `json:"tokens"`
+	Updated int64   `json:"updated"`
+	Full    int64   `json:"full"`
+}
+
+//----------------------------------------------------------------------------
+// Rate Bucket Storage Methods
+//----------------------------------------------------------------------------
+
+// SaveRateBuckets replaces the stored rate limit buckets with the given ones
+// by name, so the limits still apply after a restart.
+func (s *Store) SaveRateBuckets(buckets map[string]RateBucket) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(rateBucket))
+
+		err := deleteMatching(b, func(k, v []byte) bool {
+			return true
+		})
+		if err != nil {
+			return err
+		}
+
+		for name, rb := range buckets {
+			data, err := json.Marshal(rb)
+			if err != nil {
+				return err
+			}
+
+			err = b.Put([]byte(name), data)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.SaveRateBuckets: %v", err)
+	}
+
+	return nil
+}
+
+// GetRateBuckets returns the rate limit buckets saved with SaveRateBuckets
+// by name.
+func (s *Store) GetRateBuckets() (map[string]RateBucket, error) {
+	buckets := make(map[string]RateBucket)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(rateBucket))
+
+		return b.ForEach(func(k, v []byte) error {
+			var rb RateBucket
+
+			err := json.Unmarshal(v, &rb)
+			if err != nil {
+				return err
+			}
+
+			buckets[string(k)] = rb
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return buckets, fmt.Errorf("could not Store.GetRateBuckets: %v", err)
+	}
+
+	return buckets, nil
+}
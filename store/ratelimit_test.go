@@ -0,0 +1,50 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+var (
+	testRateDbPath = "ratelimit_test.db"
+)
+
+func testStoreRateBuckets(t *testing.T) {
+	fmt.Println(t.Name())
+
+	db := newTestStore(t, testRateDbPath)
+	defer deleteTestStore(t, testRateDbPath)
+
+	// No buckets are saved at first.
+	buckets, err := db.GetRateBuckets()
+	if err != nil || len(buckets) != 0 {
+		t.Fatal("Expected no buckets, received", buckets, err)
+	}
+
+	// Saved buckets are returned.
+	saved := map[string]RateBucket{
+		"/account/login|ip|192.0.2.1": {Tokens: 2.5, Updated: 1000},
+		"/account/forgot|account|bob": {Tokens: 0, Updated: 2000},
+	}
+
+	err = db.SaveRateBuckets(saved)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	buckets, _ = db.GetRateBuckets()
+	if len(buckets) != 2 || buckets["/account/login|ip|192.0.2.1"] != saved["/account/login|ip|192.0.2.1"] {
+		t.Fatal("Expected", saved, ", received", buckets)
+	}
+
+	// Saving replaces the buckets saved before.
+	err = db.SaveRateBuckets(map[string]RateBucket{"/account/reset|ip|192.0.2.2": {Tokens: 1, Updated: 3000}})
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	buckets, _ = db.GetRateBuckets()
+	if _, ok := buckets["/account/login|ip|192.0.2.1"]; ok || len(buckets) != 1 {
+		t.Fatal("Expected one bucket, received", buckets)
+	}
+}
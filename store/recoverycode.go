@@ -0,0 +1,237 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	RecoveryCodeCount = 10
+	recoveryCodeSize  = 12 // base32 characters, 60 bits
+	recoveryIndexSize = 2  // leading characters stored in the clear
+)
+
+var (
+	recoveryCodeKey = "%s:recovery"
+)
+
+//----------------------------------------------------------------------------
+// Recovery Code Helpers
+//----------------------------------------------------------------------------
+
+// newRecoveryCode generates a random recovery code in the form
+// XXXXXX-XXXXXX. The first recoveryIndexSize characters are the index of the
+// code and the other 50 bits are secret.
+func newRecoveryCode() string {
+	bytes := newTokenBytes()
+	code := tokenEncoder.EncodeToString(bytes[:])[:recoveryCodeSize]
+
+	return code[:recoveryCodeSize/2] + "-" + code[recoveryCodeSize/2:]
+}
+
+// normalizeRecoveryCode removes the separators users may type in a recovery
+// code and upper cases it.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// IsRecoveryCode returns true if the code has the form of a recovery code
+// rather than a code from an authenticator app.
+func IsRecoveryCode(code string) bool {
+	return len(normalizeRecoveryCode(code)) == recoveryCodeSize
+}
+
+// getRecoveryHashes returns the hashes of the recovery codes of the user
+// identified by uid using the given user bucket, each prefixed with the index
+// of its code. Used codes have an empty hash.
+func getRecoveryHashes(b *bolt.Bucket, uid UserToken) ([]string, error) {
+	var hashes []string
+
+	data := b.Get([]byte(fmt.Sprintf(recoveryCodeKey, uid)))
+	if data == nil {
+		return hashes, nil
+	}
+
+	err := json.Unmarshal(data, &hashes)
+
+	return hashes, err
+}
+
+//----------------------------------------------------------------------------
+// Recovery Code Storage Methods
+//----------------------------------------------------------------------------
+
+// GenerateRecoveryCodes creates a new set of recovery codes for the user
+// identified by uid, replacing any earlier set. Only the index and an
+// Argon2id hash of each code are kept, so the returned codes must be shown to
+// the user now. The indexes in a set are unique. The change is recorded in
+// the user's activity.
+func (s *Store) GenerateRecoveryCodes(uid UserToken) ([]string, error) {
+	var codes, hashes []string
+
+	indexes := make(map[string]bool)
+
+	for len(codes) < RecoveryCodeCount {
+		code := newRecoveryCode()
+		index := code[:recoveryIndexSize]
+
+		if indexes[index] {
+			continue
+		}
+
+		hash, err := GenerateHash(normalizeRecoveryCode(code))
+		if err != nil {
+			return nil, fmt.Errorf("could not Store.GenerateRecoveryCodes: %w", err)
+		}
+
+		indexes[index] = true
+		codes = append(codes, code)
+		hashes = append(hashes, index+hash)
+	}
+
+	data, err := json.Marshal(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("could not Store.GenerateRecoveryCodes: %v", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
+
+		if b.Get([]byte(uid.String())) == nil {
+			return fmt.Errorf("user %s not found", uid)
+		}
+
+		err := b.Put([]byte(fmt.Sprintf(recoveryCodeKey, uid)), data)
+		if err != nil {
+			return err
+		}
+
+		return addActivity(tx, uid, ActivityRecoveryCodesCreated, "")
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("could not Store.GenerateRecoveryCodes: %v", err)
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode checks the code against the unused recovery codes of the
+// user identified by uid and marks the matching code used, so each code
+// works once. Only the hash with the same index as the code is checked, so a
+// guess costs one Argon2id derivation. The use is recorded in the user's
+// activity.
+func (s *Store) UseRecoveryCode(uid UserToken, code string) error {
+	var hashes []string
+
+	code = normalizeRecoveryCode(code)
+	if len(code) != recoveryCodeSize {
+		return fmt.Errorf("could not Store.UseRecoveryCode: invalid code")
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+
+		hashes, err = getRecoveryHashes(tx.Bucket([]byte(userBucket)), uid)
+
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.UseRecoveryCode: %v", err)
+	}
+
+	// The hashes are checked outside of a transaction since Argon2id is
+	// slow. The indexes in a set are unique, so only the one hash stored
+	// after the index of the code is checked.
+	match := ""
+	for _, entry := range hashes {
+		if !strings.HasPrefix(entry, code[:recoveryIndexSize]) {
+			continue
+		}
+
+		ok, err := CheckHash(entry[recoveryIndexSize:], code)
+		if err != nil {
+			return fmt.Errorf("could not Store.UseRecoveryCode: %w", err)
+		}
+
+		if ok {
+			match = entry
+		}
+
+		break
+	}
+
+	if match == "" {
+		return fmt.Errorf("could not Store.UseRecoveryCode: invalid code")
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
+
+		current, err := getRecoveryHashes(b, uid)
+		if err != nil {
+			return err
+		}
+
+		// The code may have been used, or the set replaced, since the
+		// hashes were read.
+		left, used := 0, false
+		for i, entry := range current {
+			if entry == match {
+				current[i], used = "", true
+			}
+
+			if current[i] != "" {
+				left++
+			}
+		}
+
+		if !used {
+			return fmt.Errorf("invalid code")
+		}
+
+		data, err := json.Marshal(current)
+		if err != nil {
+			return err
+		}
+
+		err = b.Put([]byte(fmt.Sprintf(recoveryCodeKey, uid)), data)
+		if err != nil {
+			return err
+		}
+
+		return addActivity(tx, uid, ActivityRecoveryCodeUsed, fmt.Sprintf("%d codes left", left))
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.UseRecoveryCode: %v", err)
+	}
+
+	return nil
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes of the user
+// identified by uid.
+func (s *Store) RecoveryCodesLeft(uid UserToken) int {
+	var left int
+
+	s.db.View(func(tx *bolt.Tx) error {
+		hashes, err := getRecoveryHashes(tx.Bucket([]byte(userBucket)), uid)
+
+		for _, hash := range hashes {
+			if hash != "" {
+				left++
+			}
+		}
+
+		return err
+	})
+
+	return left
+}
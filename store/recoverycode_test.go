@@ -0,0 +1,95 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var (
+	testRecoveryCodeDbPath = "recoverycode_test.db"
+)
+
+func testStoreRecoveryCode(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	db := newTestStore(t, testRecoveryCodeDbPath)
+	defer deleteTestStore(t, testRecoveryCodeDbPath)
+
+	db.CreateUser(u1, testAuthGoodPassword)
+
+	codes, err := db.GenerateRecoveryCodes(u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if len(codes) != RecoveryCodeCount || db.RecoveryCodesLeft(u1.UserId) != RecoveryCodeCount {
+		t.Fatal("Expected", RecoveryCodeCount, "codes, received", len(codes))
+	}
+
+	// Only hashes of the codes are stored.
+	data := db.read(userBucket, fmt.Sprintf(recoveryCodeKey, u1.UserId))
+	if strings.Contains(string(data), normalizeRecoveryCode(codes[0])) || !strings.Contains(string(data), "$argon2id$") {
+		t.Fatal("Expected hashed codes, received", string(data))
+	}
+
+	// Each hash is stored after the index of its code, and the indexes in
+	// a set are unique.
+	var entries []string
+	json.Unmarshal(data, &entries)
+
+	indexes := make(map[string]bool)
+	for i, entry := range entries {
+		index := entry[:recoveryIndexSize]
+		if index != normalizeRecoveryCode(codes[i])[:recoveryIndexSize] || indexes[index] || entry[recoveryIndexSize] != '$' {
+			t.Fatal("Expected a unique index for", codes[i], ", received", entry)
+		}
+
+		indexes[index] = true
+	}
+
+	// Codes are recognized in any case and with or without separators.
+	code := strings.ToLower(strings.ReplaceAll(codes[0], "-", " "))
+	if !IsRecoveryCode(code) || IsRecoveryCode("123456") || IsRecoveryCode("abcde-23456") {
+		t.Fatal("Expected", code, "to be a recovery code")
+	}
+
+	err = db.UseRecoveryCode(u1.UserId, code)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// Each code works once.
+	err = db.UseRecoveryCode(u1.UserId, codes[0])
+	if err == nil {
+		t.Fatal("Expected error for used code, received", nil)
+	}
+
+	if db.RecoveryCodesLeft(u1.UserId) != RecoveryCodeCount-1 {
+		t.Fatal("Expected", RecoveryCodeCount-1, ", received", db.RecoveryCodesLeft(u1.UserId))
+	}
+
+	activity, _ := db.GetActivity(u1.UserId)
+	last := activity[len(activity)-1]
+	if last.Action != ActivityRecoveryCodeUsed || last.Detail != "9 codes left" {
+		t.Fatal("Expected", ActivityRecoveryCodeUsed, ", received", last)
+	}
+
+	// Generating a new set invalidates the old one.
+	db.GenerateRecoveryCodes(u1.UserId)
+
+	err = db.UseRecoveryCode(u1.UserId, codes[1])
+	if err == nil {
+		t.Fatal("Expected error for replaced code, received", nil)
+	}
+
+	// Disabling TOTP removes the codes.
+	db.DisableTOTP(u1.UserId, "")
+	if db.RecoveryCodesLeft(u1.UserId) != 0 {
+		t.Fatal("Expected", 0, ", received", db.RecoveryCodesLeft(u1.UserId))
+	}
+
+	db.Close()
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//----------------------------------------------------------------------------
+// Password Reset Struct
+//----------------------------------------------------------------------------
+
+// passwordReset holds a pending password reset for a user. Resets issued by
+// an admin as account recovery links record the admin's user id.
+type passwordReset struct {
+	UserId     UserToken `json:"user_id"`
+	IssuedBy   UserToken `json:"issued_by"`
+	Expiration int64     `json:"expire"`
+}
+
+//----------------------------------------------------------------------------
+// Password Reset Storage Methods
+//----------------------------------------------------------------------------
+
+// CreatePasswordReset creates a password reset for the user identified by
+// uid. The returned ResetToken is valid for the given number of seconds and
+// can only be used once. Only a hash of the token is kept in the Store.
+func (s *Store) CreatePasswordReset(uid UserToken, length int64) (ResetToken, error) {
+	token, err := s.createPasswordReset(uid, UserToken{}, length)
+	if err != nil {
+		return token, fmt.Errorf("could not Store.CreatePasswordReset: %v", err)
+	}
+
+	return token, nil
+}
+
+// CreateAccountRecovery creates a password reset for the user identified by
+// uid on behalf of the admin identified by by. It works like
+// CreatePasswordReset, but the issue and use of the reset are recorded in the
+// user's activity.
+func (s *Store) CreateAccountRecovery(uid, by UserToken, length int64) (ResetToken, error) {
+	token, err := s.createPasswordReset(uid, by, length)
+	if err != nil {
+		return token, fmt.Errorf("could not Store.CreateAccountRecovery: %v", err)
+	}
+
+	return token, nil
+}
+
+// createPasswordReset creates a password reset for the user identified by uid
+// that was issued by the user identified by by. A zero by token means the
+// user requested the reset. A transaction is used to store the reset and
+// record the issue of account recovery resets together.
+func (s *Store) createPasswordReset(uid, by UserToken, length int64) (ResetToken, error) {
+	token := NewResetToken()
+
+	pr := passwordReset{
+		UserId:     uid,
+		IssuedBy:   by,
+		Expiration: time.Now().Unix() + length,
+	}
+
+	data, err := json.Marshal(pr)
+	if err != nil {
+		return token, err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
+
+		if b.Get([]byte(uid.String())) == nil {
+			return fmt.Errorf("user %s not found", uid)
+		}
+
+		err := tx.Bucket([]byte(resetBucket)).Put([]byte(hashToken(token.String())), data)
+		if err != nil {
+			return err
+		}
+
+		if by == (UserToken{}) {
+			return nil
+		}
+
+		detail := fmt.Sprintf("issued by %s", aliasOf(b, by))
+
+		return addActivity(tx, uid, ActivityRecoveryIssued, detail)
+	})
+
+	return token, err
+}
+
+// PasswordResetValid returns true if the given reset token string can be used
+// to reset a password.
+func (s *Store) PasswordResetValid(token string) bool {
+	_, err := s.GetPasswordResetUser(token)
+
+	return err == nil
+}
+
+// GetPasswordResetUser returns the id of the user whose password the given
+// reset token string resets. An error is returned if the token cannot be
+// used.
+func (s *Store) GetPasswordResetUser(token string) (UserToken, error) {
+	var pr passwordReset
+
+	rt, err := parseResetToken(token)
+	if err != nil {
+		return pr.UserId, fmt.Errorf("could not Store.GetPasswordResetUser: %v", err)
+	}
+
+	data := s.read(resetBucket, hashToken(rt.String()))
+	if data == nil {
+		return pr.UserId, fmt.Errorf("could not Store.GetPasswordResetUser: reset not found")
+	}
+
+	err = json.Unmarshal(data, &pr)
+	if err != nil {
+		return pr.UserId, fmt.Errorf("could not Store.GetPasswordResetUser: %v", err)
+	}
+
+	if time.Now().Unix() > pr.Expiration {
+		return pr.UserId, fmt.Errorf("could not Store.GetPasswordResetUser: reset expired")
+	}
+
+	return pr.UserId, nil
+}
+
+// ResetUserPassword takes a reset token string and sets the passphrase of the
+// associated user. A transaction is used to remove every pending reset for
+// the user, store the new passphrase hash, clear any required passphrase
+// reset, reset the failed authentication count and lockout, and remove the
+// user's passkeys together. The reset is recorded in the user's activity. The
+// id of the user is returned so their sessions can be revoked.
+func (s *Store) ResetUserPassword(token, passphrase string) (UserToken, error) {
+	var uid UserToken
+
+	rt, err := parseResetToken(token)
+	if err != nil {
+		return uid, fmt.Errorf("could not Store.ResetUserPassword: %v", err)
+	}
+
+	hash, err := GenerateHash(passphrase)
+	if err != nil {
+		return uid, fmt.Errorf("could not Store.ResetUserPassword: %w", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		var pr passwordReset
+
+		rb := tx.Bucket([]byte(resetBucket))
+		b := tx.Bucket([]byte(userBucket))
+
+		data := rb.Get([]byte(hashToken(rt.String())))
+		if data == nil {
+			return fmt.Errorf("reset not found")
+		}
+
+		err := json.Unmarshal(data, &pr)
+		if err != nil {
+			return err
+		}
+
+		if time.Now().Unix() > pr.Expiration {
+			return fmt.Errorf("reset expired")
+		}
+
+		if b.Get([]byte(pr.UserId.String())) == nil {
+			return fmt.Errorf("user %s not found", pr.UserId)
+		}
+
+		// Remove this and any other pending resets for the user.
+		err = deleteMatching(rb, func(k, v []byte) bool {
+			return ownedBy(v, pr.UserId)
+		})
+		if err != nil {
+			return err
+		}
+
+		key := fmt.Sprintf(hashKey, pr.UserId.String())
+		err = b.Put([]byte(key), []byte(hash))
+		if err != nil {
+			return err
+		}
+
+		err = resetLockout(b, pr.UserId)
+		if err != nil {
+			return err
+		}
+
+		err = clearResetRequired(b, pr.UserId)
+		if err != nil {
+			return err
+		}
+
+		err = deleteUserCredentials(tx, pr.UserId, "on reset")
+		if err != nil {
+			return err
+		}
+
+		uid = pr.UserId
+
+		if pr.IssuedBy == (UserToken{}) {
+			return addActivity(tx, uid, ActivityPasswordReset, "")
+		}
+
+		detail := fmt.Sprintf("issued by %s", aliasOf(b, pr.IssuedBy))
+
+		return addActivity(tx, uid, ActivityRecoveryUsed, detail)
+	})
+
+	if err != nil {
+		return uid, fmt.Errorf("could not Store.ResetUserPassword: %v", err)
+	}
+
+	return uid, nil
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+var (
+	testResetPassphrase = "resetpassphrase123"
+	testResetDbPath     = "reset_test.db"
+)
+
+func testStoreReset(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	db := newTestStore(t, testResetDbPath)
+	defer deleteTestStore(t, testResetDbPath)
+
+	// A reset cannot be created for an unknown user.
+	_, err := db.CreatePasswordReset(u1.UserId, 60)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	db.CreateUser(u1, testUserPassphrase)
+	db.IncrementFailedAuthCount(u1.UserId)
+
+	token, err := db.CreatePasswordReset(u1.UserId, 60)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	other, _ := db.CreatePasswordReset(u1.UserId, 60)
+
+	if !db.PasswordResetValid(token.String()) {
+		t.Fatal("Expected valid reset, but it is not.")
+	}
+
+	if db.PasswordResetValid(NewResetToken().String()) {
+		t.Fatal("Expected invalid reset, but it is valid.")
+	}
+
+	ruid, err := db.GetPasswordResetUser(token.String())
+	if err != nil || ruid != u1.UserId {
+		t.Fatal("Expected", u1.UserId, ", received", ruid, err)
+	}
+
+	// Reset the password.
+	uid, err := db.ResetUserPassword(token.String(), testResetPassphrase)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if uid != u1.UserId {
+		t.Fatal("Expected", u1.UserId, ", received", uid)
+	}
+
+	if ok, _ := db.AuthenticateUser(u1.UserId, testResetPassphrase); !ok {
+		t.Fatal("Expected password to match:", testResetPassphrase)
+	}
+
+	count, _ := db.GetFailedAuthCount(u1.UserId)
+	if count != 0 {
+		t.Fatal("Expected", 0, ", received", count)
+	}
+
+	// The reset and any other pending resets can no longer be used.
+	_, err = db.ResetUserPassword(token.String(), testUserPassphrase)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	if db.PasswordResetValid(other.String()) {
+		t.Fatal("Expected invalid reset, but it is valid.")
+	}
+
+	// An expired reset cannot be used.
+	token, _ = db.CreatePasswordReset(u1.UserId, -1)
+	if db.PasswordResetValid(token.String()) {
+		t.Fatal("Expected invalid reset, but it is valid.")
+	}
+
+	_, err = db.ResetUserPassword(token.String(), testUserPassphrase)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	// The reset was recorded.
+	activities, _ := db.GetActivity(u1.UserId)
+	if len(activities) != 1 || activities[0].Action != ActivityPasswordReset {
+		t.Fatal("Expected", ActivityPasswordReset, ", received", activities)
+	}
+
+	db.Close()
+}
+
+func testStoreRecovery(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	admin := NewUser("admin")
+	db := newTestStore(t, testResetDbPath)
+	defer deleteTestStore(t, testResetDbPath)
+
+	db.CreateUser(u1, testUserPassphrase)
+	db.CreateUser(admin, testUserPassphrase)
+
+	token, err := db.CreateAccountRecovery(u1.UserId, admin.UserId, 60)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	_, err = db.ResetUserPassword(token.String(), testResetPassphrase)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if ok, _ := db.AuthenticateUser(u1.UserId, testResetPassphrase); !ok {
+		t.Fatal("Expected password to match:", testResetPassphrase)
+	}
+
+	// A recovery link can only be used once.
+	_, err = db.ResetUserPassword(token.String(), testUserPassphrase)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	// The issue and use of the recovery link were recorded.
+	activities, _ := db.GetActivity(u1.UserId)
+	if len(activities) != 2 {
+		t.Fatal("Expected", 2, ", received", len(activities))
+	}
+
+	if activities[0].Action != ActivityRecoveryIssued || activities[0].Detail != "issued by admin" {
+		t.Fatal("Expected", ActivityRecoveryIssued, ", received", activities[0])
+	}
+
+	if activities[1].Action != ActivityRecoveryUsed || activities[1].Detail != "issued by admin" {
+		t.Fatal("Expected", ActivityRecoveryUsed, ", received", activities[1])
+	}
+
+	db.Close()
+}
@@ -0,0 +1,363 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Roles seeded in every Store.
+const (
+	RoleAdmin = "admin"
+	RoleOwner = "owner"
+	RoleUser  = "user"
+)
+
+// Permissions checked by the application.
+const (
+	PermAdminAccess   = "admin.access"
+	PermUsersView     = "users.view"
+	PermUsersManage   = "users.manage"
+	PermRolesManage   = "roles.manage"
+	PermOrgManage     = "org.manage"
+	PermInvitesManage = "invites.manage"
+)
+
+var (
+	// Permissions holds every permission that can be granted to a role.
+	Permissions = []string{
+		PermAdminAccess,
+		PermUsersView,
+		PermUsersManage,
+		PermRolesManage,
+		PermOrgManage,
+		PermInvitesManage,
+	}
+
+	// OrgRoles holds the roles that can be given to members of an
+	// organization. Site wide roles, such as admin, cannot be given within an
+	// organization.
+	OrgRoles = []string{RoleOwner, RoleUser}
+
+	// roleName restricts role names to simple identifiers.
+	roleName = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,31}$`)
+)
+
+//----------------------------------------------------------------------------
+// Role Struct
+//----------------------------------------------------------------------------
+
+// Role holds a named set of permissions that can be assigned to users.
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// Has returns true if the role grants the given permission.
+func (r Role) Has(perm string) bool {
+	for _, p := range r.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bytes converts a Role object to a JSON byte array.
+func (r *Role) bytes() ([]byte, error) {
+	var b []byte
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return b, fmt.Errorf("could not Role.bytes: %v", err)
+	}
+
+	return b, nil
+}
+
+// NewRole creates a new Role with the given name and permissions. An error is
+// returned if the name is not valid or a permission is unknown.
+func NewRole(name string, perms []string) (Role, error) {
+	var r Role
+
+	if !roleName.MatchString(name) {
+		return r, fmt.Errorf("could not NewRole: invalid name %s", name)
+	}
+
+	for _, p := range perms {
+		if !validPermission(p) {
+			return r, fmt.Errorf("could not NewRole: unknown permission %s", p)
+		}
+	}
+
+	r.Name = name
+	r.Permissions = perms
+
+	return r, nil
+}
+
+// NewRoleFromBytes creates a new Role object from a JSON byte array.
+func NewRoleFromBytes(data []byte) (Role, error) {
+	var role Role
+
+	err := json.Unmarshal(data, &role)
+	if err != nil {
+		return role, fmt.Errorf("could not NewRoleFromBytes: %v", err)
+	}
+
+	return role, nil
+}
+
+// permissionsOf returns the set of permissions granted by the given roles
+// using the given role bucket. Roles that do not exist grant nothing.
+func permissionsOf(b *bolt.Bucket, roles []string) map[string]bool {
+	perms := make(map[string]bool)
+
+	for _, name := range roles {
+		data := b.Get([]byte(name))
+		if data == nil {
+			continue
+		}
+
+		role, err := NewRoleFromBytes(data)
+		if err != nil {
+			continue
+		}
+
+		for _, p := range role.Permissions {
+			perms[p] = true
+		}
+	}
+
+	return perms
+}
+
+// validPermission returns true if the given permission is known.
+func validPermission(perm string) bool {
+	for _, p := range Permissions {
+		if p == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsOrgRole returns true if the given role can be given to members of an
+// organization.
+func IsOrgRole(name string) bool {
+	for _, r := range OrgRoles {
+		if r == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+//----------------------------------------------------------------------------
+// Role Storage Methods
+//----------------------------------------------------------------------------
+
+// CreateRole takes a Role and creates it in the Store. An error is returned if
+// a role with the same name exists.
+func (s *Store) CreateRole(r Role) error {
+	if s.read(roleBucket, r.Name) != nil {
+		return fmt.Errorf("could not Store.CreateRole: role %s exists", r.Name)
+	}
+
+	return s.SaveRole(r)
+}
+
+// SaveRole takes a Role and creates or updates it in the Store.
+func (s *Store) SaveRole(r Role) error {
+	data, err := r.bytes()
+	if err != nil {
+		return fmt.Errorf("could not Store.SaveRole: %v", err)
+	}
+
+	return s.write(roleBucket, r.Name, data)
+}
+
+// GetRole takes a role name and returns the Role associated with it.
+func (s *Store) GetRole(name string) (Role, error) {
+	var role Role
+
+	data := s.read(roleBucket, name)
+	if data == nil {
+		return role, fmt.Errorf("could not Store.GetRole: role %s not found", name)
+	}
+
+	role, err := NewRoleFromBytes(data)
+	if err != nil {
+		return role, fmt.Errorf("could not Store.GetRole: %v", err)
+	}
+
+	return role, nil
+}
+
+// ListRoles returns every Role in the Store sorted by name.
+func (s *Store) ListRoles() ([]Role, error) {
+	var roles []Role
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(roleBucket)).ForEach(func(k, v []byte) error {
+			role, err := NewRoleFromBytes(v)
+			if err != nil {
+				return err
+			}
+
+			roles = append(roles, role)
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return roles, fmt.Errorf("could not Store.ListRoles: %v", err)
+	}
+
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Name < roles[j].Name
+	})
+
+	return roles, nil
+}
+
+// SetUserRoles assigns the given roles to the user identified by uid, replacing
+// any roles the user had. An error is returned if a role does not exist.
+func (s *Store) SetUserRoles(uid UserToken, roles []string) error {
+	for _, name := range roles {
+		if s.read(roleBucket, name) == nil {
+			return fmt.Errorf("could not Store.SetUserRoles: role %s not found", name)
+		}
+	}
+
+	// A user without roles is stored with an empty list so they are not
+	// mistaken for a user stored before roles existed.
+	if roles == nil {
+		roles = []string{}
+	}
+
+	err := s.updateUser(uid, func(u *User) {
+		u.Roles = roles
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.SetUserRoles: %v", err)
+	}
+
+	return nil
+}
+
+// GetPermissions returns the set of permissions granted to the given user by
+// their roles. Roles that no longer exist grant nothing.
+func (s *Store) GetPermissions(u User) map[string]bool {
+	var perms map[string]bool
+
+	s.db.View(func(tx *bolt.Tx) error {
+		perms = permissionsOf(tx.Bucket([]byte(roleBucket)), u.Roles)
+
+		return nil
+	})
+
+	return perms
+}
+
+// HasPermission returns true if the given user is granted the given
+// permission by one of their roles.
+func (s *Store) HasPermission(u User, perm string) bool {
+	return s.GetPermissions(u)[perm]
+}
+
+//----------------------------------------------------------------------------
+// Role Initialization
+//----------------------------------------------------------------------------
+
+// seedRoles creates the admin, owner, and user roles if they do not exist.
+// The admin role is granted every permission and the owner role is granted
+// management of an organization. The admin role is saved every time so it is
+// granted permissions added after it was created.
+func (s *Store) seedRoles() error {
+	seeds := []Role{
+		{Name: RoleAdmin, Permissions: Permissions},
+		{Name: RoleOwner, Permissions: []string{PermOrgManage}},
+		{Name: RoleUser, Permissions: []string{}},
+	}
+
+	for _, r := range seeds {
+		if r.Name != RoleAdmin && s.read(roleBucket, r.Name) != nil {
+			continue
+		}
+
+		err := s.SaveRole(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateAdminUsers gives roles to users stored before roles existed. Every
+// such user is given the user role, and users with the old admin flag are
+// also given the admin role.
+func (s *Store) migrateAdminUsers() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
+		updates := make(map[string][]byte)
+
+		err := b.ForEach(func(k, v []byte) error {
+			var legacy struct {
+				Admin bool     `json:"admin"`
+				Roles []string `json:"roles"`
+			}
+
+			_, err := parseUserToken(string(k))
+			if err != nil {
+				return nil
+			}
+
+			err = json.Unmarshal(v, &legacy)
+			if err != nil || legacy.Roles != nil {
+				return err
+			}
+
+			user, err := NewUserFromBytes(v)
+			if err != nil {
+				return err
+			}
+
+			user.Roles = []string{RoleUser}
+			if legacy.Admin {
+				user.Roles = append(user.Roles, RoleAdmin)
+			}
+
+			data, err := user.bytes()
+			if err != nil {
+				return err
+			}
+
+			updates[string(k)] = data
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Keys cannot be updated while iterating over the bucket.
+		for k, v := range updates {
+			err = b.Put([]byte(k), v)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
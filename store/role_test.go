@@ -0,0 +1,150 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+var (
+	testRoleName   = "auditor"
+	testRoleDbPath = "role_test.db"
+)
+
+func testStoreRole(t *testing.T) {
+	fmt.Println(t.Name())
+
+	db := newTestStore(t, testRoleDbPath)
+	defer deleteTestStore(t, testRoleDbPath)
+
+	// The admin, owner, and user roles are seeded.
+	roles, err := db.ListRoles()
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if len(roles) != 3 || roles[0].Name != RoleAdmin || roles[1].Name != RoleOwner || roles[2].Name != RoleUser {
+		t.Fatal("Expected admin, owner, and user roles, received", roles)
+	}
+
+	for _, p := range Permissions {
+		if !roles[0].Has(p) || roles[2].Has(p) {
+			t.Fatal("Expected admin to have", p, ", received", roles)
+		}
+	}
+
+	if len(roles[1].Permissions) != 1 || !roles[1].Has(PermOrgManage) {
+		t.Fatal("Expected", []string{PermOrgManage}, ", received", roles[1].Permissions)
+	}
+
+	// Invalid roles are rejected.
+	_, err = NewRole("Bad Name", nil)
+	if err == nil {
+		t.Fatal("Expected", "invalid name", ", received", err)
+	}
+
+	_, err = NewRole(testRoleName, []string{"unknown.permission"})
+	if err == nil {
+		t.Fatal("Expected", "unknown permission", ", received", err)
+	}
+
+	// Create a role.
+	role, err := NewRole(testRoleName, []string{PermAdminAccess, PermUsersView})
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	err = db.CreateRole(role)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	err = db.CreateRole(role)
+	if err == nil {
+		t.Fatal("Expected", "role exists", ", received", err)
+	}
+
+	// Assign roles and check permissions.
+	u1 := NewUser(testUserAlias)
+	db.CreateUser(u1, testUserPassphrase)
+
+	if db.HasPermission(u1, PermUsersView) {
+		t.Fatal("Expected", false, ", received", true)
+	}
+
+	err = db.SetUserRoles(u1.UserId, []string{RoleUser, "missing"})
+	if err == nil {
+		t.Fatal("Expected", "role not found", ", received", err)
+	}
+
+	err = db.SetUserRoles(u1.UserId, []string{RoleUser, testRoleName})
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	u2, _ := db.GetUser(u1.UserId)
+	if !db.HasPermission(u2, PermUsersView) || db.HasPermission(u2, PermUsersManage) {
+		t.Fatal("Expected", role.Permissions, ", received", db.GetPermissions(u2))
+	}
+
+	// Changing a role changes the permissions of its users.
+	role.Permissions = []string{PermAdminAccess}
+	db.SaveRole(role)
+
+	if db.HasPermission(u2, PermUsersView) {
+		t.Fatal("Expected", false, ", received", true)
+	}
+
+	// Removing every role leaves the user without roles.
+	db.SetUserRoles(u1.UserId, nil)
+	db.Close()
+
+	db = newTestStore(t, testRoleDbPath)
+	defer db.Close()
+
+	u2, _ = db.GetUser(u1.UserId)
+	if len(u2.Roles) != 0 {
+		t.Fatal("Expected", 0, ", received", len(u2.Roles))
+	}
+}
+
+func testStoreRoleMigration(t *testing.T) {
+	fmt.Println(t.Name())
+
+	db := newTestStore(t, testRoleDbPath)
+	defer deleteTestStore(t, testRoleDbPath)
+
+	// Store users the way they were stored before roles existed.
+	u1 := NewUser(testUserAlias)
+	u2 := NewUser(testAliasOther)
+
+	type legacyUser struct {
+		UserId UserToken `json:"user_id"`
+		Alias  string    `json:"alias"`
+		Admin  bool      `json:"admin"`
+	}
+
+	for _, u := range []legacyUser{{u1.UserId, u1.Alias, true}, {u2.UserId, u2.Alias, false}} {
+		data, _ := json.Marshal(u)
+		db.write(userBucket, u.UserId.String(), data)
+	}
+
+	db.Close()
+
+	db = newTestStore(t, testRoleDbPath)
+	defer db.Close()
+
+	admin, _ := db.GetUser(u1.UserId)
+	if !admin.HasRole(RoleAdmin) || !admin.HasRole(RoleUser) {
+		t.Fatal("Expected", []string{RoleUser, RoleAdmin}, ", received", admin.Roles)
+	}
+
+	user, _ := db.GetUser(u2.UserId)
+	if user.HasRole(RoleAdmin) || !user.HasRole(RoleUser) {
+		t.Fatal("Expected", []string{RoleUser}, ", received", user.Roles)
+	}
+
+	if !db.HasPermission(admin, PermUsersManage) {
+		t.Fatal("Expected", true, ", received", false)
+	}
+}
@@ -0,0 +1,328 @@
+package store
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+//----------------------------------------------------------------------------
+// Hash Scheme Registry
+//----------------------------------------------------------------------------
+
+// HashVerifier returns true if the passphrase matches a hash in the format of
+// one hash scheme.
+type HashVerifier func(hash, passphrase string) bool
+
+// HashChecker returns an error if a hash in the format of one hash scheme is
+// malformed or too costly to verify.
+type HashChecker func(hash string) error
+
+// hashSchemes holds the registered HashVerifier of each hash prefix, and
+// hashCheckers the registered HashChecker.
+var (
+	hashSchemes   = make(map[string]HashVerifier)
+	hashCheckers  = make(map[string]HashChecker)
+	hashSchemesMu sync.RWMutex
+)
+
+// RegisterHashScheme verifies hashes starting with the given PHC or modular
+// crypt prefix, such as $2b$, with the given HashVerifier. When several
+// prefixes match a hash the longest is used. Registering a prefix twice
+// replaces the earlier HashVerifier.
+func RegisterHashScheme(prefix string, fn HashVerifier) {
+	hashSchemesMu.Lock()
+	defer hashSchemesMu.Unlock()
+
+	hashSchemes[prefix] = fn
+}
+
+// RegisterHashChecker checks hashes starting with the given prefix with the
+// given HashChecker before they are imported. Schemes without a HashChecker
+// accept any hash with their prefix.
+func RegisterHashChecker(prefix string, fn HashChecker) {
+	hashSchemesMu.Lock()
+	defer hashSchemesMu.Unlock()
+
+	hashCheckers[prefix] = fn
+}
+
+// hashPrefix returns the longest registered prefix of the hash, or false if
+// there is none.
+func hashPrefix(hash string) (string, bool) {
+	longest, found := "", false
+
+	for prefix := range hashSchemes {
+		if strings.HasPrefix(hash, prefix) && (!found || len(prefix) > len(longest)) {
+			longest, found = prefix, true
+		}
+	}
+
+	return longest, found
+}
+
+// hashVerifier returns the HashVerifier registered for the longest prefix of
+// the hash, or nil if there is none.
+func hashVerifier(hash string) HashVerifier {
+	hashSchemesMu.RLock()
+	defer hashSchemesMu.RUnlock()
+
+	prefix, ok := hashPrefix(hash)
+	if !ok {
+		return nil
+	}
+
+	return hashSchemes[prefix]
+}
+
+// KnownHash returns true if a registered hash scheme can verify the hash.
+func KnownHash(hash string) bool {
+	return hashVerifier(hash) != nil
+}
+
+// CheckHashFormat returns an error if no registered hash scheme can verify
+// the hash, or if the HashChecker of its scheme rejects it, such as for
+// parameters too costly to verify.
+func CheckHashFormat(hash string) error {
+	hashSchemesMu.RLock()
+	prefix, ok := hashPrefix(hash)
+	check := hashCheckers[prefix]
+	hashSchemesMu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("unsupported hash scheme")
+	}
+
+	if check == nil {
+		return nil
+	}
+
+	return check(hash)
+}
+
+// VerifyHash verifies a given passphrase generates the given hash using the
+// hash scheme registered for its prefix. New hashes are always Argon2id, and
+// the others are kept so users imported from older systems can log in until
+// their hashes are replaced. A hash that cannot be checked because the
+// hashing queue is full does not match.
+func VerifyHash(hash, passphrase string) bool {
+	ok, _ := CheckHash(hash, passphrase)
+
+	return ok
+}
+
+// CheckHash verifies the passphrase like VerifyHash, but returns ErrHashBusy
+// if the hashing queue is full so callers can tell it from a wrong
+// passphrase.
+func CheckHash(hash, passphrase string) (bool, error) {
+	var ok bool
+
+	verify := hashVerifier(hash)
+	if verify == nil {
+		return false, nil
+	}
+
+	err := runHash(func() {
+		ok = verify(hash, passphrase)
+	})
+
+	return ok, err
+}
+
+// The built in hash schemes.
+func init() {
+	RegisterHashScheme("$argon2id$", verifyArgon2id)
+	RegisterHashScheme("$2a$", verifyBcrypt)
+	RegisterHashScheme("$2b$", verifyBcrypt)
+	RegisterHashScheme("$2y$", verifyBcrypt)
+	RegisterHashScheme("$scrypt$", verifyScrypt)
+	RegisterHashScheme("$pbkdf2-sha256$", verifyPBKDF2SHA256)
+
+	RegisterHashChecker("$argon2id$", checkArgon2id)
+	RegisterHashChecker("$2a$", checkBcrypt)
+	RegisterHashChecker("$2b$", checkBcrypt)
+	RegisterHashChecker("$2y$", checkBcrypt)
+	RegisterHashChecker("$scrypt$", checkScrypt)
+	RegisterHashChecker("$pbkdf2-sha256$", checkPBKDF2SHA256)
+}
+
+//----------------------------------------------------------------------------
+// Legacy Hash Schemes
+//----------------------------------------------------------------------------
+
+// Legacy hashes are checked without the NFKD normalization used for Argon2id,
+// since the systems that made them hashed the passphrase bytes as entered.
+
+// decodeHashBase64 decodes the unpadded base64 used in PHC strings. The
+// adapted base64 of passlib, which uses . in place of +, is accepted too.
+func decodeHashBase64(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.ReplaceAll(s, ".", "+"))
+}
+
+// The largest legacy hash parameters accepted. Verifying a hash costs what
+// its parameters say, so larger ones would let an imported hash tie up the
+// hashing queue or exhaust memory on each login.
+const (
+	maxScryptLogN   = 20
+	maxScryptR      = 32
+	maxScryptP      = 16
+	maxScryptMemory = 256 << 20 // bytes
+	maxPBKDF2Rounds = 2000000
+	maxBcryptCost   = 16
+)
+
+// checkBcrypt returns an error if a bcrypt hash is malformed or its cost is
+// above maxBcryptCost.
+func checkBcrypt(hash string) error {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return err
+	}
+
+	if cost > maxBcryptCost {
+		return fmt.Errorf("bcrypt cost %d is above %d", cost, maxBcryptCost)
+	}
+
+	return nil
+}
+
+// verifyBcrypt verifies a bcrypt hash in modular crypt format, such as
+// $2b$12$<salt and hash>.
+func verifyBcrypt(hash, passphrase string) bool {
+	if checkBcrypt(hash) != nil {
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(passphrase)) == nil
+}
+
+// scryptHash holds the parts of an scrypt hash.
+type scryptHash struct {
+	ln, r, p  int
+	salt, key []byte
+}
+
+// parseScrypt parses an scrypt hash in the PHC format
+// $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<key>. An error is returned if it is
+// malformed or its parameters are above the limits.
+func parseScrypt(hash string) (scryptHash, error) {
+	var sh scryptHash
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return sh, fmt.Errorf("invalid scrypt hash split")
+	}
+
+	_, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &sh.ln, &sh.r, &sh.p)
+	if err != nil {
+		return sh, fmt.Errorf("invalid scrypt parameters")
+	}
+
+	if sh.ln < 1 || sh.ln > maxScryptLogN || sh.r < 1 || sh.r > maxScryptR || sh.p < 1 || sh.p > maxScryptP ||
+		128*sh.r<<sh.ln > maxScryptMemory {
+		return sh, fmt.Errorf("scrypt parameters %s are out of range", parts[2])
+	}
+
+	sh.salt, err = decodeHashBase64(parts[3])
+	if err != nil {
+		return sh, fmt.Errorf("invalid scrypt salt")
+	}
+
+	sh.key, err = decodeHashBase64(parts[4])
+	if err != nil || len(sh.key) == 0 {
+		return sh, fmt.Errorf("invalid scrypt key")
+	}
+
+	return sh, nil
+}
+
+// checkScrypt returns an error if an scrypt hash cannot be verified.
+func checkScrypt(hash string) error {
+	_, err := parseScrypt(hash)
+
+	return err
+}
+
+// verifyScrypt verifies an scrypt hash in the PHC format
+// $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<key>.
+func verifyScrypt(hash, passphrase string) bool {
+	sh, err := parseScrypt(hash)
+	if err != nil {
+		return false
+	}
+
+	derived, err := scrypt.Key([]byte(passphrase), sh.salt, 1<<sh.ln, sh.r, sh.p, len(sh.key))
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(sh.key, derived) == 1
+}
+
+// pbkdf2Hash holds the parts of a PBKDF2 hash.
+type pbkdf2Hash struct {
+	rounds    int
+	salt, key []byte
+}
+
+// parsePBKDF2SHA256 parses a PBKDF2-SHA256 hash in the passlib format
+// $pbkdf2-sha256$<rounds>$<salt>$<key>, or with the rounds in the PHC form
+// i=<rounds>. An error is returned if it is malformed or has more than
+// maxPBKDF2Rounds rounds.
+func parsePBKDF2SHA256(hash string) (pbkdf2Hash, error) {
+	var ph pbkdf2Hash
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return ph, fmt.Errorf("invalid pbkdf2 hash split")
+	}
+
+	rounds, err := strconv.Atoi(strings.TrimPrefix(parts[2], "i="))
+	if err != nil || rounds < 1 || rounds > maxPBKDF2Rounds {
+		return ph, fmt.Errorf("pbkdf2 rounds %s are out of range", parts[2])
+	}
+
+	ph.rounds = rounds
+
+	ph.salt, err = decodeHashBase64(parts[3])
+	if err != nil {
+		return ph, fmt.Errorf("invalid pbkdf2 salt")
+	}
+
+	ph.key, err = decodeHashBase64(parts[4])
+	if err != nil || len(ph.key) == 0 {
+		return ph, fmt.Errorf("invalid pbkdf2 key")
+	}
+
+	return ph, nil
+}
+
+// checkPBKDF2SHA256 returns an error if a PBKDF2-SHA256 hash cannot be
+// verified.
+func checkPBKDF2SHA256(hash string) error {
+	_, err := parsePBKDF2SHA256(hash)
+
+	return err
+}
+
+// verifyPBKDF2SHA256 verifies a PBKDF2-SHA256 hash in the passlib format
+// $pbkdf2-sha256$<rounds>$<salt>$<key>. The rounds may also be given in the
+// PHC form i=<rounds>.
+func verifyPBKDF2SHA256(hash, passphrase string) bool {
+	ph, err := parsePBKDF2SHA256(hash)
+	if err != nil {
+		return false
+	}
+
+	derived := pbkdf2.Key([]byte(passphrase), ph.salt, ph.rounds, len(ph.key), sha256.New)
+
+	return subtle.ConstantTimeCompare(ph.key, derived) == 1
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	testLegacyPassword = "legacypassword1"
+	testScryptHash     = "$scrypt$ln=10,r=8,p=1$c2FsdHNhbHRzYWx0c2FsdA$AbvY7CDxVcc8VRIqjzngIkEY6XRgxzk+M+J2VpK6rek"
+	testPBKDF2Hash     = "$pbkdf2-sha256$1000$c2FsdHNhbHRzYWx0c2FsdA$voG.xe34QPAyXVam1JfKvJXHUiAW82H4/Pjq4QDeLoU"
+	testImportDbPath   = "import_test.db"
+)
+
+func TestHashSchemes(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte(testLegacyPassword), bcrypt.MinCost)
+
+	hashes := []string{
+		goodHash,
+		string(bcryptHash),
+		strings.Replace(string(bcryptHash), "$2a$", "$2y$", 1),
+		testScryptHash,
+		testPBKDF2Hash,
+		strings.Replace(testPBKDF2Hash, "$1000$", "$i=1000$", 1),
+	}
+
+	for _, hash := range hashes {
+		password := testLegacyPassword
+		if hash == goodHash {
+			password = goodPassword
+		}
+
+		if !KnownHash(hash) {
+			t.Fatal("Expected", hash, "to be known")
+		}
+
+		if !VerifyHash(hash, password) {
+			t.Fatal("Expected", hash, "to match")
+		}
+
+		if VerifyHash(hash, password+"x") {
+			t.Fatal("Expected", hash, "not to match")
+		}
+	}
+
+	unknown := []string{"", "plaintext", "$1$saltsalt$hash", "$md5$rounds=1000$salt$hash"}
+	for _, hash := range unknown {
+		if KnownHash(hash) || VerifyHash(hash, hash) {
+			t.Fatal("Expected", hash, "to be unknown")
+		}
+	}
+
+	for _, hash := range hashes {
+		if err := CheckHashFormat(hash); err != nil {
+			t.Fatal("Expected", nil, "for", hash, ", received", err)
+		}
+	}
+
+	// Malformed hashes and hashes too costly to verify are rejected
+	// without being checked.
+	costly, _ := bcrypt.GenerateFromPassword([]byte(testLegacyPassword), bcrypt.MinCost)
+	costly = []byte(strings.Replace(string(costly), "$04$", "$31$", 1))
+
+	malformed := []string{
+		"$scrypt$ln=99,r=8,p=1$c2FsdA$c2FsdA",
+		"$scrypt$ln=10,r=8$c2FsdA$c2FsdA",
+		"$scrypt$ln=20,r=8,p=1$c2FsdA$c2FsdA",
+		"$scrypt$ln=10,r=8,p=1000$c2FsdA$c2FsdA",
+		"$scrypt$ln=10,r=1000,p=1$c2FsdA$c2FsdA",
+		"$pbkdf2-sha256$0$c2FsdA$c2FsdA",
+		"$pbkdf2-sha256$1000$c2FsdA$",
+		"$pbkdf2-sha256$999999999$c2FsdA$c2FsdA",
+		string(costly),
+		"$argon2id$v=19$m=65536,t=4,p=0$c2FsdHNhbHRzYWx0c2FsdA$c2FsdA",
+		"$argon2id$v=19$m=4,t=4,p=1$c2FsdHNhbHRzYWx0c2FsdA$c2FsdA",
+		"$argon2id$v=19$m=4194304,t=4,p=3$c2FsdHNhbHRzYWx0c2FsdA$c2FsdA",
+		"$argon2id$v=19$m=65536,t=1000,p=3$c2FsdHNhbHRzYWx0c2FsdA$c2FsdA",
+		"$argon2id$v=19$m=65536,t=4,p=255$c2FsdHNhbHRzYWx0c2FsdA$c2FsdA",
+	}
+
+	for _, hash := range malformed {
+		if CheckHashFormat(hash) == nil {
+			t.Fatal("Expected error for", hash, ", received", nil)
+		}
+
+		if VerifyHash(hash, testLegacyPassword) {
+			t.Fatal("Expected", hash, "not to match")
+		}
+	}
+
+	if CheckHashFormat("plaintext") == nil {
+		t.Fatal("Expected error for", "plaintext", ", received", nil)
+	}
+
+	// A registered scheme is used for hashes with its prefix.
+	RegisterHashScheme("$test$", func(hash, passphrase string) bool {
+		return hash == "$test$"+passphrase
+	})
+
+	if !VerifyHash("$test$"+testLegacyPassword, testLegacyPassword) {
+		t.Fatal("Expected registered scheme to match")
+	}
+}
+
+func testStoreImportUser(t *testing.T) {
+	fmt.Println(t.Name())
+
+	db := newTestStore(t, testImportDbPath)
+	defer deleteTestStore(t, testImportDbPath)
+
+	u1 := NewUser(testUserAlias)
+
+	err := db.ImportUser(u1, "plaintext")
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	err = db.ImportUser(u1, "$pbkdf2-sha256$999999999$c2FsdA$c2FsdA")
+	if err == nil || db.UserExists(testUserAlias) {
+		t.Fatal("Expected error for costly hash, received", err)
+	}
+
+	err = db.ImportUser(u1, testScryptHash)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// The imported hash is replaced with an Argon2id hash on the first
+	// login.
+	key := fmt.Sprintf(hashKey, u1.UserId.String())
+
+	if ok, _ := db.AuthenticateUser(u1.UserId, goodPassword); ok {
+		t.Fatal("Expected password to not match:", goodPassword)
+	}
+
+	if string(db.read(userBucket, key)) != testScryptHash {
+		t.Fatal("Expected", testScryptHash, ", received", string(db.read(userBucket, key)))
+	}
+
+	if ok, _ := db.AuthenticateUser(u1.UserId, testLegacyPassword); !ok {
+		t.Fatal("Expected password to match:", testLegacyPassword)
+	}
+
+	hash := string(db.read(userBucket, key))
+	if !strings.HasPrefix(hash, "$argon2id$") || NeedsRehash(hash) {
+		t.Fatal("Expected Argon2id hash, received", hash)
+	}
+
+	if ok, _ := db.AuthenticateUser(u1.UserId, testLegacyPassword); !ok {
+		t.Fatal("Expected password to match:", testLegacyPassword)
+	}
+
+	db.Close()
+}
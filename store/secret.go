@@ -0,0 +1,69 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// SetSecretKey sets the 32 byte key used to encrypt secrets, such as TOTP
+// secrets, before they are written to the Store. Secrets written with one
+// key cannot be read with another.
+func (s *Store) SetSecretKey(key []byte) error {
+	if len(key) != keySize {
+		return fmt.Errorf("could not Store.SetSecretKey: key must be %d bytes", keySize)
+	}
+
+	s.key = append([]byte{}, key...)
+
+	return nil
+}
+
+// aead returns the AES-256-GCM cipher for the secret key.
+func (s *Store) aead() (cipher.AEAD, error) {
+	if s.key == nil {
+		return nil, fmt.Errorf("no secret key is set")
+	}
+
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext with the secret key. The additional data, such
+// as the id of the owner, must be given again to open the ciphertext, so a
+// ciphertext cannot be moved to another record.
+func (s *Store) seal(plaintext, ad []byte) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+// open decrypts a ciphertext created by seal with the same additional data.
+func (s *Store) open(ciphertext, ad []byte) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+
+	nonce, data := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, data, ad)
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	bolt "go.etcd.io/bbolt"
 )
 
 //----------------------------------------------------------------------------
@@ -16,6 +18,12 @@ type Session struct {
 	SessionId  SessionToken `json:"session_id"`
 	UserId     UserToken    `json:"user_id"`
 	Expiration int64        `json:"expire"`
+	CSRFToken  string       `json:"csrf"`
+}
+
+// Date returns the expiration time of the session formatted for display.
+func (s Session) Date() string {
+	return time.Unix(s.Expiration, 0).UTC().Format(time.RFC1123)
 }
 
 // IsExpired returns true if the session is expired.
@@ -44,6 +52,10 @@ func NewSession(uid UserToken, length int64) (Session, error) {
 	s.SessionId = NewSessionToken()
 	s.UserId = uid
 
+	// The CSRF token is sent in forms to prove a request came from the site.
+	csrf := newTokenBytes()
+	s.CSRFToken = tokenEncoder.EncodeToString(csrf[:])
+
 	t := time.Now()
 	s.Expiration = t.Unix() + length
 
@@ -105,6 +117,53 @@ func (s *Store) DeleteSession(sid SessionToken) error {
 	return s.delete(sessBucket, sid.String())
 }
 
+// DeleteUserSessions removes every session belonging to the user identified by
+// uid from the Store.
+func (s *Store) DeleteUserSessions(uid UserToken) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(sessBucket))
+
+		return deleteMatching(b, func(k, v []byte) bool {
+			return ownedBy(v, uid)
+		})
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.DeleteUserSessions: %v", err)
+	}
+
+	return nil
+}
+
+// GetUserSessions returns every session belonging to the user identified by
+// uid.
+func (s *Store) GetUserSessions(uid UserToken) ([]Session, error) {
+	var sessions []Session
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(sessBucket))
+
+		return b.ForEach(func(k, v []byte) error {
+			sess, err := NewSessionFromBytes(v)
+			if err != nil {
+				return err
+			}
+
+			if sess.UserId == uid {
+				sessions = append(sessions, sess)
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return sessions, fmt.Errorf("could not Store.GetUserSessions: %v", err)
+	}
+
+	return sessions, nil
+}
+
 // GetSession takes a SessionToken and returns the Session associated with it.
 func (s *Store) GetSession(sid SessionToken) (Session, error) {
 	var sess Session
@@ -13,7 +13,7 @@ var (
 )
 
 func testSessionEqual(t *testing.T, s1, s2 Session) {
-	if (s1.SessionId != s2.SessionId) || (s1.UserId != s2.UserId) || (s1.Expiration != s2.Expiration) {
+	if (s1.SessionId != s2.SessionId) || (s1.UserId != s2.UserId) || (s1.Expiration != s2.Expiration) || (s1.CSRFToken != s2.CSRFToken) {
 		t.Fatal("Expected", s1, ", received", s2)
 	}
 }
@@ -39,6 +39,14 @@ func TestSession(t *testing.T) {
 
 	testSessionEqual(t, s1, s2)
 
+	if s1.CSRFToken == "" {
+		t.Fatal("Expected CSRF token, received", s1.CSRFToken)
+	}
+
+	if s3, _ := NewSession(u1.UserId, 5); s3.CSRFToken == s1.CSRFToken {
+		t.Fatal("Did not generate unique CSRF tokens")
+	}
+
 	if s1.IsExpired() {
 		t.Fatal("Expected unexpired session, received", s1)
 	}
@@ -93,6 +101,38 @@ func testStoreSession(t *testing.T) {
 
 	testSessionEqual(t, s1, s3)
 
+	// Delete User Sessions
+	s4, _ := NewSession(u1.UserId, 5)
+	s5, _ := NewSession(NewUserToken(), 5)
+	db.CreateSession(s4)
+	db.CreateSession(s5)
+
+	sessions, err := db.GetUserSessions(u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if len(sessions) != 2 {
+		t.Fatal("Expected", 2, ", received", len(sessions))
+	}
+
+	err = db.DeleteUserSessions(u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	_, err = db.GetSession(s4.SessionId)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	_, err = db.GetSession(s5.SessionId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	db.CreateSession(s1)
+
 	// Delete User
 	err = db.DeleteSession(s1.SessionId)
 	if err != nil {
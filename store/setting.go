@@ -0,0 +1,37 @@
+package store
+
+import (
+	"fmt"
+)
+
+// Settings changed by admins or tools while the application runs, as
+// opposed to the Config, which is fixed when the application starts.
+const (
+	SettingRequireAdminTOTP = "require_admin_totp"
+	SettingHashPolicy       = "hash_policy"
+)
+
+//----------------------------------------------------------------------------
+// Setting Storage Methods
+//----------------------------------------------------------------------------
+
+// GetSetting returns the value of the named setting, or the default if the
+// setting has not been changed.
+func (s *Store) GetSetting(name, def string) string {
+	data := s.read(settingBucket, name)
+	if data == nil {
+		return def
+	}
+
+	return string(data)
+}
+
+// SetSetting changes the value of the named setting.
+func (s *Store) SetSetting(name, value string) error {
+	err := s.write(settingBucket, name, []byte(value))
+	if err != nil {
+		return fmt.Errorf("could not Store.SetSetting: %v", err)
+	}
+
+	return nil
+}
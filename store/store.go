@@ -3,6 +3,7 @@ package store
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"os"
 	"time"
@@ -11,20 +12,58 @@ import (
 )
 
 const (
-	userBucket = "user"
-	sessBucket = "sess"
+	userBucket     = "user"
+	sessBucket     = "sess"
+	reservedBucket = "reserved"
+	emailBucket    = "email"
+	verifyBucket   = "verify"
+	resetBucket    = "reset"
+	activityBucket = "activity"
+	roleBucket     = "role"
+	orgBucket      = "org"
+	memberBucket   = "member"
+	orgDataBucket  = "orgdata"
+	inviteBucket   = "invite"
+	prefBucket     = "pref"
+	uploadBucket   = "upload"
+	blobBucket     = "blob"
+	chalBucket     = "challenge"
+	settingBucket  = "setting"
+	credBucket     = "credential"
+	ceremonyBucket = "ceremony"
+	rateBucket     = "ratelimit"
 )
 
 var (
-	storeBuckets = [2]string{
+	storeBuckets = [20]string{
 		userBucket,
 		sessBucket,
+		reservedBucket,
+		emailBucket,
+		verifyBucket,
+		resetBucket,
+		activityBucket,
+		roleBucket,
+		orgBucket,
+		memberBucket,
+		orgDataBucket,
+		inviteBucket,
+		prefBucket,
+		uploadBucket,
+		blobBucket,
+		chalBucket,
+		settingBucket,
+		credBucket,
+		ceremonyBucket,
+		rateBucket,
 	}
 )
 
-// Store holds the bolt database
+// Store holds the bolt database and the key used to encrypt secrets kept in
+// it.
 type Store struct {
-	db *bolt.DB
+	db  *bolt.DB
+	key []byte
 }
 
 // ----------------------------------------------------------------------------
@@ -49,11 +88,53 @@ func uint64ToBytes(i uint64) []byte {
 	return buf
 }
 
+// ownedBy returns true if the given JSON value has a user_id matching uid.
+func ownedBy(value []byte, uid UserToken) bool {
+	var owner struct {
+		UserId UserToken `json:"user_id"`
+	}
+
+	err := json.Unmarshal(value, &owner)
+	if err != nil {
+		return false
+	}
+
+	return owner.UserId == uid
+}
+
+// deleteMatching removes every key/value pair in the given bucket for which
+// match returns true. Keys cannot be deleted while iterating over a bucket, so
+// the matching keys are collected first.
+func deleteMatching(b *bolt.Bucket, match func(k, v []byte) bool) error {
+	var keys [][]byte
+
+	err := b.ForEach(func(k, v []byte) error {
+		if match(k, v) {
+			keys = append(keys, k)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		err = b.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 //----------------------------------------------------------------------------
 // Initialization Database
 //----------------------------------------------------------------------------
 
-// initialize configures the BBolt database for use as a Store.
+// initialize configures the BBolt database for use as a Store. The default
+// roles are created and users stored before roles existed are migrated.
 func (s *Store) initialize() error {
 	for _, bucket := range storeBuckets {
 		err := s.createBucket(bucket)
@@ -62,7 +143,17 @@ func (s *Store) initialize() error {
 		}
 	}
 
-	return nil
+	err := s.seedRoles()
+	if err != nil {
+		return err
+	}
+
+	err = s.migrateAdminUsers()
+	if err != nil {
+		return err
+	}
+
+	return s.migrateAliases()
 }
 
 // createBucket creates a new bucket with the given name at the root of the
@@ -20,8 +20,33 @@ func TestStore(t *testing.T) {
 	t.Run("Test Store Core", testStoreCore)
 	t.Run("Test Store Backup", testStoreBackup)
 	t.Run("Test Store Auth", testStoreAuth)
+	t.Run("Test Store Import User", testStoreImportUser)
+	t.Run("Test Store Hash Policy", testStoreHashPolicy)
+	t.Run("Test Store Lockout", testStoreLockout)
+	t.Run("Test Store TOTP", testStoreTOTP)
+	t.Run("Test Store Login Challenge", testStoreLoginChallenge)
+	t.Run("Test Store Recovery Code", testStoreRecoveryCode)
+	t.Run("Test Store Credential", testStoreCredential)
+	t.Run("Test Store Ceremony", testStoreCeremony)
 	t.Run("Test Store User", testStoreUser)
+	t.Run("Test Store User Management", testStoreUserManagement)
+	t.Run("Test Store User Approval", testStoreUserApproval)
 	t.Run("Test Store Session", testStoreSession)
+	t.Run("Test Store Alias", testStoreAlias)
+	t.Run("Test Store Alias Confusable", testStoreAliasConfusable)
+	t.Run("Test Store Alias Migration", testStoreAliasMigration)
+	t.Run("Test Store Email", testStoreEmail)
+	t.Run("Test Store Reset", testStoreReset)
+	t.Run("Test Store Recovery", testStoreRecovery)
+	t.Run("Test Store Activity", testStoreActivity)
+	t.Run("Test Store Role", testStoreRole)
+	t.Run("Test Store Role Migration", testStoreRoleMigration)
+	t.Run("Test Store Org", testStoreOrg)
+	t.Run("Test Store Invite", testStoreInvite)
+	t.Run("Test Store Export", testStoreExport)
+	t.Run("Test Store Preference", testStorePreference)
+	t.Run("Test Store Upload", testStoreUpload)
+	t.Run("Test Store Rate Buckets", testStoreRateBuckets)
 }
 
 func newTestStore(t *testing.T, path string) *Store {
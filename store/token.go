@@ -4,7 +4,9 @@ package store
 // tokens have the form prefix_base32encodedbytes.
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base32"
+	"encoding/hex"
 	"fmt"
 	"strings"
 )
@@ -13,6 +15,13 @@ const (
 	tokenSize          = 32
 	userTokenPrefix    = "user_"
 	sessionTokenPrefix = "sess_"
+	verifyTokenPrefix  = "vrfy_"
+	resetTokenPrefix   = "rset_"
+	orgTokenPrefix     = "org_"
+	inviteTokenPrefix  = "invt_"
+	uploadTokenPrefix  = "upld_"
+	chalTokenPrefix    = "chal_"
+	cereTokenPrefix    = "cere_"
 )
 
 // tokenEncoder is used to encoded and decode our tokens using a standard
@@ -117,10 +126,215 @@ func parseSessionToken(s string) (SessionToken, error) {
 	return st, nil
 }
 
+//----------------------------------------------------------------------------
+// VerifyToken
+//----------------------------------------------------------------------------
+
+// VerifyToken represents an email verification token.
+type VerifyToken [tokenSize]byte
+
+// String converts a VerifyToken object to a string.
+func (v VerifyToken) String() string {
+	token := tokenEncoder.EncodeToString(v[:])
+
+	return fmt.Sprintf("%s%s", verifyTokenPrefix, token)
+}
+
+// NewVerifyToken generates a random VerifyToken.
+func NewVerifyToken() VerifyToken {
+	var vt VerifyToken
+
+	bytes := newTokenBytes()
+	copy(vt[:], bytes[:])
+
+	return vt
+}
+
+// parseVerifyToken takes a string in the form of vrfy_base32 and parses it
+// into a VerifyToken
+func parseVerifyToken(s string) (VerifyToken, error) {
+	var vt VerifyToken
+
+	if !strings.HasPrefix(s, verifyTokenPrefix) {
+		return vt, fmt.Errorf("could not parseVerifyToken: invalid prefix")
+	}
+
+	s = strings.TrimPrefix(s, verifyTokenPrefix)
+
+	data, err := tokenEncoder.DecodeString(s)
+	if err != nil {
+		return vt, fmt.Errorf("could not parseVerifyToken: %v", err)
+	}
+
+	if len(data) != tokenSize {
+		return vt, fmt.Errorf("could not parseVerifyToken: invalid length")
+	}
+
+	copy(vt[:], data)
+
+	return vt, nil
+}
+
+//----------------------------------------------------------------------------
+// ResetToken
+//----------------------------------------------------------------------------
+
+// ResetToken represents a password reset token.
+type ResetToken [tokenSize]byte
+
+// String converts a ResetToken object to a string.
+func (r ResetToken) String() string {
+	token := tokenEncoder.EncodeToString(r[:])
+
+	return fmt.Sprintf("%s%s", resetTokenPrefix, token)
+}
+
+// NewResetToken generates a random ResetToken.
+func NewResetToken() ResetToken {
+	var rt ResetToken
+
+	bytes := newTokenBytes()
+	copy(rt[:], bytes[:])
+
+	return rt
+}
+
+// parseResetToken takes a string in the form of rset_base32 and parses it
+// into a ResetToken
+func parseResetToken(s string) (ResetToken, error) {
+	var rt ResetToken
+
+	if !strings.HasPrefix(s, resetTokenPrefix) {
+		return rt, fmt.Errorf("could not parseResetToken: invalid prefix")
+	}
+
+	s = strings.TrimPrefix(s, resetTokenPrefix)
+
+	data, err := tokenEncoder.DecodeString(s)
+	if err != nil {
+		return rt, fmt.Errorf("could not parseResetToken: %v", err)
+	}
+
+	if len(data) != tokenSize {
+		return rt, fmt.Errorf("could not parseResetToken: invalid length")
+	}
+
+	copy(rt[:], data)
+
+	return rt, nil
+}
+
+//----------------------------------------------------------------------------
+// OrgToken
+//----------------------------------------------------------------------------
+
+// OrgToken represents an organization token.
+type OrgToken [tokenSize]byte
+
+// String converts an OrgToken object to a string.
+func (o OrgToken) String() string {
+	token := tokenEncoder.EncodeToString(o[:])
+
+	return fmt.Sprintf("%s%s", orgTokenPrefix, token)
+}
+
+// NewOrgToken generates a random OrgToken.
+func NewOrgToken() OrgToken {
+	var ot OrgToken
+
+	bytes := newTokenBytes()
+	copy(ot[:], bytes[:])
+
+	return ot
+}
+
+// parseOrgToken takes a string in the form of org_base32 and parses it into
+// an OrgToken
+func parseOrgToken(s string) (OrgToken, error) {
+	var ot OrgToken
+
+	if !strings.HasPrefix(s, orgTokenPrefix) {
+		return ot, fmt.Errorf("could not parseOrgToken: invalid prefix")
+	}
+
+	s = strings.TrimPrefix(s, orgTokenPrefix)
+
+	data, err := tokenEncoder.DecodeString(s)
+	if err != nil {
+		return ot, fmt.Errorf("could not parseOrgToken: %v", err)
+	}
+
+	if len(data) != tokenSize {
+		return ot, fmt.Errorf("could not parseOrgToken: invalid length")
+	}
+
+	copy(ot[:], data)
+
+	return ot, nil
+}
+
+//----------------------------------------------------------------------------
+// InviteToken
+//----------------------------------------------------------------------------
+
+// InviteToken represents a registration invite code.
+type InviteToken [tokenSize]byte
+
+// String converts an InviteToken object to a string.
+func (i InviteToken) String() string {
+	token := tokenEncoder.EncodeToString(i[:])
+
+	return fmt.Sprintf("%s%s", inviteTokenPrefix, token)
+}
+
+// NewInviteToken generates a random InviteToken.
+func NewInviteToken() InviteToken {
+	var it InviteToken
+
+	bytes := newTokenBytes()
+	copy(it[:], bytes[:])
+
+	return it
+}
+
+// parseInviteToken takes a string in the form of invt_base32 and parses it
+// into an InviteToken
+func parseInviteToken(s string) (InviteToken, error) {
+	var it InviteToken
+
+	if !strings.HasPrefix(s, inviteTokenPrefix) {
+		return it, fmt.Errorf("could not parseInviteToken: invalid prefix")
+	}
+
+	s = strings.TrimPrefix(s, inviteTokenPrefix)
+
+	data, err := tokenEncoder.DecodeString(s)
+	if err != nil {
+		return it, fmt.Errorf("could not parseInviteToken: %v", err)
+	}
+
+	if len(data) != tokenSize {
+		return it, fmt.Errorf("could not parseInviteToken: invalid length")
+	}
+
+	copy(it[:], data)
+
+	return it, nil
+}
+
 //----------------------------------------------------------------------------
 // Helper functions
 //----------------------------------------------------------------------------
 
+// hashToken returns the hex encoded SHA-256 hash of the given token string.
+// Single-use tokens are stored by their hash so a copy of the Store cannot be
+// used to redeem them.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
 // newTokenBytes returns a byte slice with tokenSize random bytes.
 func newTokenBytes() [tokenSize]byte {
 	var bytes [tokenSize]byte
@@ -132,3 +346,151 @@ func newTokenBytes() [tokenSize]byte {
 
 	return bytes
 }
+
+//----------------------------------------------------------------------------
+// UploadToken
+//----------------------------------------------------------------------------
+
+// UploadToken represents an uploaded file.
+type UploadToken [tokenSize]byte
+
+// String converts an UploadToken object to a string.
+func (u UploadToken) String() string {
+	token := tokenEncoder.EncodeToString(u[:])
+
+	return fmt.Sprintf("%s%s", uploadTokenPrefix, token)
+}
+
+// NewUploadToken generates a random UploadToken.
+func NewUploadToken() UploadToken {
+	var ut UploadToken
+
+	bytes := newTokenBytes()
+	copy(ut[:], bytes[:])
+
+	return ut
+}
+
+// parseUploadToken takes a string in the form of upld_base32 and parses it
+// into an UploadToken
+func parseUploadToken(s string) (UploadToken, error) {
+	var ut UploadToken
+
+	if !strings.HasPrefix(s, uploadTokenPrefix) {
+		return ut, fmt.Errorf("could not parseUploadToken: invalid prefix")
+	}
+
+	s = strings.TrimPrefix(s, uploadTokenPrefix)
+
+	data, err := tokenEncoder.DecodeString(s)
+	if err != nil {
+		return ut, fmt.Errorf("could not parseUploadToken: %v", err)
+	}
+
+	if len(data) != tokenSize {
+		return ut, fmt.Errorf("could not parseUploadToken: invalid length")
+	}
+
+	copy(ut[:], data)
+
+	return ut, nil
+}
+
+//----------------------------------------------------------------------------
+// ChallengeToken
+//----------------------------------------------------------------------------
+
+// ChallengeToken represents a login waiting for a second factor.
+type ChallengeToken [tokenSize]byte
+
+// String converts a ChallengeToken object to a string.
+func (c ChallengeToken) String() string {
+	token := tokenEncoder.EncodeToString(c[:])
+
+	return fmt.Sprintf("%s%s", chalTokenPrefix, token)
+}
+
+// NewChallengeToken generates a random ChallengeToken.
+func NewChallengeToken() ChallengeToken {
+	var ct ChallengeToken
+
+	bytes := newTokenBytes()
+	copy(ct[:], bytes[:])
+
+	return ct
+}
+
+// parseChallengeToken takes a string in the form of chal_base32 and parses it
+// into a ChallengeToken
+func parseChallengeToken(s string) (ChallengeToken, error) {
+	var ct ChallengeToken
+
+	if !strings.HasPrefix(s, chalTokenPrefix) {
+		return ct, fmt.Errorf("could not parseChallengeToken: invalid prefix")
+	}
+
+	s = strings.TrimPrefix(s, chalTokenPrefix)
+
+	data, err := tokenEncoder.DecodeString(s)
+	if err != nil {
+		return ct, fmt.Errorf("could not parseChallengeToken: %v", err)
+	}
+
+	if len(data) != tokenSize {
+		return ct, fmt.Errorf("could not parseChallengeToken: invalid length")
+	}
+
+	copy(ct[:], data)
+
+	return ct, nil
+}
+
+//----------------------------------------------------------------------------
+// CeremonyToken
+//----------------------------------------------------------------------------
+
+// CeremonyToken represents a WebAuthn ceremony waiting for a response from
+// an authenticator.
+type CeremonyToken [tokenSize]byte
+
+// String converts a CeremonyToken object to a string.
+func (c CeremonyToken) String() string {
+	token := tokenEncoder.EncodeToString(c[:])
+
+	return fmt.Sprintf("%s%s", cereTokenPrefix, token)
+}
+
+// NewCeremonyToken generates a random CeremonyToken.
+func NewCeremonyToken() CeremonyToken {
+	var ct CeremonyToken
+
+	bytes := newTokenBytes()
+	copy(ct[:], bytes[:])
+
+	return ct
+}
+
+// parseCeremonyToken takes a string in the form of cere_base32 and parses it
+// into a CeremonyToken
+func parseCeremonyToken(s string) (CeremonyToken, error) {
+	var ct CeremonyToken
+
+	if !strings.HasPrefix(s, cereTokenPrefix) {
+		return ct, fmt.Errorf("could not parseCeremonyToken: invalid prefix")
+	}
+
+	s = strings.TrimPrefix(s, cereTokenPrefix)
+
+	data, err := tokenEncoder.DecodeString(s)
+	if err != nil {
+		return ct, fmt.Errorf("could not parseCeremonyToken: %v", err)
+	}
+
+	if len(data) != tokenSize {
+		return ct, fmt.Errorf("could not parseCeremonyToken: invalid length")
+	}
+
+	copy(ct[:], data)
+
+	return ct, nil
+}
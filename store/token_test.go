@@ -13,6 +13,13 @@ const (
 func TestTokens(t *testing.T) {
 	t.Run("Test UserToken", testUserToken)
 	t.Run("Test SessionToken", testSessionToken)
+	t.Run("Test VerifyToken", testVerifyToken)
+	t.Run("Test ResetToken", testResetToken)
+	t.Run("Test OrgToken", testOrgToken)
+	t.Run("Test InviteToken", testInviteToken)
+	t.Run("Test UploadToken", testUploadToken)
+	t.Run("Test ChallengeToken", testChallengeToken)
+	t.Run("Test CeremonyToken", testCeremonyToken)
 }
 
 func testTokenBytes(t *testing.T, s string) {
@@ -77,3 +84,220 @@ func testSessionToken(t *testing.T) {
 
 	testTokenBytes(t, token)
 }
+
+func testVerifyToken(t *testing.T) {
+	fmt.Println(t.Name())
+
+	token := NewVerifyToken().String()
+
+	if !strings.HasPrefix(token, verifyTokenPrefix) {
+		t.Fatal("VerifyToken has incorrect prefix.")
+	}
+
+	parsed, err := parseVerifyToken(token)
+	if err != nil {
+		t.Fatal("Expected no error, recieved", err)
+	}
+
+	if parsed.String() != token {
+		t.Fatal("Expected", token, ", received", parsed.String())
+	}
+
+	_, err = parseVerifyToken(NewSessionToken().String())
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	token = strings.TrimPrefix(token, verifyTokenPrefix)
+	if len(token) != tokenBase32Size {
+		t.Fatal("Expected", tokenBase32Size, "base32 characters, received", len(token))
+	}
+
+	testTokenBytes(t, token)
+}
+
+func testResetToken(t *testing.T) {
+	fmt.Println(t.Name())
+
+	token := NewResetToken().String()
+
+	if !strings.HasPrefix(token, resetTokenPrefix) {
+		t.Fatal("ResetToken has incorrect prefix.")
+	}
+
+	parsed, err := parseResetToken(token)
+	if err != nil {
+		t.Fatal("Expected no error, recieved", err)
+	}
+
+	if parsed.String() != token {
+		t.Fatal("Expected", token, ", received", parsed.String())
+	}
+
+	_, err = parseResetToken(NewVerifyToken().String())
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	token = strings.TrimPrefix(token, resetTokenPrefix)
+	if len(token) != tokenBase32Size {
+		t.Fatal("Expected", tokenBase32Size, "base32 characters, received", len(token))
+	}
+
+	testTokenBytes(t, token)
+}
+
+func testOrgToken(t *testing.T) {
+	fmt.Println(t.Name())
+
+	token := NewOrgToken().String()
+
+	if !strings.HasPrefix(token, orgTokenPrefix) {
+		t.Fatal("OrgToken has incorrect prefix.")
+	}
+
+	parsed, err := parseOrgToken(token)
+	if err != nil {
+		t.Fatal("Expected no error, recieved", err)
+	}
+
+	if parsed.String() != token {
+		t.Fatal("Expected", token, ", received", parsed.String())
+	}
+
+	_, err = parseOrgToken(NewUserToken().String())
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	token = strings.TrimPrefix(token, orgTokenPrefix)
+	if len(token) != tokenBase32Size {
+		t.Fatal("Expected", tokenBase32Size, "base32 characters, received", len(token))
+	}
+
+	testTokenBytes(t, token)
+}
+
+func testInviteToken(t *testing.T) {
+	fmt.Println(t.Name())
+
+	token := NewInviteToken().String()
+
+	if !strings.HasPrefix(token, inviteTokenPrefix) {
+		t.Fatal("InviteToken has incorrect prefix.")
+	}
+
+	parsed, err := parseInviteToken(token)
+	if err != nil {
+		t.Fatal("Expected no error, recieved", err)
+	}
+
+	if parsed.String() != token {
+		t.Fatal("Expected", token, ", received", parsed.String())
+	}
+
+	_, err = parseInviteToken(NewResetToken().String())
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	token = strings.TrimPrefix(token, inviteTokenPrefix)
+	if len(token) != tokenBase32Size {
+		t.Fatal("Expected", tokenBase32Size, "base32 characters, received", len(token))
+	}
+
+	testTokenBytes(t, token)
+}
+
+func testUploadToken(t *testing.T) {
+	fmt.Println(t.Name())
+
+	token := NewUploadToken().String()
+
+	if !strings.HasPrefix(token, uploadTokenPrefix) {
+		t.Fatal("UploadToken has incorrect prefix.")
+	}
+
+	parsed, err := parseUploadToken(token)
+	if err != nil {
+		t.Fatal("Expected no error, recieved", err)
+	}
+
+	if parsed.String() != token {
+		t.Fatal("Expected", token, ", received", parsed.String())
+	}
+
+	_, err = parseUploadToken(NewInviteToken().String())
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	token = strings.TrimPrefix(token, uploadTokenPrefix)
+	if len(token) != tokenBase32Size {
+		t.Fatal("Expected", tokenBase32Size, "base32 characters, received", len(token))
+	}
+
+	testTokenBytes(t, token)
+}
+
+func testChallengeToken(t *testing.T) {
+	fmt.Println(t.Name())
+
+	token := NewChallengeToken().String()
+
+	if !strings.HasPrefix(token, chalTokenPrefix) {
+		t.Fatal("ChallengeToken has incorrect prefix.")
+	}
+
+	parsed, err := parseChallengeToken(token)
+	if err != nil {
+		t.Fatal("Expected no error, recieved", err)
+	}
+
+	if parsed.String() != token {
+		t.Fatal("Expected", token, ", received", parsed.String())
+	}
+
+	_, err = parseChallengeToken(NewSessionToken().String())
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	token = strings.TrimPrefix(token, chalTokenPrefix)
+	if len(token) != tokenBase32Size {
+		t.Fatal("Expected", tokenBase32Size, "base32 characters, received", len(token))
+	}
+
+	testTokenBytes(t, token)
+}
+
+func testCeremonyToken(t *testing.T) {
+	fmt.Println(t.Name())
+
+	token := NewCeremonyToken().String()
+
+	if !strings.HasPrefix(token, cereTokenPrefix) {
+		t.Fatal("CeremonyToken has incorrect prefix.")
+	}
+
+	parsed, err := parseCeremonyToken(token)
+	if err != nil {
+		t.Fatal("Expected no error, recieved", err)
+	}
+
+	if parsed.String() != token {
+		t.Fatal("Expected", token, ", received", parsed.String())
+	}
+
+	_, err = parseCeremonyToken(NewChallengeToken().String())
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	token = strings.TrimPrefix(token, cereTokenPrefix)
+	if len(token) != tokenBase32Size {
+		t.Fatal("Expected", tokenBase32Size, "base32 characters, received", len(token))
+	}
+
+	testTokenBytes(t, token)
+}
@@ -0,0 +1,246 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/asggo/wasp/totp"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	totpKey = "%s:totp"
+)
+
+//----------------------------------------------------------------------------
+// TOTP Struct
+//----------------------------------------------------------------------------
+
+// totpRecord holds the TOTP secret of a user, encrypted with the secret key
+// of the Store. The secret is pending until the user confirms it with a
+// code. Counter is the counter of the last code accepted, so a code cannot
+// be used twice.
+type totpRecord struct {
+	Secret    []byte `json:"secret"`
+	Confirmed bool   `json:"confirmed"`
+	Counter   uint64 `json:"counter"`
+}
+
+// getTOTP returns the TOTP record of the user identified by uid using the
+// given user bucket, and false if the user has none.
+func getTOTP(b *bolt.Bucket, uid UserToken) (totpRecord, bool, error) {
+	var rec totpRecord
+
+	data := b.Get([]byte(fmt.Sprintf(totpKey, uid)))
+	if data == nil {
+		return rec, false, nil
+	}
+
+	err := json.Unmarshal(data, &rec)
+
+	return rec, err == nil, err
+}
+
+// putTOTP stores the TOTP record of the user identified by uid using the
+// given user bucket.
+func putTOTP(b *bolt.Bucket, uid UserToken, rec totpRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(fmt.Sprintf(totpKey, uid)), data)
+}
+
+// checkTOTP checks the code against the secret in the record and returns the
+// counter it matched. Codes at or before the counter of the last accepted
+// code are rejected.
+func (s *Store) checkTOTP(rec totpRecord, uid UserToken, code string) (uint64, error) {
+	secret, err := s.open(rec.Secret, []byte(uid.String()))
+	if err != nil {
+		return 0, err
+	}
+
+	counter, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return 0, fmt.Errorf("invalid code")
+	}
+
+	if counter <= rec.Counter {
+		return 0, fmt.Errorf("code already used")
+	}
+
+	return counter, nil
+}
+
+//----------------------------------------------------------------------------
+// TOTP Storage Methods
+//----------------------------------------------------------------------------
+
+// PendingTOTPSecret returns the secret the user identified by uid should add
+// to their authenticator app. A new secret is created unless one is already
+// pending, so reloading the enrollment page shows the same secret. An error
+// is returned if TOTP is already enabled.
+func (s *Store) PendingTOTPSecret(uid UserToken) ([]byte, error) {
+	var secret []byte
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
+
+		if b.Get([]byte(uid.String())) == nil {
+			return fmt.Errorf("user %s not found", uid)
+		}
+
+		rec, ok, err := getTOTP(b, uid)
+		if err != nil {
+			return err
+		}
+
+		if ok && rec.Confirmed {
+			return fmt.Errorf("TOTP is already enabled")
+		}
+
+		// A pending secret that cannot be read, because the secret key
+		// changed, is replaced.
+		if ok {
+			secret, err = s.open(rec.Secret, []byte(uid.String()))
+			if err == nil {
+				return nil
+			}
+		}
+
+		secret = totp.NewSecret()
+
+		rec.Secret, err = s.seal(secret, []byte(uid.String()))
+		if err != nil {
+			return err
+		}
+
+		return putTOTP(b, uid, rec)
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("could not Store.PendingTOTPSecret: %v", err)
+	}
+
+	return secret, nil
+}
+
+// EnableTOTP confirms the pending secret of the user identified by uid with
+// a code from their authenticator app. Once enabled, a code is needed to log
+// in. The change is recorded in the user's activity.
+func (s *Store) EnableTOTP(uid UserToken, code string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
+
+		rec, ok, err := getTOTP(b, uid)
+		if err != nil {
+			return err
+		}
+
+		if !ok || rec.Confirmed {
+			return fmt.Errorf("no TOTP secret is pending")
+		}
+
+		rec.Counter, err = s.checkTOTP(rec, uid, code)
+		if err != nil {
+			return err
+		}
+
+		rec.Confirmed = true
+
+		err = putTOTP(b, uid, rec)
+		if err != nil {
+			return err
+		}
+
+		return addActivity(tx, uid, ActivityTOTPEnabled, "")
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.EnableTOTP: %v", err)
+	}
+
+	return nil
+}
+
+// VerifyTOTP checks a code from the authenticator app of the user identified
+// by uid. A transaction is used to check the code and remember its counter
+// together, so each code is only accepted once.
+func (s *Store) VerifyTOTP(uid UserToken, code string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
+
+		rec, ok, err := getTOTP(b, uid)
+		if err != nil {
+			return err
+		}
+
+		if !ok || !rec.Confirmed {
+			return fmt.Errorf("TOTP is not enabled")
+		}
+
+		rec.Counter, err = s.checkTOTP(rec, uid, code)
+		if err != nil {
+			return err
+		}
+
+		return putTOTP(b, uid, rec)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.VerifyTOTP: %v", err)
+	}
+
+	return nil
+}
+
+// TOTPEnabled returns true if the user identified by uid needs a code from
+// their authenticator app to log in. An error is returned if their TOTP
+// record cannot be read, so callers fail closed rather than treat the user as
+// having no second factor.
+func (s *Store) TOTPEnabled(uid UserToken) (bool, error) {
+	var enabled bool
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		rec, ok, err := getTOTP(tx.Bucket([]byte(userBucket)), uid)
+		enabled = ok && rec.Confirmed
+
+		return err
+	})
+
+	if err != nil {
+		return false, fmt.Errorf("could not Store.TOTPEnabled: %v", err)
+	}
+
+	return enabled, nil
+}
+
+// DisableTOTP removes the TOTP secret of the user identified by uid, pending
+// or not, along with their recovery codes, which only stand in for TOTP. The
+// change and the given detail, such as who made it, are recorded in the
+// user's activity.
+func (s *Store) DisableTOTP(uid UserToken, detail string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
+
+		err := b.Delete([]byte(fmt.Sprintf(totpKey, uid)))
+		if err != nil {
+			return err
+		}
+
+		err = b.Delete([]byte(fmt.Sprintf(recoveryCodeKey, uid)))
+		if err != nil {
+			return err
+		}
+
+		return addActivity(tx, uid, ActivityTOTPDisabled, detail)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.DisableTOTP: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/asggo/wasp/totp"
+)
+
+var (
+	testTOTPDbPath = "totp_test.db"
+)
+
+func testStoreTOTP(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	db := newTestStore(t, testTOTPDbPath)
+	defer deleteTestStore(t, testTOTPDbPath)
+
+	db.CreateUser(u1, testAuthGoodPassword)
+
+	// Secrets cannot be stored without a secret key.
+	_, err := db.PendingTOTPSecret(u1.UserId)
+	if err == nil {
+		t.Fatal("Expected error without a secret key, received", nil)
+	}
+
+	key := make([]byte, keySize)
+	rand.Read(key)
+
+	err = db.SetSecretKey(key)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// The pending secret is kept until it is confirmed.
+	secret, err := db.PendingTOTPSecret(u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	again, _ := db.PendingTOTPSecret(u1.UserId)
+	if string(again) != string(secret) {
+		t.Fatal("Expected", secret, ", received", again)
+	}
+
+	if ok, _ := db.TOTPEnabled(u1.UserId); ok {
+		t.Fatal("Expected TOTP to be disabled before it is confirmed")
+	}
+
+	// The secret is encrypted in the Store.
+	data := db.read(userBucket, fmt.Sprintf(totpKey, u1.UserId))
+	if data == nil || bytes.Contains(data, secret) {
+		t.Fatal("Expected encrypted secret, received", string(data))
+	}
+
+	// A wrong code does not enable TOTP, the right one does.
+	counter := totp.Counter(time.Now())
+
+	err = db.EnableTOTP(u1.UserId, "000000")
+	if err == nil && totp.Code(secret, counter) != "000000" {
+		t.Fatal("Expected error for wrong code, received", nil)
+	}
+
+	err = db.EnableTOTP(u1.UserId, totp.Code(secret, counter-1))
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if ok, _ := db.TOTPEnabled(u1.UserId); !ok {
+		t.Fatal("Expected TOTP to be enabled")
+	}
+
+	_, err = db.PendingTOTPSecret(u1.UserId)
+	if err == nil {
+		t.Fatal("Expected error once TOTP is enabled, received", nil)
+	}
+
+	// Codes are only accepted once, and codes older than the last one
+	// accepted are rejected.
+	err = db.VerifyTOTP(u1.UserId, totp.Code(secret, counter-1))
+	if err == nil {
+		t.Fatal("Expected error for reused code, received", nil)
+	}
+
+	err = db.VerifyTOTP(u1.UserId, totp.Code(secret, counter))
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	err = db.VerifyTOTP(u1.UserId, totp.Code(secret, counter))
+	if err == nil {
+		t.Fatal("Expected error for reused code, received", nil)
+	}
+
+	// A different secret key cannot read the secret.
+	other := make([]byte, keySize)
+	rand.Read(other)
+	db.SetSecretKey(other)
+
+	err = db.VerifyTOTP(u1.UserId, totp.Code(secret, counter+1))
+	if err == nil {
+		t.Fatal("Expected error with the wrong secret key, received", nil)
+	}
+
+	db.SetSecretKey(key)
+
+	// A record that cannot be read fails closed.
+	record := db.read(userBucket, fmt.Sprintf(totpKey, u1.UserId))
+	db.write(userBucket, fmt.Sprintf(totpKey, u1.UserId), []byte("{"))
+
+	_, err = db.TOTPEnabled(u1.UserId)
+	if err == nil {
+		t.Fatal("Expected error for an unreadable record, received", nil)
+	}
+
+	db.write(userBucket, fmt.Sprintf(totpKey, u1.UserId), record)
+
+	// Disabling TOTP removes the secret and records the change.
+	err = db.DisableTOTP(u1.UserId, "by admin")
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if ok, _ := db.TOTPEnabled(u1.UserId); ok {
+		t.Fatal("Expected TOTP to be disabled")
+	}
+
+	activity, _ := db.GetActivity(u1.UserId)
+	if len(activity) != 2 || activity[0].Action != ActivityTOTPEnabled || activity[1].Action != ActivityTOTPDisabled {
+		t.Fatal("Expected TOTP activity, received", activity)
+	}
+
+	db.Close()
+}
+
+func testStoreLoginChallenge(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	db := newTestStore(t, testTOTPDbPath)
+	defer deleteTestStore(t, testTOTPDbPath)
+
+	db.CreateUser(u1, testAuthGoodPassword)
+
+	token, err := db.CreateLoginChallenge(u1.UserId, 300)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	lc, err := db.GetLoginChallenge(token.String())
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if lc.UserId != u1.UserId {
+		t.Fatal("Expected", u1.UserId, ", received", lc.UserId)
+	}
+
+	// The challenge is removed after too many failures.
+	for i := 1; i < maxChallengeAttempts; i++ {
+		usable, err := db.FailLoginChallenge(token.String())
+		if err != nil || !usable {
+			t.Fatal("Expected usable challenge, received", usable, err)
+		}
+	}
+
+	usable, _ := db.FailLoginChallenge(token.String())
+	if usable {
+		t.Fatal("Expected unusable challenge, received", usable)
+	}
+
+	_, err = db.GetLoginChallenge(token.String())
+	if err == nil {
+		t.Fatal("Expected error for removed challenge, received", nil)
+	}
+
+	// Expired challenges are not returned.
+	token, _ = db.CreateLoginChallenge(u1.UserId, -1)
+
+	_, err = db.GetLoginChallenge(token.String())
+	if err == nil {
+		t.Fatal("Expected error for expired challenge, received", nil)
+	}
+
+	// Deleted challenges are not returned.
+	token, _ = db.CreateLoginChallenge(u1.UserId, 300)
+	db.DeleteLoginChallenge(token.String())
+
+	_, err = db.GetLoginChallenge(token.String())
+	if err == nil {
+		t.Fatal("Expected error for deleted challenge, received", nil)
+	}
+
+	db.Close()
+}
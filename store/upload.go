@@ -0,0 +1,178 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//----------------------------------------------------------------------------
+// Upload Struct
+//----------------------------------------------------------------------------
+
+// Upload holds the metadata of an uploaded file. The content of the file is
+// kept by the upload storage backend under the upload id.
+type Upload struct {
+	UploadId    UploadToken `json:"upload_id"`
+	UserId      UserToken   `json:"user_id"`
+	Name        string      `json:"name"`
+	ContentType string      `json:"content_type"`
+	Size        int64       `json:"size"`
+	Created     int64       `json:"created"`
+}
+
+// Date returns the time of the upload formatted for display.
+func (u Upload) Date() string {
+	return time.Unix(u.Created, 0).UTC().Format(time.RFC1123)
+}
+
+// NewUpload creates a new Upload owned by the user identified by uid.
+func NewUpload(uid UserToken, name, contentType string, size int64) Upload {
+	return Upload{
+		UploadId:    NewUploadToken(),
+		UserId:      uid,
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		Created:     time.Now().Unix(),
+	}
+}
+
+//----------------------------------------------------------------------------
+// Upload Storage Methods
+//----------------------------------------------------------------------------
+
+// CreateUpload stores the metadata of an uploaded file.
+func (s *Store) CreateUpload(u Upload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Errorf("could not Store.CreateUpload: %v", err)
+	}
+
+	err = s.write(uploadBucket, u.UploadId.String(), data)
+	if err != nil {
+		return fmt.Errorf("could not Store.CreateUpload: %v", err)
+	}
+
+	return nil
+}
+
+// GetUpload takes an upload id string and returns the Upload associated with
+// it.
+func (s *Store) GetUpload(id string) (Upload, error) {
+	var u Upload
+
+	ut, err := parseUploadToken(id)
+	if err != nil {
+		return u, fmt.Errorf("could not Store.GetUpload: %v", err)
+	}
+
+	data := s.read(uploadBucket, ut.String())
+	if data == nil {
+		return u, fmt.Errorf("could not Store.GetUpload: upload %s not found", id)
+	}
+
+	err = json.Unmarshal(data, &u)
+	if err != nil {
+		return u, fmt.Errorf("could not Store.GetUpload: %v", err)
+	}
+
+	return u, nil
+}
+
+// ListUploads returns the uploads owned by the user identified by uid, the
+// newest first.
+func (s *Store) ListUploads(uid UserToken) ([]Upload, error) {
+	var uploads []Upload
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(uploadBucket)).ForEach(func(k, v []byte) error {
+			if !ownedBy(v, uid) {
+				return nil
+			}
+
+			var u Upload
+
+			err := json.Unmarshal(v, &u)
+			if err != nil {
+				return err
+			}
+
+			uploads = append(uploads, u)
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return uploads, fmt.Errorf("could not Store.ListUploads: %v", err)
+	}
+
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].Created > uploads[j].Created
+	})
+
+	return uploads, nil
+}
+
+// DeleteUpload removes the metadata of the upload identified by uid.
+func (s *Store) DeleteUpload(uid UploadToken) error {
+	err := s.delete(uploadBucket, uid.String())
+	if err != nil {
+		return fmt.Errorf("could not Store.DeleteUpload: %v", err)
+	}
+
+	return nil
+}
+
+//----------------------------------------------------------------------------
+// Blob Storage Methods
+//----------------------------------------------------------------------------
+
+// PutBlob stores the content of a file under the given key. It lets the
+// Store act as an upload storage backend.
+func (s *Store) PutBlob(key string, data []byte) error {
+	err := s.write(blobBucket, key, data)
+	if err != nil {
+		return fmt.Errorf("could not Store.PutBlob: %v", err)
+	}
+
+	return nil
+}
+
+// GetBlob returns the content of the file stored under the given key. The
+// content is copied because bbolt values are only valid within their
+// transaction.
+func (s *Store) GetBlob(key string) ([]byte, error) {
+	var data []byte
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		val := tx.Bucket([]byte(blobBucket)).Get([]byte(key))
+		if val == nil {
+			return fmt.Errorf("blob %s not found", key)
+		}
+
+		data = append([]byte{}, val...)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("could not Store.GetBlob: %v", err)
+	}
+
+	return data, nil
+}
+
+// DeleteBlob removes the content of the file stored under the given key.
+func (s *Store) DeleteBlob(key string) error {
+	err := s.delete(blobBucket, key)
+	if err != nil {
+		return fmt.Errorf("could not Store.DeleteBlob: %v", err)
+	}
+
+	return nil
+}
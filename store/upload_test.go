@@ -0,0 +1,110 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+var (
+	testUploadDbPath = "upload_test.db"
+)
+
+func testStoreUpload(t *testing.T) {
+	fmt.Println(t.Name())
+
+	u1 := NewUser(testUserAlias)
+	u2 := NewUser(testAliasOther)
+	db := newTestStore(t, testUploadDbPath)
+	defer deleteTestStore(t, testUploadDbPath)
+
+	db.CreateUser(u1, testUserPassphrase)
+	db.CreateUser(u2, testUserPassphrase)
+
+	// Create Upload
+	first := NewUpload(u1.UserId, "first.txt", "text/plain; charset=utf-8", 5)
+	second := NewUpload(u1.UserId, "second.png", "image/png", 10)
+	second.Created = first.Created + 1
+	other := NewUpload(u2.UserId, "other.txt", "text/plain; charset=utf-8", 3)
+
+	for _, up := range []Upload{first, second, other} {
+		err := db.CreateUpload(up)
+		if err != nil {
+			t.Fatal("Expected", nil, ", received", err)
+		}
+	}
+
+	// Get Upload
+	up, err := db.GetUpload(first.UploadId.String())
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if up.Name != first.Name || up.UserId != u1.UserId || up.Size != 5 {
+		t.Fatal("Expected", first, ", received", up)
+	}
+
+	_, err = db.GetUpload(NewUploadToken().String())
+	if err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+
+	_, err = db.GetUpload("../" + first.UploadId.String())
+	if err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+
+	// List Uploads returns the user's uploads, the newest first.
+	list, err := db.ListUploads(u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if len(list) != 2 || list[0].UploadId != second.UploadId || list[1].UploadId != first.UploadId {
+		t.Fatal("Expected second and first, received", list)
+	}
+
+	// Blobs
+	err = db.PutBlob(first.UploadId.String(), []byte("hello"))
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	data, err := db.GetBlob(first.UploadId.String())
+	if err != nil || string(data) != "hello" {
+		t.Fatal("Expected hello, received", string(data), err)
+	}
+
+	err = db.DeleteBlob(first.UploadId.String())
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	_, err = db.GetBlob(first.UploadId.String())
+	if err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+
+	// Delete Upload
+	err = db.DeleteUpload(first.UploadId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	list, _ = db.ListUploads(u1.UserId)
+	if len(list) != 1 {
+		t.Fatal("Expected", 1, ", received", len(list))
+	}
+
+	// Upload metadata is deleted with the user.
+	db.DeleteUser(u1)
+
+	list, _ = db.ListUploads(u1.UserId)
+	if len(list) != 0 {
+		t.Fatal("Expected", 0, ", received", len(list))
+	}
+
+	list, _ = db.ListUploads(u2.UserId)
+	if len(list) != 1 {
+		t.Fatal("Expected", 1, ", received", len(list))
+	}
+}
@@ -1,12 +1,17 @@
 package store
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	bolt "go.etcd.io/bbolt"
-	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/secure/precis"
 )
 
 //----------------------------------------------------------------------------
@@ -15,9 +20,16 @@ import (
 
 // User holds a single user account.
 type User struct {
-	UserId UserToken `json:"user_id"`
-	Alias  string    `json:"alias"`
-	Admin  bool      `json:"admin"`
+	UserId        UserToken `json:"user_id"`
+	Alias         string    `json:"alias"`
+	Roles         []string  `json:"roles"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	PendingEmail  string    `json:"pending_email"`
+	ResetRequired bool      `json:"reset_required"`
+	Pending       bool      `json:"pending"`
+	AliasFlagged  bool      `json:"alias_flagged"`
+	Created       int64     `json:"created"`
 }
 
 // bytes renders a User object as a JSON byte array.
@@ -32,18 +44,75 @@ func (u *User) bytes() ([]byte, error) {
 	return b, nil
 }
 
-// NewUser creates a new User object using the given alias and passphrase. The
-// passphrase is hashed and stored in the User object.
+// HasRole returns true if the user has been assigned the given role.
+func (u User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewUser creates a new User object using the canonical form of the given
+// alias. An alias without a canonical form is kept as given and refused when
+// the User is stored.
 func NewUser(alias string) User {
 	var u User
 
 	u.UserId = NewUserToken()
-	u.Alias = strings.ToLower(norm.NFKD.String(alias))
-	u.Admin = false
+	u.Alias = alias
+	u.Roles = []string{RoleUser}
+	u.Created = time.Now().Unix()
+
+	canonical, err := CanonicalAlias(alias)
+	if err == nil {
+		u.Alias = canonical
+	}
 
 	return u
 }
 
+// confusableScripts holds scripts with letters that look alike. An alias may
+// only use letters from one of them.
+var confusableScripts = []*unicode.RangeTable{unicode.Latin, unicode.Greek, unicode.Cyrillic}
+
+// CanonicalAlias returns the canonical form of the given alias, which is the
+// form used as a key in the Store. Every alias entered by a user must be
+// passed through it. The alias is prepared with the PRECIS UsernameCaseMapped
+// profile, which maps full width characters and case and rejects spaces,
+// control characters, and other disallowed code points. Aliases that mix
+// letters from confusable scripts, such as Latin and Cyrillic, are rejected.
+func CanonicalAlias(alias string) (string, error) {
+	canonical, err := precis.UsernameCaseMapped.String(alias)
+	if err != nil {
+		return "", fmt.Errorf("could not CanonicalAlias: %v", err)
+	}
+
+	if canonical == "" {
+		return "", fmt.Errorf("could not CanonicalAlias: alias is empty")
+	}
+
+	var script *unicode.RangeTable
+
+	for _, r := range canonical {
+		for _, t := range confusableScripts {
+			if !unicode.Is(t, r) {
+				continue
+			}
+
+			if script != nil && script != t {
+				return "", fmt.Errorf("could not CanonicalAlias: alias mixes scripts")
+			}
+
+			script = t
+		}
+	}
+
+	return canonical, nil
+}
+
 // NewUserFromBytes creates a new User object from a JSON byte array.
 func NewUserFromBytes(data []byte) (User, error) {
 	var user User
@@ -57,6 +126,22 @@ func NewUserFromBytes(data []byte) (User, error) {
 	return user, nil
 }
 
+// aliasOf returns the alias of the user identified by uid using the given
+// user bucket. The user id is returned if the user cannot be found.
+func aliasOf(b *bolt.Bucket, uid UserToken) string {
+	data := b.Get([]byte(uid.String()))
+	if data == nil {
+		return uid.String()
+	}
+
+	user, err := NewUserFromBytes(data)
+	if err != nil {
+		return uid.String()
+	}
+
+	return user.Alias
+}
+
 //----------------------------------------------------------------------------
 // User Storage Methods
 //----------------------------------------------------------------------------
@@ -65,99 +150,412 @@ func NewUserFromBytes(data []byte) (User, error) {
 // to create two keys, one to relate the alias to the user id and the other to
 // relate the user id to the User bytes.
 func (s *Store) CreateUser(u User, passphrase string) error {
-	userBytes, err := u.bytes()
+	hash, err := GenerateHash(passphrase)
 	if err != nil {
-		return fmt.Errorf("could not Store.CreateUser: %v", err)
+		return fmt.Errorf("could not Store.CreateUser: %w", err)
 	}
 
-	hash, err := GenerateHash(passphrase)
+	// Verify the alias is not reserved
+	if s.AliasReserved(u.Alias, u.UserId) {
+		return fmt.Errorf("could not Store.CreateUser: alias %s is reserved", u.Alias)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return putUser(tx, u, hash)
+	})
+
 	if err != nil {
 		return fmt.Errorf("could not Store.CreateUser: %v", err)
 	}
 
+	return nil
+}
+
+// ImportUser takes a User migrated from another system and creates it in the
+// Store with the passphrase hash it had there. The hash must be in the format
+// of a registered hash scheme, with parameters its HashChecker accepts, and
+// is replaced with an Argon2id hash the first time the user logs in.
+func (s *Store) ImportUser(u User, hash string) error {
+	err := CheckHashFormat(hash)
+	if err != nil {
+		return fmt.Errorf("could not Store.ImportUser: %v", err)
+	}
+
+	if s.AliasReserved(u.Alias, u.UserId) {
+		return fmt.Errorf("could not Store.ImportUser: alias %s is reserved", u.Alias)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return putUser(tx, u, hash)
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.ImportUser: %v", err)
+	}
+
+	return nil
+}
+
+// putUser stores a new User and their passphrase hash within the given
+// transaction. An error is returned if the alias already exists.
+func putUser(tx *bolt.Tx, u User, hash string) error {
+	userBytes, err := u.bytes()
+	if err != nil {
+		return err
+	}
+
+	// Only canonical aliases are stored.
+	canonical, err := CanonicalAlias(u.Alias)
+	if err != nil || canonical != u.Alias {
+		return fmt.Errorf("alias %s is not canonical", u.Alias)
+	}
+
+	b := tx.Bucket([]byte(userBucket))
+
 	// Verify the alias does not already exist
-	data := s.read(userBucket, u.Alias)
-	if data != nil {
-		return fmt.Errorf("could not Store.CreateUser: alias %s exists", u.Alias)
+	if b.Get([]byte(u.Alias)) != nil {
+		return fmt.Errorf("alias %s exists", u.Alias)
 	}
 
+	// Index the email address along with the user, so a user is not created
+	// with an address that is taken.
+	if u.Email != "" {
+		eb := tx.Bucket([]byte(emailBucket))
+
+		if u.Email != NormalizeEmail(u.Email) || eb.Get([]byte(u.Email)) != nil {
+			return fmt.Errorf("email %s exists or is not normalized", u.Email)
+		}
+
+		err = eb.Put([]byte(u.Email), []byte(u.UserId.String()))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Associate alias and user id
+	err = b.Put([]byte(u.Alias), []byte(u.UserId.String()))
+	if err != nil {
+		return err
+	}
+
+	// Associate user id and User bytes
+	err = b.Put([]byte(u.UserId.String()), userBytes)
+	if err != nil {
+		return err
+	}
+
+	// Store the user's password hash
+	key := fmt.Sprintf(hashKey, u.UserId.String())
+	err = b.Put([]byte(key), []byte(hash))
+	if err != nil {
+		return err
+	}
+
+	// Store the user's failed authentication count
+	key = fmt.Sprintf(failedKey, u.UserId.String())
+
+	return b.Put([]byte(key), uint64ToBytes(0))
+}
+
+// // SaveUser takes a User and updates it in the Store.
+// func (s *Store) SaveUser(u User) error {
+// 	userBytes, err := u.bytes()
+// 	if err != nil {
+// 		return fmt.Errorf("could not Store.SaveUser: %v", err)
+// 	}
+
+// 	return s.write(userBucket, u.UserId.String(), userBytes)
+// }
+
+// UserDeleter removes the data a module of the application keeps outside the
+// Store about the given user, such as files in an upload storage backend.
+type UserDeleter func(s *Store, u User) error
+
+// userDeleters holds the registered UserDeleter of each module by name.
+var (
+	userDeleters   = make(map[string]UserDeleter)
+	userDeletersMu sync.RWMutex
+)
+
+// RegisterUserDeleter runs the given UserDeleter whenever a user is deleted.
+// Registering a name twice replaces the earlier UserDeleter.
+func RegisterUserDeleter(name string, fn UserDeleter) {
+	userDeletersMu.Lock()
+	defer userDeletersMu.Unlock()
+
+	userDeleters[name] = fn
+}
+
+// DeleteUser takes a User and removes it from the Store. Each registered
+// UserDeleter is run first, and the user is kept if one of them fails. A
+// transaction is then used to delete the user's keys, email address,
+// sessions, pending verifications and resets, alias reservations,
+// organization memberships, activity, preferences, upload metadata, and
+// login challenges together. ErrLastManager is returned, and the user is
+// kept, if an organization would be left without a member who can manage it.
+func (s *Store) DeleteUser(u User) error {
+	uid := u.UserId.String()
+
+	// Check the organizations before the UserDeleters remove anything.
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return checkOrgsManaged(tx, u.UserId)
+	})
+	if err != nil {
+		return fmt.Errorf("could not Store.DeleteUser: %w", err)
+	}
+
+	userDeletersMu.RLock()
+	for name, fn := range userDeleters {
+		err := fn(s, u)
+		if err != nil {
+			userDeletersMu.RUnlock()
+			return fmt.Errorf("could not Store.DeleteUser: %s %v", name, err)
+		}
+	}
+	userDeletersMu.RUnlock()
+
 	err = s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(userBucket))
 
-		// Associate alias and user id
-		err = b.Put([]byte(u.Alias), []byte(u.UserId.String()))
+		err := checkOrgsManaged(tx, u.UserId)
+		if err != nil {
+			return err
+		}
+
+		// Use the stored User in case the given one is out of date.
+		data := b.Get([]byte(uid))
+		if data != nil {
+			stored, err := NewUserFromBytes(data)
+			if err != nil {
+				return err
+			}
+
+			u = stored
+		}
+
+		err = b.Delete([]byte(u.Alias))
 		if err != nil {
 			return err
 		}
 
-		// Associate user id and User bytes
-		err = b.Put([]byte(u.UserId.String()), userBytes)
+		if u.Email != "" {
+			err = tx.Bucket([]byte(emailBucket)).Delete([]byte(u.Email))
+			if err != nil {
+				return err
+			}
+		}
+
+		// Delete the User bytes and every key derived from the user id.
+		err = deleteMatching(b, func(k, v []byte) bool {
+			return bytes.Equal(k, []byte(uid)) || bytes.HasPrefix(k, []byte(uid+":"))
+		})
 		if err != nil {
 			return err
 		}
 
-		// Store the user's password hash
-		key := fmt.Sprintf(hashKey, u.UserId.String())
-		err = b.Put([]byte(key), []byte(hash))
+		err = deleteMatching(tx.Bucket([]byte(activityBucket)), func(k, v []byte) bool {
+			return bytes.HasPrefix(k, []byte(fmt.Sprintf(activityPrefix, uid)))
+		})
 		if err != nil {
 			return err
 		}
 
-		// Store the user's failed authentication count
-		key = fmt.Sprintf(failedKey, u.UserId.String())
-		err = b.Put([]byte(key), uint64ToBytes(0))
+		err = deleteMatching(tx.Bucket([]byte(prefBucket)), func(k, v []byte) bool {
+			return bytes.HasPrefix(k, []byte(fmt.Sprintf(prefPrefix, uid)))
+		})
 		if err != nil {
 			return err
 		}
 
+		owned := []string{sessBucket, verifyBucket, resetBucket, reservedBucket, memberBucket, uploadBucket, chalBucket, credBucket, ceremonyBucket}
+		for _, name := range owned {
+			err = deleteMatching(tx.Bucket([]byte(name)), func(k, v []byte) bool {
+				return ownedBy(v, u.UserId)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
 		return nil
 	})
 
 	if err != nil {
-		return fmt.Errorf("could not Store.CreateUser: %v", err)
+		return fmt.Errorf("could not Store.DeleteUser: %w", err)
 	}
 
-	// Add the user's passwordHash to the store.
-
 	return nil
 }
 
-// // SaveUser takes a User and updates it in the Store.
-// func (s *Store) SaveUser(u User) error {
-// 	userBytes, err := u.bytes()
-// 	if err != nil {
-// 		return fmt.Errorf("could not Store.SaveUser: %v", err)
-// 	}
+// updateUser applies the given change to the User identified by uid. A
+// transaction is used so the User is read and written without interference.
+func (s *Store) updateUser(uid UserToken, change func(u *User)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(userBucket))
 
-// 	return s.write(userBucket, u.UserId.String(), userBytes)
-// }
+		data := b.Get([]byte(uid.String()))
+		if data == nil {
+			return fmt.Errorf("user %s not found", uid)
+		}
 
-// DeleteUser takes a User and removes it from the Store. A transaction is
-// used to delete both keys associated with the user.
-func (s *Store) DeleteUser(u User) error {
+		user, err := NewUserFromBytes(data)
+		if err != nil {
+			return err
+		}
+
+		change(&user)
+
+		userBytes, err := user.bytes()
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(uid.String()), userBytes)
+	})
+}
+
+// SetPasswordResetRequired sets whether the user identified by uid must
+// change their passphrase before using the site.
+func (s *Store) SetPasswordResetRequired(uid UserToken, required bool) error {
+	err := s.updateUser(uid, func(u *User) {
+		u.ResetRequired = required
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not Store.SetPasswordResetRequired: %v", err)
+	}
+
+	return nil
+}
+
+// ApproveUser allows the pending user identified by uid to log in. An error
+// is returned if the user is not awaiting approval.
+func (s *Store) ApproveUser(uid UserToken) error {
 	err := s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(userBucket))
 
-		err := b.Delete([]byte(u.UserId.String()))
+		data := b.Get([]byte(uid.String()))
+		if data == nil {
+			return fmt.Errorf("user %s not found", uid)
+		}
+
+		user, err := NewUserFromBytes(data)
 		if err != nil {
 			return err
 		}
 
-		err = b.Delete([]byte(u.Alias))
+		if !user.Pending {
+			return fmt.Errorf("user %s is not pending", uid)
+		}
+
+		user.Pending = false
+
+		userBytes, err := user.bytes()
 		if err != nil {
 			return err
 		}
 
-		return nil
+		return b.Put([]byte(uid.String()), userBytes)
 	})
 
 	if err != nil {
-		return fmt.Errorf("could not Store.DeleteUser: %v", err)
+		return fmt.Errorf("could not Store.ApproveUser: %v", err)
 	}
 
 	return nil
 }
 
+// ListPendingUsers returns the users awaiting approval, sorted by alias.
+func (s *Store) ListPendingUsers() ([]User, error) {
+	var users []User
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return eachUser(tx, func(user User) {
+			if user.Pending {
+				users = append(users, user)
+			}
+		})
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("could not Store.ListPendingUsers: %v", err)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Alias < users[j].Alias
+	})
+
+	return users, nil
+}
+
+// eachUser calls fn with every User in the given transaction.
+func eachUser(tx *bolt.Tx, fn func(u User)) error {
+	c := tx.Bucket([]byte(userBucket)).Cursor()
+
+	// User bytes are keyed by the user id alone. Other user id keys have a
+	// suffix.
+	prefix := []byte(userTokenPrefix)
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		_, err := parseUserToken(string(k))
+		if err != nil {
+			continue
+		}
+
+		user, err := NewUserFromBytes(v)
+		if err != nil {
+			return err
+		}
+
+		fn(user)
+	}
+
+	return nil
+}
+
+// ListUsers returns the users whose alias or email address contains the given
+// query, sorted by alias. At most limit users are returned, starting at
+// offset. The total number of matching users is also returned.
+func (s *Store) ListUsers(query string, offset, limit int) ([]User, int, error) {
+	var users []User
+
+	// Partial aliases may not have a canonical form.
+	canonical, err := CanonicalAlias(query)
+	if err == nil {
+		query = canonical
+	} else {
+		query = strings.ToLower(query)
+	}
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return eachUser(tx, func(user User) {
+			if strings.Contains(user.Alias, query) || strings.Contains(user.Email, query) {
+				users = append(users, user)
+			}
+		})
+	})
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not Store.ListUsers: %v", err)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Alias < users[j].Alias
+	})
+
+	total := len(users)
+
+	if offset > total {
+		offset = total
+	}
+
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+
+	return users[offset:end], total, nil
+}
+
 // GetUser takes a UserToken and returns the user associated with it.
 func (s *Store) GetUser(uid UserToken) (User, error) {
 	var user User
@@ -179,10 +577,28 @@ func (s *Store) GetUser(uid UserToken) (User, error) {
 	return user, nil
 }
 
-// GetUserByAlias takes an alias and returns the User associated with it.
+// GetUserById takes a user id string and returns the User associated with it.
+func (s *Store) GetUserById(id string) (User, error) {
+	var user User
+
+	token, err := parseUserToken(id)
+	if err != nil {
+		return user, fmt.Errorf("could not Store.GetUserById: %v", err)
+	}
+
+	return s.GetUser(token)
+}
+
+// GetUserByAlias takes an alias and returns the User associated with its
+// canonical form.
 func (s *Store) GetUserByAlias(alias string) (User, error) {
 	var user User
 
+	alias, err := CanonicalAlias(alias)
+	if err != nil {
+		return user, fmt.Errorf("could not Store.GetUserByAlias: %v", err)
+	}
+
 	data := s.read(userBucket, alias)
 	if data == nil {
 		return user, fmt.Errorf("could not Store.GetUserByAlias: alias %s not found", alias)
@@ -196,8 +612,14 @@ func (s *Store) GetUserByAlias(alias string) (User, error) {
 	return s.GetUser(token)
 }
 
-// UserExists returns true if the given user is already registered.
+// UserExists returns true if the canonical form of the given alias is
+// already registered.
 func (s *Store) UserExists(un string) bool {
+	un, err := CanonicalAlias(un)
+	if err != nil {
+		return false
+	}
+
 	data := s.read(userBucket, un)
 
 	// A nil result means the user does not exist.
@@ -2,7 +2,10 @@ package store
 
 import (
 	"fmt"
+	"strings"
 	"testing"
+
+	bolt "go.etcd.io/bbolt"
 )
 
 var (
@@ -12,7 +15,7 @@ var (
 )
 
 func testUserEqual(t *testing.T, u1, u2 User) {
-	if (u1.UserId != u2.UserId) || (u1.Alias != u2.Alias) || (u1.Admin != u2.Admin) {
+	if (u1.UserId != u2.UserId) || (u1.Alias != u2.Alias) || (strings.Join(u1.Roles, ",") != strings.Join(u2.Roles, ",")) {
 		t.Fatal("Expected", u1, ", received", u2)
 	}
 }
@@ -21,8 +24,8 @@ func TestUser(t *testing.T) {
 	fmt.Println(t.Name())
 
 	u1 := NewUser(testUserAlias)
-	if u1.Admin {
-		t.Fatal("Expected", false, ", received", u1.Admin)
+	if !u1.HasRole(RoleUser) || u1.HasRole(RoleAdmin) {
+		t.Fatal("Expected", []string{RoleUser}, ", received", u1.Roles)
 	}
 
 	bytes, err := u1.bytes()
@@ -38,6 +41,59 @@ func TestUser(t *testing.T) {
 	testUserEqual(t, u1, u2)
 }
 
+func TestCanonicalAlias(t *testing.T) {
+	fmt.Println(t.Name())
+
+	valid := map[string]string{
+		"User1234":                       "user1234",
+		"\uff35\uff33\uff25\uff32\uff11\uff12\uff13\uff14":                       "user1234",
+		"jose\u0301":                     "jos\u00e9",
+		"\u03a9\u03bc\u03ad\u03b3\u03b1": "\u03c9\u03bc\u03ad\u03b3\u03b1",
+	}
+
+	for alias, expected := range valid {
+		canonical, err := CanonicalAlias(alias)
+		if err != nil || canonical != expected {
+			t.Fatal("Expected", expected, ", received", canonical, err)
+		}
+	}
+
+	// Spaces, control and invisible characters, and mixed scripts are
+	// rejected. The fourth alias uses a Cyrillic a.
+	invalid := []string{"", "user 1234", "user\u00071234", "p\u0430ypal", "user\u200b1234"}
+
+	for _, alias := range invalid {
+		_, err := CanonicalAlias(alias)
+		if err == nil {
+			t.Fatal("Expected error for", alias, ", received nil")
+		}
+	}
+}
+
+func TestAliasSkeleton(t *testing.T) {
+	// Whole script lookalikes, marks, digits, and letter runs share the
+	// skeleton of the Latin alias. The first uses a Cyrillic a and the
+	// second Greek omicrons.
+	alike := map[string]string{
+		"\u0430dmin":     "admin",
+		"r\u03bf\u03bft": "root",
+		"ADMIN":          "admin",
+		"adm\u00efn":     "admin",
+		"r00t":           "root",
+		"rnodern":        "modem",
+	}
+
+	for a, b := range alike {
+		if AliasSkeleton(a) != AliasSkeleton(b) {
+			t.Fatal("Expected", a, "to look like", b, ", received", AliasSkeleton(a), AliasSkeleton(b))
+		}
+	}
+
+	if AliasSkeleton("admin") == AliasSkeleton("admins") {
+		t.Fatal("Expected admin and admins to differ")
+	}
+}
+
 func testStoreUser(t *testing.T) {
 	fmt.Println(t.Name())
 
@@ -81,6 +137,38 @@ func testStoreUser(t *testing.T) {
 
 	testUserEqual(t, u1, u3)
 
+	// Get User by Id
+	u4, err := s.GetUserById(u1.UserId.String())
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	testUserEqual(t, u1, u4)
+
+	_, err = s.GetUserById(testUserAlias)
+	if err == nil {
+		t.Fatal("Expected error, received", nil)
+	}
+
+	// Users with an alias that has no canonical form cannot be stored.
+	err = s.CreateUser(NewUser("user 1234"), testUserPassphrase)
+	if err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+
+	// Aliases are found by any form with the same canonical form.
+	m1 := NewUser("Mixed1234")
+	s.CreateUser(m1, testUserPassphrase)
+
+	m2, err := s.GetUserByAlias("MIXED1234")
+	if err != nil || m2.UserId != m1.UserId {
+		t.Fatal("Expected", m1.UserId, ", received", m2.UserId, err)
+	}
+
+	if !s.UserExists("mixed1234") || !s.UserExists("\uff2d\uff29\uff38\uff25\uff24\uff11\uff12\uff13\uff14") {
+		t.Fatal("Expected", true, ", received", false)
+	}
+
 	// Delete User
 	err = s.DeleteUser(u1)
 	if err != nil {
@@ -93,3 +181,163 @@ func testStoreUser(t *testing.T) {
 
 	s.Close()
 }
+
+func testStoreUserManagement(t *testing.T) {
+	fmt.Println(t.Name())
+
+	s := newTestStore(t, testUserDbPath)
+	defer deleteTestStore(t, testUserDbPath)
+
+	aliases := []string{"carol", "alice", "bob", "alicia"}
+	for _, a := range aliases {
+		s.CreateUser(NewUser(a), testUserPassphrase)
+	}
+
+	// List Users
+	users, total, err := s.ListUsers("", 0, 10)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if total != 4 || len(users) != 4 || users[0].Alias != "alice" || users[3].Alias != "carol" {
+		t.Fatal("Expected 4 sorted users, received", total, users)
+	}
+
+	users, total, _ = s.ListUsers("ALI", 0, 10)
+	if total != 2 || users[0].Alias != "alice" || users[1].Alias != "alicia" {
+		t.Fatal("Expected alice and alicia, received", users)
+	}
+
+	users, total, _ = s.ListUsers("", 2, 10)
+	if total != 4 || len(users) != 2 || users[0].Alias != "bob" {
+		t.Fatal("Expected second page, received", users)
+	}
+
+	users, _, _ = s.ListUsers("", 10, 10)
+	if len(users) != 0 {
+		t.Fatal("Expected", 0, ", received", len(users))
+	}
+
+	u1, _ := s.GetUserByAlias("bob")
+
+	// Set User Roles
+	err = s.SetUserRoles(u1.UserId, []string{RoleUser, RoleAdmin})
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	u2, _ := s.GetUser(u1.UserId)
+	if !u2.HasRole(RoleAdmin) {
+		t.Fatal("Expected", RoleAdmin, ", received", u2.Roles)
+	}
+
+	// Require a password reset, which is cleared by changing the password.
+	err = s.SetPasswordResetRequired(u1.UserId, true)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	u2, _ = s.GetUser(u1.UserId)
+	if !u2.ResetRequired {
+		t.Fatal("Expected", true, ", received", u2.ResetRequired)
+	}
+
+	s.ChangeUserPassword(u1.UserId, testUserPassphrase)
+
+	u2, _ = s.GetUser(u1.UserId)
+	if u2.ResetRequired || !u2.HasRole(RoleAdmin) {
+		t.Fatal("Expected reset cleared and admin kept, received", u2)
+	}
+
+	// Delete User removes everything belonging to the user.
+	s.SetUserEmail(u1.UserId, "bob@example.com")
+	s.ChangeUserAlias(u1.UserId, "robert", u1.UserId, 60)
+	s.AddActivity(u1.UserId, testActivityAction, "")
+	s.CreatePasswordReset(u1.UserId, 60)
+	sess, _ := NewSession(u1.UserId, 60)
+	s.CreateSession(sess)
+
+	err = s.DeleteUser(u1)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	for _, name := range storeBuckets {
+		data := s.read(name, u1.UserId.String())
+		if data != nil {
+			t.Fatal("Expected no user data in", name, ", received", string(data))
+		}
+	}
+
+	if s.UserExists("robert") || s.EmailExists("bob@example.com") || s.AliasReserved("bob", NewUserToken()) {
+		t.Fatal("Expected user keys to be removed, but they were not.")
+	}
+
+	for _, name := range storeBuckets {
+		s.db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(name)).ForEach(func(k, v []byte) error {
+				if strings.Contains(string(k), u1.UserId.String()) || ownedBy(v, u1.UserId) {
+					t.Fatal("Expected no user data in", name, ", received", string(k))
+				}
+
+				return nil
+			})
+		})
+	}
+
+	users, total, _ = s.ListUsers("", 0, 10)
+	if total != 3 {
+		t.Fatal("Expected", 3, ", received", total)
+	}
+
+	s.Close()
+}
+
+func testStoreUserApproval(t *testing.T) {
+	fmt.Println(t.Name())
+
+	s := newTestStore(t, testUserDbPath)
+	defer deleteTestStore(t, testUserDbPath)
+
+	u1 := NewUser("pending")
+	u1.Pending = true
+	s.CreateUser(u1, testUserPassphrase)
+	s.CreateUser(NewUser("approved"), testUserPassphrase)
+
+	// List Pending Users
+	users, err := s.ListPendingUsers()
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	if len(users) != 1 || users[0].UserId != u1.UserId {
+		t.Fatal("Expected", u1.Alias, ", received", users)
+	}
+
+	// Approve User
+	err = s.ApproveUser(u1.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	u2, _ := s.GetUser(u1.UserId)
+	if u2.Pending {
+		t.Fatal("Expected", false, ", received", u2.Pending)
+	}
+
+	users, _ = s.ListPendingUsers()
+	if len(users) != 0 {
+		t.Fatal("Expected", 0, ", received", len(users))
+	}
+
+	// Users who are not pending cannot be approved again.
+	err = s.ApproveUser(u1.UserId)
+	if err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+
+	err = s.ApproveUser(NewUserToken())
+	if err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+}
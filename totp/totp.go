@@ -0,0 +1,107 @@
+// Package totp implements the time-based one-time passwords described in RFC
+// 6238, using the defaults understood by authenticator apps: HMAC-SHA1,
+// six digit codes, and a 30 second period.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	SecretSize = 20 // bytes, the size of the SHA-1 output
+	Digits     = 6
+	Period     = 30 // seconds
+	Skew       = 1  // periods before and after the current one accepted
+)
+
+// encoding is the Base32 encoding used for secrets by authenticator apps.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a random secret.
+func NewSecret() []byte {
+	secret := make([]byte, SecretSize)
+	rand.Read(secret)
+
+	return secret
+}
+
+// Encode returns the secret in the Base32 form typed into authenticator apps.
+func Encode(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// Counter returns the number of periods between the Unix epoch and the given
+// time.
+func Counter(t time.Time) uint64 {
+	return uint64(t.Unix()) / Period
+}
+
+// Code returns the code for the given counter.
+func Code(secret []byte, counter uint64) string {
+	return code(secret, counter, Digits)
+}
+
+// code returns the HOTP value of the counter with the given number of digits
+// as described in RFC 4226.
+func code(secret []byte, counter uint64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod = mod * 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// Validate checks the code against the periods around the given time and
+// returns the counter of the period it matched. Spaces in the code are
+// ignored.
+func Validate(secret []byte, code string, t time.Time) (uint64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	now := Counter(t)
+	for c := now - Skew; c <= now+Skew; c++ {
+		if subtle.ConstantTimeCompare([]byte(Code(secret, c)), []byte(code)) == 1 {
+			return c, true
+		}
+	}
+
+	return 0, false
+}
+
+// URI returns the otpauth URI encoded in the QR code scanned by
+// authenticator apps. The issuer names the site and the account names the
+// user.
+func URI(issuer, account string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", Encode(secret))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
@@ -0,0 +1,83 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSecret is the SHA-1 secret of the RFC 6238 test vectors.
+var testSecret = []byte("12345678901234567890")
+
+// TestCode checks the SHA-1 test vectors in appendix B of RFC 6238.
+func TestCode(t *testing.T) {
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+
+	for secs, expected := range vectors {
+		counter := Counter(time.Unix(secs, 0))
+
+		received := code(testSecret, counter, 8)
+		if received != expected {
+			t.Fatal("Expected", expected, ", received", received)
+		}
+
+		received = Code(testSecret, counter)
+		if received != expected[2:] {
+			t.Fatal("Expected", expected[2:], ", received", received)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	counter := Counter(now)
+
+	// Codes from the current period and the periods on either side are
+	// accepted.
+	for _, c := range []uint64{counter - 1, counter, counter + 1} {
+		matched, ok := Validate(testSecret, Code(testSecret, c), now)
+		if !ok || matched != c {
+			t.Fatal("Expected", c, ", received", matched, ok)
+		}
+	}
+
+	// Codes from further away are rejected.
+	for _, c := range []uint64{counter - 2, counter + 2} {
+		_, ok := Validate(testSecret, Code(testSecret, c), now)
+		if ok {
+			t.Fatal("Expected code for", c, "to be rejected")
+		}
+	}
+
+	// Spaces are ignored and malformed codes are rejected.
+	code := Code(testSecret, counter)
+	if _, ok := Validate(testSecret, code[:3]+" "+code[3:], now); !ok {
+		t.Fatal("Expected code with a space to be accepted")
+	}
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := Validate(testSecret, code, now); ok {
+			t.Fatal("Expected", code, "to be rejected")
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("WASP", "user 1234", testSecret)
+
+	expected := "otpauth://totp/WASP:user%201234?"
+	if !strings.HasPrefix(uri, expected) {
+		t.Fatal("Expected", expected, ", received", uri)
+	}
+
+	if !strings.Contains(uri, "secret="+Encode(testSecret)) {
+		t.Fatal("Expected secret in", uri)
+	}
+}
@@ -0,0 +1,110 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/store"
+	"github.com/asggo/wasp/totp"
+)
+
+var testTwoFactorDbPath = "twofactor_test.db"
+
+// TestTwoFactorConfirm verifies new recovery codes are only generated with
+// the passphrase, and passkeys are only added and two-factor authentication
+// only disabled with the passphrase and a current code. Wrong ones count as
+// failed logins.
+func TestTwoFactorConfirm(t *testing.T) {
+	cfg := config.NewConfiguration()
+
+	s, err := store.NewStore(testTwoFactorDbPath)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+	defer os.Remove(testTwoFactorDbPath)
+	defer s.Close()
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	s.SetSecretKey(key)
+
+	u := newManageUser(t, &s, "disable1234", store.RoleUser)
+
+	srv := newManageServer(&cfg, &s)
+	defer srv.Close()
+
+	pc := newPasskeyClient(t, srv)
+	pc.do("/account/login", url.Values{"username": {u.Alias}, "password": {testManagePass}})
+
+	secret, err := s.PendingTOTPSecret(u.UserId)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	counter := totp.Counter(time.Now())
+
+	err = s.EnableTOTP(u.UserId, totp.Code(secret, counter-1))
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	_, body := pc.do("/site/user/2fa", nil)
+	csrf := testCSRFPattern.FindStringSubmatch(body)[1]
+
+	// New recovery codes need the passphrase.
+	_, body = pc.do("/site/user/2fa/recovery", url.Values{"csrf": {csrf}, "password": {"wrongpassword12"}})
+	if !strings.Contains(body, "Invalid credentials.") {
+		t.Fatal("Expected invalid credentials, received", body)
+	}
+
+	_, body = pc.do("/site/user/2fa/recovery", url.Values{"csrf": {csrf}, "password": {testManagePass}})
+	if !strings.Contains(body, "New recovery codes have been generated.") {
+		t.Fatal("Expected new recovery codes, received", body)
+	}
+
+	// Adding a passkey needs a code as well as the passphrase.
+	_, body = pc.do("/site/user/passkeys/options", url.Values{"csrf": {csrf}, "password": {testManagePass}})
+	if !strings.Contains(body, `"error":"Invalid code."`) {
+		t.Fatal("Expected an invalid code, received", body)
+	}
+
+	_, body = pc.do("/site/user/passkeys/options", url.Values{"csrf": {csrf}, "password": {testManagePass}, "code": {totp.Code(secret, counter)}})
+	if !strings.Contains(body, `"ceremony"`) {
+		t.Fatal("Expected a ceremony, received", body)
+	}
+
+	// The passphrase alone does not disable it.
+	_, body = pc.do("/site/user/2fa/disable", url.Values{"csrf": {csrf}, "password": {testManagePass}})
+	if !strings.Contains(body, "Invalid code.") {
+		t.Fatal("Expected an invalid code, received", body)
+	}
+
+	// A code with the wrong passphrase is not either.
+	_, body = pc.do("/site/user/2fa/disable", url.Values{"csrf": {csrf}, "password": {"wrongpassword12"}, "code": {totp.Code(secret, counter+1)}})
+	if !strings.Contains(body, "Invalid credentials.") {
+		t.Fatal("Expected invalid credentials, received", body)
+	}
+
+	if failed, _ := s.GetFailedAuthCount(u.UserId); failed != 4 {
+		t.Fatal("Expected", 4, "failed logins, received", failed)
+	}
+
+	if ok, _ := s.TOTPEnabled(u.UserId); !ok {
+		t.Fatal("Expected TOTP to be enabled")
+	}
+
+	// Both disable it.
+	_, body = pc.do("/site/user/2fa/disable", url.Values{"csrf": {csrf}, "password": {testManagePass}, "code": {totp.Code(secret, counter+1)}})
+	if !strings.Contains(body, "Two-factor authentication has been disabled.") {
+		t.Fatal("Expected TOTP to be disabled, received", body)
+	}
+
+	if ok, _ := s.TOTPEnabled(u.UserId); ok {
+		t.Fatal("Expected TOTP to be disabled")
+	}
+}
@@ -0,0 +1,210 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	s3Algorithm  = "AWS4-HMAC-SHA256"
+	s3DateFormat = "20060102T150405Z"
+	s3Service    = "s3"
+	s3Terminator = "aws4_request"
+)
+
+// S3Config holds the settings needed to reach an S3 compatible service.
+// Objects are addressed with path style URLs, so the Endpoint is the address
+// of the service itself, such as https://s3.us-east-1.amazonaws.com.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+//----------------------------------------------------------------------------
+// S3Storage
+//----------------------------------------------------------------------------
+
+// s3Storage keeps files as objects in a bucket of an S3 compatible service.
+// Requests are signed with AWS Signature Version 4.
+type s3Storage struct {
+	cfg    S3Config
+	client *http.Client
+}
+
+// url returns the path style URL of the object stored under the given key.
+func (s s3Storage) url(key string) string {
+	return fmt.Sprintf("%s/%s/%s", s.cfg.Endpoint, url.PathEscape(s.cfg.Bucket), url.PathEscape(key))
+}
+
+// do signs and sends a request for the object stored under the given key and
+// returns the response body if the service accepted the request.
+func (s s3Storage) do(method, key string, body []byte) ([]byte, int, error) {
+	err := checkKey(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequest(method, s.url(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	signS3Request(req, body, s.cfg.Region, s.cfg.AccessKey, s.cfg.SecretKey, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, resp.StatusCode, fmt.Errorf("%s %s returned %s", method, key, resp.Status)
+	}
+
+	return data, resp.StatusCode, nil
+}
+
+// Put uploads the file as an object.
+func (s s3Storage) Put(key string, data []byte) error {
+	_, _, err := s.do(http.MethodPut, key, data)
+	if err != nil {
+		return fmt.Errorf("could not S3Storage.Put: %v", err)
+	}
+
+	return nil
+}
+
+// Get downloads the object stored under the given key.
+func (s s3Storage) Get(key string) ([]byte, error) {
+	data, _, err := s.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not S3Storage.Get: %v", err)
+	}
+
+	return data, nil
+}
+
+// Delete removes the object stored under the given key. Removing an object
+// that does not exist is not an error.
+func (s s3Storage) Delete(key string) error {
+	_, status, err := s.do(http.MethodDelete, key, nil)
+	if err != nil && status != http.StatusNotFound {
+		return fmt.Errorf("could not S3Storage.Delete: %v", err)
+	}
+
+	return nil
+}
+
+// NewS3Storage creates a Storage that keeps files in the bucket described by
+// the given S3Config.
+func NewS3Storage(c S3Config) (Storage, error) {
+	if c.Endpoint == "" || c.Region == "" || c.Bucket == "" {
+		return nil, fmt.Errorf("could not NewS3Storage: endpoint, region, and bucket are required")
+	}
+
+	if c.AccessKey == "" || c.SecretKey == "" {
+		return nil, fmt.Errorf("could not NewS3Storage: access key and secret key are required")
+	}
+
+	c.Endpoint = strings.TrimSuffix(c.Endpoint, "/")
+
+	return s3Storage{cfg: c, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+//----------------------------------------------------------------------------
+// Signature Version 4
+//----------------------------------------------------------------------------
+
+// signS3Request adds the headers that authenticate the request with AWS
+// Signature Version 4. The payload hash is sent in the x-amz-content-sha256
+// header, so only the host and the x-amz headers are signed.
+func signS3Request(req *http.Request, body []byte, region, accessKey, secretKey string, now time.Time) {
+	sum := sha256.Sum256(body)
+
+	req.Header.Set("X-Amz-Date", now.UTC().Format(s3DateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	scope := s3Scope(req.Header.Get("X-Amz-Date"), region)
+	sig := s3Signature(req, signed, scope, secretKey)
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, accessKey, scope, strings.Join(signed, ";"), sig))
+}
+
+// s3Scope returns the credential scope of a request made at the given
+// X-Amz-Date in the given region.
+func s3Scope(amzDate, region string) string {
+	if len(amzDate) < 8 {
+		return ""
+	}
+
+	return strings.Join([]string{amzDate[:8], region, s3Service, s3Terminator}, "/")
+}
+
+// s3Signature returns the hex encoded signature of the request over the
+// given signed headers and credential scope.
+func s3Signature(req *http.Request, signed []string, scope, secretKey string) string {
+	sort.Strings(signed)
+
+	var headers strings.Builder
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		}
+
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		headers.String(),
+		strings.Join(signed, ";"),
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{
+		s3Algorithm,
+		req.Header.Get("X-Amz-Date"),
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = s3HMAC(key, part)
+	}
+
+	return hex.EncodeToString(s3HMAC(key, toSign))
+}
+
+// s3HMAC returns the HMAC-SHA256 of the data with the given key.
+func s3HMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,141 @@
+package upload
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3Error is the body of an error response from the stand-in, in the format
+// used by S3.
+var s3Error = `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`
+
+// s3StandIn is an in memory service that implements the small part of the
+// S3 API used by s3Storage. Every request must be signed with the configured
+// keys.
+type s3StandIn struct {
+	accessKey string
+	secretKey string
+
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+// fail writes an S3 error response.
+func (s *s3StandIn) fail(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, s3Error, code, msg)
+}
+
+// verify checks the signature of the request and that the body matches the
+// payload hash. It returns the body if the request is authentic.
+func (s *s3StandIn) verify(r *http.Request) ([]byte, error) {
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), s3Algorithm+" ")
+
+	fields := make(map[string]string)
+	for _, field := range strings.Split(auth, ", ") {
+		name, value, ok := strings.Cut(field, "=")
+		if ok {
+			fields[name] = value
+		}
+	}
+
+	akey, scope, _ := strings.Cut(fields["Credential"], "/")
+	if subtle.ConstantTimeCompare([]byte(akey), []byte(s.accessKey)) != 1 {
+		return nil, fmt.Errorf("unknown access key")
+	}
+
+	date, err := time.Parse(s3DateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil || time.Since(date).Abs() > 15*time.Minute {
+		return nil, fmt.Errorf("request time is invalid")
+	}
+
+	region := strings.Split(scope, "/")
+	if len(region) != 4 || scope != s3Scope(r.Header.Get("X-Amz-Date"), region[1]) {
+		return nil, fmt.Errorf("credential scope is invalid")
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	for _, name := range []string{"host", "x-amz-content-sha256", "x-amz-date"} {
+		if !slices.Contains(signed, name) {
+			return nil, fmt.Errorf("%s is not signed", name)
+		}
+	}
+
+	sig := s3Signature(r, signed, scope, s.secretKey)
+	if subtle.ConstantTimeCompare([]byte(sig), []byte(fields["Signature"])) != 1 {
+		return nil, fmt.Errorf("signature does not match")
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != r.Header.Get("X-Amz-Content-Sha256") {
+		return nil, fmt.Errorf("payload hash does not match")
+	}
+
+	return body, nil
+}
+
+// ServeHTTP stores, returns, and removes objects named by the request path.
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := s.verify(r)
+	if err != nil {
+		s.fail(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	name := r.URL.Path
+
+	switch r.Method {
+	case http.MethodPut:
+		s.mu.Lock()
+		s.objects[name] = body
+		s.mu.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		s.mu.RLock()
+		data, ok := s.objects[name]
+		s.mu.RUnlock()
+
+		if !ok {
+			s.fail(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.objects, name)
+		s.mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The method is not allowed.")
+	}
+}
+
+// NewS3StandIn creates an http.Handler that stands in for an S3 compatible
+// service during development and testing. Objects are kept in memory and
+// requests must be signed with the given keys. Serve it with httptest or
+// http.ListenAndServe and point S3Endpoint at its address.
+func NewS3StandIn(accessKey, secretKey string) http.Handler {
+	return &s3StandIn{
+		accessKey: accessKey,
+		secretKey: secretKey,
+		objects:   make(map[string][]byte),
+	}
+}
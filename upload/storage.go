@@ -0,0 +1,227 @@
+package upload
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/store"
+)
+
+// keyPattern matches the keys files are stored under. Keys are upload ids, so
+// they never contain path separators.
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,128}$`)
+
+// checkKey returns an error if the key is not a valid storage key.
+func checkKey(key string) error {
+	if !keyPattern.MatchString(key) {
+		return fmt.Errorf("invalid key %q", key)
+	}
+
+	return nil
+}
+
+// Storage keeps the content of uploaded files. The metadata of each file is
+// kept in the Store. Implement this interface to keep files in another
+// service.
+type Storage interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+// NewStorage creates the Storage selected by the UploadBackend setting of the
+// given Config.
+func NewStorage(c *config.Config, s *store.Store) (Storage, error) {
+	switch c.UploadBackend {
+	case config.UploadLocal:
+		return NewLocalStorage(c.UploadDir)
+	case config.UploadBolt:
+		return NewBoltStorage(s), nil
+	case config.UploadS3:
+		return NewS3Storage(S3Config{
+			Endpoint:  c.S3Endpoint,
+			Region:    c.S3Region,
+			Bucket:    c.S3Bucket,
+			AccessKey: c.S3AccessKey,
+			SecretKey: c.S3SecretKey,
+		})
+	}
+
+	return nil, fmt.Errorf("could not NewStorage: unknown backend %s", c.UploadBackend)
+}
+
+// Delete removes the content and the metadata of the given upload.
+func Delete(s *store.Store, fs Storage, u store.Upload) error {
+	err := fs.Delete(u.UploadId.String())
+	if err != nil {
+		return fmt.Errorf("could not Delete: %v", err)
+	}
+
+	err = s.DeleteUpload(u.UploadId)
+	if err != nil {
+		return fmt.Errorf("could not Delete: %v", err)
+	}
+
+	return nil
+}
+
+// RegisterUserDeleter removes the uploads of every deleted user from the
+// given Storage.
+func RegisterUserDeleter(fs Storage) {
+	store.RegisterUserDeleter("uploads", func(s *store.Store, u store.User) error {
+		uploads, err := s.ListUploads(u.UserId)
+		if err != nil {
+			return err
+		}
+
+		for _, up := range uploads {
+			err = Delete(s, fs, up)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+//----------------------------------------------------------------------------
+// LocalStorage
+//----------------------------------------------------------------------------
+
+// localStorage keeps files in a directory on the local file system.
+type localStorage struct {
+	dir string
+}
+
+// path returns the path of the file stored under the given key.
+func (l localStorage) path(key string) (string, error) {
+	err := checkKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(l.dir, key), nil
+}
+
+// Put writes the file to a temporary file and renames it into place, so a
+// partially written file is never read.
+func (l localStorage) Put(key string, data []byte) error {
+	path, err := l.path(key)
+	if err != nil {
+		return fmt.Errorf("could not LocalStorage.Put: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(l.dir, ".upload-*")
+	if err != nil {
+		return fmt.Errorf("could not LocalStorage.Put: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not LocalStorage.Put: %v", err)
+	}
+
+	return nil
+}
+
+// Get reads the file stored under the given key.
+func (l localStorage) Get(key string) ([]byte, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not LocalStorage.Get: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not LocalStorage.Get: %v", err)
+	}
+
+	return data, nil
+}
+
+// Delete removes the file stored under the given key. Removing a file that
+// does not exist is not an error.
+func (l localStorage) Delete(key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return fmt.Errorf("could not LocalStorage.Delete: %v", err)
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not LocalStorage.Delete: %v", err)
+	}
+
+	return nil
+}
+
+// NewLocalStorage creates a Storage that keeps files in the given directory.
+// The directory is created if it does not exist.
+func NewLocalStorage(dir string) (Storage, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, fmt.Errorf("could not NewLocalStorage: %v", err)
+	}
+
+	return localStorage{dir: dir}, nil
+}
+
+//----------------------------------------------------------------------------
+// BoltStorage
+//----------------------------------------------------------------------------
+
+// boltStorage keeps files in the Store alongside their metadata. It needs no
+// other infrastructure, but every file is kept in the database.
+type boltStorage struct {
+	db *store.Store
+}
+
+// Put stores the file in the Store.
+func (b boltStorage) Put(key string, data []byte) error {
+	err := checkKey(key)
+	if err != nil {
+		return fmt.Errorf("could not BoltStorage.Put: %v", err)
+	}
+
+	return b.db.PutBlob(key, data)
+}
+
+// Get reads the file from the Store.
+func (b boltStorage) Get(key string) ([]byte, error) {
+	err := checkKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not BoltStorage.Get: %v", err)
+	}
+
+	return b.db.GetBlob(key)
+}
+
+// Delete removes the file from the Store.
+func (b boltStorage) Delete(key string) error {
+	err := checkKey(key)
+	if err != nil {
+		return fmt.Errorf("could not BoltStorage.Delete: %v", err)
+	}
+
+	return b.db.DeleteBlob(key)
+}
+
+// NewBoltStorage creates a Storage that keeps files in the given Store.
+func NewBoltStorage(s *store.Store) Storage {
+	return boltStorage{db: s}
+}
@@ -0,0 +1,148 @@
+package upload
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/asggo/wasp/store"
+)
+
+var (
+	testUploadDir    = "uploads_test"
+	testUploadDbPath = "upload_test.db"
+	testAccessKey    = "AKIDEXAMPLE"
+	testSecretKey    = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+// testStorage puts, gets, and deletes a file with the given Storage.
+func testStorage(t *testing.T, fs Storage) {
+	key := store.NewUploadToken().String()
+
+	err := fs.Put(key, []byte("hello"))
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	data, err := fs.Get(key)
+	if err != nil || string(data) != "hello" {
+		t.Fatal("Expected hello, received", string(data), err)
+	}
+
+	err = fs.Delete(key)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	_, err = fs.Get(key)
+	if err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+
+	// Deleting a missing file is not an error.
+	err = fs.Delete(key)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	// Keys that could escape the storage are rejected.
+	err = fs.Put("../"+key, []byte("hello"))
+	if err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	defer os.RemoveAll(testUploadDir)
+
+	fs, err := NewLocalStorage(testUploadDir)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	testStorage(t, fs)
+}
+
+func TestBoltStorage(t *testing.T) {
+	s, err := store.NewStore(testUploadDbPath)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+	defer os.Remove(testUploadDbPath)
+
+	fs := NewBoltStorage(&s)
+	testStorage(t, fs)
+
+	// The files of deleted users are removed.
+	RegisterUserDeleter(fs)
+
+	user := store.NewUser("user1234")
+	s.CreateUser(user, "userpassword1234")
+
+	up := store.NewUpload(user.UserId, "hello.txt", "text/plain; charset=utf-8", 5)
+	s.CreateUpload(up)
+	fs.Put(up.UploadId.String(), []byte("hello"))
+
+	err = s.DeleteUser(user)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	_, err = fs.Get(up.UploadId.String())
+	if err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	srv := httptest.NewServer(NewS3StandIn(testAccessKey, testSecretKey))
+	defer srv.Close()
+
+	cfg := S3Config{
+		Endpoint:  srv.URL,
+		Region:    "us-east-1",
+		Bucket:    "wasp-test",
+		AccessKey: testAccessKey,
+		SecretKey: testSecretKey,
+	}
+
+	fs, err := NewS3Storage(cfg)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	testStorage(t, fs)
+
+	// Requests signed with the wrong secret are refused.
+	cfg.SecretKey = "wrong"
+	bad, _ := NewS3Storage(cfg)
+
+	err = bad.Put(store.NewUploadToken().String(), []byte("hello"))
+	if err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+
+	// Missing settings are reported.
+	_, err = NewS3Storage(S3Config{Endpoint: srv.URL})
+	if err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+}
+
+// TestS3Signature checks the signature of the GET Object example in the AWS
+// Signature Version 4 documentation.
+func TestS3Signature(t *testing.T) {
+	req := httptest.NewRequest("GET", "https://examplebucket.s3.amazonaws.com/test.txt", nil)
+	req.Header.Set("Range", "bytes=0-9")
+	req.Header.Set("X-Amz-Date", "20130524T000000Z")
+	req.Header.Set("X-Amz-Content-Sha256", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+
+	signed := []string{"host", "range", "x-amz-content-sha256", "x-amz-date"}
+	scope := s3Scope("20130524T000000Z", "us-east-1")
+	sig := s3Signature(req, signed, scope, "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY")
+
+	expected := "f0e8bdb87c964420e857bd35b5d6ed310bd44f0170aba48dd91039c6036bdb41"
+	if sig != expected {
+		t.Fatal("Expected", expected, ", received", sig)
+	}
+}
@@ -0,0 +1,276 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/asggo/wasp/config"
+	"github.com/asggo/wasp/store"
+)
+
+var testUserDbPath = "user_test.db"
+
+// newUserStore returns a Store for the user tests with a random secret key.
+func newUserStore(t *testing.T) *store.Store {
+	s, err := store.NewStore(testUserDbPath)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	s.SetSecretKey(key)
+
+	return &s
+}
+
+// TestChangePassword verifies a user can change their password with the CSRF
+// token of their session and the old password.
+func TestChangePassword(t *testing.T) {
+	cfg := config.NewConfiguration()
+	s := newUserStore(t)
+	defer os.Remove(testUserDbPath)
+	defer s.Close()
+
+	newManageUser(t, s, "user1234", store.RoleUser)
+
+	srv := newManageServer(&cfg, s)
+	defer srv.Close()
+
+	pc := newPasskeyClient(t, srv)
+	pc.do("/account/login", url.Values{"username": {"user1234"}, "password": {testManagePass}})
+
+	_, body := pc.do("/site/user/changepw", nil)
+	csrf := testCSRFPattern.FindStringSubmatch(body)[1]
+
+	// Changes without the CSRF token are forbidden.
+	_, body = pc.do("/site/user/changepw", url.Values{"old-password": {testManagePass}, "new-password": {"userpassword1235"}, "confirm": {"userpassword1235"}})
+	if !strings.Contains(body, testForbidden) {
+		t.Fatal("Expected the change to be forbidden, received", body)
+	}
+
+	tests := []struct {
+		old, pw, confirm string
+		want             *regexp.Regexp
+	}{
+		{"userpassword123", "userpassword1235", "userpassword1235", regexp.MustCompile(`Invalid credentials\.`)},
+		{testManagePass, "userpassword123", "userpassword1235", regexp.MustCompile(`The password must be at least [0-9]+ characters\.`)},
+		{testManagePass, "userpassword1235", "userpassword1236", regexp.MustCompile(`The passwords do not match\.`)},
+	}
+
+	for _, tt := range tests {
+		_, body = pc.do("/site/user/changepw", url.Values{"csrf": {csrf}, "old-password": {tt.old}, "new-password": {tt.pw}, "confirm": {tt.confirm}})
+		if !tt.want.MatchString(body) {
+			t.Fatal("Expected", tt.want, ", received", body)
+		}
+	}
+
+	// A valid change logs the user out, and the new password is used to login.
+	path, _ := pc.do("/site/user/changepw", url.Values{"csrf": {csrf}, "old-password": {testManagePass}, "new-password": {"userpassword1235"}, "confirm": {"userpassword1235"}})
+	if path != "/account/logout" {
+		t.Fatal("Expected", "/account/logout", ", received", path)
+	}
+
+	path, _ = pc.do("/account/login", url.Values{"username": {"user1234"}, "password": {"userpassword1235"}})
+	if path != "/site" {
+		t.Fatal("Expected", "/site", ", received", path)
+	}
+}
+
+// TestChangeAlias verifies a user can change their username with the CSRF
+// token of their session, and that the old username is reserved.
+func TestChangeAlias(t *testing.T) {
+	cfg := config.NewConfiguration()
+	s := newUserStore(t)
+	defer os.Remove(testUserDbPath)
+	defer s.Close()
+
+	newManageUser(t, s, "user1234", store.RoleUser)
+	newManageUser(t, s, "admin", store.RoleAdmin)
+
+	srv := newManageServer(&cfg, s)
+	defer srv.Close()
+
+	pc := newPasskeyClient(t, srv)
+	pc.do("/account/login", url.Values{"username": {"user1234"}, "password": {testManagePass}})
+
+	_, body := pc.do("/site/user/alias", nil)
+	csrf := testCSRFPattern.FindStringSubmatch(body)[1]
+
+	// Changes without the CSRF token are forbidden.
+	_, body = pc.do("/site/user/alias", url.Values{"username": {"user5678"}})
+	if !strings.Contains(body, testForbidden) {
+		t.Fatal("Expected the change to be forbidden, received", body)
+	}
+
+	tests := []struct {
+		alias string
+		want  *regexp.Regexp
+	}{
+		{"user567", regexp.MustCompile(`The username must be at least [0-9]+ characters\.`)},
+		{"postmaster", regexp.MustCompile(`That username is reserved\.`)},
+		{"user+5678", regexp.MustCompile(`The username may only contain letters, digits, periods, hyphens, and underscores\.`)},
+		{"user1234", regexp.MustCompile(`Username is already taken\.`)},
+	}
+
+	for _, tt := range tests {
+		_, body = pc.do("/site/user/alias", url.Values{"csrf": {csrf}, "username": {tt.alias}})
+		if !tt.want.MatchString(body) {
+			t.Fatal("Expected", tt.want, ", received", body)
+		}
+	}
+
+	_, body = pc.do("/site/user/alias", url.Values{"csrf": {csrf}, "username": {"user5678"}})
+	if !strings.Contains(body, "User user5678") {
+		t.Fatal("Expected the new username, received", body)
+	}
+
+	_, body = pc.do("/site/user/alias", nil)
+	if !strings.Contains(body, "user1234 changed to user5678") {
+		t.Fatal("Expected the username history, received", body)
+	}
+
+	// The old username is reserved and can no longer be used to login.
+	pc.do("/account/logout", nil)
+
+	_, body = pc.do("/account/register", url.Values{"username": {"user1234"}, "password": {"userpassword1234"}, "confirm": {"userpassword1234"}})
+	if !strings.Contains(body, "Username is already taken.") {
+		t.Fatal("Expected the old username to be reserved, received", body)
+	}
+
+	_, body = pc.do("/account/login", url.Values{"username": {"user1234"}, "password": {testManagePass}})
+	if !strings.Contains(body, "Invalid credentials.") {
+		t.Fatal("Expected the old username to be refused, received", body)
+	}
+
+	// The admin account cannot be renamed.
+	pc.do("/account/login", url.Values{"username": {"admin"}, "password": {testManagePass}})
+
+	_, body = pc.do("/site/user/alias", nil)
+	csrf = testCSRFPattern.FindStringSubmatch(body)[1]
+
+	_, body = pc.do("/site/user/alias", url.Values{"csrf": {csrf}, "username": {"administrator"}})
+	if !strings.Contains(body, "The admin account cannot be renamed.") {
+		t.Fatal("Expected the admin account to be fixed, received", body)
+	}
+}
+
+// TestChangeEmail verifies a user can set their email address with the CSRF
+// token of their session and their password.
+func TestChangeEmail(t *testing.T) {
+	cfg := config.NewConfiguration()
+	s := newUserStore(t)
+	defer os.Remove(testUserDbPath)
+	defer s.Close()
+
+	newManageUser(t, s, "user1234", store.RoleUser)
+
+	srv := newManageServer(&cfg, s)
+	defer srv.Close()
+
+	pc := newPasskeyClient(t, srv)
+	pc.do("/account/login", url.Values{"username": {"user1234"}, "password": {testManagePass}})
+
+	_, body := pc.do("/site/user/email", nil)
+	if !strings.Contains(body, "You have not set an email address.") {
+		t.Fatal("Expected no email address, received", body)
+	}
+
+	csrf := testCSRFPattern.FindStringSubmatch(body)[1]
+
+	// Changes without the CSRF token are forbidden.
+	_, body = pc.do("/site/user/email", url.Values{"email": {"user1234@example.com"}, "password": {testManagePass}})
+	if !strings.Contains(body, testForbidden) {
+		t.Fatal("Expected the change to be forbidden, received", body)
+	}
+
+	tests := []struct {
+		email, pw, want string
+	}{
+		{"user1234", testManagePass, "The email address is not valid."},
+		{"User 1234 <user1234@example.com>", testManagePass, "The email address is not valid."},
+		{"user1234@example.com", "wrongpassword123", "Invalid credentials."},
+	}
+
+	for _, tt := range tests {
+		_, body = pc.do("/site/user/email", url.Values{"csrf": {csrf}, "email": {tt.email}, "password": {tt.pw}})
+		if !strings.Contains(body, tt.want) || !strings.Contains(body, "You have not set an email address.") {
+			t.Fatal("Expected", tt.want, ", received", body)
+		}
+	}
+
+	// The address remains unverified until the link is used.
+	_, body = pc.do("/site/user/email", url.Values{"csrf": {csrf}, "email": {"User1234@Example.com"}, "password": {testManagePass}})
+	if !strings.Contains(body, "A verification link has been sent to your email address.") || !strings.Contains(body, "Email: user1234@example.com") {
+		t.Fatal("Expected the email address to be set, received", body)
+	}
+
+	if !strings.Contains(body, "Your email address is not verified.") {
+		t.Fatal("Expected the email address to be unverified, received", body)
+	}
+
+	// Verification links without the CSRF token are forbidden.
+	_, body = pc.do("/site/user/email/verify", url.Values{})
+	if !strings.Contains(body, testForbidden) {
+		t.Fatal("Expected the verification link to be forbidden, received", body)
+	}
+
+	_, body = pc.do("/site/user/email/verify", url.Values{"csrf": {csrf}})
+	if !strings.Contains(body, "A verification link has been sent to your email address.") {
+		t.Fatal("Expected a verification link, received", body)
+	}
+
+	_, body = pc.do("/site/user", nil)
+	if !strings.Contains(body, "Email: user1234@example.com (unverified)") {
+		t.Fatal("Expected the unverified email address, received", body)
+	}
+}
+
+// TestChangeEmailLockout verifies wrong passwords entered to confirm a change
+// count as failed logins, so a session cannot be used to guess the password.
+func TestChangeEmailLockout(t *testing.T) {
+	cfg := config.NewConfiguration()
+	s := newUserStore(t)
+	defer os.Remove(testUserDbPath)
+	defer s.Close()
+
+	newManageUser(t, s, "confirm1234", store.RoleUser)
+
+	srv := newManageServer(&cfg, s)
+	defer srv.Close()
+
+	pc := newPasskeyClient(t, srv)
+	pc.do("/account/login", url.Values{"username": {"confirm1234"}, "password": {testManagePass}})
+
+	_, body := pc.do("/site/user/email", nil)
+	csrf := testCSRFPattern.FindStringSubmatch(body)[1]
+
+	for i := 0; i < 4; i++ {
+		_, body = pc.do("/site/user/email", url.Values{"csrf": {csrf}, "email": {"confirm1234@example.com"}, "password": {"wrongpassword123"}})
+		if !strings.Contains(body, "Invalid credentials.") {
+			t.Fatal("Expected", "Invalid credentials.", ", received", body)
+		}
+	}
+
+	_, body = pc.do("/site/user/email", url.Values{"csrf": {csrf}, "email": {"confirm1234@example.com"}, "password": {"wrongpassword123"}})
+	if !strings.Contains(body, "Too many failed logins.") {
+		t.Fatal("Expected", "Too many failed logins.", ", received", body)
+	}
+
+	// The right password is refused once the account is locked.
+	_, body = pc.do("/site/user/email", url.Values{"csrf": {csrf}, "email": {"confirm1234@example.com"}, "password": {testManagePass}})
+	if !strings.Contains(body, "Too many failed logins.") || !strings.Contains(body, "You have not set an email address.") {
+		t.Fatal("Expected", "Too many failed logins.", ", received", body)
+	}
+
+	pc.do("/account/logout", nil)
+
+	_, body = pc.do("/account/login", url.Values{"username": {"confirm1234"}, "password": {testManagePass}})
+	if !strings.Contains(body, "Too many failed logins.") {
+		t.Fatal("Expected", "Too many failed logins.", ", received", body)
+	}
+}
@@ -0,0 +1,209 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Authenticator is a software authenticator that keeps discoverable ES256
+// credentials in memory, acting as the browser and the authenticator
+// together. It lets tests run the registration and sign-in ceremonies
+// without a browser. Its keys are not protected, so it must only be used in
+// tests.
+type Authenticator struct {
+	Origin string
+
+	mu    sync.Mutex
+	creds []*softCredential
+}
+
+// softCredential is a credential held by an Authenticator.
+type softCredential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	counter    uint32
+}
+
+// NewAuthenticator returns an Authenticator that reports the given origin in
+// the client data, as a browser showing a page from that origin would.
+func NewAuthenticator(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+// Create registers a new credential using the options given to
+// navigator.credentials.create and returns the JSON credential the browser
+// would send to the relying party. A credential already held for the same
+// user and relying party is replaced.
+func (a *Authenticator) Create(opts CreationOptions) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	supported := slices.ContainsFunc(opts.PubKeyCredParams, func(p Parameter) bool {
+		return p.Type == "public-key" && p.Alg == AlgES256
+	})
+
+	if !supported {
+		return nil, fmt.Errorf("could not Authenticator.Create: ES256 is not accepted")
+	}
+
+	for _, d := range opts.ExcludeCredentials {
+		if a.find(opts.RP.ID, d.ID) != nil {
+			return nil, fmt.Errorf("could not Authenticator.Create: credential already registered")
+		}
+	}
+
+	userHandle, err := Encoding.DecodeString(opts.User.ID)
+	if err != nil {
+		return nil, fmt.Errorf("could not Authenticator.Create: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not Authenticator.Create: %v", err)
+	}
+
+	point, err := key.PublicKey.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("could not Authenticator.Create: %v", err)
+	}
+
+	cred := &softCredential{
+		id:         NewChallenge(),
+		rpID:       opts.RP.ID,
+		userHandle: userHandle,
+		key:        key,
+	}
+
+	a.creds = slices.DeleteFunc(a.creds, func(c *softCredential) bool {
+		return c.rpID == cred.rpID && bytes.Equal(c.userHandle, cred.userHandle)
+	})
+	a.creds = append(a.creds, cred)
+
+	authData := cred.authData(flagUP | flagUV | flagAT)
+	binary.Write(authData, binary.BigEndian, [16]byte{})
+	binary.Write(authData, binary.BigEndian, uint16(len(cred.id)))
+	authData.Write(cred.id)
+	authData.Write(encodeES256Key(point.Bytes()))
+
+	var attObj bytes.Buffer
+	cborHead(&attObj, cborMap, 3)
+	cborTextString(&attObj, "fmt")
+	cborTextString(&attObj, "none")
+	cborTextString(&attObj, "attStmt")
+	cborHead(&attObj, cborMap, 0)
+	cborTextString(&attObj, "authData")
+	cborByteString(&attObj, authData.Bytes())
+
+	resp := Response{
+		ClientDataJSON:    Encoding.EncodeToString(a.clientData("webauthn.create", opts.Challenge)),
+		AttestationObject: Encoding.EncodeToString(attObj.Bytes()),
+	}
+
+	return cred.json(resp)
+}
+
+// Get signs in with a credential using the options given to
+// navigator.credentials.get and returns the JSON credential the browser would
+// send to the relying party. The most recently created credential for the
+// relying party is used, limited to the allowed credentials if any are
+// listed.
+func (a *Authenticator) Get(opts RequestOptions) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var cred *softCredential
+
+	for _, c := range a.creds {
+		if c.rpID != opts.RPID {
+			continue
+		}
+
+		allowed := len(opts.AllowCredentials) == 0 || slices.ContainsFunc(opts.AllowCredentials, func(d Descriptor) bool {
+			return d.ID == Encoding.EncodeToString(c.id)
+		})
+
+		if allowed {
+			cred = c
+		}
+	}
+
+	if cred == nil {
+		return nil, fmt.Errorf("could not Authenticator.Get: no credential for %s", opts.RPID)
+	}
+
+	cred.counter++
+
+	authData := cred.authData(flagUP | flagUV).Bytes()
+	clientData := a.clientData("webauthn.get", opts.Challenge)
+
+	sum := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), sum[:]...))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("could not Authenticator.Get: %v", err)
+	}
+
+	resp := Response{
+		ClientDataJSON:    Encoding.EncodeToString(clientData),
+		AuthenticatorData: Encoding.EncodeToString(authData),
+		Signature:         Encoding.EncodeToString(sig),
+		UserHandle:        Encoding.EncodeToString(cred.userHandle),
+	}
+
+	return cred.json(resp)
+}
+
+// find returns the credential with the given base64url ID for the relying
+// party, or nil if the authenticator does not hold it.
+func (a *Authenticator) find(rpID, id string) *softCredential {
+	for _, c := range a.creds {
+		if c.rpID == rpID && Encoding.EncodeToString(c.id) == id {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// clientData returns the client data a browser collects for a ceremony.
+func (a *Authenticator) clientData(typ, challenge string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":        typ,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+
+	return data
+}
+
+// authData returns the start of the authenticator data of the credential
+// with the given flags.
+func (c *softCredential) authData(flags byte) *bytes.Buffer {
+	var b bytes.Buffer
+
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	b.Write(rpIDHash[:])
+	b.WriteByte(flags)
+	binary.Write(&b, binary.BigEndian, c.counter)
+
+	return &b
+}
+
+// json returns the JSON form of the credential with the given response.
+func (c *softCredential) json(resp Response) ([]byte, error) {
+	id := Encoding.EncodeToString(c.id)
+
+	return json.Marshal(Credential{ID: id, RawID: id, Type: "public-key", Response: resp})
+}
@@ -0,0 +1,224 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// maxDepth limits how deeply CBOR arrays and maps may be nested.
+const maxDepth = 16
+
+// CBOR major types.
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborSimple = 7
+)
+
+//----------------------------------------------------------------------------
+// CBOR Decoding
+//----------------------------------------------------------------------------
+
+// decodeCBOR decodes the CBOR value at the start of data and returns it along
+// with the number of bytes it used. Only the subset of CBOR used by WebAuthn
+// is supported: integers, byte and text strings, arrays, maps with integer or
+// text keys, booleans, and null. Integers are returned as int64 and maps as
+// map[interface{}]interface{}.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	d := decoder{data: data}
+
+	v, err := d.value(0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not decodeCBOR: %v", err)
+	}
+
+	return v, d.pos, nil
+}
+
+// decoder reads CBOR values from data starting at pos.
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+// head reads the initial byte of a value and its argument.
+func (d *decoder) head() (byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, fmt.Errorf("unexpected end of data")
+	}
+
+	major, info := d.data[d.pos]>>5, d.data[d.pos]&0x1f
+	d.pos++
+
+	if info < 24 {
+		return major, uint64(info), nil
+	}
+
+	if info > 27 {
+		return 0, 0, fmt.Errorf("indefinite lengths are not supported")
+	}
+
+	size := 1 << (info - 24)
+	if len(d.data)-d.pos < size {
+		return 0, 0, fmt.Errorf("unexpected end of data")
+	}
+
+	var arg uint64
+	for _, b := range d.data[d.pos : d.pos+size] {
+		arg = arg<<8 | uint64(b)
+	}
+
+	d.pos += size
+
+	return major, arg, nil
+}
+
+// value reads the next value, which is nested depth arrays or maps deep.
+func (d *decoder) value(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("values are nested too deeply")
+	}
+
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("integer is too large")
+		}
+
+		return int64(arg), nil
+	case cborNegInt:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("integer is too large")
+		}
+
+		return -1 - int64(arg), nil
+	case cborBytes, cborText:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, fmt.Errorf("unexpected end of data")
+		}
+
+		b := append([]byte{}, d.data[d.pos:d.pos+int(arg)]...)
+		d.pos += int(arg)
+
+		if major == cborText {
+			return string(b), nil
+		}
+
+		return b, nil
+	case cborArray:
+		// Each item takes at least one byte, so a longer array cannot fit.
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, fmt.Errorf("unexpected end of data")
+		}
+
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, v)
+		}
+
+		return items, nil
+	case cborMap:
+		if arg > uint64(len(d.data)-d.pos)/2 {
+			return nil, fmt.Errorf("unexpected end of data")
+		}
+
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("map keys must be integers or text")
+			}
+
+			if _, ok := m[k]; ok {
+				return nil, fmt.Errorf("duplicate map key %v", k)
+			}
+
+			m[k], err = d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return m, nil
+	case cborSimple:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported major type %d", major)
+}
+
+//----------------------------------------------------------------------------
+// CBOR Encoding
+//----------------------------------------------------------------------------
+
+// cborHead appends the initial byte of a value of the given major type and
+// its argument, using the shortest form as CTAP2 requires.
+func cborHead(b *bytes.Buffer, major byte, arg uint64) {
+	switch {
+	case arg < 24:
+		b.WriteByte(major<<5 | byte(arg))
+	case arg <= math.MaxUint8:
+		b.WriteByte(major<<5 | 24)
+		b.WriteByte(byte(arg))
+	case arg <= math.MaxUint16:
+		b.WriteByte(major<<5 | 25)
+		binary.Write(b, binary.BigEndian, uint16(arg))
+	case arg <= math.MaxUint32:
+		b.WriteByte(major<<5 | 26)
+		binary.Write(b, binary.BigEndian, uint32(arg))
+	default:
+		b.WriteByte(major<<5 | 27)
+		binary.Write(b, binary.BigEndian, arg)
+	}
+}
+
+// cborInt appends an integer.
+func cborInt(b *bytes.Buffer, v int64) {
+	if v < 0 {
+		cborHead(b, cborNegInt, uint64(-1-v))
+		return
+	}
+
+	cborHead(b, cborUint, uint64(v))
+}
+
+// cborByteString appends a byte string.
+func cborByteString(b *bytes.Buffer, v []byte) {
+	cborHead(b, cborBytes, uint64(len(v)))
+	b.Write(v)
+}
+
+// cborTextString appends a text string.
+func cborTextString(b *bytes.Buffer, v string) {
+	cborHead(b, cborText, uint64(len(v)))
+	b.WriteString(v)
+}
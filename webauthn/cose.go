@@ -0,0 +1,157 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms supported for credential public keys, in order of
+// preference.
+const (
+	AlgES256 = -7   // ECDSA with P-256 and SHA-256
+	AlgEdDSA = -8   // Ed25519
+	AlgRS256 = -257 // RSASSA-PKCS1-v1_5 with SHA-256
+)
+
+// Algorithms lists the supported COSE algorithms in order of preference.
+var Algorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters and values used by the supported algorithms.
+const (
+	coseKty = 1
+	coseAlg = 3
+
+	coseCrv = -1 // curve of EC2 and OKP keys
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1 // modulus of RSA keys
+	coseE   = -2 // exponent of RSA keys
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// minRSABits is the smallest RSA modulus accepted.
+const minRSABits = 2048
+
+// coseKey is a credential public key decoded from its COSE form.
+type coseKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parseCOSEKey decodes the COSE key at the start of data and returns it along
+// with the number of bytes it used. Keys for unsupported algorithms are
+// rejected.
+func parseCOSEKey(data []byte) (coseKey, int, error) {
+	var ck coseKey
+
+	v, n, err := decodeCBOR(data)
+	if err != nil {
+		return ck, 0, err
+	}
+
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return ck, 0, fmt.Errorf("COSE key is not a map")
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	ck.alg, _ = m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == ktyEC2 && ck.alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return ck, 0, fmt.Errorf("invalid P-256 key")
+		}
+
+		// Parsing the point with crypto/ecdh checks it is on the curve.
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return ck, 0, fmt.Errorf("invalid P-256 key: %v", err)
+		}
+
+		ck.key = &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+	case kty == ktyOKP && ck.alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return ck, 0, fmt.Errorf("invalid Ed25519 key")
+		}
+
+		ck.key = ed25519.PublicKey(x)
+	case kty == ktyRSA && ck.alg == AlgRS256:
+		nb, _ := m[int64(coseN)].([]byte)
+		eb, _ := m[int64(coseE)].([]byte)
+
+		mod := new(big.Int).SetBytes(nb)
+		exp := new(big.Int).SetBytes(eb)
+
+		if mod.BitLen() < minRSABits || !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return ck, 0, fmt.Errorf("invalid RSA key")
+		}
+
+		ck.key = &rsa.PublicKey{N: mod, E: int(exp.Int64())}
+	default:
+		return ck, 0, fmt.Errorf("unsupported key type %d with algorithm %d", kty, ck.alg)
+	}
+
+	return ck, n, nil
+}
+
+// verify checks the signature of the data with the key.
+func (ck coseKey) verify(data, sig []byte) bool {
+	switch key := ck.key.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, sum[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	}
+
+	return false
+}
+
+// encodeES256Key returns the COSE form of a P-256 public key given as an
+// uncompressed point.
+func encodeES256Key(point []byte) []byte {
+	var b bytes.Buffer
+
+	cborHead(&b, cborMap, 5)
+	cborInt(&b, coseKty)
+	cborInt(&b, ktyEC2)
+	cborInt(&b, coseAlg)
+	cborInt(&b, AlgES256)
+	cborInt(&b, coseCrv)
+	cborInt(&b, crvP256)
+	cborInt(&b, coseX)
+	cborByteString(&b, point[1:33])
+	cborInt(&b, coseY)
+	cborByteString(&b, point[33:65])
+
+	return b.Bytes()
+}
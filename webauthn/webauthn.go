@@ -0,0 +1,467 @@
+// Package webauthn implements the relying party side of the Web
+// Authentication ceremonies used to register passkeys and security keys and
+// to sign in with them. Attestation is not requested, so any authenticator
+// can be registered, and user verification is required, so a credential is
+// enough to sign in without a passphrase.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	ChallengeSize = 32   // bytes
+	MaxIDSize     = 1023 // bytes, the longest credential ID allowed
+)
+
+// Authenticator data flags.
+const (
+	flagUP = 0x01 // user present
+	flagUV = 0x04 // user verified
+	flagBE = 0x08 // backup eligible
+	flagBS = 0x10 // backed up
+	flagAT = 0x40 // attested credential data included
+	flagED = 0x80 // extension data included
+)
+
+// Encoding is the unpadded base64url encoding used for binary values in the
+// JSON exchanged with the browser.
+var Encoding = base64.RawURLEncoding
+
+// RelyingParty describes the site credentials are registered with. ID is the
+// domain credentials are scoped to and Origin is the origin of the pages that
+// run the ceremonies. Timeout is the number of seconds the browser waits for
+// the user.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origin  string
+	Timeout int64
+}
+
+// NewChallenge generates a random challenge for a ceremony.
+func NewChallenge() []byte {
+	challenge := make([]byte, ChallengeSize)
+	rand.Read(challenge)
+
+	return challenge
+}
+
+//----------------------------------------------------------------------------
+// Ceremony Options
+//----------------------------------------------------------------------------
+
+// Descriptor identifies a credential in ceremony options.
+type Descriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// Parameter names a credential type and algorithm the relying party accepts.
+type Parameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// Entity names the relying party or the user in creation options.
+type Entity struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+// Selection holds the authenticator requirements in creation options.
+type Selection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// CreationOptions holds the options passed to navigator.credentials.create to
+// register a credential. Binary values are base64url encoded.
+type CreationOptions struct {
+	Challenge              string       `json:"challenge"`
+	RP                     Entity       `json:"rp"`
+	User                   Entity       `json:"user"`
+	PubKeyCredParams       []Parameter  `json:"pubKeyCredParams"`
+	Timeout                int64        `json:"timeout"`
+	ExcludeCredentials     []Descriptor `json:"excludeCredentials"`
+	AuthenticatorSelection Selection    `json:"authenticatorSelection"`
+	Attestation            string       `json:"attestation"`
+}
+
+// RequestOptions holds the options passed to navigator.credentials.get to
+// sign in. No credentials are listed, so the authenticator offers the
+// discoverable credentials it holds for the relying party.
+type RequestOptions struct {
+	Challenge        string       `json:"challenge"`
+	Timeout          int64        `json:"timeout"`
+	RPID             string       `json:"rpId"`
+	AllowCredentials []Descriptor `json:"allowCredentials"`
+	UserVerification string       `json:"userVerification"`
+}
+
+// CreationOptions returns the options to register a discoverable credential
+// for the user identified by userID. The credentials the user already has
+// are excluded so the same authenticator is not registered twice.
+func (rp RelyingParty) CreationOptions(challenge, userID []byte, name string, exclude [][]byte) CreationOptions {
+	opts := CreationOptions{
+		Challenge:          Encoding.EncodeToString(challenge),
+		RP:                 Entity{ID: rp.ID, Name: rp.Name},
+		User:               Entity{ID: Encoding.EncodeToString(userID), Name: name, DisplayName: name},
+		Timeout:            rp.Timeout * 1000,
+		ExcludeCredentials: []Descriptor{},
+		AuthenticatorSelection: Selection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: "none",
+	}
+
+	for _, alg := range Algorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, Parameter{"public-key", alg})
+	}
+
+	for _, id := range exclude {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, Descriptor{"public-key", Encoding.EncodeToString(id)})
+	}
+
+	return opts
+}
+
+// RequestOptions returns the options to sign in with a discoverable
+// credential.
+func (rp RelyingParty) RequestOptions(challenge []byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        Encoding.EncodeToString(challenge),
+		Timeout:          rp.Timeout * 1000,
+		RPID:             rp.ID,
+		AllowCredentials: []Descriptor{},
+		UserVerification: "required",
+	}
+}
+
+//----------------------------------------------------------------------------
+// Ceremony Responses
+//----------------------------------------------------------------------------
+
+// Credential holds the JSON form of the PublicKeyCredential returned by the
+// browser. Binary values are base64url encoded.
+type Credential struct {
+	ID       string   `json:"id"`
+	RawID    string   `json:"rawId"`
+	Type     string   `json:"type"`
+	Response Response `json:"response"`
+}
+
+// Response holds the authenticator response of a Credential. Registrations
+// include the attestation object, and sign-ins include the authenticator
+// data, the signature, and the user handle.
+type Response struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject,omitempty"`
+	AuthenticatorData string `json:"authenticatorData,omitempty"`
+	Signature         string `json:"signature,omitempty"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+// Registration holds a credential verified by Register. PublicKey is the
+// COSE form of the key, to be kept for VerifyAssertion.
+type Registration struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+// Assertion holds a sign-in response parsed by ParseAssertion. The credential
+// ID and user handle are used to find the stored public key before the
+// assertion is verified.
+type Assertion struct {
+	ID         []byte
+	UserHandle []byte
+
+	clientData []byte
+	authData   []byte
+	signature  []byte
+}
+
+// Register verifies the JSON credential returned by
+// navigator.credentials.create against the challenge of the ceremony and
+// returns the new credential.
+func (rp RelyingParty) Register(challenge []byte, data []byte) (Registration, error) {
+	var reg Registration
+
+	cred, id, err := parseCredential(data)
+	if err != nil {
+		return reg, fmt.Errorf("could not RelyingParty.Register: %v", err)
+	}
+
+	clientData, err := Encoding.DecodeString(cred.Response.ClientDataJSON)
+	if err != nil {
+		return reg, fmt.Errorf("could not RelyingParty.Register: %v", err)
+	}
+
+	err = rp.checkClientData(clientData, "webauthn.create", challenge)
+	if err != nil {
+		return reg, fmt.Errorf("could not RelyingParty.Register: %v", err)
+	}
+
+	attObj, err := Encoding.DecodeString(cred.Response.AttestationObject)
+	if err != nil {
+		return reg, fmt.Errorf("could not RelyingParty.Register: %v", err)
+	}
+
+	// The attestation statement is not checked since none was requested.
+	v, n, err := decodeCBOR(attObj)
+	if err != nil {
+		return reg, fmt.Errorf("could not RelyingParty.Register: %v", err)
+	}
+
+	m, ok := v.(map[interface{}]interface{})
+	if !ok || n != len(attObj) {
+		return reg, fmt.Errorf("could not RelyingParty.Register: invalid attestation object")
+	}
+
+	authData, ok := m["authData"].([]byte)
+	if !ok {
+		return reg, fmt.Errorf("could not RelyingParty.Register: missing authenticator data")
+	}
+
+	ad, err := rp.parseAuthData(authData, true)
+	if err != nil {
+		return reg, fmt.Errorf("could not RelyingParty.Register: %v", err)
+	}
+
+	if !bytes.Equal(ad.credID, id) {
+		return reg, fmt.Errorf("could not RelyingParty.Register: credential ID mismatch")
+	}
+
+	reg.ID = ad.credID
+	reg.PublicKey = ad.publicKey
+	reg.SignCount = ad.signCount
+
+	return reg, nil
+}
+
+// ParseAssertion parses the JSON credential returned by
+// navigator.credentials.get.
+func ParseAssertion(data []byte) (Assertion, error) {
+	var a Assertion
+
+	cred, id, err := parseCredential(data)
+	if err != nil {
+		return a, fmt.Errorf("could not ParseAssertion: %v", err)
+	}
+
+	a.ID = id
+
+	fields := []struct {
+		value string
+		dst   *[]byte
+	}{
+		{cred.Response.ClientDataJSON, &a.clientData},
+		{cred.Response.AuthenticatorData, &a.authData},
+		{cred.Response.Signature, &a.signature},
+		{cred.Response.UserHandle, &a.UserHandle},
+	}
+
+	for _, f := range fields {
+		*f.dst, err = Encoding.DecodeString(f.value)
+		if err != nil {
+			return a, fmt.Errorf("could not ParseAssertion: %v", err)
+		}
+	}
+
+	return a, nil
+}
+
+// VerifyAssertion verifies the assertion against the challenge of the
+// ceremony and the COSE public key stored for the credential. It returns the
+// signature counter of the authenticator, which should be checked against
+// the stored counter to detect cloned authenticators.
+func (rp RelyingParty) VerifyAssertion(challenge []byte, a Assertion, publicKey []byte) (uint32, error) {
+	err := rp.checkClientData(a.clientData, "webauthn.get", challenge)
+	if err != nil {
+		return 0, fmt.Errorf("could not RelyingParty.VerifyAssertion: %v", err)
+	}
+
+	ad, err := rp.parseAuthData(a.authData, false)
+	if err != nil {
+		return 0, fmt.Errorf("could not RelyingParty.VerifyAssertion: %v", err)
+	}
+
+	key, n, err := parseCOSEKey(publicKey)
+	if err != nil || n != len(publicKey) {
+		return 0, fmt.Errorf("could not RelyingParty.VerifyAssertion: invalid public key")
+	}
+
+	sum := sha256.Sum256(a.clientData)
+	signed := append(append([]byte{}, a.authData...), sum[:]...)
+
+	if !key.verify(signed, a.signature) {
+		return 0, fmt.Errorf("could not RelyingParty.VerifyAssertion: invalid signature")
+	}
+
+	return ad.signCount, nil
+}
+
+// parseCredential decodes the JSON credential and returns it with its raw
+// ID.
+func parseCredential(data []byte) (Credential, []byte, error) {
+	var cred Credential
+
+	err := json.Unmarshal(data, &cred)
+	if err != nil {
+		return cred, nil, err
+	}
+
+	if cred.Type != "public-key" {
+		return cred, nil, fmt.Errorf("invalid credential type %q", cred.Type)
+	}
+
+	if cred.RawID != "" && cred.RawID != cred.ID {
+		return cred, nil, fmt.Errorf("credential ID mismatch")
+	}
+
+	id, err := Encoding.DecodeString(cred.ID)
+	if err != nil {
+		return cred, nil, err
+	}
+
+	if len(id) == 0 || len(id) > MaxIDSize {
+		return cred, nil, fmt.Errorf("invalid credential ID length")
+	}
+
+	return cred, id, nil
+}
+
+// checkClientData verifies the client data collected by the browser is for
+// the given ceremony type and challenge, and comes from the origin of the
+// relying party.
+func (rp RelyingParty) checkClientData(data []byte, typ string, challenge []byte) error {
+	var cd struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+
+	err := json.Unmarshal(data, &cd)
+	if err != nil {
+		return err
+	}
+
+	if cd.Type != typ {
+		return fmt.Errorf("invalid client data type %q", cd.Type)
+	}
+
+	received, err := Encoding.DecodeString(cd.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return fmt.Errorf("challenge mismatch")
+	}
+
+	if cd.Origin != rp.Origin || cd.CrossOrigin {
+		return fmt.Errorf("invalid origin %q", cd.Origin)
+	}
+
+	return nil
+}
+
+//----------------------------------------------------------------------------
+// Authenticator Data
+//----------------------------------------------------------------------------
+
+// authData holds the parsed authenticator data. The credential ID and public
+// key are only set in registrations.
+type authData struct {
+	flags     byte
+	signCount uint32
+	credID    []byte
+	publicKey []byte
+}
+
+// parseAuthData parses the authenticator data and checks it is scoped to the
+// relying party and that the user was present and verified. Registrations
+// must include attested credential data and sign-ins must not.
+func (rp RelyingParty) parseAuthData(data []byte, attested bool) (authData, error) {
+	var ad authData
+
+	if len(data) < 37 {
+		return ad, fmt.Errorf("authenticator data is too short")
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(data[:32], rpIDHash[:]) != 1 {
+		return ad, fmt.Errorf("relying party ID mismatch")
+	}
+
+	ad.flags = data[32]
+	ad.signCount = binary.BigEndian.Uint32(data[33:37])
+	rest := data[37:]
+
+	if ad.flags&flagUP == 0 || ad.flags&flagUV == 0 {
+		return ad, fmt.Errorf("user was not present and verified")
+	}
+
+	if ad.flags&flagBS != 0 && ad.flags&flagBE == 0 {
+		return ad, fmt.Errorf("credential is backed up but not backup eligible")
+	}
+
+	if (ad.flags&flagAT != 0) != attested {
+		return ad, fmt.Errorf("unexpected attested credential data")
+	}
+
+	if attested {
+		// The AAGUID of the authenticator model comes first and is not
+		// used.
+		if len(rest) < 18 {
+			return ad, fmt.Errorf("attested credential data is too short")
+		}
+
+		size := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+
+		if size == 0 || size > MaxIDSize || size > len(rest) {
+			return ad, fmt.Errorf("invalid credential ID length")
+		}
+
+		ad.credID = append([]byte{}, rest[:size]...)
+		rest = rest[size:]
+
+		_, n, err := parseCOSEKey(rest)
+		if err != nil {
+			return ad, err
+		}
+
+		ad.publicKey = append([]byte{}, rest[:n]...)
+		rest = rest[n:]
+	}
+
+	// Extension outputs are not used, but must be well formed.
+	if ad.flags&flagED != 0 {
+		v, n, err := decodeCBOR(rest)
+		if err != nil {
+			return ad, err
+		}
+
+		if _, ok := v.(map[interface{}]interface{}); !ok {
+			return ad, fmt.Errorf("invalid extension data")
+		}
+
+		rest = rest[n:]
+	}
+
+	if len(rest) != 0 {
+		return ad, fmt.Errorf("unexpected trailing authenticator data")
+	}
+
+	return ad, nil
+}
@@ -0,0 +1,166 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+var testRP = RelyingParty{ID: "localhost", Name: "WASP", Origin: "https://localhost:8000", Timeout: 300}
+
+// register runs a registration ceremony with the authenticator.
+func register(t *testing.T, a *Authenticator, userID []byte, exclude [][]byte) Registration {
+	challenge := NewChallenge()
+
+	data, err := a.Create(testRP.CreationOptions(challenge, userID, "user1234", exclude))
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	reg, err := testRP.Register(challenge, data)
+	if err != nil {
+		t.Fatal("Expected", nil, ", received", err)
+	}
+
+	return reg
+}
+
+func TestRegister(t *testing.T) {
+	a := NewAuthenticator(testRP.Origin)
+	reg := register(t, a, []byte("user1234"), nil)
+
+	if len(reg.ID) != ChallengeSize || reg.SignCount != 0 {
+		t.Fatal("Expected new credential, received", reg)
+	}
+
+	// The authenticator refuses to register a credential it already holds.
+	_, err := a.Create(testRP.CreationOptions(NewChallenge(), []byte("user1234"), "user1234", [][]byte{reg.ID}))
+	if err == nil {
+		t.Fatal("Expected error for excluded credential, received", nil)
+	}
+
+	// Responses for another challenge, origin, or relying party are rejected.
+	challenge := NewChallenge()
+	data, _ := a.Create(testRP.CreationOptions(challenge, []byte("user5678"), "user5678", nil))
+
+	if _, err := testRP.Register(NewChallenge(), data); err == nil {
+		t.Fatal("Expected error for wrong challenge, received", nil)
+	}
+
+	other := testRP
+	other.Origin = "https://evil.example"
+	if _, err := other.Register(challenge, data); err == nil {
+		t.Fatal("Expected error for wrong origin, received", nil)
+	}
+
+	other = testRP
+	other.ID = "evil.example"
+	if _, err := other.Register(challenge, data); err == nil {
+		t.Fatal("Expected error for wrong relying party, received", nil)
+	}
+
+	// A sign-in response cannot be used to register.
+	assertion, _ := a.Get(testRP.RequestOptions(challenge))
+	if _, err := testRP.Register(challenge, assertion); err == nil {
+		t.Fatal("Expected error for sign-in response, received", nil)
+	}
+}
+
+func TestAssertion(t *testing.T) {
+	a := NewAuthenticator(testRP.Origin)
+	reg := register(t, a, []byte("user1234"), nil)
+
+	// Each sign-in is verified with the registered key and reports a higher
+	// counter.
+	for _, expected := range []uint32{1, 2} {
+		challenge := NewChallenge()
+
+		data, err := a.Get(testRP.RequestOptions(challenge))
+		if err != nil {
+			t.Fatal("Expected", nil, ", received", err)
+		}
+
+		assertion, err := ParseAssertion(data)
+		if err != nil {
+			t.Fatal("Expected", nil, ", received", err)
+		}
+
+		if !bytes.Equal(assertion.ID, reg.ID) || string(assertion.UserHandle) != "user1234" {
+			t.Fatal("Expected", reg.ID, ", received", assertion.ID, assertion.UserHandle)
+		}
+
+		count, err := testRP.VerifyAssertion(challenge, assertion, reg.PublicKey)
+		if err != nil {
+			t.Fatal("Expected", nil, ", received", err)
+		}
+
+		if count != expected {
+			t.Fatal("Expected", expected, ", received", count)
+		}
+	}
+
+	challenge := NewChallenge()
+	data, _ := a.Get(testRP.RequestOptions(challenge))
+	assertion, _ := ParseAssertion(data)
+
+	// Assertions for another challenge or signed by another key are rejected.
+	if _, err := testRP.VerifyAssertion(NewChallenge(), assertion, reg.PublicKey); err == nil {
+		t.Fatal("Expected error for wrong challenge, received", nil)
+	}
+
+	other := register(t, NewAuthenticator(testRP.Origin), []byte("user1234"), nil)
+	if _, err := testRP.VerifyAssertion(challenge, assertion, other.PublicKey); err == nil {
+		t.Fatal("Expected error for wrong key, received", nil)
+	}
+
+	// Tampering with the signed data breaks the signature.
+	assertion.authData[32] |= flagBE
+	if _, err := testRP.VerifyAssertion(challenge, assertion, reg.PublicKey); err == nil {
+		t.Fatal("Expected error for tampered data, received", nil)
+	}
+
+	// Malformed credentials are rejected.
+	var cred Credential
+	json.Unmarshal(data, &cred)
+	cred.Type = "password"
+	data, _ = json.Marshal(cred)
+
+	if _, err := ParseAssertion(data); err == nil {
+		t.Fatal("Expected error for wrong credential type, received", nil)
+	}
+}
+
+func TestCBOR(t *testing.T) {
+	var b bytes.Buffer
+	cborHead(&b, cborMap, 2)
+	cborInt(&b, -300)
+	cborByteString(&b, []byte{1, 2, 3})
+	cborTextString(&b, "key")
+	cborHead(&b, cborArray, 1)
+	cborInt(&b, 70000)
+
+	v, n, err := decodeCBOR(b.Bytes())
+	if err != nil || n != b.Len() {
+		t.Fatal("Expected", nil, ", received", err, n)
+	}
+
+	m := v.(map[interface{}]interface{})
+	if !bytes.Equal(m[int64(-300)].([]byte), []byte{1, 2, 3}) || m["key"].([]interface{})[0] != int64(70000) {
+		t.Fatal("Expected decoded map, received", m)
+	}
+
+	// Truncated, indefinite length, and deeply nested values are rejected.
+	invalid := [][]byte{
+		b.Bytes()[:b.Len()-1],
+		{0x5f},
+		bytes.Repeat([]byte{0x81}, maxDepth+2),
+		{0x5a, 0xff, 0xff, 0xff, 0xff},
+		{0xa2, 0x01, 0x01, 0x01, 0x01},
+	}
+
+	for _, data := range invalid {
+		if _, _, err := decodeCBOR(data); err == nil {
+			t.Fatal("Expected error for", data, ", received", nil)
+		}
+	}
+}